@@ -12,8 +12,10 @@ import (
 	"time"
 
 	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/bootstrap"
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
 	"awesomeProject/beacon/p2p_network/libs/kademlia"
+	"awesomeProject/beacon/p2p_network/libs/lan"
 
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
@@ -23,6 +25,7 @@ var (
 	hostFlag    = pflag.IPP("host", "h", nil, "binding host")
 	portFlag    = pflag.Uint16P("port", "p", 0, "binding port")
 	addressFlag = pflag.StringP("address", "a", "", "publicly reachable network address")
+	lanFlag     = pflag.Bool("lan", false, "discover peers on the local network")
 )
 
 type chatMessage struct {
@@ -89,22 +92,34 @@ func main() {
 
 	overlay := kademlia.New(kademlia.WithProtocolEvents(events))
 
-	// Bind Kademlia to the node.
-	node.Bind(overlay.Protocol())
+	// Bootstrap from the nodes passed as arguments, and re-bootstrap from them should we lose all our peers.
+	manager := bootstrap.New(overlay,
+		bootstrap.WithSeeds(pflag.Args()...),
+		bootstrap.WithEvents(bootstrap.Events{
+			OnBootstrapped: func(seeds []string, discovered []cryptographic.ID) {
+				fmt.Printf("Bootstrapped from %d node(s), and discovered %d peer(s).\n", len(seeds), len(discovered))
+			},
+			OnSeedFailed: func(addr string, err error) {
+				fmt.Printf("Failed to ping bootstrap node (%s). Skipping... [error: %s]\n", addr, err)
+			},
+		}),
+	)
+
+	// Bind Kademlia and the bootstrap manager to the node.
+	node.Bind(overlay.Protocol(), manager.Protocol())
+
+	// Should we be asked to, discover peers on the local network through multicast announcements.
+	if *lanFlag {
+		node.Bind(lan.New(overlay).Protocol())
+	}
 
-	// Have the node start listening for new peers.
+	// Have the node start listening for new peers, which has it bootstrap in the background.
 
 	check(node.Listen())
 
 	// Print out the nodes ID and a help message comprised of commands.
 	help(node)
 
-	// Ping nodes to initially bootstrap and discover peers from.
-	bootstrap(node, pflag.Args()...)
-
-	// Attempt to discover peers if we are bootstrapped to any nodes.
-	discover(overlay)
-
 	// Accept chat message inputs and handle chat commands in a separate goroutine.
 	go input(func(line string) {
 		chat(node, overlay, line)
@@ -179,20 +194,6 @@ func help(node *core_module.Node) {
 	)
 }
 
-// bootstrap pings and dials an array of network addresses which we may interact with and discover peers from.
-func bootstrap(node *core_module.Node, addresses ...string) {
-	for _, addr := range addresses {
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		_, err := node.Ping(ctx, addr)
-		cancel()
-
-		if err != nil {
-			fmt.Printf("Failed to ping bootstrap node (%s). Skipping... [error: %s]\n", addr, err)
-			continue
-		}
-	}
-}
-
 // discover uses Kademlia to discover new peers from nodes we already are aware of.
 func discover(overlay *kademlia.Protocol) {
 	ids := overlay.Discover()
@@ -16,10 +16,17 @@ import (
 
 	"awesomeProject/beacon/p2p_network/libs/common"
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/peerstore"
 
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
+// inboxSize is the number of messages received from a peer that may be queued up to be handled in order, should
+// a node be configured to handle messages from a single peer in order, before the goroutine reading messages from
+// the peer blocks until they are handled.
+const inboxSize = 128
+
 type clientSide bool
 
 const (
@@ -75,6 +82,10 @@ type Client struct {
 	writerLock  sync.Mutex
 
 	requests *requestMap
+	extended atomic.Bool
+
+	inbox    chan HandlerContext
+	draining atomic.Bool
 
 	ready      chan struct{}
 	readerDone chan struct{}
@@ -106,6 +117,10 @@ func newClient(node *Node) *Client {
 		clientDone: make(chan struct{}),
 	}
 
+	if node.orderedHandling {
+		c.inbox = make(chan HandlerContext, inboxSize)
+	}
+
 	c.SetLogger(node.Logger())
 
 	return c
@@ -119,6 +134,121 @@ func (c *Client) ID() cryptographic.ID {
 	return c.id
 }
 
+// RemoteAddr returns the network address of the peer this client is connected to, as observed from the underlying
+// connection. It may differ from the public address advertised on the ID of the peer, such as should the peer be
+// behind a NAT, or should the connection be inbound. It returns nil should the client not yet be connected.
+//
+// RemoteAddr may be called concurrently once the client is ready.
+func (c *Client) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.RemoteAddr()
+}
+
+// Addr returns the address at which the peer this client is connected to may be reached. Peers dialed over transports
+// other than TCP may not be reachable at the address advertised on their ID, and so may be reached at the address
+// they were dialed at instead. The address advertised on the ID of the peer is returned otherwise.
+//
+// Addr may be called concurrently once the client is ready.
+func (c *Client) Addr() string {
+	if c.side == clientSideInbound && c.conn != nil && c.conn.RemoteAddr().Network() != "tcp" {
+		return c.addr
+	}
+
+	return c.id.Address
+}
+
+// Extended returns true should the peer run a version of this library that announced the kinds of messages it
+// supports upon connecting, and false should it run an older version that only supports plain messages and requests.
+//
+// Extended may be called concurrently.
+func (c *Client) Extended() bool {
+	return c.extended.Load()
+}
+
+// Send sends data to the peer this client is connected to as a message. Unlike (*Node).Send, no new connection is
+// established should the connection be dropped, and an error is returned instead. It is useful for replying to a
+// peer over the very connection it is connected to your node through, such as should the connection be inbound.
+//
+// Send may be called concurrently once the client is ready.
+func (c *Client) Send(data []byte) error {
+	if err := c.send(0, messageKindDefault, data); err != nil {
+		return err
+	}
+
+	for _, protocol := range c.node.protocols {
+		if protocol.OnMessageSent == nil {
+			continue
+		}
+
+		protocol.OnMessageSent(c)
+	}
+
+	return nil
+}
+
+// SendMessage encodes msg which is a Go type registered via (*Node).RegisterMessage, and sends it to the peer this
+// client is connected to as a message. For more details, refer to (*Client).Send and (*Node).RegisterMessage.
+//
+// SendMessage may be called concurrently once the client is ready.
+func (c *Client) SendMessage(msg common.Serializable) error {
+	data, err := c.node.EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.Send(data)
+}
+
+// Request sends data to the peer this client is connected to as a request, and blocks until either a response has
+// been received which will be subsequently returned, ctx was canceled/expired, or the connection was dropped. Unlike
+// (*Node).Request, no new connection is established should the connection be dropped, and an error is returned
+// instead. For more details, refer to (*Node).Request.
+//
+// Request may be called concurrently once the client is ready.
+func (c *Client) Request(ctx context.Context, data []byte) ([]byte, error) {
+	msg, err := c.request(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, protocol := range c.node.protocols {
+		if protocol.OnMessageSent == nil {
+			continue
+		}
+
+		protocol.OnMessageSent(c)
+	}
+
+	return msg.data, nil
+}
+
+// RequestMessage encodes req which is a Go type registered via (*Node).RegisterMessage, sends it as a request to the
+// peer this client is connected to, and returns a decoded response. For more details, refer to (*Client).Request and
+// (*Node).RegisterMessage.
+//
+// RequestMessage may be called concurrently once the client is ready.
+func (c *Client) RequestMessage(ctx context.Context, req common.Serializable) (common.Serializable, error) {
+	data, err := c.node.EncodeMessage(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	data, err = c.Request(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.node.DecodeMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request: %v", err)
+	}
+
+	return res, nil
+}
+
 // Logger returns the underlying logger associated to this client. It may optionally be set via (*Client).SetLogger.
 //
 // Logger may be called concurrently.
@@ -213,9 +343,16 @@ func (c *Client) outbound(ctx context.Context, addr string) {
 		close(c.clientDone)
 	}()
 
-	var dialer net.Dialer
+	dial := c.node.dialer
+	if dial == nil {
+		var dialer net.Dialer
+
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}
+	}
 
-	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	conn, err := dial(ctx, addr)
 	if err != nil {
 		c.reportError(err)
 		close(c.ready)
@@ -236,6 +373,10 @@ func (c *Client) outbound(ctx context.Context, addr string) {
 
 	c.Logger().Debug("Peer connection closed.")
 
+	if c.node.peerstore != nil {
+		c.node.peerstore.MarkSeen(c.id.PubKey)
+	}
+
 	for _, protocol := range c.node.protocols {
 		if protocol.OnPeerDisconnected == nil {
 			continue
@@ -269,6 +410,10 @@ func (c *Client) inbound(conn net.Conn, addr string) {
 	c.recvLoop()
 	c.close()
 
+	if c.node.peerstore != nil {
+		c.node.peerstore.MarkSeen(c.id.PubKey)
+	}
+
 	for _, protocol := range c.node.protocols {
 		if protocol.OnPeerDisconnected == nil {
 			continue
@@ -337,7 +482,7 @@ func (c *Client) write(data []byte) error {
 	return c.writer.Flush()
 }
 
-func (c *Client) send(nonce uint64, data []byte) error {
+func (c *Client) send(nonce uint64, kind messageKind, data []byte) error {
 	if c.node.idleTimeout > 0 {
 		if err := c.conn.SetWriteDeadline(time.Now().Add(c.node.idleTimeout)); err != nil {
 			return err
@@ -347,7 +492,7 @@ func (c *Client) send(nonce uint64, data []byte) error {
 	c.writerLock.Lock()
 	defer c.writerLock.Unlock()
 
-	data = message{nonce: nonce, data: data}.marshal(c.writerBuf[:0])
+	data = message{nonce: nonce, kind: kind, data: data}.marshal(c.writerBuf[:0])
 
 	if c.suite != nil {
 		var err error
@@ -370,6 +515,13 @@ func (c *Client) send(nonce uint64, data []byte) error {
 	return err
 }
 
+func (c *Client) flush() error {
+	c.writerLock.Lock()
+	defer c.writerLock.Unlock()
+
+	return c.writer.Flush()
+}
+
 func (c *Client) request(ctx context.Context, data []byte) (message, error) {
 	// Figure out an available request nonce.
 
@@ -380,7 +532,9 @@ func (c *Client) request(ctx context.Context, data []byte) (message, error) {
 
 	// Send request.
 
-	if err := c.send(nonce, data); err != nil {
+	start := time.Now()
+
+	if err := c.send(nonce, messageKindDefault, data); err != nil {
 		c.requests.markRequestFailed(nonce)
 		return message{}, err
 	}
@@ -398,9 +552,44 @@ func (c *Client) request(ctx context.Context, data []byte) (message, error) {
 		return message{}, ctx.Err()
 	}
 
+	if c.node.peerstore != nil {
+		c.node.peerstore.UpdateRTT(c.id.PubKey, time.Since(start))
+	}
+
+	if msg.kind == messageKindError {
+		remote, err := UnmarshalRemoteError(msg.data)
+		if err != nil {
+			return message{}, fmt.Errorf("failed to decode error response: %w", err)
+		}
+
+		return message{}, remote
+	}
+
 	return msg, nil
 }
 
+func (c *Client) requestStream(ctx context.Context, data []byte) (*ResponseStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Figure out an available request nonce.
+
+	ch, nonce, err := c.requests.nextStreamNonce(ctx.Done())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Send stream request.
+
+	if err := c.send(nonce, messageKindStreamRequest, data); err != nil {
+		c.requests.markRequestFailed(nonce)
+		cancel()
+		return nil, err
+	}
+
+	return &ResponseStream{client: c, nonce: nonce, frames: ch, ctx: ctx, cancel: cancel}, nil
+}
+
 func (c *Client) handshake() {
 	defer close(c.ready)
 
@@ -474,7 +663,7 @@ func (c *Client) handshake() {
 
 	// Send to our peer for our overlay ID.
 
-	buf := c.node.id.Marshal()
+	buf := c.node.ID().Marshal()
 	buf = append(buf, c.node.Sign(append(buf, shared...))...)
 
 	if err := c.write(buf); err != nil {
@@ -490,15 +679,15 @@ func (c *Client) handshake() {
 		return
 	}
 
-	id, err := cryptographic.UnmarshalID(data)
+	id, n, err := cryptographic.UnmarshalID(data)
 	if err != nil {
 		c.reportError(fmt.Errorf("failed to parse peer id while handling overlay handshake: %v", err))
 		return
 	}
 
-	// Validate the peers ownership of the overlay ID.
+	// Validate the peers ownership of the overlay ID, which is signed as it was encoded by the peer.
 
-	buf = make([]byte, id.Size())
+	buf = make([]byte, n)
 	copy(buf, data)
 
 	if len(data) != len(buf)+cryptographic.SizeSignature {
@@ -515,8 +704,21 @@ func (c *Client) handshake() {
 		return
 	}
 
+	// Validate that the peers overlay ID solves the crypto puzzles this node requires IDs to solve.
+
+	if !c.node.puzzle.VerifyID(id) {
+		c.reportError(errors.New("peer id does not solve the crypto puzzles required by this node"))
+		return
+	}
+
 	c.id = id
 
+	if c.node.peerstore != nil {
+		c.node.peerstore.AddAddress(id.PubKey, id.Address, peerstore.SourceHandshake)
+		c.node.peerstore.SetNonce(id.PubKey, id.Nonce)
+		c.node.peerstore.MarkSeen(id.PubKey)
+	}
+
 	c.SetLogger(c.Logger().With(
 		zap.String("peer_id", id.PubKey.String()),
 		zap.String("peer_addr", id.Address),
@@ -526,6 +728,14 @@ func (c *Client) handshake() {
 
 	c.Logger().Debug("Peer connection opened.")
 
+	// Announce to our peer that we understand message kinds other than the default kind. The announcement is sent
+	// before anything else, such that our peer knows of it by the time it handles any of our requests.
+
+	if err := c.send(0, messageKindHello, nil); err != nil {
+		c.reportError(fmt.Errorf("failed to announce message kinds: %w", err))
+		return
+	}
+
 	for _, protocol := range c.node.protocols {
 		if protocol.OnPeerConnected == nil {
 			continue
@@ -557,16 +767,37 @@ func (c *Client) recvLoop() {
 			break
 		}
 
+		if msg.kind == messageKindHello {
+			c.extended.Store(true)
+
+			continue
+		}
+
 		msg.data = append([]byte{}, msg.data...)
 
-		if ch := c.requests.findRequest(msg.nonce); ch != nil {
-			ch <- msg
-			close(ch)
+		if req := c.requests.findRequest(msg); req != nil {
+			req.deliver(msg)
 
 			continue
 		}
 
-		c.node.work <- HandlerContext{client: c, msg: msg}
+		if msg.kind == messageKindStreamFrame || msg.kind == messageKindStreamEnd || msg.kind == messageKindError {
+			continue // Discard responses to requests that have since been closed or already been responded to.
+		}
+
+		if msg.kind == messageKindStreamRequest && !c.extended.Load() {
+			continue // Discard responses to closed stream requests from peers that predate message kinds.
+		}
+
+		if c.inbox != nil {
+			c.inbox <- HandlerContext{client: c, msg: msg}
+
+			if c.draining.CAS(false, true) {
+				c.node.workers.submit(HandlerContext{client: c})
+			}
+		} else {
+			c.node.workers.submit(HandlerContext{client: c, msg: msg})
+		}
 
 		for _, protocol := range c.node.protocols {
 			if protocol.OnMessageRecv == nil {
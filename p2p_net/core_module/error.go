@@ -0,0 +1,121 @@
+package core_module
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StatusCode classifies a RemoteError sent back by a peer in response to a request.
+type StatusCode uint32
+
+const (
+	// StatusUnknown marks an error that was not classified by the peer that sent it back.
+	StatusUnknown StatusCode = iota
+
+	// StatusInvalidRequest marks a request that was malformed or otherwise could not be handled as-is.
+	StatusInvalidRequest
+
+	// StatusNotFound marks a request for some resource that the peer does not have.
+	StatusNotFound
+
+	// StatusUnavailable marks a request that the peer is temporarily unable to handle, and may be retried.
+	StatusUnavailable
+
+	// StatusInternal marks a request that failed due to some internal error on the peer's side.
+	StatusInternal
+)
+
+// String returns a human-readable name of this status code.
+func (c StatusCode) String() string {
+	switch c {
+	case StatusUnknown:
+		return "unknown"
+	case StatusInvalidRequest:
+		return "invalid request"
+	case StatusNotFound:
+		return "not found"
+	case StatusUnavailable:
+		return "unavailable"
+	case StatusInternal:
+		return "internal"
+	default:
+		return fmt.Sprintf("status %d", uint32(c))
+	}
+}
+
+// RemoteError is a typed error that may be sent back in response to a request or stream request, either through
+// (*HandlerContext).SendError or by returning it from a Handler. It is returned from (*Node).Request,
+// (*Node).RequestMessage, and (*ResponseStream).Err to the peer that made the request, and may be inspected through
+// errors.As.
+type RemoteError struct {
+	// Code classifies the error.
+	Code StatusCode
+
+	// Message is a human-readable description of the error.
+	Message string
+
+	// Details optionally carries arbitrary, application-specific data about the error.
+	Details []byte
+
+	// Disconnect, should a Handler return this error, closes the connection to the peer after the error has been sent
+	// back. It is not sent over the wire.
+	Disconnect bool
+}
+
+// NewRemoteError instantiates a new RemoteError with a status code, a human-readable message, and optional details.
+func NewRemoteError(code StatusCode, message string, details []byte) *RemoteError {
+	return &RemoteError{Code: code, Message: message, Details: details}
+}
+
+// Error implements error and returns the status code and message of this error.
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("peer responded with an error (%s): %s", e.Code, e.Message)
+}
+
+// Marshal serializes this error into its byte representation, which comprises of a 32-bit status code, a 32-bit
+// length-prefixed message, and its details. All integers are big-endian.
+func (e *RemoteError) Marshal() []byte {
+	buf := make([]byte, 8, 8+len(e.Message)+len(e.Details))
+
+	binary.BigEndian.PutUint32(buf[:4], uint32(e.Code))
+	binary.BigEndian.PutUint32(buf[4:8], uint32(len(e.Message)))
+
+	buf = append(buf, e.Message...)
+	buf = append(buf, e.Details...)
+
+	return buf
+}
+
+// UnmarshalRemoteError decodes buf into a RemoteError. It throws io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalRemoteError(buf []byte) (*RemoteError, error) {
+	if len(buf) < 8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	code := StatusCode(binary.BigEndian.Uint32(buf[:4]))
+	size := binary.BigEndian.Uint32(buf[4:8])
+	buf = buf[8:]
+
+	if uint32(len(buf)) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	e := &RemoteError{Code: code, Message: string(buf[:size])}
+
+	if len(buf[size:]) > 0 {
+		e.Details = append([]byte{}, buf[size:]...)
+	}
+
+	return e, nil
+}
+
+func toRemoteError(err error) *RemoteError {
+	var remote *RemoteError
+	if errors.As(err, &remote) {
+		return remote
+	}
+
+	return &RemoteError{Code: StatusUnknown, Message: err.Error()}
+}
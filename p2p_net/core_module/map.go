@@ -3,7 +3,6 @@ package core_module
 import (
 	"container/list"
 	"errors"
-	"math"
 	"sync"
 )
 
@@ -96,21 +95,68 @@ func (c *clientMap) slice() []*Client {
 	return clients
 }
 
+// streamBufferSize is the number of response frames to a stream request that may be buffered before the goroutine
+// reading messages from a peer blocks until they are consumed.
+const streamBufferSize = 64
+
+type pendingRequest struct {
+	ch     chan message
+	done   <-chan struct{}
+	stream bool
+}
+
+func (p *pendingRequest) deliver(msg message) {
+	if !p.stream {
+		p.ch <- msg
+		close(p.ch)
+
+		return
+	}
+
+	select {
+	case p.ch <- msg:
+	case <-p.done:
+		return
+	}
+
+	if msg.kind != messageKindStreamFrame {
+		close(p.ch)
+	}
+}
+
 type requestMap struct {
 	sync.Mutex
-	entries map[uint64]chan message
+	entries map[uint64]*pendingRequest
 	nonce   uint64
 }
 
 func newRequestMap() *requestMap {
-	return &requestMap{entries: make(map[uint64]chan message)}
+	return &requestMap{entries: make(map[uint64]*pendingRequest)}
 }
 
 func (r *requestMap) nextNonce() (<-chan message, uint64, error) {
+	req, nonce, err := r.register(&pendingRequest{ch: make(chan message, 1)})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return req.ch, nonce, nil
+}
+
+func (r *requestMap) nextStreamNonce(done <-chan struct{}) (<-chan message, uint64, error) {
+	req, nonce, err := r.register(&pendingRequest{ch: make(chan message, streamBufferSize), done: done, stream: true})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return req.ch, nonce, nil
+}
+
+func (r *requestMap) register(req *pendingRequest) (*pendingRequest, uint64, error) {
 	r.Lock()
 	defer r.Unlock()
 
-	if r.nonce == math.MaxUint64 {
+	if r.nonce == maxMessageNonce {
 		r.nonce = 0
 	}
 
@@ -121,38 +167,50 @@ func (r *requestMap) nextNonce() (<-chan message, uint64, error) {
 		return nil, 0, errors.New("ran out of available nonce to use for making a new request")
 	}
 
-	ch := make(chan message, 1)
-	r.entries[nonce] = ch
+	r.entries[nonce] = req
 
-	return ch, nonce, nil
+	return req, nonce, nil
 }
 
 func (r *requestMap) markRequestFailed(nonce uint64) {
 	r.Lock()
 	defer r.Unlock()
 
-	close(r.entries[nonce])
-	delete(r.entries, nonce)
+	if req, exists := r.entries[nonce]; exists {
+		close(req.ch)
+		delete(r.entries, nonce)
+	}
 }
 
-func (r *requestMap) findRequest(nonce uint64) chan<- message {
+// findRequest returns the pending request msg is a response to. The pending request is removed unless msg is
+// a response frame to a stream request, as more frames are expected to follow.
+func (r *requestMap) findRequest(msg message) *pendingRequest {
 	r.Lock()
 	defer r.Unlock()
 
-	ch, exists := r.entries[nonce]
-	if exists {
-		delete(r.entries, nonce)
+	req, exists := r.entries[msg.nonce]
+	if exists && !(req.stream && msg.kind == messageKindStreamFrame) {
+		delete(r.entries, msg.nonce)
 	}
 
-	return ch
+	return req
+}
+
+// cancel removes a pending request without closing its channel, such that any responses received for it afterwards
+// are discarded.
+func (r *requestMap) cancel(nonce uint64) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.entries, nonce)
 }
 
 func (r *requestMap) close() {
 	r.Lock()
 	defer r.Unlock()
 
-	for nonce := range r.entries {
-		close(r.entries[nonce])
+	for nonce, req := range r.entries {
+		close(req.ch)
 		delete(r.entries, nonce)
 	}
 }
@@ -1,12 +1,26 @@
 package core_module
 
+import (
+	"context"
+	"net"
+)
+
 // Handler is called whenever a node receives data from either an inbound/outbound peer connection. Multiple handlers
 // may be registered to a node by (*Node).Handle before the node starts listening for new peers.
 //
 // Returning an error in a handler closes the connection and marks the connection to have closed unexpectedly or due
-// to error. Should you intend to wish to skip a handler from processing some given data, return a nil error.
+// to error. A handler that panics is treated as though it returned an error wrapping ErrHandlerPanicked. Should you
+// intend to wish to skip a handler from processing some given data, return a nil error.
+//
+// Should the data be of a request and the error returned be a *RemoteError, the error is instead sent back to the
+// peer as the response to the request, no more handlers are executed for the data, and the connection is kept open
+// unless (*RemoteError).Disconnect is set.
 type Handler func(ctx HandlerContext) error
 
+// Dialer establishes a connection to a peer at addr, over which a node performs its handshake and exchanges messages.
+// A Dialer may be configured on a node by WithNodeDialer so that peers may be reached over transports other than TCP.
+type Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
 // Protocol is an interface that may be implemented by libraries and projects built on top of Noise to hook callbacks
 // onto a series of events that are emitted throughout a nodes lifecycle. They may be registered to a node by
 // (*Node).Bind before the node starts listening for new peers.
@@ -22,6 +36,12 @@ type Protocol struct {
 	// Errors returned from implementations of Bind will propagate back up to (*Node).Listen as a returned error.
 	Bind func(node *Node) error
 
+	// Close is called when the node is closed, after it has stopped listening for new peers. Implementations of Close
+	// should stop all goroutines that were spawned in Bind, and may be called more than once.
+	//
+	// Errors returned from implementations of Close will propagate back up to (*Node).Close as a returned error.
+	Close func() error
+
 	// OnPeerConnected is called when a node successfully receives an incoming peer/connects to an outgoing peer, and
 	// completes noise's protocol handshake.
 	OnPeerConnected func(client *Client)
@@ -38,4 +58,8 @@ type Protocol struct {
 
 	// OnMessageRecv is called whenever a message or response is received from a peer.
 	OnMessageRecv func(client *Client)
+
+	// OnHandlerPanic is called whenever a Handler panics while handling a message received from a peer, with the value
+	// the Handler panicked with and the stack trace of the panic. The connection to the peer is closed afterwards.
+	OnHandlerPanic func(client *Client, recovered interface{}, stack []byte)
 }
@@ -3,6 +3,7 @@ package core_module
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 
 	"awesomeProject/beacon/p2p_network/libs/common"
@@ -12,14 +13,48 @@ import (
 	"go.uber.org/zap"
 )
 
+type messageKind byte
+
+// Message kinds are carried in the most significant byte of the 64-bit nonce prefixing every message, such that
+// messages of the default kind are encoded exactly as they are by peers that predate message kinds. Peers that predate
+// message kinds treat the nonce as opaque, and so respond to a request of any kind with a single response bearing the
+// very same nonce.
+const (
+	messageKindShift = 56
+	maxMessageNonce  = 1<<messageKindShift - 1
+)
+
+const (
+	// messageKindDefault marks a message, a request, or the single response to a request.
+	messageKindDefault messageKind = iota
+
+	// messageKindStreamRequest marks a request which may be responded to with many response frames.
+	messageKindStreamRequest
+
+	// messageKindStreamFrame marks a single response frame sent back to a stream request.
+	messageKindStreamFrame
+
+	// messageKindStreamEnd marks the end of a stream of response frames.
+	messageKindStreamEnd
+
+	// messageKindError marks a RemoteError sent back in response to a request or stream request.
+	messageKindError
+
+	// messageKindHello is sent once after the handshake to announce that message kinds other than the default kind
+	// are understood. Error responses are never sent back to a peer that has not announced so, and stream requests
+	// sent to such a peer are responded to with a single response of the stream request kind.
+	messageKindHello
+)
+
 type message struct {
 	nonce uint64
+	kind  messageKind
 	data  []byte
 }
 
 func (m message) marshal(dst []byte) []byte {
 	dst = append(dst, make([]byte, 8)...)
-	binary.BigEndian.PutUint64(dst[:8], m.nonce)
+	binary.BigEndian.PutUint64(dst[:8], uint64(m.kind)<<messageKindShift|m.nonce&maxMessageNonce)
 	dst = append(dst, m.data...)
 
 	return dst
@@ -33,7 +68,14 @@ func unmarshalMessage(data []byte) (message, error) {
 	nonce := binary.BigEndian.Uint64(data[:8])
 	data = data[8:]
 
-	return message{nonce: nonce, data: data}, nil
+	kind := messageKind(nonce >> messageKindShift)
+	nonce &= maxMessageNonce
+
+	if kind > messageKindHello {
+		return message{}, fmt.Errorf("got message of unknown kind %d", kind)
+	}
+
+	return message{nonce: nonce, kind: kind, data: data}, nil
 }
 
 // HandlerContext provides contextual information upon the recipient of data from an inbound/outbound connection. It
@@ -41,7 +83,8 @@ func unmarshalMessage(data []byte) (message, error) {
 type HandlerContext struct {
 	client *Client
 	msg    message
-	sent   atomic.Bool
+	sent   *atomic.Bool
+	ended  *atomic.Bool
 }
 
 // ID returns the ID of the inbound/outbound peer that sent you the data that is currently being handled.
@@ -49,6 +92,12 @@ func (ctx *HandlerContext) ID() cryptographic.ID {
 	return ctx.client.ID()
 }
 
+// Client returns the inbound/outbound peer connection through which you were sent the data that is currently being
+// handled. It may be used to send messages or requests to the peer over the very same connection.
+func (ctx *HandlerContext) Client() *Client {
+	return ctx.client
+}
+
 // Logger returns the logger instance associated to the inbound/outbound peer being handled.
 func (ctx *HandlerContext) Logger() *zap.Logger {
 	return ctx.client.Logger()
@@ -68,17 +117,99 @@ func (ctx *HandlerContext) IsRequest() bool {
 	return ctx.msg.nonce > 0
 }
 
+// IsStream marks whether or not the data received was intended to be of a stream request, which may be responded
+// to with many response frames through (*HandlerContext).SendFrame before being ended through
+// (*HandlerContext).CloseStream.
+//
+// IsStream may be called concurrently.
+func (ctx *HandlerContext) IsStream() bool {
+	return ctx.msg.kind == messageKindStreamRequest
+}
+
 // Send sends data back to the peer that has sent you data. Should the data the peer send you be of a request, Send
 // will send data back as a response. It returns an error if multiple responses attempt to be sent to a single request,
-// or if an error occurred while attempting to send the peer a message.
+// or if an error occurred while attempting to send the peer a message. Should the data the peer send you be of a
+// stream request, Send sends data back as a single response frame. Refer to (*HandlerContext).SendFrame for more
+// details.
 //
 // Send may be called concurrently.
 func (ctx *HandlerContext) Send(data []byte) error {
+	if ctx.IsStream() {
+		return ctx.SendFrame(data)
+	}
+
 	if ctx.IsRequest() && !ctx.sent.CAS(false, true) {
 		return errors.New("server-side may only send back a single response to a request")
 	}
 
-	return ctx.client.send(ctx.msg.nonce, data)
+	return ctx.client.send(ctx.msg.nonce, messageKindDefault, data)
+}
+
+// SendFrame sends data back as a single response frame to a stream request. Any number of response frames may be
+// sent back, after which the stream must be ended through (*HandlerContext).CloseStream. It returns an error if the
+// data received was not of a stream request, if the stream has already been ended, or if an error occurred while
+// attempting to send the peer a message.
+//
+// SendFrame may be called concurrently, though frames sent concurrently are received in no particular order.
+func (ctx *HandlerContext) SendFrame(data []byte) error {
+	if !ctx.IsStream() {
+		return errors.New("server-side may only send back response frames to a stream request")
+	}
+
+	if ctx.ended.Load() {
+		return errors.New("server-side may not send back response frames to a stream that has ended")
+	}
+
+	return ctx.client.send(ctx.msg.nonce, messageKindStreamFrame, data)
+}
+
+// CloseStream sends back an end-of-stream marker to a stream request, after which no more response frames may be
+// sent back. Should err be non-nil, the stream is instead ended by sending err back as a RemoteError. Refer to
+// (*HandlerContext).SendError for more details. It returns an error if the data received was not of a stream
+// request, if the stream has already been ended, or if an error occurred while attempting to send the peer a message.
+//
+// CloseStream may be called concurrently.
+func (ctx *HandlerContext) CloseStream(err error) error {
+	if !ctx.IsStream() {
+		return errors.New("server-side may only end a stream request")
+	}
+
+	if err != nil {
+		return ctx.SendError(toRemoteError(err))
+	}
+
+	if !ctx.ended.CAS(false, true) {
+		return errors.New("server-side may only end a stream once")
+	}
+
+	return ctx.client.send(ctx.msg.nonce, messageKindStreamEnd, nil)
+}
+
+// SendError sends err back as the response to a request, or as the end of a stream request. The peer that made the
+// request receives err as a *RemoteError returned from (*Node).Request, or from (*ResponseStream).Err. It returns an
+// error if the data received was not of a request, if the peer predates error responses, if a response has already
+// been sent back to the request or the stream request has already been ended, or if an error occurred while
+// attempting to send the peer a message.
+//
+// SendError may be called concurrently.
+func (ctx *HandlerContext) SendError(err *RemoteError) error {
+	if !ctx.IsRequest() {
+		return errors.New("server-side may only send back an error to a request")
+	}
+
+	if !ctx.client.extended.Load() {
+		return errors.New("server-side may not send back an error to a peer that predates error responses")
+	}
+
+	if ctx.IsStream() && !ctx.ended.CAS(false, true) {
+		return errors.New("server-side may only end a stream once")
+	}
+
+	if !ctx.IsStream() && !ctx.sent.CAS(false, true) {
+		return errors.New("server-side may only send back a single response to a request")
+	}
+
+	return ctx.client.send(ctx.msg.nonce, messageKindError, err.Marshal())
 }
 
 // DecodeMessage decodes the raw bytes that some peer has sent you into a Go type. The Go type must have previously
@@ -110,3 +241,17 @@ func (ctx *HandlerContext) SendMessage(msg common.Serializable) error {
 
 	return ctx.Send(data)
 }
+
+// SendFrameMessage encodes and serializes a Go type into a byte slice, and sends it back as a single response frame
+// to a stream request. Refer to (*HandlerContext).SendFrame for more details. An error is thrown if the Go type passed
+// in has not been registered to the node to which the handler this context is under was registered on.
+//
+// SendFrameMessage may be called concurrently.
+func (ctx *HandlerContext) SendFrameMessage(msg common.Serializable) error {
+	data, err := ctx.client.node.EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	return ctx.SendFrame(data)
+}
@@ -1,6 +1,7 @@
 package core_module
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 
 	"awesomeProject/beacon/p2p_network/libs/common"
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/peerstore"
 
 	"github.com/oasislabs/ed25519"
 	"go.uber.org/atomic"
@@ -26,7 +28,10 @@ import (
 //
 // A node at most will only have one goroutine + num configured worker goroutines associated to it which represents
 // the listener looking to accept new incoming peer connections, and workers responsible for handling incoming peer
-// messages. A node once closed or once started (as in, (*Node).Listen was called) should not be reused.
+// messages. Workers are spawned as incoming peer messages queue up, and are released after being idle for some time
+// down to a configured minimum. A worker recovers from any panics in handlers, and keeps handling incoming peer
+// messages after a handler returns an error. A node once closed or once started (as in, (*Node).Listen was called)
+// should not be reused.
 type Node struct {
 	logger *zap.Logger
 
@@ -37,15 +42,28 @@ type Node struct {
 	publicKey  cryptographic.PublicKey
 	privateKey cryptographic.PrivateKey
 
-	id cryptographic.ID
+	id     cryptographic.ID
+	idLock sync.RWMutex
+	addrs  []cryptographic.Addr
+	record cryptographic.Record
+
+	puzzle cryptographic.Puzzle
+	nonce  []byte
 
 	maxDialAttempts        uint
 	maxInboundConnections  uint
 	maxOutboundConnections uint
 	maxRecvMessageSize     uint32
+	minWorkers             uint
 	numWorkers             uint
 
-	idleTimeout time.Duration
+	idleTimeout       time.Duration
+	workerIdleTimeout time.Duration
+
+	orderedHandling bool
+
+	dialer    Dialer
+	peerstore *peerstore.Store
 
 	listener  net.Listener
 	listening atomic.Bool
@@ -57,8 +75,7 @@ type Node struct {
 	protocols []Protocol
 	handlers  []Handler
 
-	workers sync.WaitGroup
-	work    chan HandlerContext
+	workers *workerPool
 
 	listenerDone chan error
 }
@@ -73,7 +90,10 @@ func NewNode(opts ...NodeOption) (*Node, error) {
 		maxInboundConnections:  128,
 		maxOutboundConnections: 128,
 		maxRecvMessageSize:     4 << 20,
+		minWorkers:             1,
 		numWorkers:             uint(runtime.NumCPU()),
+
+		workerIdleTimeout: 10 * time.Second,
 	}
 
 	for _, opt := range opts {
@@ -90,6 +110,22 @@ func NewNode(opts ...NodeOption) (*Node, error) {
 		n.logger = logger.Get()
 	}
 
+	if len(n.addrs) > cryptographic.MaxAddrs {
+		return nil, fmt.Errorf("node may advertise at most %d addresses, but was given %d",
+			cryptographic.MaxAddrs, len(n.addrs),
+		)
+	}
+
+	if n.privateKey == cryptographic.ZeroPrivateKey && n.puzzle != (cryptographic.Puzzle{}) {
+		_, privateKey, nonce, err := n.puzzle.GenerateKeys(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		n.privateKey = privateKey
+		n.nonce = nonce
+	}
+
 	if n.privateKey == cryptographic.ZeroPrivateKey {
 		_, privateKey, err := ed25519.GenerateKey(nil)
 		if err != nil {
@@ -101,8 +137,22 @@ func NewNode(opts ...NodeOption) (*Node, error) {
 
 	copy(n.publicKey[:], ed25519.PrivateKey(n.privateKey[:]).Public().(ed25519.PublicKey)[:])
 
+	if !n.puzzle.VerifyStatic(n.publicKey) {
+		return nil, errors.New("public key of node does not solve the static crypto puzzle")
+	}
+
+	if n.nonce == nil {
+		nonce, err := n.puzzle.Solve(n.publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		n.nonce = nonce
+	}
+
 	if n.id.PubKey == cryptographic.ZeroPublicKey && n.host != nil && n.port > 0 {
 		n.id = cryptographic.NewID(n.publicKey, n.host, n.port)
+		n.id.Nonce = n.nonce
 	}
 
 	n.inbound = newClientMap(n.maxInboundConnections)
@@ -110,6 +160,8 @@ func NewNode(opts ...NodeOption) (*Node, error) {
 
 	n.codec = common.NewCodec()
 
+	n.workers = newWorkerPool(n)
+
 	return n, nil
 }
 
@@ -131,9 +183,13 @@ func (n *Node) Listen() error {
 		}
 	}()
 
-	n.listener, err = net.Listen("tcp", net.JoinHostPort(common.NormalizeIP(n.host), strconv.FormatUint(uint64(n.port), 10)))
-	if err != nil {
-		return err
+	if n.listener == nil {
+		bindAddr := net.JoinHostPort(common.NormalizeIP(n.host), strconv.FormatUint(uint64(n.port), 10))
+
+		n.listener, err = net.Listen("tcp", bindAddr)
+		if err != nil {
+			return err
+		}
 	}
 
 	addr, ok := n.listener.Addr().(*net.TCPAddr)
@@ -148,34 +204,29 @@ func (n *Node) Listen() error {
 	if n.addr == "" {
 		n.addr = net.JoinHostPort(common.NormalizeIP(n.host), strconv.FormatUint(uint64(n.port), 10))
 		n.id = cryptographic.NewID(n.publicKey, n.host, n.port)
+		n.id.Addrs = n.addrs
+		n.id.Nonce = n.nonce
 	} else {
-		resolved, err := common.ResolveAddress(n.addr)
-		if err != nil {
-			_ = n.listener.Close()
-			return err
-		}
-
-		hostStr, portStr, err := net.SplitHostPort(resolved)
+		id, err := n.resolveID(n.addr)
 		if err != nil {
 			_ = n.listener.Close()
 			return err
 		}
 
-		host := net.ParseIP(hostStr)
-		if host == nil {
-			_ = n.listener.Close()
-			return errors.New("host in provided public address is invalid (must be IPv4/IPv6)")
-		}
+		n.id = id
+	}
 
-		port, err := strconv.ParseUint(portStr, 10, 16)
-		if err != nil {
+	if n.peerstore != nil {
+		if err = n.peerstore.Load(); err != nil {
 			_ = n.listener.Close()
 			return err
 		}
-
-		n.id = cryptographic.NewID(n.publicKey, host, uint16(port))
 	}
 
+	// Workers are started before protocols are bound, as protocols may dial peers the very moment they are bound.
+
+	n.workers.start()
+
 	for _, protocol := range n.protocols {
 		if protocol.Bind == nil {
 			continue
@@ -183,39 +234,20 @@ func (n *Node) Listen() error {
 
 		if err = protocol.Bind(n); err != nil {
 			_ = n.listener.Close()
+			n.workers.close()
+
 			return err
 		}
 	}
 
-	n.work = make(chan HandlerContext, int(n.numWorkers))
-	n.workers.Add(int(n.numWorkers))
-
-	for i := uint(0); i < n.numWorkers; i++ {
-		go func() {
-			defer n.workers.Done()
-
-			for ctx := range n.work {
-				for _, handler := range n.handlers {
-					if err := handler(ctx); err != nil {
-						ctx.client.Logger().Warn("Got an error executing a message handler.", zap.Error(err))
-						ctx.client.reportError(err)
-						ctx.client.close()
-
-						return
-					}
-				}
-			}
-		}()
-	}
+	n.listening.Store(true)
 
 	go func() {
-		n.listening.Store(true)
-
 		defer func() {
 			n.inbound.release()
+			n.outbound.release()
 
-			close(n.work)
-			n.workers.Wait()
+			n.workers.close()
 
 			n.listening.Store(false)
 			close(n.listenerDone)
@@ -223,7 +255,7 @@ func (n *Node) Listen() error {
 
 		n.Logger().Info("Listening for incoming peers.",
 			zap.String("bind_addr", addr.String()),
-			zap.String("id_addr", n.id.Address),
+			zap.String("id_addr", n.ID().Address),
 			zap.String("public_key", n.publicKey.String()),
 			zap.String("private_key", n.privateKey.String()),
 		)
@@ -235,18 +267,27 @@ func (n *Node) Listen() error {
 				break
 			}
 
-			addr := conn.RemoteAddr().String()
-
-			client, exists := n.inbound.get(n, addr)
-			if !exists {
-				go client.inbound(conn, addr)
-			}
+			n.Accept(conn)
 		}
 	}()
 
 	return nil
 }
 
+// Accept has the node handshake with and handle messages from a peer over conn as though it were an incoming peer
+// connection accepted by the nodes listener. It is useful for accepting peers over transports other than TCP. The
+// node must already be listening for new peers.
+//
+// Accept may be called concurrently.
+func (n *Node) Accept(conn net.Conn) {
+	addr := conn.RemoteAddr().String()
+
+	client, exists := n.inbound.get(n, addr)
+	if !exists {
+		go client.inbound(conn, addr)
+	}
+}
+
 // RegisterMessage registers a Go type T that implements the Serializable interface with an associated deserialize
 // function whose signature comprises of func([]byte) (T, error). RegisterMessage should be called in the following
 // manner:
@@ -316,6 +357,20 @@ func (n *Node) RequestMessage(ctx context.Context, addr string, req common.Seria
 	return res, nil
 }
 
+// RequestStreamMessage encodes msg which is a Go type registered via (*Node).RegisterMessage, and sends it as a
+// stream request to addr. Response frames may be decoded through (*ResponseStream).DecodeMessage. For more details,
+// refer to (*Node).RequestStream and (*Node).RegisterMessage.
+func (n *Node) RequestStreamMessage(
+	ctx context.Context, addr string, req common.Serializable,
+) (*ResponseStream, error) {
+	data, err := n.EncodeMessage(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	return n.RequestStream(ctx, addr, data)
+}
+
 // Send takes an available connection from this nodes connection pool if the peer at addr has never been connected
 // to before, connects to it, handshakes with the peer, and sends it data.
 //
@@ -331,7 +386,7 @@ func (n *Node) Send(ctx context.Context, addr string, data []byte) error {
 		return err
 	}
 
-	if err := c.send(0, data); err != nil {
+	if err := c.send(0, messageKindDefault, data); err != nil {
 		return err
 	}
 
@@ -358,6 +413,8 @@ func (n *Node) Send(ctx context.Context, addr string, data []byte) error {
 // will follow through. An error is returned if connecting to the peer should it not have been connected to before
 // fails, or if handshaking fails.
 //
+// Should the peer respond with an error, it is returned as a *RemoteError which may be inspected through errors.As.
+//
 // If there is no available connection from this nodes connection pool, the connection that is at the tail of the pool
 // is closed and evicted and used to send a request to addr.
 func (n *Node) Request(ctx context.Context, addr string, data []byte) ([]byte, error) {
@@ -382,6 +439,39 @@ func (n *Node) Request(ctx context.Context, addr string, data []byte) ([]byte, e
 	return msg.data, nil
 }
 
+// RequestStream takes an available connection from this nodes connection pool if the peer at addr has never been
+// connected to before, connects to it, handshakes with the peer, and sends it a stream request should the entire
+// process be successful.
+//
+// Unlike (*Node).Request, the peer may respond to a stream request with any number of response frames before ending
+// the stream. The response frames may be iterated over through the returned *ResponseStream, which must be closed
+// once it is no longer needed. ctx bounds the lifetime of the entire stream.
+//
+// If there already exists a live connection to the peer at addr, no new connection is established and the stream
+// request will follow through. An error is returned if connecting to the peer should it not have been connected to
+// before fails, or if handshaking fails.
+func (n *Node) RequestStream(ctx context.Context, addr string, data []byte) (*ResponseStream, error) {
+	c, err := n.dialIfNotExists(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := c.requestStream(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, protocol := range c.node.protocols {
+		if protocol.OnMessageSent == nil {
+			continue
+		}
+
+		protocol.OnMessageSent(c)
+	}
+
+	return stream, nil
+}
+
 // Ping takes an available connection from this nodes connection pool if the peer at addr has never been connected
 // to before, connects to it, handshakes with the peer, and returns a *Client instance should the entire process
 // be successful.
@@ -400,7 +490,8 @@ func (n *Node) Ping(ctx context.Context, addr string) (*Client, error) {
 
 // Close gracefully stops all live inbound/outbound peer connections registered on this node, and stops the node
 // from handling/accepting new incoming peer connections. It returns an error if an error occurs closing the nodes
-// listener. Nodes that are closed should not ever be re-used.
+// listener. Nodes that are closed should not ever be re-used. Once all peer connections have been stopped, all
+// protocols bound to the node are closed, and should the node be configured with a peerstore, the peerstore is saved.
 //
 // Close may be called concurrently.
 func (n *Node) Close() error {
@@ -412,7 +503,25 @@ func (n *Node) Close() error {
 
 	<-n.listenerDone
 
-	return nil
+	var err error
+
+	for _, protocol := range n.protocols {
+		if protocol.Close == nil {
+			continue
+		}
+
+		if cerr := protocol.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	if n.peerstore != nil {
+		if serr := n.peerstore.Save(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	return err
 }
 
 func (n *Node) dialIfNotExists(ctx context.Context, addr string) (*Client, error) {
@@ -457,6 +566,10 @@ func (n *Node) dialIfNotExists(ctx context.Context, addr string) (*Client, error
 
 	err = fmt.Errorf("attempted to dial %s several times but failed: %v", addr, err)
 
+	if n.peerstore != nil {
+		n.peerstore.MarkDialFailure(addr)
+	}
+
 	for _, protocol := range n.protocols {
 		if protocol.OnPingFailed == nil {
 			continue
@@ -521,9 +634,99 @@ func (n *Node) Outbound() []*Client {
 //
 // Addr may be called concurrently.
 func (n *Node) Addr() string {
+	n.idLock.RLock()
+	defer n.idLock.RUnlock()
+
 	return n.addr
 }
 
+// SetAddress updates the public address of this node, which is advertised on the ID sent to peers during the
+// handshake protocol for all connections established afterwards. It is useful should the node learn of its publicly
+// reachable address only after (*Node).Listen has been called, such as when the node is behind a NAT. It returns an
+// error if addr is not a valid 'host:port' address.
+//
+// SetAddress may be called concurrently.
+func (n *Node) SetAddress(addr string) error {
+	id, err := n.resolveID(addr)
+	if err != nil {
+		return err
+	}
+
+	n.idLock.Lock()
+	defer n.idLock.Unlock()
+
+	n.addr = addr
+	n.id = id
+
+	return nil
+}
+
+func (n *Node) resolveID(addr string) (cryptographic.ID, error) {
+	resolved, err := common.ResolveAddress(addr)
+	if err != nil {
+		return cryptographic.ID{}, err
+	}
+
+	hostStr, portStr, err := net.SplitHostPort(resolved)
+	if err != nil {
+		return cryptographic.ID{}, err
+	}
+
+	host := net.ParseIP(hostStr)
+	if host == nil {
+		return cryptographic.ID{}, errors.New("host in provided public address is invalid (must be IPv4/IPv6)")
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return cryptographic.ID{}, err
+	}
+
+	id := cryptographic.NewID(n.publicKey, host, uint16(port))
+	id.Addrs = n.addrs
+	id.Nonce = n.nonce
+
+	return id, nil
+}
+
+// Puzzle returns the difficulty of the S/Kademlia crypto puzzles the ID of this node solves, and which the IDs of
+// peers are required to solve in order to complete a handshake with this node.
+func (n *Node) Puzzle() cryptographic.Puzzle {
+	return n.puzzle
+}
+
+// Record returns a peer record signed by this node which vouches for the ID of this node. The record is re-signed
+// under a higher sequence number whenever the ID of this node changes, such as through (*Node).SetAddress. Sequence
+// numbers are derived from the current time, such that records signed after the node restarts supersede records
+// signed before.
+//
+// Record may be called concurrently.
+func (n *Node) Record() cryptographic.Record {
+	n.idLock.Lock()
+	defer n.idLock.Unlock()
+
+	if n.record.Seq > 0 && bytes.Equal(n.record.ID.Marshal(), n.id.Marshal()) {
+		return n.record
+	}
+
+	seq := uint64(time.Now().UnixNano())
+	if seq <= n.record.Seq {
+		seq = n.record.Seq + 1
+	}
+
+	n.record = cryptographic.NewRecord(n.id, seq, n.privateKey)
+
+	return n.record
+}
+
+// Peerstore returns the peerstore this node records all that it learns about peers to, or nil should the node not
+// be configured with a peerstore through WithNodePeerstore.
+//
+// Peerstore may be called concurrently.
+func (n *Node) Peerstore() *peerstore.Store {
+	return n.peerstore
+}
+
 // Logger returns the underlying logger associated to this node. The logger, should it not be configured through the
 // WithNodeLogger functional option when calling NewNode, is by default zap.NewNop().
 //
@@ -537,5 +740,8 @@ func (n *Node) Logger() *zap.Logger {
 //
 // ID may be called concurrently.
 func (n *Node) ID() cryptographic.ID {
+	n.idLock.RLock()
+	defer n.idLock.RUnlock()
+
 	return n.id
 }
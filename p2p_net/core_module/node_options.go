@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/peerstore"
 
 	"go.uber.org/zap"
 )
@@ -73,6 +74,34 @@ func WithNodeNumWorkers(numWorkers uint) NodeOption {
 	}
 }
 
+// WithNodeMinWorkers sets the min number of workers a node keeps around to handle incoming peer messages, should the
+// workers be idle. By default, the min number of workers is 1. The min number of workers is capped to the max number
+// of workers configured through WithNodeNumWorkers.
+func WithNodeMinWorkers(minWorkers uint) NodeOption {
+	return func(n *Node) {
+		n.minWorkers = minWorkers
+	}
+}
+
+// WithNodeWorkerIdleTimeout sets the duration a worker goes without handling any incoming peer messages before it
+// is released, should there be more workers than the min number of workers configured through WithNodeMinWorkers.
+// By default, the timeout is set to be 10 seconds. If an idle timeout of 0 is specified, workers are never released
+// until the node is closed.
+func WithNodeWorkerIdleTimeout(workerIdleTimeout time.Duration) NodeOption {
+	return func(n *Node) {
+		n.workerIdleTimeout = workerIdleTimeout
+	}
+}
+
+// WithNodeOrderedHandling sets whether or not messages received from a single peer are to be handled sequentially
+// in the order they were received. Messages from different peers are still handled in parallel by the workers a node
+// spawns. By default, messages received from a single peer are handled in parallel, and thus in no particular order.
+func WithNodeOrderedHandling(orderedHandling bool) NodeOption {
+	return func(n *Node) {
+		n.orderedHandling = orderedHandling
+	}
+}
+
 // WithNodeIdleTimeout sets the duration in which should there be no subsequent reads/writes on a connection, the
 // connection shall timeout and have resources related to it released. By default, the timeout is set to be 3 seconds.
 // If an idle timeout of 0 is specified, idle timeouts will be disabled.
@@ -135,3 +164,51 @@ func WithNodeAddress(addr string) NodeOption {
 		n.addr = addr
 	}
 }
+
+// WithNodeDialer sets the Dialer a node uses to establish outgoing connections to peers. By default, peers are dialed
+// over TCP. A Dialer may choose to dial some addresses over TCP and others over a different transport.
+func WithNodeDialer(dialer Dialer) NodeOption {
+	return func(n *Node) {
+		n.dialer = dialer
+	}
+}
+
+// WithNodeListener sets the listener a node accepts incoming peers from once (*Node).Listen is called. The address of
+// the listener must be a *net.TCPAddr, which is taken to be the binding host and port of the node. By default, a node
+// listens for incoming peers over TCP on its binding host and port.
+func WithNodeListener(listener net.Listener) NodeOption {
+	return func(n *Node) {
+		n.listener = listener
+	}
+}
+
+// WithNodePeerstore sets the peerstore a node records all that it learns about peers to, such as the addresses peers
+// advertise, the last time peers were seen or failed to be dialed, and the round-trip time of requests sent to peers.
+// The peerstore is loaded when the node starts listening for new peers, and saved when the node is closed. By
+// default, a node does not record anything it learns about peers.
+func WithNodePeerstore(store *peerstore.Store) NodeOption {
+	return func(n *Node) {
+		n.peerstore = store
+	}
+}
+
+// WithNodeAddrs sets other typed addresses a node advertises on its ID that it may be reached at, in addition to its
+// public address, such as a DNS name or a relay address. Note that peers running versions of this library that
+// predate the versioned encoding of IDs are unable to handshake with a node advertising other addresses. By default,
+// a node only advertises its public address.
+func WithNodeAddrs(addrs ...cryptographic.Addr) NodeOption {
+	return func(n *Node) {
+		n.addrs = addrs
+	}
+}
+
+// WithNodePuzzle sets the difficulty of the S/Kademlia static and dynamic crypto puzzles the ID of a node must solve,
+// and which the IDs of peers must solve in order to complete a handshake with the node. Should no private key be set
+// for the node, one whose public key solves the static puzzle is generated. Otherwise, the public key of the node
+// must solve the static puzzle. The dynamic puzzle is solved upon the instantiation of the node. By default, the
+// difficulty of both puzzles is zero, such that all IDs solve them.
+func WithNodePuzzle(puzzle cryptographic.Puzzle) NodeOption {
+	return func(n *Node) {
+		n.puzzle = puzzle
+	}
+}
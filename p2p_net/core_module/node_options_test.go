@@ -1,6 +1,7 @@
 package core_module
 
 import (
+	"context"
 	"net"
 	"testing"
 	"testing/quick"
@@ -186,4 +187,98 @@ func TestNodeOptions(t *testing.T) {
 	}
 
 	assert.NoError(t, quick.Check(i, &quick.Config{MaxCount: 10}))
+
+	j := func(a uint, b time.Duration) bool {
+		n, err := NewNode(WithNodeMinWorkers(a), WithNodeWorkerIdleTimeout(b))
+		if !assert.NoError(t, err) {
+			return false
+		}
+
+		if !assert.EqualValues(t, n.minWorkers, a) {
+			return false
+		}
+
+		if !assert.EqualValues(t, n.workerIdleTimeout, b) {
+			return false
+		}
+
+		return true
+	}
+
+	assert.NoError(t, quick.Check(j, &quick.Config{MaxCount: 10}))
+
+	k := func(a bool) bool {
+		n, err := NewNode(WithNodeOrderedHandling(a))
+		if !assert.NoError(t, err) {
+			return false
+		}
+
+		if !assert.EqualValues(t, n.orderedHandling, a) {
+			return false
+		}
+
+		return true
+	}
+
+	assert.NoError(t, quick.Check(k, &quick.Config{MaxCount: 10}))
+
+	l := func(a bool) bool {
+		var dialer Dialer
+		if a {
+			dialer = func(ctx context.Context, addr string) (net.Conn, error) { return nil, nil }
+		}
+
+		n, err := NewNode(WithNodeDialer(dialer))
+		if !assert.NoError(t, err) {
+			return false
+		}
+
+		if !assert.Equal(t, a, n.dialer != nil) {
+			return false
+		}
+
+		return true
+	}
+
+	assert.NoError(t, quick.Check(l, &quick.Config{MaxCount: 10}))
+
+	m := func(values []string) bool {
+		addrs := make([]cryptographic.Addr, 0, len(values))
+		for _, value := range values {
+			addrs = append(addrs, cryptographic.NewAddr(value))
+		}
+
+		n, err := NewNode(WithNodeAddrs(addrs...))
+		if !assert.NoError(t, err) {
+			return false
+		}
+
+		if !assert.EqualValues(t, n.addrs, addrs) {
+			return false
+		}
+
+		return true
+	}
+
+	assert.NoError(t, quick.Check(m, &quick.Config{MaxCount: 10}))
+
+	_, err := NewNode(WithNodeAddrs(make([]cryptographic.Addr, cryptographic.MaxAddrs+1)...))
+	assert.Error(t, err)
+
+	n := func(c1, c2 uint8) bool {
+		puzzle := cryptographic.Puzzle{C1: int(c1 % 6), C2: int(c2 % 6)}
+
+		n, err := NewNode(WithNodePuzzle(puzzle))
+		if !assert.NoError(t, err) {
+			return false
+		}
+
+		if !assert.Equal(t, puzzle, n.Puzzle()) || !assert.True(t, puzzle.Verify(n.publicKey, n.nonce)) {
+			return false
+		}
+
+		return true
+	}
+
+	assert.NoError(t, quick.Check(n, &quick.Config{MaxCount: 10}))
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
@@ -185,6 +186,176 @@ func TestRPCWithTwoPeersUnderMaxNumConnections(t *testing.T) {
 	}
 }
 
+func TestRequestStream(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	count := 100
+
+	a, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer a.Close()
+
+	expected := errors.New("stream was cut short")
+
+	a.Handle(func(ctx core_module.HandlerContext) error {
+		if !ctx.IsStream() {
+			assert.Error(t, ctx.CloseStream(expected))
+			return ctx.Send([]byte("not a stream"))
+		}
+
+		for i := 0; i < count; i++ {
+			if err := ctx.SendFrame([]byte(fmt.Sprintf("frame %d", i))); err != nil {
+				return err
+			}
+		}
+
+		switch string(ctx.Data()) {
+		case "fail":
+			return ctx.CloseStream(expected)
+		case "unterminated":
+			return nil
+		}
+
+		return ctx.CloseStream(nil)
+	})
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer b.Close()
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+
+	stream, err := b.RequestStream(context.TODO(), a.Addr(), []byte("hello"))
+	assert.NoError(t, err)
+
+	i := 0
+	for ; stream.Next(); i++ {
+		assert.EqualValues(t, fmt.Sprintf("frame %d", i), stream.Data())
+	}
+
+	assert.NoError(t, stream.Err())
+	assert.Equal(t, count, i)
+	assert.False(t, stream.Next())
+
+	stream.Close()
+
+	stream, err = b.RequestStream(context.TODO(), a.Addr(), []byte("fail"))
+	assert.NoError(t, err)
+
+	for i = 0; stream.Next(); i++ {
+	}
+
+	var remote *core_module.RemoteError
+	assert.True(t, errors.As(stream.Err(), &remote))
+	assert.Equal(t, core_module.StatusUnknown, remote.Code)
+	assert.Equal(t, expected.Error(), remote.Message)
+	assert.Equal(t, count, i)
+
+	stream.Close()
+
+	// Streams left unterminated by handlers should be ended once handlers return.
+
+	stream, err = b.RequestStream(context.TODO(), a.Addr(), []byte("unterminated"))
+	assert.NoError(t, err)
+
+	for i = 0; stream.Next(); i++ {
+	}
+
+	assert.NoError(t, stream.Err())
+	assert.Equal(t, count, i)
+
+	stream.Close()
+
+	// Closing a stream early should not stall the connection for other requests.
+
+	stream, err = b.RequestStream(context.TODO(), a.Addr(), []byte("hello"))
+	assert.NoError(t, err)
+	assert.True(t, stream.Next())
+
+	stream.Close()
+
+	data, err := b.Request(context.TODO(), a.Addr(), []byte("hello"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, []byte("not a stream"), data)
+}
+
+func TestRemoteError(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	a, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer a.Close()
+
+	a.Handle(func(ctx core_module.HandlerContext) error {
+		switch string(ctx.Data()) {
+		case "missing":
+			return core_module.NewRemoteError(core_module.StatusNotFound, "no such thing", []byte("details"))
+		case "disconnect":
+			return &core_module.RemoteError{Code: core_module.StatusInvalidRequest, Message: "go away", Disconnect: true}
+		case "sent":
+			if err := ctx.Send(ctx.Data()); err != nil {
+				return err
+			}
+
+			return core_module.NewRemoteError(core_module.StatusInternal, "failed after responding", nil)
+		default:
+			return ctx.Send(ctx.Data())
+		}
+	})
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer b.Close()
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+
+	_, err = b.Request(context.TODO(), a.Addr(), []byte("missing"))
+
+	var remote *core_module.RemoteError
+	assert.True(t, errors.As(err, &remote))
+	assert.Equal(t, core_module.StatusNotFound, remote.Code)
+	assert.Equal(t, "no such thing", remote.Message)
+	assert.EqualValues(t, []byte("details"), remote.Details)
+
+	// The connection should stay open after an error response.
+
+	data, err := b.Request(context.TODO(), a.Addr(), []byte("hello"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, []byte("hello"), data)
+
+	assert.Len(t, a.Inbound(), 1)
+	assert.Len(t, b.Outbound(), 1)
+
+	ab, ba := a.Inbound()[0], b.Outbound()[0]
+
+	_, err = b.Request(context.TODO(), a.Addr(), []byte("disconnect"))
+	assert.True(t, errors.As(err, &remote))
+	assert.Equal(t, core_module.StatusInvalidRequest, remote.Code)
+
+	ab.WaitUntilClosed()
+	ba.WaitUntilClosed()
+
+	assert.Len(t, a.Inbound(), 0)
+	assert.Len(t, b.Outbound(), 0)
+
+	// An error returned by a handler after it has responded should not be sent back as a second response, and
+	// instead closes the connection like any other error that may not be sent back.
+
+	data, err = b.Request(context.TODO(), a.Addr(), []byte("sent"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, []byte("sent"), data)
+
+	assert.Eventually(t, func() bool {
+		return len(a.Inbound()) == 0 && len(b.Outbound()) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
 func TestCloseClientFromServerSide(t *testing.T) {
 	defer goleak.VerifyNone(t)
 
@@ -356,6 +527,180 @@ func TestHandlerErrorCausesConnToClose(t *testing.T) {
 	assert.Len(t, b.Outbound(), 0)
 }
 
+func TestHandlerPanicIsRecovered(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	panicked := make(chan []byte, 1)
+
+	a, err := core_module.NewNode(core_module.WithNodeNumWorkers(1))
+	assert.NoError(t, err)
+
+	defer a.Close()
+
+	a.Bind(core_module.Protocol{
+		OnHandlerPanic: func(client *core_module.Client, recovered interface{}, stack []byte) {
+			panicked <- stack
+		},
+	})
+
+	a.Handle(func(ctx core_module.HandlerContext) error {
+		switch string(ctx.Data()) {
+		case "panic":
+			panic("ack")
+		case "fail":
+			return errors.New("ack")
+		default:
+			return ctx.Send(ctx.Data())
+		}
+	})
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer b.Close()
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+
+	// A single worker should survive both a panicking handler and a handler returning an error, and keep on
+	// handling messages from new connections afterwards.
+
+	for _, data := range []string{"panic", "fail"} {
+		assert.NoError(t, b.Send(context.TODO(), a.Addr(), []byte(data)))
+
+		ab, ba := a.Inbound()[0], b.Outbound()[0]
+
+		ab.WaitUntilClosed()
+		ba.WaitUntilClosed()
+
+		if data == "panic" {
+			assert.True(t, errors.Is(ab.Error(), core_module.ErrHandlerPanicked))
+			assert.NotEmpty(t, <-panicked)
+		}
+
+		res, err := b.Request(context.TODO(), a.Addr(), []byte("hello"))
+		assert.NoError(t, err)
+		assert.EqualValues(t, []byte("hello"), res)
+	}
+}
+
+func TestWorkersGrowWithQueueDepth(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	count := 4
+
+	a, err := core_module.NewNode(
+		core_module.WithNodeMinWorkers(1),
+		core_module.WithNodeNumWorkers(uint(count)),
+		core_module.WithNodeWorkerIdleTimeout(10*time.Millisecond),
+	)
+	assert.NoError(t, err)
+
+	defer a.Close()
+
+	// Every handler blocks until all handlers are executing at once, which only happens should the worker pool grow
+	// from 1 worker to count workers.
+
+	var wg sync.WaitGroup
+	wg.Add(count)
+
+	a.Handle(func(ctx core_module.HandlerContext) error {
+		wg.Done()
+		wg.Wait()
+
+		return ctx.Send(ctx.Data())
+	})
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer b.Close()
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+
+	var done sync.WaitGroup
+	done.Add(count)
+
+	for i := 0; i < count; i++ {
+		go func() {
+			defer done.Done()
+
+			_, err := b.Request(context.TODO(), a.Addr(), []byte("hello"))
+			assert.NoError(t, err)
+		}()
+	}
+
+	done.Wait()
+}
+
+func TestOrderedHandling(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	count := 1000
+
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]int)
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(2 * count)
+
+	a, err := core_module.NewNode(core_module.WithNodeOrderedHandling(true), core_module.WithNodeNumWorkers(4))
+	assert.NoError(t, err)
+
+	defer a.Close()
+
+	a.Handle(func(ctx core_module.HandlerContext) error {
+		defer wg.Done()
+
+		var i int
+		_, err := fmt.Sscanf(string(ctx.Data()), "%d", &i)
+		assert.NoError(t, err)
+
+		mu.Lock()
+		received[ctx.ID().PubKey.String()] = append(received[ctx.ID().PubKey.String()], i)
+		mu.Unlock()
+
+		return nil
+	})
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer b.Close()
+
+	c, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer c.Close()
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+	assert.NoError(t, c.Listen())
+
+	for _, node := range []*core_module.Node{b, c} {
+		node := node
+
+		go func() {
+			for i := 0; i < count; i++ {
+				assert.NoError(t, node.Send(context.TODO(), a.Addr(), []byte(fmt.Sprintf("%d", i))))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Len(t, received, 2)
+
+	for _, seq := range received {
+		for i := range seq {
+			assert.Equal(t, i, seq[i])
+		}
+	}
+}
+
 func TestWithNodeMaxRecvMessageSize(t *testing.T) {
 	// Set the limit to 1MB.
 
@@ -421,3 +766,143 @@ func BenchmarkSend(b *testing.B) {
 		}
 	})
 }
+
+func TestHandshakeWithAddrs(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	addrs := []cryptographic.Addr{
+		cryptographic.NewAddr("node.example.com:3000"),
+		cryptographic.NewAddr("/var/run/node.sock"),
+	}
+
+	a, err := core_module.NewNode(core_module.WithNodeAddrs(addrs...))
+	assert.NoError(t, err)
+	defer a.Close()
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+
+	client, err := a.Ping(context.TODO(), b.Addr())
+	assert.NoError(t, err)
+	assert.Equal(t, b.ID().Address, client.ID().Address)
+	assert.Empty(t, client.ID().Addrs)
+
+	client, err = b.Ping(context.TODO(), a.Addr())
+	assert.NoError(t, err)
+	assert.Equal(t, a.ID().Address, client.ID().Address)
+	assert.Equal(t, addrs, client.ID().Addrs)
+}
+
+func TestHandshakeWithPuzzle(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	puzzle := cryptographic.Puzzle{C1: 4, C2: 4}
+
+	_, privateKey, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	for puzzle.VerifyStatic(privateKey.Public()) {
+		_, privateKey, err = cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+	}
+
+	// A node may not be instantiated with a private key that does not solve the static puzzle.
+
+	_, err = core_module.NewNode(core_module.WithNodePrivateKey(privateKey), core_module.WithNodePuzzle(puzzle))
+	assert.Error(t, err)
+
+	a, err := core_module.NewNode(core_module.WithNodePuzzle(puzzle), core_module.WithNodeMaxDialAttempts(1))
+	assert.NoError(t, err)
+	defer a.Close()
+
+	b, err := core_module.NewNode(core_module.WithNodePuzzle(puzzle))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	c, err := core_module.NewNode(core_module.WithNodePrivateKey(privateKey))
+	assert.NoError(t, err)
+	defer c.Close()
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+	assert.NoError(t, c.Listen())
+
+	client, err := a.Ping(context.TODO(), b.Addr())
+	assert.NoError(t, err)
+	assert.True(t, puzzle.VerifyID(client.ID()))
+
+	// A node that requires the puzzles to be solved fails to handshake with a node whose ID does not solve them.
+
+	_, err = a.Ping(context.TODO(), c.Addr())
+	assert.Error(t, err)
+}
+
+func TestProtocolClose(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	n, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	closed := 0
+
+	n.Bind(core_module.Protocol{
+		Close: func() error {
+			closed++
+			return errors.New("failed to close")
+		},
+	})
+
+	assert.NoError(t, n.Listen())
+	assert.EqualError(t, n.Close(), "failed to close")
+	assert.Equal(t, 1, closed)
+}
+
+func TestProtocolBindReceivesMessages(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	a, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer a.Close()
+
+	a.Handle(func(ctx core_module.HandlerContext) error {
+		return ctx.Send([]byte("hello b!"))
+	})
+
+	assert.NoError(t, a.Listen())
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	defer b.Close()
+
+	received := make(chan struct{}, 1)
+
+	b.Handle(func(ctx core_module.HandlerContext) error {
+		received <- struct{}{}
+		return nil
+	})
+
+	// A protocol that messages a peer the very moment it is bound should have the peers reply handled.
+
+	b.Bind(core_module.Protocol{
+		Bind: func(node *core_module.Node) error {
+			if err := node.Send(context.TODO(), a.Addr(), []byte("hello a!")); err != nil {
+				return err
+			}
+
+			select {
+			case <-received:
+				return nil
+			case <-time.After(3 * time.Second):
+				return errors.New("reply was not handled")
+			}
+		},
+	})
+
+	assert.NoError(t, b.Listen())
+}
@@ -0,0 +1,131 @@
+package core_module
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"awesomeProject/beacon/p2p_network/libs/common"
+)
+
+// ResponseStream iterates over the response frames a peer sends back to a stream request made through
+// (*Node).RequestStream. It is to be used in the following manner:
+//
+//	for stream.Next() {
+//	    frame := stream.Data()
+//	}
+//
+//	if err := stream.Err(); err != nil {
+//	    ...
+//	}
+//
+// A stream must be released through (*ResponseStream).Close once it is no longer needed, as the connection to the
+// peer otherwise blocks on response frames that are never consumed once too many of them have been buffered.
+type ResponseStream struct {
+	client *Client
+	nonce  uint64
+	frames <-chan message
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	data  []byte
+	last  bool
+	ended bool
+	err   error
+}
+
+// Next blocks the current goroutine until the next response frame is received, after which it may be read through
+// (*ResponseStream).Data. It returns false once the peer has ended the stream, the context the stream request was
+// made with was canceled/expired, or the connection was dropped. Should it return false, (*ResponseStream).Err
+// reports why.
+func (s *ResponseStream) Next() bool {
+	if s.last {
+		s.ended = true
+		s.data = nil
+	}
+
+	if s.ended || s.err != nil {
+		return false
+	}
+
+	var (
+		msg message
+		ok  bool
+	)
+
+	select {
+	case msg, ok = <-s.frames:
+	case <-s.ctx.Done():
+		s.err = s.ctx.Err()
+		return false
+	case <-s.client.readerDone:
+		select {
+		case msg, ok = <-s.frames: // Drain any frames received before the connection was dropped.
+		default:
+			s.err = io.ErrUnexpectedEOF
+			return false
+		}
+	}
+
+	if !ok {
+		s.err = io.ErrUnexpectedEOF
+		return false
+	}
+
+	switch msg.kind {
+	case messageKindStreamEnd:
+		s.ended = true
+		s.data = nil
+
+		return false
+	case messageKindError:
+		s.ended = true
+		s.data = nil
+
+		remote, err := UnmarshalRemoteError(msg.data)
+		if err != nil {
+			s.err = fmt.Errorf("failed to decode error response: %w", err)
+		} else {
+			s.err = remote
+		}
+
+		return false
+	case messageKindStreamRequest:
+		// Peers that predate stream requests respond to them with a single response that bears the kind of the
+		// stream request, after which the stream is to be treated as having ended.
+
+		s.last = true
+		s.data = msg.data
+	default:
+		s.data = msg.data
+	}
+
+	return true
+}
+
+// Data returns the raw bytes of the response frame that was last received through (*ResponseStream).Next.
+func (s *ResponseStream) Data() []byte {
+	return s.data
+}
+
+// DecodeMessage decodes the response frame that was last received through (*ResponseStream).Next into a Go type
+// registered via (*Node).RegisterMessage.
+func (s *ResponseStream) DecodeMessage() (common.Serializable, error) {
+	return s.client.node.DecodeMessage(s.data)
+}
+
+// Err returns the error that caused (*ResponseStream).Next to return false. It returns nil should the peer have
+// ended the stream without an error, or a *RemoteError should the peer have ended the stream with an error.
+func (s *ResponseStream) Err() error {
+	return s.err
+}
+
+// Close releases all resources associated to this stream. Any response frames that are received afterwards are
+// discarded.
+//
+// Close may be called concurrently.
+func (s *ResponseStream) Close() {
+	s.client.requests.cancel(s.nonce)
+	s.cancel()
+}
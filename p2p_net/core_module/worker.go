@@ -0,0 +1,266 @@
+package core_module
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// workerPool is an elastic pool of goroutines which execute the handlers registered on a node against incoming peer
+// messages. The pool grows up to a max number of workers whenever a message is submitted with no idle worker to
+// handle it, and shrinks back down to a min number of workers as workers go idle. Messages submitted while the pool
+// is at its max size queue up until a worker frees up.
+type workerPool struct {
+	node *Node
+
+	min, max    uint32
+	idleTimeout time.Duration
+
+	work chan HandlerContext
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	size atomic.Uint32
+}
+
+func newWorkerPool(node *Node) *workerPool {
+	p := &workerPool{
+		node: node,
+
+		min:         uint32(node.minWorkers),
+		max:         uint32(node.numWorkers),
+		idleTimeout: node.workerIdleTimeout,
+	}
+
+	if p.min > p.max {
+		p.min = p.max
+	}
+
+	p.work = make(chan HandlerContext)
+	p.done = make(chan struct{})
+
+	return p
+}
+
+// start spawns the min number of workers the pool keeps around. Work may be submitted to the pool before it is
+// started, in which case workers are spawned as needed.
+func (p *workerPool) start() {
+	for i := uint32(0); i < p.min; i++ {
+		p.grow()
+	}
+}
+
+// submit hands ctx off to an idle worker. Should there be no idle worker available, a new worker is spawned should
+// the pool not be at its max size, and the current goroutine blocks until a worker is available to handle ctx. Work
+// submitted after the pool is closed is dropped.
+func (p *workerPool) submit(ctx HandlerContext) {
+	select {
+	case p.work <- ctx:
+		return
+	case <-p.done:
+		return
+	default:
+	}
+
+	p.grow()
+
+	select {
+	case p.work <- ctx:
+	case <-p.done:
+	}
+}
+
+// close stops accepting work, and waits until all workers have finished handling the work handed off to them.
+func (p *workerPool) close() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+func (p *workerPool) grow() {
+	for {
+		size := p.size.Load()
+		if size >= p.max {
+			return
+		}
+
+		if p.size.CAS(size, size+1) {
+			break
+		}
+	}
+
+	p.wg.Add(1)
+
+	go p.run()
+}
+
+func (p *workerPool) shrink() bool {
+	for {
+		size := p.size.Load()
+		if size <= p.min {
+			return false
+		}
+
+		if p.size.CAS(size, size-1) {
+			return true
+		}
+	}
+}
+
+func (p *workerPool) run() {
+	defer p.wg.Done()
+
+	var (
+		timer   *time.Timer
+		timeout <-chan time.Time
+	)
+
+	if p.idleTimeout > 0 {
+		timer = time.NewTimer(p.idleTimeout)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case <-p.done:
+			p.size.Dec()
+			return
+		case ctx := <-p.work:
+			if p.node.orderedHandling {
+				p.drain(ctx.client)
+			} else {
+				p.handle(ctx)
+			}
+
+			if timer != nil && !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timeout:
+			if p.shrink() {
+				return
+			}
+		}
+
+		if timer != nil {
+			timer.Reset(p.idleTimeout)
+		}
+	}
+}
+
+// drain sequentially handles all messages queued up in the inbox of client, should the node be configured to handle
+// messages from a single peer in order. Only one worker at a time may drain the inbox of a single client.
+func (p *workerPool) drain(client *Client) {
+	for {
+	inbox:
+		for {
+			select {
+			case ctx := <-client.inbox:
+				p.handle(ctx)
+			default:
+				break inbox
+			}
+		}
+
+		client.draining.Store(false)
+
+		// Messages may have been queued up in between the inbox being drained and the inbox being marked as no longer
+		// being drained.
+
+		if len(client.inbox) == 0 || !client.draining.CAS(false, true) {
+			return
+		}
+	}
+}
+
+// handle executes all handlers registered on the node against ctx. Should a handler panic, the panic is recovered,
+// and reported as though the handler returned an error.
+func (p *workerPool) handle(ctx HandlerContext) {
+	// Handlers are passed copies of ctx, and so whether a response has been sent back or a stream has been ended is
+	// shared between them and the worker through pointers.
+
+	ctx.sent = atomic.NewBool(false)
+
+	if ctx.IsStream() {
+		ctx.ended = atomic.NewBool(false)
+	}
+
+	for _, handler := range p.node.handlers {
+		err := p.execute(handler, ctx)
+		if err == nil {
+			continue
+		}
+
+		var remote *RemoteError
+
+		if errors.As(err, &remote) && ctx.IsRequest() {
+			// Should the error not be sent back, the connection is closed such that the peer is not left waiting on
+			// a response that never arrives.
+
+			if err := ctx.SendError(remote); err != nil {
+				ctx.client.Logger().Warn("Got an error sending back an error response.", zap.Error(err))
+			} else if !remote.Disconnect {
+				return
+			}
+		}
+
+		// Responses sent back before the handler returned are flushed before the connection is closed.
+
+		_ = ctx.client.flush()
+
+		ctx.client.Logger().Warn("Got an error executing a message handler.", zap.Error(err))
+		ctx.client.reportError(err)
+		ctx.client.close()
+
+		return
+	}
+
+	// End stream requests that handlers have not ended, such that the peer is not left waiting on frames that never
+	// arrive.
+
+	if ctx.IsStream() && !ctx.ended.Load() {
+		if err := ctx.CloseStream(nil); err != nil {
+			ctx.client.Logger().Warn("Got an error ending a stream request.", zap.Error(err))
+		}
+	}
+}
+
+func (p *workerPool) execute(handler Handler, ctx HandlerContext) (err error) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		stack := debug.Stack()
+
+		ctx.client.Logger().Error("Recovered from a panic executing a message handler.",
+			zap.Any("panic", recovered),
+			zap.ByteString("stack", stack),
+		)
+
+		for _, protocol := range p.node.protocols {
+			if protocol.OnHandlerPanic == nil {
+				continue
+			}
+
+			protocol.OnHandlerPanic(ctx.client, recovered, stack)
+		}
+
+		err = fmt.Errorf("%w: %v", ErrHandlerPanicked, recovered)
+	}()
+
+	return handler(ctx)
+}
+
+// ErrHandlerPanicked is reported by a client whose connection was closed because a handler panicked while handling
+// data received from it.
+var ErrHandlerPanicked = errors.New("handler panicked")
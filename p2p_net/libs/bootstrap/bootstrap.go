@@ -0,0 +1,354 @@
+// Package bootstrap implements a bootstrap manager, which connects your node to an overlay network through a set of
+// seed peers, and keeps it connected by re-bootstrapping whenever your node is connected to too few peers, or
+// whenever the routing table of your node holds too few peers.
+//
+// Seed addresses may be configured by hand, read from a file, or resolved from the DNS TXT records of a set of domain
+// names. Seeds are dialed in parallel with exponential backoff, after which peers are discovered through the seeds
+// that were reached by executing a lookup for the ID of your node through the Kademlia overlay network.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/kademlia"
+
+	"go.uber.org/zap"
+)
+
+// ErrNoSeeds is returned by (*Manager).Bootstrap should no seed addresses be configured, or should all sources of
+// seed addresses fail to be read or resolved.
+var ErrNoSeeds = errors.New("no seeds to bootstrap from")
+
+// ErrSeedsUnreachable is returned by (*Manager).Bootstrap should no seed be reached.
+var ErrSeedsUnreachable = errors.New("failed to reach any seed")
+
+// Manager bootstraps your node into a Kademlia overlay network, and re-bootstraps your node whenever it is connected
+// to fewer peers, or its routing table holds fewer peers, than configured. It is expected that Manager is bound to a
+// .Node via (*.Node).Bind alongside the Kademlia overlay it bootstraps, before the node starts listening for incoming
+// peers.
+type Manager struct {
+	overlay *kademlia.Protocol
+	node    *core_module.Node
+	logger  *zap.Logger
+	events  Events
+
+	seeds    []string
+	seedFile string
+	dnsNames []string
+	resolver Resolver
+
+	minPeers      int
+	minTableSize  int
+	checkInterval time.Duration
+
+	dialTimeout  time.Duration
+	dialAttempts int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+
+	rounds  sync.Mutex
+	trigger chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New returns a new bootstrap manager which bootstraps through overlay.
+func New(overlay *kademlia.Protocol, opts ...Option) *Manager {
+	m := &Manager{
+		overlay:  overlay,
+		resolver: net.DefaultResolver,
+
+		minPeers:      1,
+		minTableSize:  1,
+		checkInterval: time.Minute,
+
+		dialTimeout:  3 * time.Second,
+		dialAttempts: 3,
+		backoff:      500 * time.Millisecond,
+		maxBackoff:   10 * time.Second,
+
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Protocol returns a .Protocol that may registered to a node via (*.Node).Bind.
+func (m *Manager) Protocol() core_module.Protocol {
+	return core_module.Protocol{
+		Bind:               m.Bind,
+		Close:              m.Close,
+		OnPeerDisconnected: m.OnPeerDisconnected,
+	}
+}
+
+// Bind starts a goroutine which bootstraps your node straight away, and then checks at the interval configured
+// through WithCheckInterval, and whenever a peer disconnects, whether your node must re-bootstrap, until
+// (*Manager).Close is called.
+func (m *Manager) Bind(node *core_module.Node) error {
+	m.node = node
+
+	if m.logger == nil {
+		m.logger = node.Logger()
+	}
+
+	m.wg.Add(1)
+	go m.maintain()
+
+	return nil
+}
+
+// Close stops the goroutine started by (*Manager).Bind, and aborts any bootstrap round in progress. It may be called
+// more than once.
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+
+	m.wg.Wait()
+
+	return nil
+}
+
+// OnPeerDisconnected has your node check whether it must re-bootstrap.
+func (m *Manager) OnPeerDisconnected(*core_module.Client) {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Healthy returns true should your node be connected to, and should its routing table hold, at least as many peers as
+// configured through WithMinPeers and WithMinTableSize.
+func (m *Manager) Healthy() bool {
+	connected := make(map[cryptographic.PublicKey]struct{})
+
+	for _, client := range append(m.node.Inbound(), m.node.Outbound()...) {
+		connected[client.ID().PubKey] = struct{}{}
+	}
+
+	delete(connected, cryptographic.PublicKey{}) // Clients that have yet to complete their handshake.
+
+	// The routing table always holds the ID of your node.
+
+	return len(connected) >= m.minPeers && m.overlay.Table().NumEntries()-1 >= m.minTableSize
+}
+
+// Bootstrap executes a single bootstrap round. All seeds are dialed in parallel, each up to as many times as
+// configured through WithDialAttempts with exponential backoff in between attempts, after which peers are discovered
+// through the seeds that were reached. Rounds never run concurrently; should a round already be in progress,
+// Bootstrap waits for it to complete before starting another.
+//
+// It returns the IDs of the peers discovered. It returns ErrNoSeeds should there be no seeds, or ErrSeedsUnreachable
+// should no seed be reached. Should ctx be cancelled or expire, the round is aborted.
+func (m *Manager) Bootstrap(ctx context.Context) ([]cryptographic.ID, error) {
+	m.rounds.Lock()
+	defer m.rounds.Unlock()
+
+	seeds, err := m.Seeds(ctx)
+	if err != nil {
+		m.logger.Warn("Failed to load some seeds.", zap.Error(err))
+	}
+
+	if len(seeds) == 0 {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNoSeeds, err)
+		}
+
+		return nil, ErrNoSeeds
+	}
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		reached []string
+		last    error
+	)
+
+	for _, addr := range seeds {
+		addr := addr
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := m.dial(ctx, addr)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				last = err
+				return
+			}
+
+			reached = append(reached, addr)
+		}()
+	}
+
+	wg.Wait()
+
+	if len(reached) == 0 {
+		return nil, fmt.Errorf("%w out of %d seed(s): %v", ErrSeedsUnreachable, len(seeds), last)
+	}
+
+	discovered := m.overlay.Find(ctx, m.node.ID().PubKey)
+
+	m.logger.Debug("Bootstrapped.", zap.Strings("seeds", reached), zap.Int("num_discovered", len(discovered)))
+
+	if m.events.OnBootstrapped != nil {
+		m.events.OnBootstrapped(reached, discovered)
+	}
+
+	return discovered, nil
+}
+
+// dial pings the seed at addr, retrying with exponential backoff should it fail to respond.
+func (m *Manager) dial(ctx context.Context, addr string) error {
+	var err error
+
+	delay := m.backoff
+
+	for attempt := 1; ; attempt++ {
+		dialCtx, cancel := context.WithTimeout(ctx, m.dialTimeout)
+		err = m.overlay.Ping(dialCtx, addr)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt == m.dialAttempts || !m.sleep(ctx, delay) {
+			break
+		}
+
+		if delay *= 2; delay > m.maxBackoff {
+			delay = m.maxBackoff
+		}
+	}
+
+	m.logger.Debug("Failed to reach seed.", zap.String("seed_addr", addr), zap.Error(err))
+
+	if m.events.OnSeedFailed != nil {
+		m.events.OnSeedFailed(addr, err)
+	}
+
+	return err
+}
+
+// sleep blocks the current goroutine for d, and returns false should ctx be done or (*Manager).Close be called
+// beforehand.
+func (m *Manager) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-m.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// maintain bootstraps your node should it not be healthy, and checks again whether it is healthy at the configured
+// interval and whenever a peer disconnects. Bootstrap rounds that fail to reach any seed are retried with exponential
+// backoff, capped to the configured interval, during which disconnecting peers are ignored.
+func (m *Manager) maintain() {
+	defer m.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-m.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var (
+		failures int
+		retryAt  time.Time
+	)
+
+	check := func() time.Duration {
+		if time.Now().Before(retryAt) {
+			return time.Until(retryAt)
+		}
+
+		if m.Healthy() {
+			return m.checkInterval
+		}
+
+		if _, err := m.Bootstrap(ctx); err != nil {
+			switch {
+			case ctx.Err() != nil:
+			case errors.Is(err, ErrNoSeeds) && len(m.seeds) == 0 && m.seedFile == "" && len(m.dnsNames) == 0:
+				m.logger.Debug("Not bootstrapping as no seeds are configured.")
+			default:
+				m.logger.Warn("Failed to bootstrap.", zap.Error(err))
+			}
+
+			delay := m.backoff
+			for i := 0; i < failures && delay < m.maxBackoff; i++ {
+				delay *= 2
+			}
+
+			if delay > m.maxBackoff {
+				delay = m.maxBackoff
+			}
+
+			if delay > m.checkInterval {
+				delay = m.checkInterval
+			}
+
+			failures++
+
+			retryAt = time.Now().Add(delay)
+
+			return delay
+		}
+
+		failures, retryAt = 0, time.Time{}
+
+		return m.checkInterval
+	}
+
+	timer := time.NewTimer(check())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-timer.C:
+		case <-m.trigger:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		timer.Reset(check())
+	}
+}
@@ -0,0 +1,181 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/kademlia"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func newOverlay(t *testing.T, protocols ...func(overlay *kademlia.Protocol) core_module.Protocol) (
+	*core_module.Node, *kademlia.Protocol,
+) {
+	t.Helper()
+
+	node, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	overlay := kademlia.New()
+	node.Bind(overlay.Protocol())
+
+	for _, protocol := range protocols {
+		node.Bind(protocol(overlay))
+	}
+
+	assert.NoError(t, node.Listen())
+
+	return node, overlay
+}
+
+func TestSeeds(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "seeds")
+	assert.NoError(t, os.WriteFile(path, []byte("# Seeds.\n\n127.0.0.1:3001\n 127.0.0.1:3002 \n127.0.0.1:3000\n"), 0644))
+
+	resolver := StaticResolver{
+		"seeds.example.com":  {"127.0.0.1:3003, 127.0.0.1:3004", "127.0.0.1:3001"},
+		"seeds2.example.com": {"127.0.0.1:3005"},
+	}
+
+	m := New(nil,
+		WithSeeds("127.0.0.1:3000"),
+		WithSeedFile(path),
+		WithDNSSeeds("seeds.example.com", "missing.example.com", "seeds2.example.com"),
+		WithResolver(resolver),
+	)
+
+	seeds, err := m.Seeds(context.TODO())
+	assert.Error(t, err)
+
+	var dnsErr *net.DNSError
+	assert.True(t, errors.As(err, &dnsErr))
+
+	assert.Equal(t, []string{
+		"127.0.0.1:3000", "127.0.0.1:3001", "127.0.0.1:3002", "127.0.0.1:3003", "127.0.0.1:3004", "127.0.0.1:3005",
+	}, seeds)
+
+	// A seed file that does not exist is skipped.
+
+	m = New(nil, WithSeeds("127.0.0.1:3000"), WithSeedFile(filepath.Join(t.TempDir(), "missing")))
+
+	seeds, err = m.Seeds(context.TODO())
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, []string{"127.0.0.1:3000"}, seeds)
+}
+
+func TestBootstrap(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	seed, seedOverlay := newOverlay(t)
+	defer seed.Close()
+
+	peer, peerOverlay := newOverlay(t)
+	defer peer.Close()
+
+	assert.NoError(t, peerOverlay.Ping(context.TODO(), seed.Addr()))
+
+	assert.Eventually(t, func() bool {
+		return seedOverlay.Table().Recorded(peer.ID().PubKey)
+	}, 3*time.Second, 10*time.Millisecond)
+
+	var (
+		lock         sync.Mutex
+		bootstrapped [][]cryptographic.ID
+		failed       []string
+	)
+
+	events := Events{
+		OnBootstrapped: func(seeds []string, discovered []cryptographic.ID) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			assert.Equal(t, []string{seed.Addr()}, seeds)
+			bootstrapped = append(bootstrapped, discovered)
+		},
+		OnSeedFailed: func(addr string, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			failed = append(failed, addr)
+		},
+	}
+
+	var m *Manager
+
+	node, overlay := newOverlay(t, func(overlay *kademlia.Protocol) core_module.Protocol {
+		m = New(overlay,
+			WithEvents(events),
+			WithSeeds(seed.Addr(), "127.0.0.1:1"),
+			WithDialAttempts(2),
+			WithBackoff(10*time.Millisecond, 20*time.Millisecond),
+		)
+
+		return m.Protocol()
+	})
+	defer node.Close()
+
+	// Your node bootstraps straight away, and discovers peers through the seeds that were reached.
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return len(bootstrapped) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	assert.True(t, overlay.Table().Recorded(seed.ID().PubKey))
+	assert.True(t, overlay.Table().Recorded(peer.ID().PubKey))
+	assert.True(t, m.Healthy())
+
+	lock.Lock()
+	assert.Equal(t, []string{"127.0.0.1:1"}, failed)
+	lock.Unlock()
+
+	// Your node re-bootstraps should it no longer be connected to any peer.
+
+	for _, client := range append(node.Inbound(), node.Outbound()...) {
+		client.Close()
+		client.WaitUntilClosed()
+	}
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return len(bootstrapped) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestBootstrapFailures(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	node, overlay := newOverlay(t)
+	defer node.Close()
+
+	m := New(overlay)
+	m.node, m.logger = node, node.Logger()
+
+	_, err := m.Bootstrap(context.TODO())
+	assert.True(t, errors.Is(err, ErrNoSeeds))
+
+	m = New(overlay, WithSeeds("127.0.0.1:1"), WithDialAttempts(3), WithBackoff(10*time.Millisecond, time.Second))
+	m.node, m.logger = node, node.Logger()
+
+	start := time.Now()
+
+	_, err = m.Bootstrap(context.TODO())
+	assert.True(t, errors.Is(err, ErrSeedsUnreachable))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(30*time.Millisecond)) // Backs off for 10ms, then 20ms.
+}
@@ -0,0 +1,134 @@
+package bootstrap
+
+import (
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/zap"
+)
+
+// Events comprise of callbacks that may be hooked against by a user to handle events that occur throughout the
+// lifecycle of a bootstrap manager.
+type Events struct {
+	// OnBootstrapped is called whenever a bootstrap round completes having reached at least one seed, with the
+	// addresses of the seeds that were reached, and the IDs of the peers discovered through them.
+	OnBootstrapped func(seeds []string, discovered []cryptographic.ID)
+
+	// OnSeedFailed is called whenever a seed fails to be reached after as many attempts as configured through
+	// WithDialAttempts.
+	OnSeedFailed func(addr string, err error)
+}
+
+// Option is a functional option that may be configured when instantiating a new bootstrap manager.
+type Option func(m *Manager)
+
+// WithEvents registers a batch of callbacks onto a single bootstrap manager.
+func WithEvents(events Events) Option {
+	return func(m *Manager) {
+		m.events = events
+	}
+}
+
+// WithLogger configures the logger instance for a bootstrap manager. By default, the logger used is the logger of
+// the node which the bootstrap manager is bound to.
+func WithLogger(logger *zap.Logger) Option {
+	return func(m *Manager) {
+		m.logger = logger
+	}
+}
+
+// WithSeeds sets the addresses of the seeds your node bootstraps from. By default, no seeds are configured.
+func WithSeeds(addrs ...string) Option {
+	return func(m *Manager) {
+		m.seeds = addrs
+	}
+}
+
+// WithSeedFile sets a file that lists the addresses of seeds your node bootstraps from, one address per line. Blank
+// lines, and lines starting with '#', are ignored. The file is re-read upon every bootstrap round, such that seeds may
+// be changed without restarting your node. By default, no seed file is configured.
+func WithSeedFile(path string) Option {
+	return func(m *Manager) {
+		m.seedFile = path
+	}
+}
+
+// WithDNSSeeds sets domain names whose DNS TXT records list the addresses of seeds your node bootstraps from. Each
+// TXT record may list several addresses separated by whitespace or commas. Records are resolved upon every bootstrap
+// round through the resolver configured through WithResolver. By default, no domain names are configured.
+func WithDNSSeeds(names ...string) Option {
+	return func(m *Manager) {
+		m.dnsNames = names
+	}
+}
+
+// WithResolver sets the resolver DNS TXT records of the domain names configured through WithDNSSeeds are resolved
+// through. By default, net.DefaultResolver is used.
+func WithResolver(resolver Resolver) Option {
+	return func(m *Manager) {
+		m.resolver = resolver
+	}
+}
+
+// WithMinPeers sets the number of distinct peers your node must be connected to. Should your node be connected to
+// fewer peers, it re-bootstraps. By default, it is set to 1.
+func WithMinPeers(peers int) Option {
+	return func(m *Manager) {
+		m.minPeers = peers
+	}
+}
+
+// WithMinTableSize sets the number of peers the routing table of your node must hold. Should it hold fewer peers,
+// your node re-bootstraps. By default, it is set to 1.
+func WithMinTableSize(size int) Option {
+	return func(m *Manager) {
+		m.minTableSize = size
+	}
+}
+
+// WithCheckInterval sets the interval at which your node checks whether it must re-bootstrap. Your node also checks
+// whenever a peer disconnects from it. By default, it is set to 1 minute.
+func WithCheckInterval(interval time.Duration) Option {
+	return func(m *Manager) {
+		if interval <= 0 {
+			return
+		}
+
+		m.checkInterval = interval
+	}
+}
+
+// WithDialTimeout sets the max amount of time a single attempt to dial a seed may take. By default, it is set to 3
+// seconds.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(m *Manager) {
+		m.dialTimeout = timeout
+	}
+}
+
+// WithDialAttempts sets the max number of times a seed is dialed in a single bootstrap round before it is considered
+// unreachable. By default, it is set to 3.
+func WithDialAttempts(attempts int) Option {
+	return func(m *Manager) {
+		if attempts < 1 {
+			attempts = 1
+		}
+
+		m.dialAttempts = attempts
+	}
+}
+
+// WithBackoff sets the delay before a seed is dialed again after failing to be dialed, which doubles after every
+// failed attempt up to max. The same delay applies between bootstrap rounds that fail to reach any seed, though it
+// never exceeds the interval configured through WithCheckInterval. By default, it is set to 500 milliseconds, and max
+// is set to 10 seconds.
+func WithBackoff(initial, max time.Duration) Option {
+	return func(m *Manager) {
+		if initial <= 0 || max < initial {
+			return
+		}
+
+		m.backoff, m.maxBackoff = initial, max
+	}
+}
@@ -0,0 +1,120 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// Resolver resolves the DNS TXT records of a domain name. *net.Resolver implements Resolver.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// StaticResolver is a Resolver that resolves domain names to the TXT records they are mapped to, without making any
+// DNS queries. It is useful as a local stand-in for DNS in tests.
+type StaticResolver map[string][]string
+
+// LookupTXT returns the TXT records name is mapped to, or a *net.DNSError should name not be mapped to any records.
+func (r StaticResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, exists := r[name]
+	if !exists {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	return records, nil
+}
+
+// Seeds returns the addresses of all seeds configured through WithSeeds, listed in the file configured through
+// WithSeedFile, and listed in the DNS TXT records of the domain names configured through WithDNSSeeds, in that order,
+// with duplicates and the address of your node removed. Sources that fail to be read or resolved are skipped, and
+// the first error encountered is returned alongside the addresses of all seeds from the other sources.
+func (m *Manager) Seeds(ctx context.Context) ([]string, error) {
+	var (
+		addrs []string
+		first error
+	)
+
+	addrs = append(addrs, m.seeds...)
+
+	if m.seedFile != "" {
+		file, err := readSeedFile(m.seedFile)
+		if err != nil {
+			first = err
+		}
+
+		addrs = append(addrs, file...)
+	}
+
+	for _, name := range m.dnsNames {
+		records, err := m.resolver.LookupTXT(ctx, name)
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("failed to resolve dns seeds of %q: %w", name, err)
+			}
+
+			continue
+		}
+
+		for _, record := range records {
+			addrs = append(addrs, splitAddrs(record)...)
+		}
+	}
+
+	seen := make(map[string]struct{}, len(addrs))
+	seeds := addrs[:0]
+
+	for _, addr := range addrs {
+		if _, exists := seen[addr]; exists {
+			continue
+		}
+
+		seen[addr] = struct{}{}
+
+		if m.node != nil && addr == m.node.Addr() {
+			continue
+		}
+
+		seeds = append(seeds, addr)
+	}
+
+	return seeds, first
+}
+
+// readSeedFile returns the addresses listed in the seed file at path.
+func readSeedFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open seed file: %w", err)
+	}
+	defer file.Close()
+
+	var addrs []string
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		addrs = append(addrs, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return addrs, fmt.Errorf("failed to read seed file: %w", err)
+	}
+
+	return addrs, nil
+}
+
+// splitAddrs splits the addresses listed in a single DNS TXT record.
+func splitAddrs(record string) []string {
+	return strings.FieldsFunc(record, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
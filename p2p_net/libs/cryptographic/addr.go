@@ -0,0 +1,78 @@
+package cryptographic
+
+import (
+	"net"
+	"strings"
+)
+
+// AddrType marks the transport over which, and the way in which, an Addr is to be dialed.
+type AddrType uint8
+
+const (
+	// AddrTypeUnknown marks an address of a type not known to this version of the library. Such addresses are kept
+	// as-is when decoding and re-encoding IDs, but are never dialed.
+	AddrTypeUnknown AddrType = iota
+
+	// AddrTypeTCP marks an address of the form 'ip:port' dialed over TCP.
+	AddrTypeTCP
+
+	// AddrTypeDNS marks an address of the form 'hostname:port', whose hostname is resolved over DNS, dialed over TCP.
+	AddrTypeDNS
+
+	// AddrTypeUnix marks an address that is the path to a Unix domain socket.
+	AddrTypeUnix
+
+	// AddrTypeRelay marks an address of the form '<relay address>/relay/<hex-encoded public key>' dialed through a
+	// circuit opened on a relay.
+	AddrTypeRelay
+)
+
+// String returns a human-readable name for this address type.
+func (t AddrType) String() string {
+	switch t {
+	case AddrTypeTCP:
+		return "tcp"
+	case AddrTypeDNS:
+		return "dns"
+	case AddrTypeUnix:
+		return "unix"
+	case AddrTypeRelay:
+		return "relay"
+	default:
+		return "unknown"
+	}
+}
+
+// Addr is a typed network address a peer may be reached at.
+type Addr struct {
+	Type  AddrType `json:"type"`
+	Value string   `json:"value"`
+}
+
+// NewAddr returns an Addr whose type is inferred from the form of value. Values of the form 'host:port' are typed as
+// TCP or DNS addresses depending on whether host is an IP, values containing '/relay/' are typed as relay addresses,
+// and absolute paths are typed as Unix domain socket addresses.
+func NewAddr(value string) Addr {
+	switch {
+	case strings.Contains(value, "/relay/"):
+		return Addr{Type: AddrTypeRelay, Value: value}
+	case strings.HasPrefix(value, "/"):
+		return Addr{Type: AddrTypeUnix, Value: value}
+	}
+
+	host, _, err := net.SplitHostPort(value)
+	if err != nil {
+		return Addr{Type: AddrTypeUnknown, Value: value}
+	}
+
+	if host == "" || net.ParseIP(host) != nil {
+		return Addr{Type: AddrTypeTCP, Value: value}
+	}
+
+	return Addr{Type: AddrTypeDNS, Value: value}
+}
+
+// String returns the value of this address.
+func (a Addr) String() string {
+	return a.Value
+}
@@ -1,9 +1,13 @@
 package cryptographic
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net"
 	"strconv"
 	"strings"
@@ -11,9 +15,42 @@ import (
 	"awesomeProject/beacon/p2p_network/libs/common"
 )
 
-// ID represents a peer ID. It comprises of a cryptographic public key, and a public, reachable network address
-// specified by a IPv4/IPv6 host and 16-bit port number. The size of an ID in terms of its byte representation
-// is static, with its contents being deterministic.
+var (
+	// ErrUnsupportedIDVersion is returned when decoding an ID encoded in a version of the versioned layout that is
+	// not supported by this version of the library.
+	ErrUnsupportedIDVersion = errors.New("unsupported id version")
+
+	// ErrUnsupportedKeyType is returned when decoding an ID bearing a public key of a type that is not supported by
+	// this version of the library.
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+)
+
+// KeyType marks the signature scheme of the public key borne by an ID.
+type KeyType uint8
+
+// KeyTypeEd25519 marks an Ed25519 public key, which is the only key type supported by this version of the library.
+const KeyTypeEd25519 KeyType = 1
+
+// IDVersion is the latest version of the versioned encoding of IDs.
+const IDVersion = 1
+
+// MaxAddrs is the max number of typed addresses an ID may bear other than its primary address. Typed addresses past
+// the limit are dropped while encoding an ID.
+const MaxAddrs = math.MaxUint16
+
+// sizeLegacyID is the size of the legacy encoding of an ID, which comprises of a public key, a 16-byte host, and a
+// 16-bit port.
+const sizeLegacyID = SizePublicKey + net.IPv6len + 2
+
+// idMagic prefixes the versioned encoding of an ID, and is what tells it apart from the legacy encoding of an ID.
+var idMagic = [4]byte{0x00, 'p', 'i', 'd'}
+
+// ID represents a peer ID. It comprises of a cryptographic public key, a public, reachable network address specified
+// by a IPv4/IPv6 host and 16-bit port number, and optionally many other typed addresses the bearer may be reached at.
+//
+// IDs that bear only a host and port are encoded in a legacy layout of static size that is understood by all
+// versions of this library. All other IDs are encoded in a versioned, length-prefixed layout that carries the primary
+// address, and all other typed addresses, of the bearer.
 type ID struct {
 	// The Ed25519 public key of the bearer of this ID.
 	PubKey PublicKey `json:"public_key"`
@@ -24,8 +61,14 @@ type ID struct {
 	// Public port of the bearer of this ID.
 	Port uint16
 
-	// 'host:port'
+	// The primary address of the bearer of this ID, which is 'host:port' unless specified otherwise.
 	Address string
+
+	// Addrs lists other typed addresses the bearer of this ID may be reached at.
+	Addrs []Addr `json:"addrs,omitempty"`
+
+	// Nonce is the solution of the bearer of this ID to the S/Kademlia dynamic crypto puzzle. See Puzzle.
+	Nonce []byte `json:"nonce,omitempty"`
 }
 
 // NewID instantiates a new, immutable cryptographic user ID.
@@ -34,9 +77,38 @@ func NewID(pubKey PublicKey, host net.IP, port uint16) ID {
 	return ID{PubKey: pubKey, Host: host, Port: port, Address: addr}
 }
 
-// Size returns the number of bytes this ID comprises of.
+// Legacy returns true should this ID be encoded in the legacy layout, which is the case should it bear no addresses
+// other than its host and port, and no puzzle nonce. IDs whose public key begins with the magic of the versioned
+// layout are never encoded in the legacy layout, as they would otherwise be mistaken for being versioned.
+func (i ID) Legacy() bool {
+	if len(i.Addrs) > 0 || len(i.Nonce) > 0 || bytes.HasPrefix(i.PubKey[:], idMagic[:]) {
+		return false
+	}
+
+	return i.Address == "" || i.Address == NewID(i.PubKey, i.Host, i.Port).Address
+}
+
+// Size returns the number of bytes this ID comprises of once encoded.
 func (i ID) Size() int {
-	return len(i.PubKey) + net.IPv6len + 2
+	if i.Legacy() {
+		return sizeLegacyID
+	}
+
+	size := len(idMagic) + 1 + 4  // Magic, version, and body length.
+	size += 1 + 2 + len(i.PubKey) // Key type, key length, and key.
+	size += net.IPv6len + 2       // Host, and port.
+	size += 2 + len(truncate(i.Address))
+	size += 2
+
+	for _, addr := range truncateAddrs(i.Addrs) {
+		size += 1 + 2 + len(truncate(addr.Value))
+	}
+
+	if len(i.Nonce) > 0 {
+		size += 2 + len(truncateBytes(i.Nonce))
+	}
+
+	return size
 }
 
 // String returns a JSON representation of this ID.
@@ -50,9 +122,62 @@ func (i ID) String() string {
 	return builder.String()
 }
 
-// Marshal serializes this ID into its byte representation.
+// Marshal serializes this ID into its byte representation. The legacy layout is used should the ID bear no addresses
+// other than its host and port, and the versioned layout is used otherwise.
+//
+// The versioned layout comprises of a 4-byte magic, a version byte, and the length of the remainder as a 32-bit
+// big-endian integer. The remainder comprises of a key type byte, a length-prefixed public key, a 16-byte host, a
+// 16-bit port, a length-prefixed primary address, and a 16-bit count of typed addresses which each comprise of a
+// type byte and a length-prefixed value. Should the ID bear a puzzle nonce, the remainder is followed by the
+// length-prefixed nonce, which decoders that predate it skip over. All lengths are 16-bit big-endian integers.
 func (i ID) Marshal() []byte {
-	buf := make([]byte, i.Size())
+	if i.Legacy() {
+		return i.MarshalLegacy()
+	}
+
+	buf := make([]byte, 0, i.Size())
+
+	buf = append(buf, idMagic[:]...)
+	buf = append(buf, IDVersion, 0, 0, 0, 0)
+
+	buf = append(buf, byte(KeyTypeEd25519))
+	buf = appendUint16(buf, uint16(len(i.PubKey)))
+	buf = append(buf, i.PubKey[:]...)
+
+	host := make([]byte, net.IPv6len)
+	copy(host, i.Host)
+
+	buf = append(buf, host...)
+	buf = appendUint16(buf, i.Port)
+
+	buf = appendString(buf, i.Address)
+
+	addrs := truncateAddrs(i.Addrs)
+
+	buf = appendUint16(buf, uint16(len(addrs)))
+
+	for _, addr := range addrs {
+		buf = append(buf, byte(addr.Type))
+		buf = appendString(buf, addr.Value)
+	}
+
+	if len(i.Nonce) > 0 {
+		nonce := truncateBytes(i.Nonce)
+
+		buf = appendUint16(buf, uint16(len(nonce)))
+		buf = append(buf, nonce...)
+	}
+
+	binary.BigEndian.PutUint32(buf[len(idMagic)+1:len(idMagic)+5], uint32(len(buf)-len(idMagic)-5))
+
+	return buf
+}
+
+// MarshalLegacy serializes this ID into the legacy layout regardless of whether it bears any addresses other than its
+// host and port, or a puzzle nonce, which are dropped. It is useful for sending IDs to peers running older versions
+// of this library, which only decode the legacy layout.
+func (i ID) MarshalLegacy() []byte {
+	buf := make([]byte, sizeLegacyID)
 
 	copy(buf[:len(i.PubKey)], i.PubKey[:])
 	copy(buf[len(i.PubKey):len(i.PubKey)+net.IPv6len], i.Host)
@@ -61,9 +186,127 @@ func (i ID) Marshal() []byte {
 	return buf
 }
 
-// UnmarshalID deserializes buf, representing a slice of bytes, ID instance. It throws io.ErrUnexpectedEOF if the
-// contents of buf is malformed.
-func UnmarshalID(buf []byte) (ID, error) {
+// UnmarshalID deserializes buf, representing a slice of bytes, ID instance. Both the legacy and versioned layouts are
+// accepted, with the versioned layout being told apart by its magic. Should buf be prefixed by the magic, it must
+// decode as a versioned ID, and ErrUnsupportedIDVersion or ErrUnsupportedKeyType is returned should it be of a version
+// or bear a key type unknown to this version of the library. It throws io.ErrUnexpectedEOF if the contents of buf is
+// malformed.
+//
+// It returns the number of bytes of buf that the ID was decoded from, which may differ from (ID).Size of the ID
+// returned should the ID have been encoded with fields unknown to this version of the library. Data following an ID
+// must always be read from the number of bytes returned onwards.
+func UnmarshalID(buf []byte) (ID, int, error) {
+	if len(buf) >= len(idMagic) && bytes.Equal(buf[:len(idMagic)], idMagic[:]) {
+		id, n, err := unmarshalVersionedID(buf[len(idMagic):])
+		if err != nil {
+			return ID{}, 0, err
+		}
+
+		return id, len(idMagic) + n, nil
+	}
+
+	id, err := unmarshalLegacyID(buf)
+	if err != nil {
+		return ID{}, 0, err
+	}
+
+	return id, sizeLegacyID, nil
+}
+
+func unmarshalVersionedID(buf []byte) (ID, int, error) {
+	if len(buf) < 5 {
+		return ID{}, 0, io.ErrUnexpectedEOF
+	}
+
+	version := buf[0]
+	if version != IDVersion {
+		return ID{}, 0, fmt.Errorf("%w: %d", ErrUnsupportedIDVersion, version)
+	}
+
+	size := binary.BigEndian.Uint32(buf[1:5])
+	buf = buf[5:]
+
+	if uint32(len(buf)) < size {
+		return ID{}, 0, io.ErrUnexpectedEOF
+	}
+
+	buf = buf[:size]
+
+	if len(buf) < 1 {
+		return ID{}, 0, io.ErrUnexpectedEOF
+	}
+
+	if keyType := KeyType(buf[0]); keyType != KeyTypeEd25519 {
+		return ID{}, 0, fmt.Errorf("%w: %d", ErrUnsupportedKeyType, keyType)
+	}
+
+	key, buf, err := readBytes(buf[1:])
+	if err != nil {
+		return ID{}, 0, err
+	}
+
+	if len(key) != SizePublicKey {
+		return ID{}, 0, fmt.Errorf("expected public key to be %d bytes, but got %d bytes: %w",
+			SizePublicKey, len(key), io.ErrUnexpectedEOF,
+		)
+	}
+
+	var id ID
+	copy(id.PubKey[:], key)
+
+	if len(buf) < net.IPv6len+2 {
+		return ID{}, 0, io.ErrUnexpectedEOF
+	}
+
+	id.Host = make([]byte, net.IPv6len)
+	copy(id.Host, buf[:net.IPv6len])
+
+	id.Port = binary.BigEndian.Uint16(buf[net.IPv6len : net.IPv6len+2])
+	buf = buf[net.IPv6len+2:]
+
+	address, buf, err := readBytes(buf)
+	if err != nil {
+		return ID{}, 0, err
+	}
+
+	id.Address = string(address)
+
+	if len(buf) < 2 {
+		return ID{}, 0, io.ErrUnexpectedEOF
+	}
+
+	count := binary.BigEndian.Uint16(buf[:2])
+	buf = buf[2:]
+
+	for j := uint16(0); j < count; j++ {
+		if len(buf) < 1 {
+			return ID{}, 0, io.ErrUnexpectedEOF
+		}
+
+		typ := AddrType(buf[0])
+
+		value, rest, err := readBytes(buf[1:])
+		if err != nil {
+			return ID{}, 0, err
+		}
+
+		id.Addrs = append(id.Addrs, Addr{Type: typ, Value: string(value)})
+		buf = rest
+	}
+
+	if len(buf) > 0 {
+		nonce, _, err := readBytes(buf)
+		if err != nil {
+			return ID{}, 0, err
+		}
+
+		id.Nonce = append([]byte(nil), nonce...)
+	}
+
+	return id, 5 + int(size), nil
+}
+
+func unmarshalLegacyID(buf []byte) (ID, error) {
 	if len(buf) < SizePublicKey {
 		return ID{}, io.ErrUnexpectedEOF
 	}
@@ -90,3 +333,54 @@ func UnmarshalID(buf []byte) (ID, error) {
 
 	return NewID(pubKey, host, port), nil
 }
+
+func truncate(str string) string {
+	if len(str) > math.MaxUint16 {
+		return str[:math.MaxUint16]
+	}
+
+	return str
+}
+
+func truncateBytes(buf []byte) []byte {
+	if len(buf) > math.MaxUint16 {
+		return buf[:math.MaxUint16]
+	}
+
+	return buf
+}
+
+func truncateAddrs(addrs []Addr) []Addr {
+	if len(addrs) > MaxAddrs {
+		return addrs[:MaxAddrs]
+	}
+
+	return addrs
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendString(buf []byte, str string) []byte {
+	str = truncate(str)
+
+	buf = appendUint16(buf, uint16(len(str)))
+
+	return append(buf, str...)
+}
+
+func readBytes(buf []byte) ([]byte, []byte, error) {
+	if len(buf) < 2 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	size := int(binary.BigEndian.Uint16(buf[:2]))
+	buf = buf[2:]
+
+	if len(buf) < size {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	return buf[:size], buf[size:], nil
+}
@@ -1,6 +1,8 @@
 package cryptographic
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -45,15 +47,204 @@ func TestID_String(t *testing.T) {
 func TestUnmarshalID(t *testing.T) {
 	t.Parallel()
 
-	_, err := UnmarshalID(nil)
+	_, _, err := UnmarshalID(nil)
 	assert.EqualError(t, err, io.ErrUnexpectedEOF.Error())
 
-	_, err = UnmarshalID(append(ZeroPublicKey[:], 1))
+	_, _, err = UnmarshalID(append(ZeroPublicKey[:], 1))
 	assert.EqualError(t, err, io.ErrUnexpectedEOF.Error())
 
-	_, err = UnmarshalID(append(ZeroPublicKey[:], append(net.IPv6loopback, 1)...))
+	_, _, err = UnmarshalID(append(ZeroPublicKey[:], append(net.IPv6loopback, 1)...))
 	assert.EqualError(t, err, io.ErrUnexpectedEOF.Error())
 
-	_, err = UnmarshalID(append(ZeroPublicKey[:], append(net.IPv6loopback, 1, 2)...))
+	_, n, err := UnmarshalID(append(ZeroPublicKey[:], append(net.IPv6loopback, 1, 2, 3)...))
 	assert.NoError(t, err)
+	assert.Equal(t, sizeLegacyID, n)
+}
+
+func TestIDRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	f := func(pubKey PublicKey, host net.IP, port uint16, address string, values []string) bool {
+		id := NewID(pubKey, host, port)
+
+		buf := id.Marshal()
+
+		if !assert.Len(t, buf, sizeLegacyID) || !assert.Equal(t, id.Size(), len(buf)) {
+			return false
+		}
+
+		if address != "" {
+			id.Address = address
+		}
+
+		for _, value := range values {
+			id.Addrs = append(id.Addrs, NewAddr(value))
+		}
+
+		buf = id.Marshal()
+
+		if !assert.Equal(t, id.Size(), len(buf)) {
+			return false
+		}
+
+		decoded, n, err := UnmarshalID(append(buf, 1, 2, 3))
+		if !assert.NoError(t, err) || !assert.Equal(t, len(buf), n) {
+			return false
+		}
+
+		if !assert.Equal(t, id.Size(), decoded.Size()) || !assert.Equal(t, buf, decoded.Marshal()) {
+			return false
+		}
+
+		if !assert.Equal(t, id.PubKey, decoded.PubKey) || !assert.Equal(t, id.Address, decoded.Address) {
+			return false
+		}
+
+		if !assert.Equal(t, len(id.Addrs), len(decoded.Addrs)) {
+			return false
+		}
+
+		for i := range id.Addrs {
+			if !assert.Equal(t, id.Addrs[i], decoded.Addrs[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	assert.NoError(t, quick.Check(f, nil))
+}
+
+func TestUnmarshalVersionedID(t *testing.T) {
+	t.Parallel()
+
+	pub, _, err := GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	id := NewID(pub, net.ParseIP("10.0.0.1"), 3000)
+	id.Addrs = []Addr{NewAddr("node.example.com:3000"), NewAddr("/tmp/node.sock")}
+
+	assert.False(t, id.Legacy())
+	assert.Equal(t, AddrTypeDNS, id.Addrs[0].Type)
+	assert.Equal(t, AddrTypeUnix, id.Addrs[1].Type)
+
+	buf := id.Marshal()
+
+	for i := len(idMagic); i < len(buf); i++ {
+		_, _, err := unmarshalVersionedID(buf[len(idMagic):i])
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	}
+
+	future := append([]byte(nil), buf...)
+	future[len(idMagic)] = IDVersion + 1
+
+	_, _, err = UnmarshalID(future)
+	assert.True(t, errors.Is(err, ErrUnsupportedIDVersion))
+
+	future = append([]byte(nil), buf...)
+	future[len(idMagic)+5] = byte(KeyTypeEd25519 + 1)
+
+	_, _, err = UnmarshalID(future)
+	assert.True(t, errors.Is(err, ErrUnsupportedKeyType))
+
+	// An ID whose public key happens to begin with the magic should be encoded in the versioned layout, so that it is
+	// not mistaken for being versioned.
+
+	copy(pub[:], idMagic[:])
+
+	prefixed := NewID(pub, net.ParseIP("10.0.0.1"), 3000)
+	assert.False(t, prefixed.Legacy())
+
+	decoded, n, err := UnmarshalID(prefixed.Marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, prefixed.Size(), n)
+	assert.Equal(t, prefixed.PubKey, decoded.PubKey)
+	assert.Equal(t, prefixed.Address, decoded.Address)
+}
+
+// marshalVersionedID encodes id in the versioned layout regardless of whether or not it may be encoded in the legacy
+// layout, with extra appended to the end of its body as though it were fields unknown to this version of the library.
+func marshalVersionedID(id ID, extra []byte) []byte {
+	buf := append([]byte(nil), idMagic[:]...)
+	buf = append(buf, IDVersion, 0, 0, 0, 0)
+
+	buf = append(buf, byte(KeyTypeEd25519))
+	buf = appendUint16(buf, uint16(len(id.PubKey)))
+	buf = append(buf, id.PubKey[:]...)
+
+	host := make([]byte, net.IPv6len)
+	copy(host, id.Host)
+
+	buf = append(buf, host...)
+	buf = appendUint16(buf, id.Port)
+	buf = appendString(buf, id.Address)
+	buf = appendUint16(buf, 0)
+
+	if len(id.Nonce) > 0 {
+		buf = appendUint16(buf, uint16(len(id.Nonce)))
+		buf = append(buf, id.Nonce...)
+	}
+
+	buf = append(buf, extra...)
+
+	binary.BigEndian.PutUint32(buf[len(idMagic)+1:len(idMagic)+5], uint32(len(buf)-len(idMagic)-5))
+
+	return buf
+}
+
+func TestUnmarshalIDTrailingFields(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	// A versioned encoding of an ID that bears no addresses or nonce decodes to an ID that would otherwise be encoded
+	// in the legacy layout, and a versioned encoding carrying fields unknown to this version of the library decodes
+	// with those fields skipped over. Either way, the bytes that follow must be read from where the ID ends.
+
+	id := NewID(pub, net.ParseIP("10.0.0.1"), 3000)
+
+	withNonce := id
+	withNonce.Nonce = []byte{1, 2, 3}
+
+	cases := []struct {
+		id  ID
+		buf []byte
+	}{
+		{id: id, buf: marshalVersionedID(id, nil)},
+		{id: withNonce, buf: marshalVersionedID(withNonce, []byte{0, 4, 'n', 'e', 'x', 't'})},
+	}
+
+	for _, c := range cases {
+		decoded, n, err := UnmarshalID(append(append([]byte(nil), c.buf...), 0xff, 0xff))
+		assert.NoError(t, err)
+		assert.Equal(t, len(c.buf), n)
+		assert.Equal(t, c.id.PubKey, decoded.PubKey)
+		assert.Equal(t, c.id.Address, decoded.Address)
+		assert.Equal(t, c.id.Nonce, decoded.Nonce)
+
+		// Records concatenated one after another should still be decoded one after another.
+
+		first := make([]byte, 8, 8+len(c.buf)+SizeSignature)
+		binary.BigEndian.PutUint64(first, 1)
+
+		signature := NewRecord(c.id, 1, priv).Signature
+
+		first = append(first, c.buf...)
+		first = append(first, signature[:]...)
+
+		buf := append(first, NewRecord(c.id, 2, priv).Marshal()...)
+
+		rec, n, err := UnmarshalRecord(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, len(first), n)
+		assert.EqualValues(t, 1, rec.Seq)
+
+		rec, n, err = UnmarshalRecord(buf[n:])
+		assert.NoError(t, err)
+		assert.Equal(t, len(buf)-len(first), n)
+		assert.EqualValues(t, 2, rec.Seq)
+		assert.True(t, rec.Verify())
+	}
 }
@@ -0,0 +1,135 @@
+package cryptographic
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// SizePuzzleNonce is the size in bytes of solutions to the S/Kademlia dynamic crypto puzzle produced by
+// (Puzzle).Solve.
+const SizePuzzleNonce = 8
+
+// ErrPuzzleUnsolvable is returned when a solution to a crypto puzzle may not be found.
+var ErrPuzzleUnsolvable = errors.New("crypto puzzle may not be solved")
+
+// Puzzle describes the difficulty of the static and dynamic crypto puzzles suggested by the S/Kademlia paper, which
+// make generating node IDs costly so as to resist Sybil attacks.
+//
+// The static puzzle is solved by a public key whose double SHA-256 hash begins with at least C1 zero bits. As the
+// static puzzle may only be solved by generating new keys, it bounds the rate at which IDs may be generated.
+//
+// The dynamic puzzle is solved by a nonce X such that the SHA-256 hash of the XOR of the SHA-256 hash of a public key
+// with X begins with at least C2 zero bits. The dynamic puzzle may be solved for any key, and its difficulty may be
+// raised over time without requiring that peers generate new keys.
+//
+// The zero-value of Puzzle is solved by all public keys and nonces.
+type Puzzle struct {
+	C1 int
+	C2 int
+}
+
+// Verify returns true should pub solve the static puzzle, and nonce solve the dynamic puzzle for pub.
+func (p Puzzle) Verify(pub PublicKey, nonce []byte) bool {
+	return p.VerifyStatic(pub) && p.VerifyDynamic(pub, nonce)
+}
+
+// VerifyID returns true should the public key and nonce of id solve both puzzles. See (Puzzle).Verify.
+func (p Puzzle) VerifyID(id ID) bool {
+	return p.Verify(id.PubKey, id.Nonce)
+}
+
+// VerifyStatic returns true should pub solve the static puzzle.
+func (p Puzzle) VerifyStatic(pub PublicKey) bool {
+	if p.C1 <= 0 {
+		return true
+	}
+
+	h := sha256.Sum256(pub[:])
+	h = sha256.Sum256(h[:])
+
+	return leadingZeros(h[:]) >= p.C1
+}
+
+// VerifyDynamic returns true should nonce solve the dynamic puzzle for pub. Nonces larger than the size of a SHA-256
+// hash never solve the dynamic puzzle.
+func (p Puzzle) VerifyDynamic(pub PublicKey, nonce []byte) bool {
+	if p.C2 <= 0 {
+		return true
+	}
+
+	if len(nonce) > sha256.Size {
+		return false
+	}
+
+	h := sha256.Sum256(pub[:])
+
+	for i := range nonce {
+		h[i] ^= nonce[i]
+	}
+
+	h = sha256.Sum256(h[:])
+
+	return leadingZeros(h[:]) >= p.C2
+}
+
+// Solve searches for a nonce that solves the dynamic puzzle for pub. It throws ErrPuzzleUnsolvable should the
+// difficulty of the dynamic puzzle exceed the number of bits in a SHA-256 hash.
+func (p Puzzle) Solve(pub PublicKey) ([]byte, error) {
+	if p.C2 <= 0 {
+		return nil, nil
+	}
+
+	if p.C2 > sha256.Size*8 {
+		return nil, ErrPuzzleUnsolvable
+	}
+
+	nonce := make([]byte, SizePuzzleNonce)
+
+	for x := uint64(0); ; x++ {
+		binary.BigEndian.PutUint64(nonce, x)
+
+		if p.VerifyDynamic(pub, nonce) {
+			return nonce, nil
+		}
+	}
+}
+
+// GenerateKeys repeatedly generates new pairs of cryptographic keys until the public key solves the static puzzle,
+// and then solves the dynamic puzzle for it. Nil may be passed to rand in order to use crypto/rand by default. It
+// returns an error if rand is invalid, or should either puzzle not be solvable.
+func (p Puzzle) GenerateKeys(rand io.Reader) (PublicKey, PrivateKey, []byte, error) {
+	if p.C1 > sha256.Size*8 {
+		return ZeroPublicKey, ZeroPrivateKey, nil, ErrPuzzleUnsolvable
+	}
+
+	for {
+		pub, priv, err := GenerateKeys(rand)
+		if err != nil {
+			return ZeroPublicKey, ZeroPrivateKey, nil, err
+		}
+
+		if !p.VerifyStatic(pub) {
+			continue
+		}
+
+		nonce, err := p.Solve(pub)
+		if err != nil {
+			return ZeroPublicKey, ZeroPrivateKey, nil, err
+		}
+
+		return pub, priv, nonce, nil
+	}
+}
+
+func leadingZeros(buf []byte) int {
+	for i, b := range buf {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+
+	return len(buf) * 8
+}
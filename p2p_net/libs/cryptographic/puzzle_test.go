@@ -0,0 +1,72 @@
+package cryptographic
+
+import (
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPuzzle(t *testing.T) {
+	t.Parallel()
+
+	f := func(c1, c2 uint8) bool {
+		puzzle := Puzzle{C1: int(c1 % 8), C2: int(c2 % 8)}
+
+		pub, _, nonce, err := puzzle.GenerateKeys(nil)
+		if !assert.NoError(t, err) {
+			return false
+		}
+
+		if !assert.True(t, puzzle.Verify(pub, nonce)) {
+			return false
+		}
+
+		id := NewID(pub, nil, 3000)
+		id.Nonce = nonce
+
+		decoded, _, err := UnmarshalID(id.Marshal())
+		if !assert.NoError(t, err) || !assert.True(t, puzzle.VerifyID(decoded)) {
+			return false
+		}
+
+		return true
+	}
+
+	assert.NoError(t, quick.Check(f, &quick.Config{MaxCount: 20}))
+}
+
+func TestPuzzleDifficulty(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, Puzzle{}.Verify(ZeroPublicKey, nil))
+
+	puzzle := Puzzle{C1: 8, C2: 12}
+
+	pub, _, nonce, err := puzzle.GenerateKeys(nil)
+	assert.NoError(t, err)
+	assert.True(t, puzzle.Verify(pub, nonce))
+
+	// IDs lacking a nonce, or bearing a nonce solved for a different key, do not solve the dynamic puzzle. A key may
+	// solve a puzzle as easy as the one above without a nonce by chance, and so a far harder one is checked instead.
+
+	assert.False(t, Puzzle{C2: 64}.VerifyDynamic(pub, nil))
+
+	other, _, otherNonce, err := puzzle.GenerateKeys(nil)
+	assert.NoError(t, err)
+	assert.False(t, puzzle.VerifyDynamic(pub, otherNonce) && puzzle.VerifyDynamic(other, nonce))
+
+	// Raising the difficulty of the dynamic puzzle only requires a new nonce to be solved for the same key.
+
+	harder := Puzzle{C1: puzzle.C1, C2: puzzle.C2 + 4}
+
+	nonce, err = harder.Solve(pub)
+	assert.NoError(t, err)
+	assert.True(t, harder.Verify(pub, nonce))
+
+	_, err = Puzzle{C2: 257}.Solve(pub)
+	assert.ErrorIs(t, err, ErrPuzzleUnsolvable)
+
+	_, _, _, err = Puzzle{C1: 257}.GenerateKeys(nil)
+	assert.ErrorIs(t, err, ErrPuzzleUnsolvable)
+}
@@ -0,0 +1,96 @@
+package cryptographic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// recordDomain prefixes all data signed to produce the signature of a record, such that the signature of a record may
+// never be mistaken as a signature of some other data.
+const recordDomain = ".__p2p_peer_record"
+
+// Record is a self-signed peer record, through which the bearer of an ID vouches for the addresses it may be reached
+// at. Records are ordered by their sequence number, with records of a higher sequence number superseding records
+// of a lower sequence number for the same public key.
+type Record struct {
+	ID        ID
+	Seq       uint64
+	Signature Signature
+}
+
+// NewRecord signs id and seq with privateKey, which must be the private key of the bearer of id, and returns the
+// resulting record.
+func NewRecord(id ID, seq uint64, privateKey PrivateKey) Record {
+	r := Record{ID: id, Seq: seq}
+	r.Signature = privateKey.Sign(r.payload())
+
+	return r
+}
+
+// Verify returns true should the signature of this record have been produced by the bearer of its ID.
+func (r Record) Verify() bool {
+	return r.ID.PubKey.Verify(r.payload(), r.Signature)
+}
+
+// Size returns the number of bytes this record comprises of once encoded.
+func (r Record) Size() int {
+	return 8 + r.ID.Size() + SizeSignature
+}
+
+// Marshal implements .Serializable and encodes the sequence number of this record as a 64-bit big-endian integer,
+// followed by its ID, followed by its signature.
+func (r Record) Marshal() []byte {
+	buf := make([]byte, 8, r.Size())
+	binary.BigEndian.PutUint64(buf, r.Seq)
+
+	buf = append(buf, r.ID.Marshal()...)
+	buf = append(buf, r.Signature[:]...)
+
+	return buf
+}
+
+// UnmarshalRecord decodes buf into a Record. Trailing bytes after the record are ignored, such that records may be
+// concatenated with one another and decoded one after another through the number of bytes of buf the record was
+// decoded from, which is returned. The signature of the record is not verified. It throws an io.ErrUnexpectedEOF if
+// buf is malformed.
+func UnmarshalRecord(buf []byte) (Record, int, error) {
+	if len(buf) < 8 {
+		return Record{}, 0, io.ErrUnexpectedEOF
+	}
+
+	r := Record{Seq: binary.BigEndian.Uint64(buf[:8])}
+
+	id, n, err := UnmarshalID(buf[8:])
+	if err != nil {
+		return Record{}, 0, fmt.Errorf("could not read record id: %w", err)
+	}
+
+	r.ID = id
+	buf = buf[8+n:]
+
+	if len(buf) < SizeSignature {
+		return Record{}, 0, io.ErrUnexpectedEOF
+	}
+
+	copy(r.Signature[:], buf[:SizeSignature])
+
+	return r, 8 + n + SizeSignature, nil
+}
+
+func (r Record) payload() []byte {
+	buf := make([]byte, 0, len(recordDomain)+8+r.ID.Size())
+
+	buf = append(buf, recordDomain...)
+	buf = appendUint64(buf, r.Seq)
+	buf = append(buf, r.ID.Marshal()...)
+
+	return buf
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+
+	return append(buf, b[:]...)
+}
@@ -0,0 +1,52 @@
+package cryptographic
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	id := NewID(pub, net.ParseIP("10.0.0.1"), 3000)
+	id.Addrs = []Addr{NewAddr("node.example.com:3000")}
+
+	rec := NewRecord(id, 42, priv)
+	assert.True(t, rec.Verify())
+
+	buf := rec.Marshal()
+	assert.Len(t, buf, rec.Size())
+
+	decoded, n, err := UnmarshalRecord(append(buf, 1, 2, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	assert.EqualValues(t, 42, decoded.Seq)
+	assert.Equal(t, rec.Signature, decoded.Signature)
+	assert.Equal(t, buf, decoded.Marshal())
+	assert.True(t, decoded.Verify())
+
+	_, _, err = UnmarshalRecord(buf[:len(buf)-1])
+	assert.EqualError(t, err, io.ErrUnexpectedEOF.Error())
+
+	// Tampering with either the sequence number or the ID invalidates the signature.
+
+	tampered := decoded
+	tampered.Seq++
+	assert.False(t, tampered.Verify())
+
+	tampered = decoded
+	tampered.ID.Address = "6.6.6.6:3000"
+	assert.False(t, tampered.Verify())
+
+	// Records signed by some other key are invalid.
+
+	_, other, err := GenerateKeys(nil)
+	assert.NoError(t, err)
+	assert.False(t, NewRecord(id, 42, other).Verify())
+}
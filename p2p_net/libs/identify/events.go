@@ -0,0 +1,71 @@
+package identify
+
+import (
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// Events comprise of callbacks that may be hooked against by a user to handle events that occur throughout the
+// lifecycle of this identify protocol.
+type Events struct {
+	// OnPeerIdentified is called whenever a peer that has connected to your node sends over its identify message.
+	OnPeerIdentified func(id cryptographic.ID, msg Message)
+
+	// OnAddressSuggested is called whenever enough peers agree on how they observe the network address of your node,
+	// and the address they agree upon differs from the public address advertised by your node. The address suggested
+	// comprises of the host your peers agree upon, and the port advertised by your node.
+	OnAddressSuggested func(addr string)
+}
+
+// Option is a functional option that may be configured when instantiating a new instance of this identify protocol.
+type Option func(protocol *Protocol)
+
+// WithEvents registers a batch of callbacks onto a single identify protocol instance.
+func WithEvents(events Events) Option {
+	return func(protocol *Protocol) {
+		protocol.events = events
+	}
+}
+
+// WithAgent sets the agent advertised to peers, which names the software, and the version of the software, that
+// your node runs. By default, it is set to DefaultAgent.
+func WithAgent(agent string) Option {
+	return func(protocol *Protocol) {
+		protocol.agent = agent
+	}
+}
+
+// WithProtocols sets the names of all protocols advertised to peers as being supported by your node. By default,
+// no protocols are advertised.
+func WithProtocols(protocols ...string) Option {
+	return func(protocol *Protocol) {
+		protocol.protocols = protocols
+	}
+}
+
+// WithListenAddrs sets the network addresses advertised to peers as being listened on by your node for new peers. By
+// default, the public address of your node is advertised.
+func WithListenAddrs(listenAddrs ...string) Option {
+	return func(protocol *Protocol) {
+		protocol.listenAddrs = listenAddrs
+	}
+}
+
+// WithObservationThreshold sets the number of distinct peers that must agree on how they observe the network address
+// of your node before the address they agree upon is suggested. By default, it is set to 3.
+func WithObservationThreshold(threshold int) Option {
+	return func(protocol *Protocol) {
+		if threshold < 1 {
+			threshold = 1
+		}
+
+		protocol.threshold = threshold
+	}
+}
+
+// WithAddressUpdate sets whether or not the public address of your node is to be updated via (*.Node).SetAddress
+// whenever an address is suggested. By default, addresses are only suggested through Events.
+func WithAddressUpdate(updateAddress bool) Option {
+	return func(protocol *Protocol) {
+		protocol.updateAddress = updateAddress
+	}
+}
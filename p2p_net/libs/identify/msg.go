@@ -0,0 +1,129 @@
+package identify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Message is sent by both sides of a connection to one another once the connection has been established. It
+// describes the sender, and how the sender observes the network address of the recipient.
+type Message struct {
+	// Agent names the software, and the version of the software, that the sender runs.
+	Agent string
+
+	// Protocols lists the names of all protocols the sender supports.
+	Protocols []string
+
+	// ListenAddrs lists all network addresses the sender is listening for new peers on.
+	ListenAddrs []string
+
+	// ObservedAddr is the network address of the recipient as observed from the connection the sender has with the
+	// recipient.
+	ObservedAddr string
+}
+
+// Marshal implements .Serializable and encodes the agent, the list of protocols, the list of listen addresses, and the
+// observed address of this message. Strings are prefixed by their length as a 16-bit big-endian integer, and lists
+// are prefixed by their length as a single byte.
+func (m Message) Marshal() []byte {
+	buf := appendString(nil, m.Agent)
+	buf = appendStrings(buf, m.Protocols)
+	buf = appendStrings(buf, m.ListenAddrs)
+	buf = appendString(buf, m.ObservedAddr)
+
+	return buf
+}
+
+// UnmarshalMessage decodes buf into a Message. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalMessage(buf []byte) (Message, error) {
+	var (
+		msg Message
+		err error
+	)
+
+	if msg.Agent, buf, err = readString(buf); err != nil {
+		return msg, fmt.Errorf("could not read agent: %w", err)
+	}
+
+	if msg.Protocols, buf, err = readStrings(buf); err != nil {
+		return msg, fmt.Errorf("could not read protocols: %w", err)
+	}
+
+	if msg.ListenAddrs, buf, err = readStrings(buf); err != nil {
+		return msg, fmt.Errorf("could not read listen addresses: %w", err)
+	}
+
+	if msg.ObservedAddr, _, err = readString(buf); err != nil {
+		return msg, fmt.Errorf("could not read observed address: %w", err)
+	}
+
+	return msg, nil
+}
+
+func appendString(buf []byte, str string) []byte {
+	if len(str) > math.MaxUint16 {
+		str = str[:math.MaxUint16]
+	}
+
+	buf = append(buf, 0, 0)
+	binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(str)))
+
+	return append(buf, str...)
+}
+
+func appendStrings(buf []byte, strs []string) []byte {
+	if len(strs) > math.MaxUint8 {
+		strs = strs[:math.MaxUint8]
+	}
+
+	buf = append(buf, byte(len(strs)))
+
+	for _, str := range strs {
+		buf = appendString(buf, str)
+	}
+
+	return buf
+}
+
+func readString(buf []byte) (string, []byte, error) {
+	if len(buf) < 2 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+
+	size := int(binary.BigEndian.Uint16(buf[:2]))
+	buf = buf[2:]
+
+	if len(buf) < size {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+
+	return string(buf[:size]), buf[size:], nil
+}
+
+func readStrings(buf []byte) ([]string, []byte, error) {
+	if len(buf) < 1 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	size := int(buf[0])
+	buf = buf[1:]
+
+	strs := make([]string, 0, size)
+
+	for i := 0; i < size; i++ {
+		var (
+			str string
+			err error
+		)
+
+		if str, buf, err = readString(buf); err != nil {
+			return nil, nil, err
+		}
+
+		strs = append(strs, str)
+	}
+
+	return strs, buf, nil
+}
@@ -0,0 +1,245 @@
+// Package identify is an implementation of a protocol through which peers exchange the software they run, the
+// protocols they support, the addresses they listen on, and the addresses they observe one another from once
+// a connection is established. Observed addresses are used to suggest, or update, the public address of a node
+// should it be behind a NAT.
+package identify
+
+import (
+	"net"
+	"strconv"
+	"sync"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/peerstore"
+
+	"go.uber.org/zap"
+)
+
+// DefaultAgent is the agent advertised to peers should none be configured.
+const DefaultAgent = "p2p_network/0.1.0"
+
+// Protocol implements the identify protocol. It is expected that Protocol is bound to a .Node via (*.Node).Bind
+// before the node starts listening for incoming peers.
+type Protocol struct {
+	node   *core_module.Node
+	events Events
+
+	agent       string
+	protocols   []string
+	listenAddrs []string
+
+	threshold     int
+	updateAddress bool
+
+	sync.RWMutex
+
+	peers     map[cryptographic.PublicKey]Message
+	observed  map[cryptographic.PublicKey]string
+	suggested string
+}
+
+// New returns a new instance of the identify protocol.
+func New(opts ...Option) *Protocol {
+	p := &Protocol{
+		agent:     DefaultAgent,
+		threshold: 3,
+
+		peers:    make(map[cryptographic.PublicKey]Message),
+		observed: make(map[cryptographic.PublicKey]string),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Protocol returns a .Protocol that may registered to a node via (*.Node).Bind.
+func (p *Protocol) Protocol() core_module.Protocol {
+	return core_module.Protocol{
+		Bind:               p.Bind,
+		OnPeerConnected:    p.OnPeerConnected,
+		OnPeerDisconnected: p.OnPeerDisconnected,
+	}
+}
+
+// Bind registers a single message identify.Message, and handles them by registering the (*Protocol).Handle Handler.
+func (p *Protocol) Bind(node *core_module.Node) error {
+	p.node = node
+
+	node.RegisterMessage(Message{}, UnmarshalMessage)
+	node.Handle(p.Handle)
+
+	return nil
+}
+
+// OnPeerConnected sends the newly connected peer an identify message describing your node, and how your node
+// observes the network address of the peer.
+func (p *Protocol) OnPeerConnected(client *core_module.Client) {
+	listenAddrs := p.listenAddrs
+	if len(listenAddrs) == 0 {
+		listenAddrs = []string{p.node.Addr()}
+	}
+
+	msg := Message{
+		Agent:       p.agent,
+		Protocols:   p.protocols,
+		ListenAddrs: listenAddrs,
+	}
+
+	if addr := client.RemoteAddr(); addr != nil {
+		msg.ObservedAddr = addr.String()
+	}
+
+	if err := client.SendMessage(msg); err != nil {
+		client.Logger().Debug("Failed to send identify message.", zap.Error(err))
+	}
+}
+
+// OnPeerDisconnected forgets all that was learned about the disconnected peer.
+func (p *Protocol) OnPeerDisconnected(client *core_module.Client) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.peers, client.ID().PubKey)
+	delete(p.observed, client.ID().PubKey)
+}
+
+// Handle implements .Protocol and handles identify.Message messages. Should your node be configured with a peerstore,
+// the protocols advertised by the peer are recorded to the peerstore. Listen addresses advertised by the peer are only
+// recorded to the peerstore should they be covered by the ID the peer signed while handshaking, as they are otherwise
+// unverified claims. All listen addresses advertised by the peer may be retrieved via (*Protocol).Peer.
+func (p *Protocol) Handle(ctx core_module.HandlerContext) error {
+	if ctx.IsRequest() {
+		return nil
+	}
+
+	obj, err := ctx.DecodeMessage()
+	if err != nil {
+		return nil
+	}
+
+	msg, ok := obj.(Message)
+	if !ok {
+		return nil
+	}
+
+	p.Lock()
+	p.peers[ctx.ID().PubKey] = msg
+	p.Unlock()
+
+	if store := p.node.Peerstore(); store != nil {
+		store.SetProtocols(ctx.ID().PubKey, msg.Protocols)
+
+		for _, addr := range msg.ListenAddrs {
+			if signed(ctx.ID(), addr) {
+				store.AddAddress(ctx.ID().PubKey, addr, peerstore.SourceIdentify)
+			}
+		}
+	}
+
+	if p.events.OnPeerIdentified != nil {
+		p.events.OnPeerIdentified(ctx.ID(), msg)
+	}
+
+	p.observe(ctx.ID().PubKey, msg.ObservedAddr)
+
+	return nil
+}
+
+// signed returns true should addr be the address, or one of the typed addresses, of id.
+func signed(id cryptographic.ID, addr string) bool {
+	if id.Address == addr {
+		return true
+	}
+
+	for _, other := range id.Addrs {
+		if other.Value == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Peer returns the identify message last received from a connected peer, and true if one was received, or a
+// zero-value Message and false otherwise.
+func (p *Protocol) Peer(id cryptographic.PublicKey) (Message, bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	msg, exists := p.peers[id]
+
+	return msg, exists
+}
+
+// ObservedAddr returns the address last suggested by connected peers as being the public address of your node, or
+// an empty string should no address have been suggested yet.
+func (p *Protocol) ObservedAddr() string {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.suggested
+}
+
+// observe records how peer observes the network address of your node. Should enough peers agree on the host of your
+// node, and the host differs from the one advertised by your node, an address comprised of the host and the port
+// advertised by your node is suggested. Loopback and unspecified hosts are never suggested.
+func (p *Protocol) observe(peer cryptographic.PublicKey, observed string) {
+	host, _, err := net.SplitHostPort(observed)
+	if err != nil {
+		return
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return
+	}
+
+	self := p.node.ID()
+
+	p.Lock()
+
+	p.observed[peer] = ip.String()
+
+	counts := make(map[string]int, len(p.observed))
+
+	best := ""
+	for _, host := range p.observed {
+		counts[host]++
+
+		if counts[host] > counts[best] || (counts[host] == counts[best] && host < best) {
+			best = host
+		}
+	}
+
+	if counts[best] < p.threshold || net.ParseIP(best).Equal(self.Host) {
+		p.Unlock()
+		return
+	}
+
+	addr := net.JoinHostPort(best, strconv.FormatUint(uint64(self.Port), 10))
+
+	if addr == p.suggested {
+		p.Unlock()
+		return
+	}
+
+	p.suggested = addr
+	p.Unlock()
+
+	if p.events.OnAddressSuggested != nil {
+		p.events.OnAddressSuggested(addr)
+	}
+
+	if p.updateAddress {
+		if err := p.node.SetAddress(addr); err != nil {
+			p.node.Logger().Warn("Failed to update the public address of the node.",
+				zap.String("addr", addr),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package identify
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/peerstore"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestIdentify(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	events := Events{
+		OnPeerIdentified: func(id cryptographic.ID, msg Message) {
+			wg.Done()
+		},
+	}
+
+	sa, sb := peerstore.New(""), peerstore.New("")
+
+	a, err := core_module.NewNode(core_module.WithNodePeerstore(sa))
+	assert.NoError(t, err)
+	defer a.Close()
+
+	ia := New(WithEvents(events), WithAgent("alice/1.0"), WithProtocols("kademlia", "gossip"))
+	a.Bind(ia.Protocol())
+
+	b, err := core_module.NewNode(core_module.WithNodePeerstore(sb))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	ib := New(WithEvents(events), WithAgent("bob/1.0"), WithListenAddrs("10.0.0.1:3000", "10.0.0.2:3000"))
+	b.Bind(ib.Protocol())
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+
+	client, err := b.Ping(context.TODO(), a.Addr())
+	assert.NoError(t, err)
+
+	wg.Wait()
+
+	msg, exists := ib.Peer(a.ID().PubKey)
+	assert.True(t, exists)
+	assert.Equal(t, "alice/1.0", msg.Agent)
+	assert.Equal(t, []string{"kademlia", "gossip"}, msg.Protocols)
+	assert.Equal(t, []string{a.Addr()}, msg.ListenAddrs)
+	assert.Equal(t, a.Inbound()[0].RemoteAddr().String(), msg.ObservedAddr)
+
+	msg, exists = ia.Peer(b.ID().PubKey)
+	assert.True(t, exists)
+	assert.Equal(t, "bob/1.0", msg.Agent)
+	assert.Empty(t, msg.Protocols)
+	assert.Equal(t, []string{"10.0.0.1:3000", "10.0.0.2:3000"}, msg.ListenAddrs)
+	assert.Equal(t, client.RemoteAddr().String(), msg.ObservedAddr)
+
+	// Listen addresses not covered by the handshake ID of a peer should not be recorded, and those that are should not
+	// replace the source they were first learned of from.
+
+	peer, exists := sa.Peer(b.ID().PubKey)
+	assert.True(t, exists)
+	assert.Len(t, peer.Addresses, 1)
+	assert.Equal(t, b.Addr(), peer.Addresses[0].Addr)
+
+	peer, exists = sb.Peer(a.ID().PubKey)
+	assert.True(t, exists)
+	assert.Len(t, peer.Addresses, 1)
+	assert.Equal(t, a.Addr(), peer.Addresses[0].Addr)
+	assert.Equal(t, peerstore.SourceHandshake, peer.Addresses[0].Source)
+	assert.Equal(t, []string{"kademlia", "gossip"}, peer.Protocols)
+}
+
+func TestObservedAddrConsensus(t *testing.T) {
+	pub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	node, err := core_module.NewNode(core_module.WithNodeID(cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), 3000)))
+	assert.NoError(t, err)
+
+	var suggested []string
+
+	p := New(WithObservationThreshold(2), WithAddressUpdate(true), WithEvents(Events{
+		OnAddressSuggested: func(addr string) {
+			suggested = append(suggested, addr)
+		},
+	}))
+	assert.NoError(t, p.Bind(node))
+
+	peers := make([]cryptographic.PublicKey, 4)
+	for i := range peers {
+		peers[i], _, err = cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+	}
+
+	// Loopback addresses and addresses reported by a single peer are never suggested.
+
+	p.observe(peers[0], "127.0.0.1:1234")
+	p.observe(peers[1], "1.2.3.4:1234")
+	p.observe(peers[1], "1.2.3.4:4321")
+	assert.Empty(t, suggested)
+
+	// Two peers agreeing on an address should have it suggested, and the nodes address updated.
+
+	p.observe(peers[2], "1.2.3.4:5678")
+	assert.Equal(t, []string{net.JoinHostPort("1.2.3.4", strconv.Itoa(3000))}, suggested)
+	assert.Equal(t, "1.2.3.4:3000", p.ObservedAddr())
+	assert.Equal(t, "1.2.3.4:3000", node.Addr())
+
+	// Once the address is adopted, it should not be suggested again.
+
+	p.observe(peers[3], "1.2.3.4:9999")
+	assert.Len(t, suggested, 1)
+}
@@ -1,7 +1,6 @@
 package kademlia
 
 import (
-	"bytes"
 	"math/bits"
 	"sort"
 
@@ -54,11 +53,142 @@ func PrefixLen(a []byte) int {
 	return len(a) * 8
 }
 
-// SortByDistance sorts ids by descending XOR distance with respect to id.
+// Distance is the XOR distance between two public keys, read as a 256-bit big-endian unsigned integer.
+type Distance [cryptographic.SizePublicKey]byte
+
+// DistanceBetween returns the XOR distance between a and b. Unlike XOR, it does not allocate.
+func DistanceBetween(a, b cryptographic.PublicKey) Distance {
+	var d Distance
+
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+
+	return d
+}
+
+// Cmp returns -1 should d be shorter than other, 0 should d equal other, or +1 should d be longer than other.
+func (d Distance) Cmp(other Distance) int {
+	for i := range d {
+		switch {
+		case d[i] < other[i]:
+			return -1
+		case d[i] > other[i]:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// PrefixLen returns the number of prefixed zero bits of d, which is the number of leading bits shared by the two public
+// keys d is the distance between.
+func (d Distance) PrefixLen() int {
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+
+	return len(d) * 8
+}
+
+// closer returns true should a be strictly closer to target than b w.r.t. XOR distance. It compares the distances
+// byte by byte, and stops at the first byte they differ in.
+func closer(target, a, b cryptographic.PublicKey) bool {
+	for i := range target {
+		x, y := a[i]^target[i], b[i]^target[i]
+		if x != y {
+			return x < y
+		}
+	}
+
+	return false
+}
+
+// byDistance sorts ids by ascending XOR distance with respect to target.
+type byDistance struct {
+	target cryptographic.PublicKey
+	ids    []cryptographic.ID
+}
+
+func (s *byDistance) Len() int           { return len(s.ids) }
+func (s *byDistance) Less(i, j int) bool { return closer(s.target, s.ids[i].PubKey, s.ids[j].PubKey) }
+func (s *byDistance) Swap(i, j int)      { s.ids[i], s.ids[j] = s.ids[j], s.ids[i] }
+
+// SortByDistance sorts ids in-place by ascending XOR distance with respect to id, such that the closest ID comes
+// first.
 func SortByDistance(id cryptographic.PublicKey, ids []cryptographic.ID) []cryptographic.ID {
-	sort.Slice(ids, func(i, j int) bool {
-		return bytes.Compare(XOR(ids[i].PubKey[:], id[:]), XOR(ids[j].PubKey[:], id[:])) == -1
-	})
+	sort.Sort(&byDistance{target: id, ids: ids})
 
 	return ids
 }
+
+// closestHeap is a binary max-heap of at most k IDs, ordered by XOR distance with respect to target, which is used to
+// select the k closest IDs to target out of a larger set of IDs.
+type closestHeap struct {
+	target cryptographic.PublicKey
+	ids    []cryptographic.ID
+	k      int
+}
+
+// push offers id to the heap. Should the heap be full, id takes the place of the furthest ID in the heap should id be
+// closer to target.
+func (h *closestHeap) push(id cryptographic.ID) {
+	if len(h.ids) < h.k {
+		h.ids = append(h.ids, id)
+
+		for i := len(h.ids) - 1; i > 0; {
+			parent := (i - 1) / 2
+			if !closer(h.target, h.ids[parent].PubKey, h.ids[i].PubKey) {
+				break
+			}
+
+			h.ids[parent], h.ids[i] = h.ids[i], h.ids[parent]
+			i = parent
+		}
+
+		return
+	}
+
+	if h.k > 0 && closer(h.target, id.PubKey, h.ids[0].PubKey) {
+		h.ids[0] = id
+		h.down(0, len(h.ids))
+	}
+}
+
+// down restores the heap property of the first n IDs in the heap, starting from the ID at index i.
+func (h *closestHeap) down(i, n int) {
+	for {
+		furthest := i
+
+		for _, child := range [2]int{2*i + 1, 2*i + 2} {
+			if child < n && closer(h.target, h.ids[furthest].PubKey, h.ids[child].PubKey) {
+				furthest = child
+			}
+		}
+
+		if furthest == i {
+			return
+		}
+
+		h.ids[i], h.ids[furthest] = h.ids[furthest], h.ids[i]
+		i = furthest
+	}
+}
+
+// full returns true should the heap hold k IDs.
+func (h *closestHeap) full() bool {
+	return len(h.ids) >= h.k
+}
+
+// sorted sorts the IDs in the heap in-place by ascending XOR distance with respect to target, and returns them. The
+// heap may no longer be used afterwards.
+func (h *closestHeap) sorted() []cryptographic.ID {
+	for n := len(h.ids) - 1; n > 0; n-- {
+		h.ids[0], h.ids[n] = h.ids[n], h.ids[0]
+		h.down(0, n)
+	}
+
+	return h.ids
+}
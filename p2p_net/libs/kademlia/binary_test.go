@@ -0,0 +1,122 @@
+package kademlia
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func randomKeys(t testing.TB, n int) []cryptographic.PublicKey {
+	t.Helper()
+
+	keys := make([]cryptographic.PublicKey, 0, n)
+
+	for i := 0; i < n; i++ {
+		pub, _, err := cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+
+		keys = append(keys, pub)
+	}
+
+	return keys
+}
+
+func TestDistance(t *testing.T) {
+	t.Parallel()
+
+	keys := randomKeys(t, 32)
+
+	for _, a := range keys {
+		for _, b := range keys {
+			d := DistanceBetween(a, b)
+
+			assert.Equal(t, XOR(a[:], b[:]), d[:])
+			assert.Equal(t, PrefixLen(d[:]), d.PrefixLen())
+			assert.Equal(t, d, DistanceBetween(b, a))
+
+			for _, c := range keys {
+				e := DistanceBetween(c, b)
+
+				assert.Equal(t, bytes.Compare(d[:], e[:]), d.Cmp(e))
+				assert.Equal(t, d.Cmp(e) < 0, closer(b, a, c))
+			}
+		}
+	}
+
+	assert.Equal(t, cryptographic.SizePublicKey*8, DistanceBetween(keys[0], keys[0]).PrefixLen())
+}
+
+func TestSortByDistance(t *testing.T) {
+	t.Parallel()
+
+	keys := randomKeys(t, 64)
+	target := keys[0]
+
+	ids := make([]cryptographic.ID, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, cryptographic.ID{PubKey: key})
+	}
+
+	ids = SortByDistance(target, ids)
+
+	assert.Equal(t, target, ids[0].PubKey)
+	assert.True(t, sort.SliceIsSorted(ids, func(i, j int) bool {
+		return bytes.Compare(XOR(ids[i].PubKey[:], target[:]), XOR(ids[j].PubKey[:], target[:])) < 0
+	}))
+}
+
+func TestClosestHeap(t *testing.T) {
+	t.Parallel()
+
+	keys := randomKeys(t, 64)
+	target := keys[0]
+
+	expected := make([]cryptographic.ID, 0, len(keys))
+	for _, key := range keys {
+		expected = append(expected, cryptographic.ID{PubKey: key})
+	}
+
+	expected = SortByDistance(target, expected)
+
+	for _, k := range []int{0, 1, 7, 16, len(keys), len(keys) + 1} {
+		h := closestHeap{target: target, k: k}
+
+		for i := len(keys) - 1; i >= 0; i-- {
+			h.push(cryptographic.ID{PubKey: keys[i]})
+		}
+
+		n := k
+		if n > len(keys) {
+			n = len(keys)
+		}
+
+		if n == 0 {
+			assert.Empty(t, h.sorted())
+			continue
+		}
+
+		assert.Equal(t, expected[:n], h.sorted())
+	}
+}
+
+func BenchmarkSortByDistance(b *testing.B) {
+	keys := randomKeys(b, 256)
+	target := keys[0]
+
+	ids := make([]cryptographic.ID, len(keys))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for j, key := range keys { // Sorting an already sorted slice is not representative.
+			ids[j].PubKey = key
+		}
+
+		SortByDistance(target, ids)
+	}
+}
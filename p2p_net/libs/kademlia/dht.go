@@ -0,0 +1,333 @@
+package kademlia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/zap"
+)
+
+// Put stores value under key on the peers closest to key found through an iterative FIND_NODE lookup, and in the
+// local storage of your node. The value expires after the TTL configured through WithProtocolValueTTL, and is
+// republished by every node that stores it until then.
+//
+// It throws an error should the value be rejected by the validator configured through WithProtocolValidator, should
+// it fail to be stored locally, or should there be peers closest to key yet none of them accept the value.
+//
+// Unless a validator is configured through WithProtocolValidator, any peer may overwrite the value stored under key on
+// any node, including your own, with a value of its choosing.
+func (p *Protocol) Put(ctx context.Context, key, value []byte) error {
+	if len(key) > math.MaxUint16 {
+		return ErrKeyTooLarge
+	}
+
+	if err := p.validate(key, value); err != nil {
+		return err
+	}
+
+	v := Value{Key: key, Data: value, Expires: time.Now().Add(p.valueTTL)}
+
+	if err := p.storage.Put(v); err != nil {
+		return fmt.Errorf("failed to store value locally: %w", err)
+	}
+
+	p.quota.forget(key)
+
+	return p.replicate(ctx, v)
+}
+
+// Get returns the value stored under key, first checking the local storage of your node, and otherwise executing an
+// iterative FIND_VALUE lookup. Values found through a lookup are cached on the closest peer to key that responded to
+// the lookup without the value. It throws ErrValueNotFound should no value be found.
+func (p *Protocol) Get(ctx context.Context, key []byte, opts ...IteratorOption) ([]byte, error) {
+	if v, exists := p.local(key); exists {
+		return v.Data, nil
+	}
+
+	opts = append([]IteratorOption{WithIteratorPuzzle(p.puzzle), WithIteratorValidator(p.validator)}, opts...)
+
+	v, closest, found := NewIterator(p.node, p.table, opts...).FindValue(ctx, key)
+	if !found {
+		return nil, ErrValueNotFound
+	}
+
+	if len(closest) > 0 {
+		if err := p.store(ctx, closest[0], v); err != nil {
+			p.logger.Debug("Failed to cache value on the closest peer along the lookup path.",
+				zap.String("peer_id", closest[0].String()),
+				zap.String("peer_addr", closest[0].Address),
+				zap.Error(err),
+			)
+		}
+	}
+
+	return v.Data, nil
+}
+
+// Close implements .Protocol and stops the goroutines which periodically maintain the routing table and the DHT,
+// saving a final snapshot of the routing table should a snapshot file be configured. It may be called more than once.
+func (p *Protocol) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+
+	p.wg.Wait()
+
+	return nil
+}
+
+// Storage returns the local storage backend of this Kademlia overlay's DHT.
+func (p *Protocol) Storage() Storage {
+	return p.storage
+}
+
+func (p *Protocol) validate(key, value []byte) error {
+	if p.validator == nil {
+		return nil
+	}
+
+	if err := p.validator(key, value); err != nil {
+		return fmt.Errorf("value was rejected: %w", err)
+	}
+
+	return nil
+}
+
+// local returns the value stored under key in local storage should it exist and not have expired. Expired values are
+// deleted.
+func (p *Protocol) local(key []byte) (Value, bool) {
+	v, exists, err := p.storage.Get(key)
+	if err != nil {
+		p.logger.Warn("Failed to read value from storage.", zap.Error(err))
+		return Value{}, false
+	}
+
+	if !exists {
+		return Value{}, false
+	}
+
+	if v.Expired(time.Now()) {
+		if err := p.storage.Delete(key); err != nil {
+			p.logger.Warn("Failed to delete expired value from storage.", zap.Error(err))
+		}
+
+		p.quota.forget(key)
+
+		return Value{}, false
+	}
+
+	return v, true
+}
+
+// replicate stores v on the peers closest to its key. It throws an error should there be peers closest to its key
+// yet none of them accept v.
+func (p *Protocol) replicate(ctx context.Context, v Value) error {
+	closest := p.Find(ctx, HashKey(v.Key))
+	if len(closest) == 0 {
+		return nil
+	}
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		stored int
+		last   error
+	)
+
+	wg.Add(len(closest))
+
+	for _, id := range closest {
+		id := id
+
+		go func() {
+			defer wg.Done()
+
+			err := p.store(ctx, id, v)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				last = err
+				return
+			}
+
+			stored++
+		}()
+	}
+
+	wg.Wait()
+
+	if stored == 0 {
+		return fmt.Errorf("failed to store value on any of the %d closest peers: %w", len(closest), last)
+	}
+
+	return nil
+}
+
+// store sends a STORE RPC call to id for v, with the time left until v expires as its TTL.
+func (p *Protocol) store(ctx context.Context, id cryptographic.ID, v Value) error {
+	ttl := v.TTL(time.Now())
+	if ttl == 0 {
+		return nil
+	}
+
+	res, err := p.node.RequestMessage(ctx, id.Address, StoreRequest{Key: v.Key, Value: v.Data, TTL: ttl})
+	if err != nil {
+		return err
+	}
+
+	if _, ok := res.(StoreResponse); !ok {
+		return fmt.Errorf("did not get a store response back from %s", id.Address)
+	}
+
+	return nil
+}
+
+func (p *Protocol) handleStoreRequest(ctx core_module.HandlerContext, req StoreRequest) error {
+	if req.TTL <= 0 {
+		return core_module.NewRemoteError(core_module.StatusInvalidRequest, "ttl must be positive", nil)
+	}
+
+	if err := p.validate(req.Key, req.Value); err != nil {
+		return core_module.NewRemoteError(core_module.StatusInvalidRequest, err.Error(), nil)
+	}
+
+	// Peers may not have values outlive the TTL configured on this node.
+
+	ttl := req.TTL
+	if ttl > p.valueTTL {
+		ttl = p.valueTTL
+	}
+
+	v := Value{Key: req.Key, Data: req.Value, Expires: time.Now().Add(ttl), Owner: ctx.ID().PubKey}
+
+	if err := p.quota.put(v.Owner, v, p.storage.Put); err != nil {
+		if errors.Is(err, ErrStorageQuotaExceeded) {
+			return core_module.NewRemoteError(core_module.StatusUnavailable, err.Error(), nil)
+		}
+
+		p.logger.Warn("Failed to store value.", zap.Error(err))
+		return core_module.NewRemoteError(core_module.StatusInternal, "failed to store value", nil)
+	}
+
+	return ctx.SendMessage(StoreResponse{})
+}
+
+func (p *Protocol) handleFindValueRequest(ctx core_module.HandlerContext, req FindValueRequest) error {
+	if v, exists := p.local(req.Key); exists {
+		return ctx.SendMessage(FindValueResponse{Found: true, Value: v.Data, TTL: v.TTL(time.Now())})
+	}
+
+	return ctx.SendMessage(FindValueResponse{Records: p.records(p.closest(HashKey(req.Key), ctx.ID().PubKey))})
+}
+
+// maintain periodically deletes expired values from storage and republishes all values that have yet to expire to
+// the peers closest to them, periodically forgets expired providers and re-announces your node as a provider of all
+// keys passed to (*Protocol).Provide, periodically refreshes the routing table, and periodically saves snapshots of
+// the routing table, until (*Protocol).Close is called, upon which a final snapshot is saved.
+func (p *Protocol) maintain() {
+	defer p.wg.Done()
+
+	republish, stopRepublish := tick(p.republishInterval)
+	defer stopRepublish()
+
+	reprovide, stopReprovide := tick(p.provideInterval)
+	defer stopReprovide()
+
+	refresh, stopRefresh := tick(p.refreshInterval)
+	defer stopRefresh()
+
+	snapshot, stopSnapshot := tick(p.snapshotInterval)
+	defer stopSnapshot()
+
+	for {
+		select {
+		case <-p.done:
+			p.saveSnapshot()
+			return
+		case <-republish:
+			p.republish()
+		case <-reprovide:
+			p.reprovide()
+		case <-refresh:
+			p.refresh()
+		case <-snapshot:
+			p.saveSnapshot()
+		}
+	}
+}
+
+// tick returns a channel that ticks every interval, and a function that stops it. Should interval be non-positive,
+// the channel returned never ticks.
+func tick(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+
+	ticker := time.NewTicker(interval)
+
+	return ticker.C, ticker.Stop
+}
+
+// maintenanceContext returns a context that is cancelled once (*Protocol).Close is called.
+func (p *Protocol) maintenanceContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-p.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+func (p *Protocol) refresh() {
+	ctx, cancel := p.maintenanceContext()
+	defer cancel()
+
+	p.Refresh(ctx)
+}
+
+func (p *Protocol) republish() {
+	values, err := p.storage.Values()
+	if err != nil {
+		p.logger.Warn("Failed to read values from storage.", zap.Error(err))
+		return
+	}
+
+	ctx, cancel := p.maintenanceContext()
+	defer cancel()
+
+	now := time.Now()
+
+	for _, v := range values {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if v.Expired(now) {
+			if err := p.storage.Delete(v.Key); err != nil {
+				p.logger.Warn("Failed to delete expired value from storage.", zap.Error(err))
+			}
+
+			p.quota.forget(v.Key)
+
+			continue
+		}
+
+		if err := p.replicate(ctx, v); err != nil {
+			p.logger.Debug("Failed to republish value.", zap.Error(err))
+		}
+	}
+}
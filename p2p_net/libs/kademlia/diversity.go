@@ -0,0 +1,163 @@
+package kademlia
+
+import (
+	"fmt"
+	"net"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// GroupFunc maps the host of a peer to the group it belongs to, such as the autonomous system that announces it. An
+// empty group is returned should the group of the host be unknown.
+type GroupFunc func(ip net.IP) string
+
+// DiversityLimits bound the number of peers from the same network that may be admitted into a routing table, which
+// makes it costly for an attacker holding a single network to eclipse a node. Peers are grouped by the /24 subnet of
+// their IPv4 host, or the /48 subnet of their IPv6 host, and optionally by the group their host is mapped to by
+// Group. The host of a peer is the host your node observed it at over a connection, or the host the peer claims should
+// it not have been observed. Limits that are zero are not enforced.
+type DiversityLimits struct {
+	// MaxPerSubnetPerBucket is the max number of peers from the same subnet a single bucket may hold.
+	MaxPerSubnetPerBucket int
+
+	// MaxPerSubnet is the max number of peers from the same subnet the whole routing table may hold.
+	MaxPerSubnet int
+
+	// MaxPerGroupPerBucket is the max number of peers from the same group a single bucket may hold.
+	MaxPerGroupPerBucket int
+
+	// MaxPerGroup is the max number of peers from the same group the whole routing table may hold.
+	MaxPerGroup int
+
+	// Group maps hosts to groups. Group limits are not enforced should it be nil.
+	Group GroupFunc
+
+	// AllowPrivate exempts peers observed at loopback, private, or link-local hosts from all limits, such that test
+	// clusters and local networks may be formed. Peers that have not been observed over a connection, such as those
+	// learned of from other peers, are never exempt, as the hosts they claim are unverified.
+	AllowPrivate bool
+}
+
+// DefaultDiversityLimits are the limits a Protocol enforces unless configured otherwise through
+// WithProtocolDiversityLimits. No more than 2 peers from the same subnet may be admitted into any one bucket, and no
+// more than 8 peers from the same subnet into the whole routing table. Peers observed at
+// loopback and private hosts are exempt.
+var DefaultDiversityLimits = DiversityLimits{
+	MaxPerSubnetPerBucket: 2,
+	MaxPerSubnet:          8,
+	AllowPrivate:          true,
+}
+
+// enabled returns true should any of these limits be enforced.
+func (l DiversityLimits) enabled() bool {
+	return l.MaxPerSubnetPerBucket > 0 || l.MaxPerSubnet > 0 || l.MaxPerGroupPerBucket > 0 || l.MaxPerGroup > 0
+}
+
+// exempt returns true should peers at ip be exempt from these limits, where observed is true should ip have been
+// observed over a connection rather than claimed.
+func (l DiversityLimits) exempt(ip net.IP, observed bool) bool {
+	if !observed || !l.AllowPrivate {
+		return false
+	}
+
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+// Subnet returns the /24 subnet of ip should it be an IPv4 address, or the /48 subnet of ip otherwise.
+func Subnet(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+	}
+
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}
+}
+
+// diversityGroup identifies the subnet and group a peer belongs to.
+type diversityGroup struct {
+	subnet string
+	group  string
+}
+
+func (l DiversityLimits) groupOf(ip net.IP) diversityGroup {
+	g := diversityGroup{subnet: Subnet(ip).String()}
+
+	if l.Group != nil {
+		g.group = l.Group(ip)
+	}
+
+	return g
+}
+
+// hostOf returns the host id was observed at and true, or the host id claims and false should id not have been
+// observed over a connection. The caller must hold the lock.
+func (t *Table) hostOf(id cryptographic.ID) (net.IP, bool) {
+	if host, observed := t.observed[id.PubKey]; observed {
+		return host, true
+	}
+
+	return id.Host, false
+}
+
+// admit returns an error wrapping ErrDiversityLimit should admitting target into the bucket at index idx exceed any
+// of the configured limits. Entries bearing the public key of target or of this routing table are not counted. The
+// caller must hold the lock.
+func (t *Table) admit(target cryptographic.ID, idx int) error {
+	if !t.limits.enabled() {
+		return nil
+	}
+
+	host, observed := t.hostOf(target)
+	if t.limits.exempt(host, observed) {
+		return nil
+	}
+
+	g := t.limits.groupOf(host)
+
+	var bucketSubnet, tableSubnet, bucketGroup, tableGroup int
+
+	for i, bucket := range t.entries {
+		for _, id := range bucket {
+			if id.PubKey == target.PubKey || id.PubKey == t.self.PubKey {
+				continue
+			}
+
+			host, observed := t.hostOf(id)
+			if t.limits.exempt(host, observed) {
+				continue
+			}
+
+			other := t.limits.groupOf(host)
+
+			if other.subnet == g.subnet {
+				tableSubnet++
+
+				if i == idx {
+					bucketSubnet++
+				}
+			}
+
+			if g.group != "" && other.group == g.group {
+				tableGroup++
+
+				if i == idx {
+					bucketGroup++
+				}
+			}
+		}
+	}
+
+	switch {
+	case t.limits.MaxPerSubnetPerBucket > 0 && bucketSubnet >= t.limits.MaxPerSubnetPerBucket:
+		return fmt.Errorf("%w: bucket already holds %d peer(s) from subnet %s", ErrDiversityLimit, bucketSubnet, g.subnet)
+	case t.limits.MaxPerSubnet > 0 && tableSubnet >= t.limits.MaxPerSubnet:
+		return fmt.Errorf("%w: table already holds %d peer(s) from subnet %s", ErrDiversityLimit, tableSubnet, g.subnet)
+	case g.group == "":
+		return nil
+	case t.limits.MaxPerGroupPerBucket > 0 && bucketGroup >= t.limits.MaxPerGroupPerBucket:
+		return fmt.Errorf("%w: bucket already holds %d peer(s) from group %s", ErrDiversityLimit, bucketGroup, g.group)
+	case t.limits.MaxPerGroup > 0 && tableGroup >= t.limits.MaxPerGroup:
+		return fmt.Errorf("%w: table already holds %d peer(s) from group %s", ErrDiversityLimit, tableGroup, g.group)
+	}
+
+	return nil
+}
@@ -18,4 +18,16 @@ type Events struct {
 	// OnPeerEvicted is called when your node fails to ping/dial a peer that was previously admitted into your nodes'
 	// routing table, which leads to an eviction of the peers ID from your nodes' routing table.
 	OnPeerEvicted func(id cryptographic.ID)
+
+	// OnPeerCached is called when a peer is refused from being inserted into your nodes' routing table as its
+	// intended bucket is full, and is instead cached as a candidate in the replacement cache of the bucket.
+	OnPeerCached func(id cryptographic.ID)
+
+	// OnPeerPromoted is called when a candidate is promoted from the replacement cache of a bucket into your nodes'
+	// routing table, in place of an entry that was evicted from the bucket.
+	OnPeerPromoted func(id cryptographic.ID)
+
+	// OnPeerRejected is called when a peer is refused from being inserted into your nodes' routing table as it would
+	// exceed the IP diversity limits of your nodes' routing table. err describes which limit would be exceeded.
+	OnPeerRejected func(id cryptographic.ID, err error)
 }
@@ -0,0 +1,214 @@
+package kademlia
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/zap"
+)
+
+// evictionCandidate is a peer waiting on a liveness check to take the place of the tail of a full bucket, alongside
+// the host your node observed the peer from, or nil should your node not have observed the peer over IP.
+type evictionCandidate struct {
+	id   cryptographic.ID
+	host net.IP
+}
+
+// evictionCheck is a liveness check of the tail of a full bucket, alongside the candidates waiting to take its place
+// should it fail to respond.
+type evictionCheck struct {
+	tail       cryptographic.ID
+	candidates []evictionCandidate
+}
+
+// evictionQueue is a bounded FIFO queue of liveness checks. Checks of the same tail are coalesced into a single
+// check, and candidates are only ever waiting on one check at a time.
+type evictionQueue struct {
+	sync.Mutex
+
+	checks     map[cryptographic.PublicKey]*evictionCheck
+	candidates map[cryptographic.PublicKey]struct{}
+	queued     []cryptographic.PublicKey
+
+	size   int
+	notify chan struct{}
+}
+
+func newEvictionQueue(size int) *evictionQueue {
+	return &evictionQueue{
+		checks:     make(map[cryptographic.PublicKey]*evictionCheck),
+		candidates: make(map[cryptographic.PublicKey]struct{}),
+		size:       size,
+		notify:     make(chan struct{}, 1),
+	}
+}
+
+// push has candidate, observed from host, wait on a liveness check of tail, queueing a new check should none be
+// queued or in progress for tail. It returns false should the queue be full.
+func (q *evictionQueue) push(tail, candidate cryptographic.ID, host net.IP) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if _, waiting := q.candidates[candidate.PubKey]; waiting {
+		return true
+	}
+
+	check, exists := q.checks[tail.PubKey]
+	if !exists {
+		if len(q.checks) >= q.size {
+			return false
+		}
+
+		check = &evictionCheck{tail: tail}
+
+		q.checks[tail.PubKey] = check
+		q.queued = append(q.queued, tail.PubKey)
+
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	check.candidates = append(check.candidates, evictionCandidate{id: candidate, host: host})
+	q.candidates[candidate.PubKey] = struct{}{}
+
+	return true
+}
+
+// pop returns the tail of the oldest queued check, and true should there be one. The check remains in the queue until
+// (*evictionQueue).finish is called, such that candidates may still wait on it.
+func (q *evictionQueue) pop() (cryptographic.ID, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.queued) == 0 {
+		return cryptographic.ID{}, false
+	}
+
+	tail := q.queued[0]
+	q.queued = q.queued[1:]
+
+	return q.checks[tail].tail, true
+}
+
+// finish removes the check of tail from the queue, and returns the candidates that were waiting on it in the order
+// they were pushed.
+func (q *evictionQueue) finish(tail cryptographic.PublicKey) []evictionCandidate {
+	q.Lock()
+	defer q.Unlock()
+
+	check, exists := q.checks[tail]
+	if !exists {
+		return nil
+	}
+
+	delete(q.checks, tail)
+
+	for _, candidate := range check.candidates {
+		delete(q.candidates, candidate.id.PubKey)
+	}
+
+	return check.candidates
+}
+
+// len returns the number of checks that are either queued or in progress.
+func (q *evictionQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return len(q.checks)
+}
+
+// EvictionQueueDepth returns the number of liveness checks of the tails of full buckets that are either queued or in
+// progress. See (*Protocol).Ack.
+func (p *Protocol) EvictionQueueDepth() int {
+	return p.evictions.len()
+}
+
+// queueEviction pushes a liveness check of tail for candidate, observed from host, onto the eviction queue. Should
+// the queue be full, candidate is cached as a replacement straight away.
+func (p *Protocol) queueEviction(tail, candidate cryptographic.ID, host net.IP) {
+	if p.evictions.push(tail, candidate, host) {
+		return
+	}
+
+	p.logger.Debug("Eviction queue is full; peer was cached as a replacement without its bucket being checked.",
+		zap.String("peer_id", candidate.String()),
+		zap.String("peer_addr", candidate.Address),
+	)
+
+	p.cache(candidate)
+}
+
+// evictor pops liveness checks off of the eviction queue and executes them one at a time, until (*Protocol).Close is
+// called.
+func (p *Protocol) evictor() {
+	defer p.wg.Done()
+
+	ctx, cancel := p.maintenanceContext()
+	defer cancel()
+
+	for {
+		tail, ok := p.evictions.pop()
+		if !ok {
+			select {
+			case <-p.done:
+				return
+			case <-p.evictions.notify:
+			}
+
+			continue
+		}
+
+		p.checkTail(ctx, tail)
+	}
+}
+
+// checkTail pings tail. Should tail be unresponsive, it is evicted, and all candidates that were waiting on it are
+// acknowledged again starting from the most recently seen, such that it may take the place of tail. Candidates are
+// acknowledged with the host they were observed from, such that the IP diversity limits of the routing table are
+// enforced upon the same host as when they were first acknowledged. Otherwise, all candidates are cached as
+// replacements.
+func (p *Protocol) checkTail(ctx context.Context, tail cryptographic.ID) {
+	pingCtx, cancel := context.WithTimeout(ctx, p.pingTimeout)
+	err := p.Ping(pingCtx, tail.Address)
+	cancel()
+
+	candidates := p.evictions.finish(tail.PubKey)
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil && p.unresponsive(tail.PubKey) {
+		p.evict(tail.PubKey, err)
+
+		for i := len(candidates) - 1; i >= 0; i-- {
+			p.ack(candidates[i].id, candidates[i].host)
+		}
+
+		return
+	}
+
+	for _, candidate := range candidates {
+		p.logger.Debug("Peer failed to be inserted into routing table as it's intended bucket is full, and was "+
+			"cached as a replacement instead.",
+			zap.String("peer_id", candidate.id.String()),
+			zap.String("peer_addr", candidate.id.Address),
+		)
+
+		p.cache(candidate.id)
+	}
+}
+
+// cache adds candidate to the replacement cache of its bucket, and fires the OnPeerCached event should it have been
+// newly added.
+func (p *Protocol) cache(candidate cryptographic.ID) {
+	if p.table.AddReplacement(candidate) && p.events.OnPeerCached != nil {
+		p.events.OnPeerCached(candidate)
+	}
+}
@@ -0,0 +1,70 @@
+package kademlia
+
+import (
+	"net"
+	"testing"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictionQueue(t *testing.T) {
+	t.Parallel()
+
+	ids := make([]cryptographic.ID, 0, 5)
+
+	for i := 0; i < cap(ids); i++ {
+		pub, _, err := cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+
+		ids = append(ids, cryptographic.NewID(pub, net.ParseIP("127.0.0.1"), uint16(3000+i)))
+	}
+
+	q := newEvictionQueue(2)
+
+	_, ok := q.pop()
+	assert.False(t, ok)
+
+	// Checks of the same tail are coalesced, and candidates only ever wait on a single check.
+
+	assert.True(t, q.push(ids[0], ids[2], nil))
+	assert.True(t, q.push(ids[0], ids[3], net.ParseIP("10.0.0.1")))
+	assert.True(t, q.push(ids[1], ids[2], nil))
+	assert.Equal(t, 1, q.len())
+
+	assert.True(t, q.push(ids[1], ids[4], nil))
+	assert.Equal(t, 2, q.len())
+
+	// The queue is bounded.
+
+	assert.False(t, q.push(ids[2], ids[0], nil))
+	assert.Equal(t, 2, q.len())
+
+	// Checks are popped in order, and remain in the queue until they are finished.
+
+	tail, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, ids[0], tail)
+	assert.Equal(t, 2, q.len())
+
+	assert.True(t, q.push(ids[0], ids[1], nil))
+
+	// Candidates are returned alongside the host they were observed from.
+
+	assert.Equal(t, []evictionCandidate{{id: ids[2]}, {id: ids[3], host: net.ParseIP("10.0.0.1")}, {id: ids[1]}},
+		q.finish(ids[0].PubKey),
+	)
+	assert.Equal(t, 1, q.len())
+	assert.Nil(t, q.finish(ids[0].PubKey))
+
+	tail, ok = q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, ids[1], tail)
+
+	_, ok = q.pop()
+	assert.False(t, ok)
+
+	assert.Equal(t, []evictionCandidate{{id: ids[4]}}, q.finish(ids[1].PubKey))
+	assert.Equal(t, 0, q.len())
+}
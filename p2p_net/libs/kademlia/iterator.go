@@ -2,27 +2,37 @@ package kademlia
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"awesomeProject/beacon/p2p_network/core_module"
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/peerstore"
 
 	"go.uber.org/zap"
 )
 
 // Iterator represents a S/Kademlia overlay network iterator over all peers that may be discovered in the network. It
 // is used for peer discovery, and for finding peers by their public key over an overlay network.
+//
+// A lookup is split into several disjoint paths as suggested by the S/Kademlia paper, such that no peer is queried by
+// more than one path. Each path queries up to a configured number of peers in parallel, always choosing the peers
+// closest to the target that it has yet to query, and terminates once the k peers closest to the target it knows of
+// have all responded.
+//
+// An Iterator may execute many lookups concurrently, as the state of each lookup is kept apart from the Iterator.
+// Should the Iterator be configured with a trace however, it must only execute a single lookup at a time.
 type Iterator struct {
-	sync.Mutex
-
 	node   *core_module.Node
 	table  *Table
 	logger *zap.Logger
+	puzzle cryptographic.Puzzle
 
-	visited map[cryptographic.PublicKey]struct{}
-	results chan cryptographic.ID
-	buckets [][]cryptographic.ID
+	validator Validator
+	trace     *LookupTrace
 
 	maxNumResults                int
 	numParallelLookups           int
@@ -31,6 +41,26 @@ type Iterator struct {
 	lookupTimeout time.Duration
 }
 
+// lookup holds the state of a single lookup executed by an Iterator, such that many lookups may be executed
+// concurrently by the same Iterator.
+type lookup struct {
+	*Iterator
+
+	sync.Mutex
+
+	target  cryptographic.PublicKey
+	visited map[cryptographic.PublicKey]struct{}
+
+	key       []byte
+	providers func(id cryptographic.ID)
+
+	value  Value
+	found  bool
+	cancel context.CancelFunc
+
+	tracer *lookupTracer
+}
+
 // NewIterator instantiates a new overlay network iterator bounded to a node and routing table that may be
 // optionally configured with a variadic list of functional options.
 func NewIterator(node *core_module.Node, table *Table, opts ...IteratorOption) *Iterator {
@@ -39,7 +69,7 @@ func NewIterator(node *core_module.Node, table *Table, opts ...IteratorOption) *
 		table:  table,
 		logger: node.Logger(),
 
-		maxNumResults:                BucketSize,
+		maxNumResults:                table.BucketSize(),
 		numParallelLookups:           3,
 		numParallelRequestsPerLookup: 8,
 
@@ -53,131 +83,421 @@ func NewIterator(node *core_module.Node, table *Table, opts ...IteratorOption) *
 	return it
 }
 
-// Find attempts to salvage through the Kademlia overlay network through the peers of the node this iterator
-// is bound to for a target public key. It blocks the current goroutine until the search is complete.
-func (it *Iterator) Find(target cryptographic.PublicKey) []cryptographic.ID {
-	var closest []cryptographic.ID
+// Find executes an iterative FIND_NODE lookup through the Kademlia overlay network for a target public key, starting
+// from the peers closest to the target in the routing table of the node this iterator is bound to. It blocks the
+// current goroutine until the lookup is complete, or until ctx is cancelled or expires, and returns the IDs of the
+// peers closest to the target which responded to the lookup sorted by their distance to the target. Should ctx be
+// cancelled or expire, the peers which responded thus far are returned.
+func (it *Iterator) Find(ctx context.Context, target cryptographic.PublicKey) []cryptographic.ID {
+	return (&lookup{Iterator: it}).run(ctx, target)
+}
 
-	it.init(target)
-	go it.iterate()
+// FindValue executes an iterative FIND_VALUE lookup through the Kademlia overlay network for the value stored under
+// key, which is otherwise identical to an iterative FIND_NODE lookup for the position of key in the key space, except
+// that the lookup stops as soon as any peer responds with a value. Values are checked against the validator configured
+// on this iterator, and peers that respond with a value that is rejected are treated as having failed to respond.
+//
+// It returns the value and true should a value be found, alongside the IDs of the peers closest to the key that
+// responded to the lookup without a value, sorted by their distance to the key.
+func (it *Iterator) FindValue(ctx context.Context, key []byte) (Value, []cryptographic.ID, bool) {
+	l := &lookup{Iterator: it, key: key}
 
-	for id := range it.results {
-		closest = append(closest, id)
-	}
+	closest := l.run(ctx, HashKey(key))
 
-	closest = SortByDistance(target, closest)
+	l.Lock()
+	defer l.Unlock()
 
-	if len(closest) > it.maxNumResults {
-		closest = closest[:it.maxNumResults]
-	}
+	return l.value, closest, l.found
+}
 
-	return closest
+// FindProviders executes an iterative GET_PROVIDERS lookup through the Kademlia overlay network for the providers of
+// key, which is otherwise identical to an iterative FIND_NODE lookup for the position of key in the key space. The IDs
+// vouched for by the valid signed peer records of providers that peers respond with are passed to found as they
+// arrive, possibly concurrently and more than once. The lookup does not stop early once providers are found, and may
+// instead be stopped by cancelling ctx.
+//
+// It returns the IDs of the peers closest to key that responded to the lookup, sorted by their distance to the key.
+func (it *Iterator) FindProviders(ctx context.Context, key []byte, found func(id cryptographic.ID)) []cryptographic.ID {
+	return (&lookup{Iterator: it, key: key, providers: found}).run(ctx, HashKey(key))
 }
 
-func (it *Iterator) init(target cryptographic.PublicKey) {
-	it.results = make(chan cryptographic.ID, 1)
+// run executes the lookup for target, and returns the IDs of the peers closest to target that responded to it.
+func (l *lookup) run(ctx context.Context, target cryptographic.PublicKey) []cryptographic.ID {
+	ctx, l.cancel = context.WithCancel(ctx)
+	defer l.cancel()
+
+	l.target = target
+	l.visited = map[cryptographic.PublicKey]struct{}{l.node.ID().PubKey: {}}
 
-	it.visited = map[cryptographic.PublicKey]struct{}{
-		it.node.ID().PubKey: {},
-		target:              {},
+	if l.trace != nil {
+		l.tracer = newLookupTracer(l.node.ID().PubKey, target)
 	}
 
-	it.buckets = make([][]cryptographic.ID, it.numParallelLookups)
+	paths := make([][]cryptographic.ID, l.numParallelLookups)
 
-	for i, id := range it.table.Peers() {
-		it.visited[id.PubKey] = struct{}{}
-		it.buckets[i%it.numParallelLookups] = append(it.buckets[i%it.numParallelLookups], id)
+	// Seed the lookup with the target itself should it be in the routing table, as (*Table).FindClosestResponsive
+	// omits it.
+
+	candidates := l.table.FindClosestResponsive(target, l.maxNumResults)
+
+	if info, exists := l.table.Info(target); exists {
+		candidates = append([]cryptographic.ID{info.ID}, candidates...)
+	}
+
+	seeds := 0
+
+	for _, id := range candidates {
+		if id.PubKey == l.node.ID().PubKey || !l.puzzle.VerifyID(id) {
+			continue
+		}
+
+		paths[seeds%len(paths)] = append(paths[seeds%len(paths)], id)
+		seeds++
 	}
-}
 
-func (it *Iterator) iterate() {
-	var wg sync.WaitGroup
-	wg.Add(len(it.buckets))
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		closest []cryptographic.ID
+	)
+
+	for path, seeds := range paths {
+		if len(seeds) == 0 {
+			continue
+		}
 
-	for i := 0; i < len(it.buckets); i++ {
-		i := i
+		path, seeds := path, seeds
+
+		wg.Add(1)
 
 		go func() {
 			defer wg.Done()
-			it.processLookupBucket(i)
+
+			responded := l.path(ctx, path, seeds)
+
+			lock.Lock()
+			closest = append(closest, responded...)
+			lock.Unlock()
 		}()
 	}
 
 	wg.Wait()
 
-	close(it.results)
+	l.table.Touch(target)
+
+	closest = SortByDistance(target, closest)
+
+	if len(closest) > l.maxNumResults {
+		closest = closest[:l.maxNumResults]
+	}
+
+	if l.tracer != nil {
+		*l.trace = l.tracer.finish(closest)
+	}
+
+	return closest
 }
 
-func (it *Iterator) processLookupBucket(i int) {
-	queue := make(chan cryptographic.ID, it.numParallelRequestsPerLookup)
-	results := make(chan []cryptographic.ID, it.numParallelRequestsPerLookup)
-	pending := 0
+// claim marks id as visited, and returns true should no other path have visited id beforehand. Should the lookup be
+// traced, id is recorded as having been learned of by path after hop queries, from the peer with public key from.
+func (l *lookup) claim(id cryptographic.ID, from cryptographic.PublicKey, path, hop int) bool {
+	l.Lock()
+	defer l.Unlock()
 
-	var wg sync.WaitGroup
-	wg.Add(it.numParallelRequestsPerLookup)
+	if _, visited := l.visited[id.PubKey]; visited {
+		return false
+	}
 
-	for i := 0; i < it.numParallelRequestsPerLookup; i++ {
-		go func() {
-			defer wg.Done()
-			it.processLookupRequests(queue, results)
-		}()
+	l.visited[id.PubKey] = struct{}{}
+
+	if l.tracer != nil {
+		l.tracer.learned(id, from, path, hop)
 	}
 
+	return true
+}
+
+type candidateState uint8
+
+const (
+	candidateUnqueried candidateState = iota
+	candidateQueried
+	candidateResponded
+	candidateHeldValue
+)
+
+type candidate struct {
+	id    cryptographic.ID
+	state candidateState
+	hop   int
+}
+
+type lookupResponse struct {
+	id      cryptographic.ID
+	results []cryptographic.ID
+	found   bool
+	err     error
+}
+
+// path executes a single disjoint path of the lookup, starting from seeds, and returns the IDs of all peers that
+// responded to it without a value.
+func (l *lookup) path(ctx context.Context, path int, seeds []cryptographic.ID) []cryptographic.ID {
+	var candidates []candidate
+
+	add := func(id cryptographic.ID, from cryptographic.PublicKey, hop int) {
+		if !l.claim(id, from, path, hop) {
+			return
+		}
+
+		candidates = append(candidates, candidate{id: id, hop: hop})
+	}
+
+	for _, id := range seeds {
+		add(id, l.node.ID().PubKey, 0)
+	}
+
+	responses := make(chan lookupResponse, l.numParallelRequestsPerLookup)
+	pending := 0
+
 	for {
-		for len(it.buckets[i]) > 0 && len(queue) < cap(queue) {
-			popped := it.buckets[i][0]
-			it.buckets[i] = it.buckets[i][1:]
+		// Query the closest k candidates that have yet to be queried, up to the configured amount of parallel
+		// requests. Candidates further than the closest k are never queried.
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return closer(l.target, candidates[i].id.PubKey, candidates[j].id.PubKey)
+		})
+
+		if len(candidates) > l.maxNumResults {
+			candidates = candidates[:l.maxNumResults]
+		}
+
+		for i := 0; i < len(candidates) && pending < l.numParallelRequestsPerLookup && ctx.Err() == nil; i++ {
+			if candidates[i].state != candidateUnqueried {
+				continue
+			}
 
-			queue <- popped
+			candidates[i].state = candidateQueried
 			pending++
+
+			id := candidates[i].id
+
+			go func() {
+				start := time.Now()
+				results, found, err := l.lookupRequest(ctx, id)
+
+				if l.tracer != nil {
+					l.Lock()
+					l.tracer.queried(id.PubKey, time.Since(start), results, found, err)
+					l.Unlock()
+				}
+
+				responses <- lookupResponse{id: id, results: results, found: found, err: err}
+			}()
 		}
 
+		// The lookup terminates once the closest k candidates have all responded, or once ctx is done and all
+		// pending requests have returned.
+
 		if pending == 0 {
 			break
 		}
 
-		ids := <-results
+		res := <-responses
+		pending--
+
+		hop := 0
+
+		for i := range candidates {
+			if candidates[i].id.PubKey != res.id.PubKey {
+				continue
+			}
+
+			hop = candidates[i].hop
+
+			if res.err != nil {
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
 
-		it.Lock()
-		for _, id := range ids {
-			if _, visited := it.visited[id.PubKey]; !visited {
-				it.visited[id.PubKey] = struct{}{}
-				it.buckets[i] = append(it.buckets[i], id)
+			if res.found {
+				candidates[i].state = candidateHeldValue
+			} else {
+				candidates[i].state = candidateResponded
 			}
+
+			break
 		}
-		it.Unlock()
 
-		pending--
+		if res.err != nil {
+			l.logger.Debug("Peer failed to respond to lookup.",
+				zap.String("peer_id", res.id.String()),
+				zap.String("peer_addr", res.id.Address),
+				zap.Error(res.err),
+			)
+
+			continue
+		}
+
+		// Only peers that have responded to the lookup are recorded to the peerstore, as the peers they respond with
+		// may not be reachable at the addresses they claim.
+
+		if store := l.node.Peerstore(); store != nil {
+			store.AddAddress(res.id.PubKey, res.id.Address, peerstore.SourceKademlia)
+		}
+
+		for _, id := range res.results {
+			add(id, res.id.PubKey, hop+1)
+		}
 	}
 
-	close(queue)
-	wg.Wait()
-	close(results)
-}
+	responded := make([]cryptographic.ID, 0, len(candidates))
 
-func (it *Iterator) processLookupRequests(in <-chan cryptographic.ID, out chan<- []cryptographic.ID) {
-	for id := range in {
-		it.lookupRequest(id, out)
+	for _, c := range candidates {
+		if c.state == candidateResponded {
+			responded = append(responded, c.id)
+		}
 	}
+
+	return responded
 }
 
-func (it *Iterator) lookupRequest(id cryptographic.ID, out chan<- []cryptographic.ID) {
-	ctx, cancel := context.WithTimeout(context.Background(), it.lookupTimeout)
+// lookupRequest queries id for the peers it knows of closest to the target of the lookup, alongside the value of the
+// lookup should it be a FIND_VALUE lookup, or the providers of the lookup should it be a GET_PROVIDERS lookup. It
+// returns the IDs vouched for by the signed peer records in the response that are valid and solve the crypto puzzles
+// of the network, or true should id have responded with a valid value. The peer reached at the address of id must
+// have proven ownership of the public key of id through the handshake, as the address of an unsigned ID may otherwise
+// be that of a peer impersonating the bearer of the public key.
+func (l *lookup) lookupRequest(ctx context.Context, id cryptographic.ID) ([]cryptographic.ID, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.lookupTimeout)
 	defer cancel()
 
-	obj, err := it.node.RequestMessage(ctx, id.Address, FindNodeRequest{Target: id.PubKey})
+	client, err := l.node.Ping(ctx, id.Address)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if client.ID().PubKey != id.PubKey {
+		return nil, false, fmt.Errorf("peer at %s does not hold the public key %s", id.Address, id.PubKey)
+	}
+
+	if l.providers != nil {
+		return l.getProvidersRequest(ctx, client)
+	}
+
+	if l.key != nil {
+		return l.findValueRequest(ctx, client)
+	}
+
+	obj, err := client.RequestMessage(ctx, FindNodeRequest{Target: l.target})
 	if err != nil {
-		out <- nil
-		return
+		return nil, false, err
 	}
 
 	res, ok := obj.(FindNodeResponse)
 	if !ok {
-		out <- nil
-		return
+		return nil, false, errors.New("did not get a find node response back")
 	}
 
-	it.results <- id
+	return append(l.verify(res.Records), l.unverified(res.Unverified)...), false, nil
+}
+
+func (l *lookup) findValueRequest(ctx context.Context, client *core_module.Client) ([]cryptographic.ID, bool, error) {
+	obj, err := client.RequestMessage(ctx, FindValueRequest{Key: l.key})
+	if err != nil {
+		return nil, false, err
+	}
+
+	res, ok := obj.(FindValueResponse)
+	if !ok {
+		return nil, false, errors.New("did not get a find value response back")
+	}
+
+	if !res.Found {
+		return l.verify(res.Records), false, nil
+	}
+
+	if l.validator != nil {
+		if err := l.validator(l.key, res.Value); err != nil {
+			return nil, false, fmt.Errorf("peer responded with an invalid value: %w", err)
+		}
+	}
+
+	l.Lock()
+	if !l.found {
+		l.found = true
+		l.value = Value{Key: l.key, Data: res.Value, Expires: time.Now().Add(res.TTL)}
+	}
+	l.Unlock()
+
+	l.cancel()
+
+	return nil, true, nil
+}
+
+func (l *lookup) getProvidersRequest(
+	ctx context.Context, client *core_module.Client,
+) ([]cryptographic.ID, bool, error) {
+	obj, err := client.RequestMessage(ctx, GetProvidersRequest{Key: l.key})
+	if err != nil {
+		return nil, false, err
+	}
+
+	res, ok := obj.(GetProvidersResponse)
+	if !ok {
+		return nil, false, errors.New("did not get a get providers response back")
+	}
+
+	for _, provider := range l.verify(res.Providers) {
+		l.providers(provider)
+	}
+
+	return l.verify(res.Records), false, nil
+}
+
+// verify returns the IDs vouched for by records that are valid and solve the crypto puzzles of the network.
+func (it *Iterator) verify(records []cryptographic.Record) []cryptographic.ID {
+	results := make([]cryptographic.ID, 0, len(records))
+
+	for _, rec := range records {
+		if !rec.Verify() || !it.puzzle.VerifyID(rec.ID) {
+			continue
+		}
+
+		// Only keep the record with the highest sequence number known for each peer, and only record it should the
+		// peer already be in the routing table.
+
+		if it.table.Recorded(rec.ID.PubKey) {
+			it.table.UpdateRecord(rec)
+		}
+
+		if latest, exists := it.table.Record(rec.ID.PubKey); exists && latest.Seq > rec.Seq {
+			rec = latest
+		}
+
+		results = append(results, rec.ID)
+	}
+
+	return results
+}
+
+// unverified returns the unsigned IDs sent by peers running older versions of this library that solve the crypto
+// puzzles of the network. They may be queried by the lookup, which only queries peers that have proven ownership of
+// the public key of the ID through the handshake, but are never recorded as signed peer records. Should a signed
+// peer record be known for the bearer of an ID, the ID vouched for by the record is returned instead.
+func (it *Iterator) unverified(ids []cryptographic.ID) []cryptographic.ID {
+	results := make([]cryptographic.ID, 0, len(ids))
+
+	for _, id := range ids {
+		if rec, exists := it.table.Record(id.PubKey); exists {
+			id = rec.ID
+		}
+
+		if !it.puzzle.VerifyID(id) {
+			continue
+		}
+
+		results = append(results, id)
+	}
 
-	out <- res.Results
+	return results
 }
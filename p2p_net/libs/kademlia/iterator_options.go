@@ -3,6 +3,8 @@ package kademlia
 import (
 	"time"
 
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
 	"go.uber.org/zap"
 )
 
@@ -18,24 +20,28 @@ func WithIteratorLogger(logger *zap.Logger) IteratorOption {
 	}
 }
 
-// WithIteratorMaxNumResults sets the max number of resultant peer IDs from a single (*Iterator).Find call. By default,
-// it is set to the max capacity of a routing table bucket which is 16 based on the S/Kademlia paper.
+// WithIteratorMaxNumResults sets the max number of resultant peer IDs from a single (*Iterator).Find call, otherwise
+// known as k. Each disjoint path of a lookup terminates once the k closest peers it knows of have responded. By
+// default, it is set to the max capacity of each bucket of the routing table the iterator is bound to, which is 16
+// based on the S/Kademlia paper unless configured otherwise through WithTableBucketSize.
 func WithIteratorMaxNumResults(maxNumResults int) IteratorOption {
 	return func(it *Iterator) {
 		it.maxNumResults = maxNumResults
 	}
 }
 
-// WithIteratorNumParallelLookups sets the max number of parallel disjoint lookups that may occur at once while
-// executing (*Iterator).Find. By default, it is set to 3 based on the S/Kademlia paper.
+// WithIteratorNumParallelLookups sets the number of disjoint paths a lookup is split into while executing
+// (*Iterator).Find. No peer is queried by more than one path. By default, it is set to 3 based on the S/Kademlia
+// paper.
 func WithIteratorNumParallelLookups(numParallelLookups int) IteratorOption {
 	return func(it *Iterator) {
 		it.numParallelLookups = numParallelLookups
 	}
 }
 
-// WithIteratorNumParallelRequestsPerLookup sets the max number of parallel requests a single disjoint lookup
-// may make during the execution of (*Iterator).Find. By default, it is set to 8 based on the S/Kademlia paper.
+// WithIteratorNumParallelRequestsPerLookup sets the max number of parallel requests a single disjoint path of a lookup
+// may make during the execution of (*Iterator).Find, otherwise known as alpha. By default, it is set to 8 based on
+// the S/Kademlia paper.
 func WithIteratorNumParallelRequestsPerLookup(numParallelRequestsPerLookup int) IteratorOption {
 	return func(it *Iterator) {
 		it.numParallelRequestsPerLookup = numParallelRequestsPerLookup
@@ -49,3 +55,29 @@ func WithIteratorLookupTimeout(lookupTimeout time.Duration) IteratorOption {
 		it.lookupTimeout = lookupTimeout
 	}
 }
+
+// WithIteratorPuzzle sets the difficulty of the S/Kademlia crypto puzzles the IDs of peers must solve in order to be
+// queried, or to be returned as results by an iterator. By default, all IDs are accepted. (*Protocol).Find and
+// (*Protocol).Discover default to the difficulty configured on the protocol.
+func WithIteratorPuzzle(puzzle cryptographic.Puzzle) IteratorOption {
+	return func(it *Iterator) {
+		it.puzzle = puzzle
+	}
+}
+
+// WithIteratorValidator sets the validator values found by (*Iterator).FindValue are checked against. By default, all
+// values are accepted. (*Protocol).Get defaults to the validator configured on the protocol.
+func WithIteratorValidator(validator Validator) IteratorOption {
+	return func(it *Iterator) {
+		it.validator = validator
+	}
+}
+
+// WithIteratorTrace has the lookup executed by an iterator be recorded into trace, overwriting its contents once the
+// lookup completes. trace must not be read until the lookup completes, and should not be shared across iterators. An
+// iterator configured with a trace must not execute lookups concurrently. By default, lookups are not traced.
+func WithIteratorTrace(trace *LookupTrace) IteratorOption {
+	return func(it *Iterator) {
+		it.trace = trace
+	}
+}
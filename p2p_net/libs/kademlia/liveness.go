@@ -0,0 +1,134 @@
+package kademlia
+
+import (
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// EntryInfo describes the liveness of a single entry of a routing table.
+type EntryInfo struct {
+	// ID is the ID of the entry.
+	ID cryptographic.ID
+
+	// AddedAt is the time the entry was inserted into the routing table.
+	AddedAt time.Time
+
+	// LastSeen is the last time the entry was acknowledged via (*Table).Update, or responded to a ping recorded via
+	// (*Table).RecordRTT.
+	LastSeen time.Time
+
+	// Failures is the number of consecutive pings to the entry that have failed, as recorded via
+	// (*Table).RecordFailure. It is reset once the entry is seen again.
+	Failures int
+
+	// RTT is the smoothed round-trip time of pings to the entry, or zero should no ping to the entry have yet been
+	// recorded.
+	RTT time.Duration
+}
+
+// entryInfo is the liveness metadata kept by a routing table for each of its entries.
+type entryInfo struct {
+	addedAt  time.Time
+	lastSeen time.Time
+	failures int
+	rtt      time.Duration
+}
+
+// rttSmoothing is the weight, as a power of two, given to all previous round-trip time samples of an entry over a new
+// sample, such that the smoothed round-trip time is updated as rtt += (sample - rtt) / 8 as in RFC 6298.
+const rttSmoothing = 3
+
+// Info returns the liveness metadata of target, and true should target be in this routing table, or a zero-value
+// EntryInfo and false otherwise.
+func (t *Table) Info(target cryptographic.PublicKey) (EntryInfo, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	for _, id := range t.entries[t.getBucketIndex(target)] {
+		if id.PubKey == target {
+			info := t.info[target]
+
+			return EntryInfo{
+				ID:       id,
+				AddedAt:  info.addedAt,
+				LastSeen: info.lastSeen,
+				Failures: info.failures,
+				RTT:      info.rtt,
+			}, true
+		}
+	}
+
+	return EntryInfo{}, false
+}
+
+// RecordRTT records that target responded to a ping which took rtt to complete. Target is marked as last seen now with
+// no consecutive failures, and rtt is folded into its smoothed round-trip time. It returns false should target not be
+// in this routing table.
+func (t *Table) RecordRTT(target cryptographic.PublicKey, rtt time.Duration) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	info, exists := t.info[target]
+	if !exists {
+		return false
+	}
+
+	if info.rtt == 0 {
+		info.rtt = rtt
+	} else {
+		info.rtt += (rtt - info.rtt) >> rttSmoothing
+	}
+
+	info.lastSeen, info.failures = time.Now(), 0
+
+	t.info[target] = info
+
+	return true
+}
+
+// RecordFailure records that a ping to target failed. It returns the number of consecutive pings to target that have
+// failed, and true should target be in this routing table, or zero and false otherwise.
+func (t *Table) RecordFailure(target cryptographic.PublicKey) (int, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	info, exists := t.info[target]
+	if !exists {
+		return 0, false
+	}
+
+	info.failures++
+
+	t.info[target] = info
+
+	return info.failures, true
+}
+
+// Stale returns the IDs of all entries that have not been seen within olderThan, excluding the ID which this routing
+// table's distance metric is defined against.
+func (t *Table) Stale(olderThan time.Duration) []cryptographic.ID {
+	t.RLock()
+	defer t.RUnlock()
+
+	cutoff := time.Now().Add(-olderThan)
+
+	var stale []cryptographic.ID
+
+	for _, bucket := range t.entries {
+		for _, id := range bucket {
+			if id.PubKey != t.self.PubKey && t.info[id.PubKey].lastSeen.Before(cutoff) {
+				stale = append(stale, id)
+			}
+		}
+	}
+
+	return stale
+}
+
+// seen marks target as last seen at now with no consecutive failures. The caller must hold the write lock.
+func (t *Table) seen(target cryptographic.PublicKey, now time.Time) {
+	info := t.info[target]
+	info.lastSeen, info.failures = now, 0
+	t.info[target] = info
+}
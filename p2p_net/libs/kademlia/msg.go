@@ -1,8 +1,11 @@
 package kademlia
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"time"
 
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
 )
@@ -52,26 +55,52 @@ func UnmarshalFindNodeRequest(buf []byte) (FindNodeRequest, error) {
 	return req, nil
 }
 
-// FindNodeResponse returns the results of a FIND_NODE RPC call which comprises of the IDs of peers closest to a
-// target public key specified in a FindNodeRequest.
+// FindNodeResponse returns the results of a FIND_NODE RPC call which comprises of the signed peer records of peers
+// closest to a target public key specified in a FindNodeRequest. The signature of each record is to be verified by
+// the receiver, such that a peer may not advertise addresses on behalf of public keys it does not own.
 type FindNodeResponse struct {
-	Results []cryptographic.ID
+	Records []cryptographic.Record
+
+	// Unverified lists the unsigned peer IDs sent by peers running older versions of this library. The addresses
+	// within them are not vouched for by their bearers, and so they must not be stored as though they were signed peer
+	// records. They are only encoded should Legacy be set.
+	Unverified []cryptographic.ID
+
+	// Legacy is true should the response be sent to a peer running an older version of this library, in which case
+	// Unverified is encoded in the legacy layout in place of Records.
+	Legacy bool
 }
 
-// Marshal implements .Serializable and encodes the list of closest peer ID results into a byte representative
-// of the length of the list, concatenated with the serialized byte representation of the peer IDs themselves.
+// Marshal implements .Serializable and encodes the list of closest peer records into a zero byte, followed by the
+// length of the list as a 16-bit big-endian integer, concatenated with the serialized byte representation of the peer
+// records themselves. Peers running older versions of this library decode such a list as being empty, rather than
+// failing to decode it, and so should Legacy be set, the response is encoded in the legacy layout instead, which
+// comprises of the length of the list of unverified IDs as a single byte followed by the legacy layout of the IDs.
 func (r FindNodeResponse) Marshal() []byte {
-	buf := []byte{byte(len(r.Results))}
+	if r.Legacy {
+		return r.marshalLegacy()
+	}
+
+	records := r.Records
+
+	if len(records) > math.MaxUint16 {
+		records = records[:math.MaxUint16]
+	}
+
+	buf := []byte{0, 0, 0}
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(records)))
 
-	for _, result := range r.Results {
-		buf = append(buf, result.Marshal()...)
+	for _, record := range records {
+		buf = append(buf, record.Marshal()...)
 	}
 
 	return buf
 }
 
-// UnmarshalFindNodeResponse decodes buf, which is expected to encode a list of closest peer ID results, into a
-// FindNodeResponse. It throws an io.ErrUnexpectedEOF if buf is malformed.
+// UnmarshalFindNodeResponse decodes buf, which is expected to encode a list of closest peer records, into a
+// FindNodeResponse. Lists of unsigned peer IDs sent by peers running older versions of this library, which comprise
+// of the length of the list as a single non-zero byte followed by the IDs themselves, are decoded into
+// (FindNodeResponse).Unverified. It throws an io.ErrUnexpectedEOF if buf is malformed.
 func UnmarshalFindNodeResponse(buf []byte) (FindNodeResponse, error) {
 	var res FindNodeResponse
 
@@ -79,22 +108,484 @@ func UnmarshalFindNodeResponse(buf []byte) (FindNodeResponse, error) {
 		return res, io.ErrUnexpectedEOF
 	}
 
-	size := buf[0]
+	if buf[0] != 0 {
+		return unmarshalLegacyFindNodeResponse(buf)
+	}
+
+	if len(buf) == 1 {
+		return res, nil
+	}
+
 	buf = buf[1:]
 
-	results := make([]cryptographic.ID, 0, size)
+	if len(buf) < 2 {
+		return res, io.ErrUnexpectedEOF
+	}
 
-	for i := 0; i < cap(results); i++ {
-		id, err := cryptographic.UnmarshalID(buf)
+	size := int(binary.BigEndian.Uint16(buf[:2]))
+	buf = buf[2:]
+
+	records := make([]cryptographic.Record, 0, size)
+
+	for i := 0; i < cap(records); i++ {
+		record, n, err := cryptographic.UnmarshalRecord(buf)
 		if err != nil {
 			return res, io.ErrUnexpectedEOF
 		}
 
-		results = append(results, id)
-		buf = buf[id.Size():]
+		records = append(records, record)
+		buf = buf[n:]
+	}
+
+	res.Records = records
+
+	return res, nil
+}
+
+func (r FindNodeResponse) marshalLegacy() []byte {
+	ids := r.Unverified
+
+	if len(ids) > math.MaxUint8 {
+		ids = ids[:math.MaxUint8]
+	}
+
+	buf := []byte{byte(len(ids))}
+
+	for _, id := range ids {
+		buf = append(buf, id.MarshalLegacy()...)
+	}
+
+	return buf
+}
+
+func unmarshalLegacyFindNodeResponse(buf []byte) (FindNodeResponse, error) {
+	var res FindNodeResponse
+
+	size := int(buf[0])
+	buf = buf[1:]
+
+	ids := make([]cryptographic.ID, 0, size)
+
+	for i := 0; i < cap(ids); i++ {
+		id, n, err := cryptographic.UnmarshalID(buf)
+		if err != nil {
+			return res, io.ErrUnexpectedEOF
+		}
+
+		ids = append(ids, id)
+		buf = buf[n:]
+	}
+
+	res.Unverified = ids
+
+	return res, nil
+}
+
+// PeerRecord is sent by a peer to each peer it connects to, and carries the latest signed peer record of the sender.
+type PeerRecord struct {
+	Record cryptographic.Record
+}
+
+// Marshal implements .Serializable and returns the serialized byte representation of the peer record.
+func (r PeerRecord) Marshal() []byte {
+	return r.Record.Marshal()
+}
+
+// UnmarshalPeerRecord decodes buf into a PeerRecord. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalPeerRecord(buf []byte) (PeerRecord, error) {
+	record, _, err := cryptographic.UnmarshalRecord(buf)
+	if err != nil {
+		return PeerRecord{}, io.ErrUnexpectedEOF
+	}
+
+	return PeerRecord{Record: record}, nil
+}
+
+// StoreRequest represents a STORE RPC call in the Kademlia specification. It asks a peer to store a value under some
+// key for some amount of time.
+type StoreRequest struct {
+	Key   []byte
+	Value []byte
+	TTL   time.Duration
+}
+
+// Marshal implements .Serializable and encodes the key of this request prefixed by its length as a 16-bit big-endian
+// integer, followed by the TTL of the value in nanoseconds as a 64-bit big-endian integer, followed by the value.
+// Keys larger than 65535 bytes are truncated.
+func (r StoreRequest) Marshal() []byte {
+	key := r.Key
+	if len(key) > math.MaxUint16 {
+		key = key[:math.MaxUint16]
+	}
+
+	buf := make([]byte, 2+len(key)+8, 2+len(key)+8+len(r.Value))
+
+	binary.BigEndian.PutUint16(buf[:2], uint16(len(key)))
+	copy(buf[2:], key)
+	binary.BigEndian.PutUint64(buf[2+len(key):], uint64(r.TTL))
+
+	return append(buf, r.Value...)
+}
+
+// UnmarshalStoreRequest decodes buf into a StoreRequest. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalStoreRequest(buf []byte) (StoreRequest, error) {
+	if len(buf) < 2 {
+		return StoreRequest{}, io.ErrUnexpectedEOF
+	}
+
+	size := int(binary.BigEndian.Uint16(buf[:2]))
+	buf = buf[2:]
+
+	if len(buf) < size+8 {
+		return StoreRequest{}, io.ErrUnexpectedEOF
+	}
+
+	req := StoreRequest{
+		Key:   buf[:size],
+		TTL:   time.Duration(binary.BigEndian.Uint64(buf[size : size+8])),
+		Value: buf[size+8:],
+	}
+
+	return req, nil
+}
+
+// StoreResponse represents an empty acknowledgement that a StoreRequest was successfully handled.
+type StoreResponse struct{}
+
+// Marshal implements .Serializable and returns a nil byte slice.
+func (r StoreResponse) Marshal() []byte { return nil }
+
+// UnmarshalStoreResponse returns a StoreResponse instance and never throws an error.
+func UnmarshalStoreResponse([]byte) (StoreResponse, error) { return StoreResponse{}, nil }
+
+// FindValueRequest represents a FIND_VALUE RPC call in the Kademlia specification. It asks a peer for the value
+// stored under some key, or otherwise for the signed peer records of the peers it knows of closest to the key.
+type FindValueRequest struct {
+	Key []byte
+}
+
+// Marshal implements .Serializable and returns the key of this request.
+func (r FindValueRequest) Marshal() []byte {
+	return r.Key
+}
+
+// UnmarshalFindValueRequest decodes buf into a FindValueRequest, and never throws an error.
+func UnmarshalFindValueRequest(buf []byte) (FindValueRequest, error) {
+	return FindValueRequest{Key: buf}, nil
+}
+
+// FindValueResponse returns the results of a FIND_VALUE RPC call, which comprises of either the value stored under
+// the key specified in a FindValueRequest and the time left until it expires, or the signed peer records of the
+// peers closest to the key should no value be stored under the key.
+type FindValueResponse struct {
+	Found   bool
+	Value   []byte
+	TTL     time.Duration
+	Records []cryptographic.Record
+}
+
+// Marshal implements .Serializable. Should a value have been found, it is encoded as a one byte followed by the TTL of
+// the value in nanoseconds as a 64-bit big-endian integer, followed by the value. Otherwise, it is encoded as a zero
+// byte followed by the peer records encoded as they would be in a FindNodeResponse.
+func (r FindValueResponse) Marshal() []byte {
+	if !r.Found {
+		return append([]byte{0}, FindNodeResponse{Records: r.Records}.Marshal()...)
+	}
+
+	buf := make([]byte, 1+8, 1+8+len(r.Value))
+
+	buf[0] = 1
+	binary.BigEndian.PutUint64(buf[1:9], uint64(r.TTL))
+
+	return append(buf, r.Value...)
+}
+
+// UnmarshalFindValueResponse decodes buf into a FindValueResponse. It throws an io.ErrUnexpectedEOF if buf is
+// malformed.
+func UnmarshalFindValueResponse(buf []byte) (FindValueResponse, error) {
+	if len(buf) < 1 {
+		return FindValueResponse{}, io.ErrUnexpectedEOF
+	}
+
+	if buf[0] == 0 {
+		res, err := UnmarshalFindNodeResponse(buf[1:])
+		if err != nil {
+			return FindValueResponse{}, err
+		}
+
+		return FindValueResponse{Records: res.Records}, nil
+	}
+
+	if len(buf) < 1+8 {
+		return FindValueResponse{}, io.ErrUnexpectedEOF
 	}
 
-	res.Results = results
+	res := FindValueResponse{
+		Found: true,
+		TTL:   time.Duration(binary.BigEndian.Uint64(buf[1:9])),
+		Value: buf[9:],
+	}
 
 	return res, nil
 }
+
+// AddProviderRequest represents an ADD_PROVIDER RPC call. It announces to a peer that the sender provides the content
+// or service identified by some key, and carries the signed peer record of the sender.
+type AddProviderRequest struct {
+	Key    []byte
+	Record cryptographic.Record
+}
+
+// Marshal implements .Serializable and encodes the key of this request prefixed by its length as a 16-bit big-endian
+// integer, followed by the peer record of the provider. Keys larger than 65535 bytes are truncated.
+func (r AddProviderRequest) Marshal() []byte {
+	key := r.Key
+	if len(key) > math.MaxUint16 {
+		key = key[:math.MaxUint16]
+	}
+
+	buf := make([]byte, 2+len(key), 2+len(key)+r.Record.Size())
+
+	binary.BigEndian.PutUint16(buf[:2], uint16(len(key)))
+	copy(buf[2:], key)
+
+	return append(buf, r.Record.Marshal()...)
+}
+
+// UnmarshalAddProviderRequest decodes buf into an AddProviderRequest. It throws an io.ErrUnexpectedEOF if buf is
+// malformed.
+func UnmarshalAddProviderRequest(buf []byte) (AddProviderRequest, error) {
+	if len(buf) < 2 {
+		return AddProviderRequest{}, io.ErrUnexpectedEOF
+	}
+
+	size := int(binary.BigEndian.Uint16(buf[:2]))
+	buf = buf[2:]
+
+	if len(buf) < size {
+		return AddProviderRequest{}, io.ErrUnexpectedEOF
+	}
+
+	record, _, err := cryptographic.UnmarshalRecord(buf[size:])
+	if err != nil {
+		return AddProviderRequest{}, io.ErrUnexpectedEOF
+	}
+
+	return AddProviderRequest{Key: buf[:size], Record: record}, nil
+}
+
+// AddProviderResponse represents an empty acknowledgement that an AddProviderRequest was successfully handled.
+type AddProviderResponse struct{}
+
+// Marshal implements .Serializable and returns a nil byte slice.
+func (r AddProviderResponse) Marshal() []byte { return nil }
+
+// UnmarshalAddProviderResponse returns an AddProviderResponse instance and never throws an error.
+func UnmarshalAddProviderResponse([]byte) (AddProviderResponse, error) {
+	return AddProviderResponse{}, nil
+}
+
+// GetProvidersRequest represents a GET_PROVIDERS RPC call. It asks a peer for the providers it knows of for some key,
+// alongside the signed peer records of the peers it knows of closest to the key.
+type GetProvidersRequest struct {
+	Key []byte
+}
+
+// Marshal implements .Serializable and returns the key of this request.
+func (r GetProvidersRequest) Marshal() []byte {
+	return r.Key
+}
+
+// UnmarshalGetProvidersRequest decodes buf into a GetProvidersRequest, and never throws an error.
+func UnmarshalGetProvidersRequest(buf []byte) (GetProvidersRequest, error) {
+	return GetProvidersRequest{Key: buf}, nil
+}
+
+// GetProvidersResponse returns the results of a GET_PROVIDERS RPC call, which comprises of the signed peer records
+// of the providers of the key specified in a GetProvidersRequest, and of the peers closest to the key.
+type GetProvidersResponse struct {
+	Providers []cryptographic.Record
+	Records   []cryptographic.Record
+}
+
+// Marshal implements .Serializable and encodes the number of providers as a 16-bit big-endian integer, followed by
+// the peer records of the providers, followed by the peer records of the closest peers encoded as they would be in a
+// FindNodeResponse.
+func (r GetProvidersResponse) Marshal() []byte {
+	providers := r.Providers
+	if len(providers) > math.MaxUint16 {
+		providers = providers[:math.MaxUint16]
+	}
+
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, uint16(len(providers)))
+
+	for _, provider := range providers {
+		buf = append(buf, provider.Marshal()...)
+	}
+
+	return append(buf, FindNodeResponse{Records: r.Records}.Marshal()...)
+}
+
+// UnmarshalGetProvidersResponse decodes buf into a GetProvidersResponse. It throws an io.ErrUnexpectedEOF if buf is
+// malformed.
+func UnmarshalGetProvidersResponse(buf []byte) (GetProvidersResponse, error) {
+	if len(buf) < 2 {
+		return GetProvidersResponse{}, io.ErrUnexpectedEOF
+	}
+
+	size := int(binary.BigEndian.Uint16(buf[:2]))
+	buf = buf[2:]
+
+	providers := make([]cryptographic.Record, 0, size)
+
+	for i := 0; i < cap(providers); i++ {
+		provider, n, err := cryptographic.UnmarshalRecord(buf)
+		if err != nil {
+			return GetProvidersResponse{}, io.ErrUnexpectedEOF
+		}
+
+		providers = append(providers, provider)
+		buf = buf[n:]
+	}
+
+	res, err := UnmarshalFindNodeResponse(buf)
+	if err != nil {
+		return GetProvidersResponse{}, err
+	}
+
+	return GetProvidersResponse{Providers: providers, Records: res.Records}, nil
+}
+
+// routedDomain prefixes all data signed by the origin of a routed message, such that its signature may never be
+// mistaken as a signature of some other data.
+const routedDomain = ".__p2p_routed_message"
+
+// RoutedMessage represents a message that is routed hop by hop towards the peer closest to Target w.r.t. XOR
+// distance, which then handles it. Each peer along the way forwards the message to the peer in its routing table
+// closest to Target, should that peer be closer to Target than itself.
+type RoutedMessage struct {
+	// ID uniquely identifies the message, such that peers may detect the message being routed in a loop.
+	ID [16]byte
+
+	// Target is the position in the key space the message is routed towards.
+	Target cryptographic.PublicKey
+
+	// Origin is the ID of the peer that originally sent the message.
+	Origin cryptographic.ID
+
+	// Signature is the signature of the ID, target, hop limit, reply flag and payload of the message, produced by
+	// Origin. Peers drop messages whose signature was not produced by Origin.
+	Signature cryptographic.Signature
+
+	// Hops is the number of times the message has been forwarded thus far.
+	Hops uint8
+
+	// MaxHops is the max number of times the message may be forwarded.
+	MaxHops uint8
+
+	// Reply is true should the origin wait for a reply from the peer that handles the message.
+	Reply bool
+
+	// Payload is the arbitrary data carried by the message.
+	Payload []byte
+}
+
+// Verify returns true should the signature of this message have been produced by its origin.
+func (m RoutedMessage) Verify() bool {
+	return m.Origin.PubKey.Verify(m.signed(), m.Signature)
+}
+
+// signed returns the data the origin of this message signs. The hop count is left out, as it is incremented by each
+// peer that forwards the message.
+func (m RoutedMessage) signed() []byte {
+	buf := make([]byte, 0, len(routedDomain)+len(m.ID)+len(m.Target)+m.Origin.Size()+2+len(m.Payload))
+
+	buf = append(buf, routedDomain...)
+	buf = append(buf, m.ID[:]...)
+	buf = append(buf, m.Target[:]...)
+	buf = append(buf, m.Origin.Marshal()...)
+	buf = append(buf, m.MaxHops)
+
+	if m.Reply {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+
+	return append(buf, m.Payload...)
+}
+
+// Marshal implements .Serializable and encodes the ID and target of this message, followed by the ID of its origin,
+// followed by its signature, followed by its hop count, its hop limit, and whether a reply is expected as single
+// bytes, followed by its payload.
+func (m RoutedMessage) Marshal() []byte {
+	origin := m.Origin.Marshal()
+
+	buf := make([]byte, 0, len(m.ID)+len(m.Target)+len(origin)+len(m.Signature)+3+len(m.Payload))
+
+	buf = append(buf, m.ID[:]...)
+	buf = append(buf, m.Target[:]...)
+	buf = append(buf, origin...)
+	buf = append(buf, m.Signature[:]...)
+	buf = append(buf, m.Hops, m.MaxHops)
+
+	if m.Reply {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+
+	return append(buf, m.Payload...)
+}
+
+// UnmarshalRoutedMessage decodes buf into a RoutedMessage. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalRoutedMessage(buf []byte) (RoutedMessage, error) {
+	var m RoutedMessage
+
+	if len(buf) < len(m.ID)+len(m.Target) {
+		return RoutedMessage{}, io.ErrUnexpectedEOF
+	}
+
+	copy(m.ID[:], buf[:len(m.ID)])
+	buf = buf[len(m.ID):]
+
+	copy(m.Target[:], buf[:len(m.Target)])
+	buf = buf[len(m.Target):]
+
+	origin, n, err := cryptographic.UnmarshalID(buf)
+	if err != nil {
+		return RoutedMessage{}, io.ErrUnexpectedEOF
+	}
+
+	m.Origin = origin
+	buf = buf[n:]
+
+	if len(buf) < len(m.Signature)+3 {
+		return RoutedMessage{}, io.ErrUnexpectedEOF
+	}
+
+	copy(m.Signature[:], buf[:len(m.Signature)])
+	buf = buf[len(m.Signature):]
+
+	m.Hops, m.MaxHops, m.Reply = buf[0], buf[1], buf[2] == 1
+	m.Payload = buf[3:]
+
+	return m, nil
+}
+
+// RoutedResponse acknowledges that a RoutedMessage was handled by the peer closest to its target, and carries the
+// reply of that peer should the origin of the message have asked for one.
+type RoutedResponse struct {
+	Payload []byte
+}
+
+// Marshal implements .Serializable and returns the reply carried by this response.
+func (r RoutedResponse) Marshal() []byte {
+	return r.Payload
+}
+
+// UnmarshalRoutedResponse decodes buf into a RoutedResponse, and never throws an error.
+func UnmarshalRoutedResponse(buf []byte) (RoutedResponse, error) {
+	return RoutedResponse{Payload: buf}, nil
+}
@@ -0,0 +1,226 @@
+package kademlia
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindNodeResponse(t *testing.T) {
+	t.Parallel()
+
+	records := make([]cryptographic.Record, 0, 300)
+
+	for i := 0; i < cap(records); i++ {
+		pub, priv, err := cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+
+		id := cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), uint16(3000+i))
+		records = append(records, cryptographic.NewRecord(id, uint64(i+1), priv))
+	}
+
+	records[1].ID.Addrs = []cryptographic.Addr{cryptographic.NewAddr("node.example.com:3000")}
+
+	buf := FindNodeResponse{Records: records}.Marshal()
+	assert.EqualValues(t, 0, buf[0])
+
+	res, err := UnmarshalFindNodeResponse(buf)
+	assert.NoError(t, err)
+	assert.Len(t, res.Records, len(records))
+
+	assert.Equal(t, records[0].ID.Address, res.Records[0].ID.Address)
+	assert.Equal(t, records[0].Signature, res.Records[0].Signature)
+	assert.True(t, res.Records[0].Verify())
+
+	assert.Equal(t, records[1].ID.Addrs, res.Records[1].ID.Addrs)
+	assert.False(t, res.Records[1].Verify())
+
+	_, err = UnmarshalFindNodeResponse(buf[:len(buf)-1])
+	assert.Error(t, err)
+
+	// Lists of unsigned IDs sent by peers running older versions of this library are decoded as being unverified.
+
+	legacy := []byte{2}
+	legacy = append(legacy, records[0].ID.Marshal()...)
+	legacy = append(legacy, records[2].ID.Marshal()...)
+
+	res, err = UnmarshalFindNodeResponse(legacy)
+	assert.NoError(t, err)
+	assert.Len(t, res.Records, 0)
+	assert.Equal(t, []cryptographic.ID{records[0].ID, records[2].ID}, res.Unverified)
+
+	assert.Equal(t, []byte{0, 0, 0}, res.Marshal())
+
+	// Responses sent to peers running older versions of this library are encoded in the legacy layout, with IDs
+	// stripped down to their host and port.
+
+	res.Legacy = true
+	assert.Equal(t, legacy, res.Marshal())
+
+	res.Unverified = []cryptographic.ID{records[1].ID}
+
+	decoded, err := UnmarshalFindNodeResponse(res.Marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, []cryptographic.ID{cryptographic.NewID(records[1].ID.PubKey, records[1].ID.Host, records[1].ID.Port)},
+		decoded.Unverified,
+	)
+
+	_, err = UnmarshalFindNodeResponse(legacy[:len(legacy)-1])
+	assert.Error(t, err)
+
+	res, err = UnmarshalFindNodeResponse([]byte{0})
+	assert.NoError(t, err)
+	assert.Len(t, res.Records, 0)
+}
+
+func TestStoreRequest(t *testing.T) {
+	t.Parallel()
+
+	req := StoreRequest{Key: []byte("key"), Value: []byte("value"), TTL: time.Hour}
+
+	decoded, err := UnmarshalStoreRequest(req.Marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, req, decoded)
+
+	buf := req.Marshal()
+
+	_, err = UnmarshalStoreRequest(buf[:2+len(req.Key)+7])
+	assert.Error(t, err)
+}
+
+func TestFindValueResponse(t *testing.T) {
+	t.Parallel()
+
+	res := FindValueResponse{Found: true, Value: []byte("value"), TTL: time.Minute}
+
+	decoded, err := UnmarshalFindValueResponse(res.Marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, res, decoded)
+
+	pub, priv, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	rec := cryptographic.NewRecord(cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), 3000), 1, priv)
+
+	decoded, err = UnmarshalFindValueResponse(FindValueResponse{Records: []cryptographic.Record{rec}}.Marshal())
+	assert.NoError(t, err)
+	assert.False(t, decoded.Found)
+	assert.Len(t, decoded.Records, 1)
+	assert.True(t, decoded.Records[0].Verify())
+
+	_, err = UnmarshalFindValueResponse([]byte{1, 0, 0})
+	assert.Error(t, err)
+}
+
+func TestProviderMessages(t *testing.T) {
+	t.Parallel()
+
+	records := make([]cryptographic.Record, 0, 3)
+
+	for i := 0; i < cap(records); i++ {
+		pub, priv, err := cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+
+		id := cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), uint16(3000+i))
+		records = append(records, cryptographic.NewRecord(id, uint64(i+1), priv))
+	}
+
+	req := AddProviderRequest{Key: []byte("content"), Record: records[0]}
+
+	decoded, err := UnmarshalAddProviderRequest(req.Marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, req.Key, decoded.Key)
+	assert.Equal(t, req.Record.Marshal(), decoded.Record.Marshal())
+	assert.True(t, decoded.Record.Verify())
+
+	_, err = UnmarshalAddProviderRequest(req.Marshal()[:10])
+	assert.Error(t, err)
+
+	res := GetProvidersResponse{Providers: records[:1], Records: records[1:]}
+
+	got, err := UnmarshalGetProvidersResponse(res.Marshal())
+	assert.NoError(t, err)
+	assert.Len(t, got.Providers, 1)
+	assert.Len(t, got.Records, 2)
+	assert.Equal(t, records[0].Marshal(), got.Providers[0].Marshal())
+
+	for _, rec := range append(got.Providers, got.Records...) {
+		assert.True(t, rec.Verify())
+	}
+
+	got, err = UnmarshalGetProvidersResponse(GetProvidersResponse{}.Marshal())
+	assert.NoError(t, err)
+	assert.Empty(t, got.Providers)
+	assert.Empty(t, got.Records)
+
+	_, err = UnmarshalGetProvidersResponse([]byte{0, 1, 0})
+	assert.Error(t, err)
+}
+
+func TestRoutedMessages(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	msg := RoutedMessage{
+		ID:      [16]byte{1, 2, 3},
+		Target:  HashKey([]byte("key")),
+		Origin:  cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), 3000),
+		Hops:    2,
+		MaxHops: 16,
+		Reply:   true,
+		Payload: []byte("payload"),
+	}
+
+	msg.Signature = priv.Sign(msg.signed())
+
+	decoded, err := UnmarshalRoutedMessage(msg.Marshal())
+	assert.NoError(t, err)
+	assert.Equal(t, msg.ID, decoded.ID)
+	assert.Equal(t, msg.Target, decoded.Target)
+	assert.Equal(t, msg.Origin.Marshal(), decoded.Origin.Marshal())
+	assert.Equal(t, msg.Signature, decoded.Signature)
+	assert.True(t, decoded.Verify())
+	assert.Equal(t, msg.Hops, decoded.Hops)
+	assert.Equal(t, msg.MaxHops, decoded.MaxHops)
+	assert.True(t, decoded.Reply)
+	assert.Equal(t, msg.Payload, decoded.Payload)
+
+	// Forwarding the message does not invalidate its signature, though tampering with it does.
+
+	forged := decoded
+	forged.Hops++
+	assert.True(t, forged.Verify())
+
+	forged.MaxHops++
+	assert.False(t, forged.Verify())
+
+	forged.MaxHops--
+	forged.Payload = []byte("forged")
+	assert.False(t, forged.Verify())
+
+	other, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	forged.Payload = decoded.Payload
+	forged.Origin = cryptographic.NewID(other, net.ParseIP("10.0.0.1"), 3000)
+	assert.False(t, forged.Verify())
+
+	msg.Reply, msg.Payload = false, nil
+
+	decoded, err = UnmarshalRoutedMessage(msg.Marshal())
+	assert.NoError(t, err)
+	assert.False(t, decoded.Reply)
+	assert.Empty(t, decoded.Payload)
+
+	_, err = UnmarshalRoutedMessage(msg.Marshal()[:len(msg.Marshal())-1])
+	assert.Error(t, err)
+
+	_, err = UnmarshalRoutedMessage(msg.Marshal()[:40])
+	assert.Error(t, err)
+}
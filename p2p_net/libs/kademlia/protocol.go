@@ -6,19 +6,43 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"sync"
 	"time"
 
 	"awesomeProject/beacon/p2p_network/core_module"
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/peerstore"
 
 	"go.uber.org/zap"
 )
 
-// BucketSize returns the capacity, or the total number of peer ID entries a single routing table bucket may hold.
+// BucketSize returns the default capacity, or the total number of peer ID entries a single routing table bucket may
+// hold. It may be configured per routing table through WithTableBucketSize, or per Protocol through
+// WithProtocolBucketSize.
 const BucketSize int = 16
 
-// ErrBucketFull is returned when a routing table bucket is at max capacity.
-var ErrBucketFull = errors.New("bucket is full")
+var (
+	// ErrBucketFull is returned when a routing table bucket is at max capacity.
+	ErrBucketFull = errors.New("bucket is full")
+
+	// ErrValueNotFound is returned by (*Protocol).Get when no value is stored under a key.
+	ErrValueNotFound = errors.New("value not found")
+
+	// ErrKeyTooLarge is returned when storing a value under a key that is larger than 65535 bytes.
+	ErrKeyTooLarge = errors.New("key is too large")
+
+	// ErrDiversityLimit is returned when admitting a peer into the routing table would exceed its IP diversity limits.
+	ErrDiversityLimit = errors.New("ip diversity limit exceeded")
+
+	// ErrStorageQuotaExceeded is returned when storing a value on behalf of a peer would exceed the number of keys or
+	// bytes the peer may have stored on your node, or the total number of bytes stored on behalf of all peers.
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+
+	// ErrProviderLimit is returned when recording a provider of a key would exceed the number of keys the provider may
+	// provide, or the number of keys providers are tracked for.
+	ErrProviderLimit = errors.New("provider limit exceeded")
+)
 
 // Protocol implements routing/discovery portion of the Kademlia protocol with improvements suggested by the
 // S/Kademlia paper. It is expected that Protocol is bound to a .Node via (*.Node).Bind before the node
@@ -29,14 +53,89 @@ type Protocol struct {
 	table  *Table
 
 	events Events
+	puzzle cryptographic.Puzzle
+
+	storage           Storage
+	quota             *storageQuota
+	maxPeerKeys       int
+	maxPeerBytes      int
+	maxStorageBytes   int
+	validator         Validator
+	valueTTL          time.Duration
+	republishInterval time.Duration
+
+	providers       *providerStore
+	maxProviderKeys int
+	maxProvidedKeys int
+	provided        map[string]struct{}
+	providedLock    sync.Mutex
+	providerTTL     time.Duration
+	provideInterval time.Duration
+
+	refreshInterval time.Duration
+	maxPingFailures int
+
+	evictions         *evictionQueue
+	evictionQueueSize int
+	evictionWorkers   int
+
+	diversity  DiversityLimits
+	bucketSize int
+
+	snapshotPath     string
+	snapshotInterval time.Duration
+
+	routes         *routeCache
+	routedHandlers []RoutedHandler
+	routedLock     sync.RWMutex
+	routeMaxHops   uint8
+	routeTimeout   time.Duration
+	routing        chan routedRequest
+	routeQueueSize int
+	routeWorkers   int
 
 	pingTimeout time.Duration
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
 // New returns a new instance of the Kademlia protocol.
 func New(opts ...ProtocolOption) *Protocol {
 	p := &Protocol{
 		pingTimeout: 3 * time.Second,
+
+		valueTTL:          24 * time.Hour,
+		maxPeerKeys:       1024,
+		maxPeerBytes:      1 << 20,
+		maxStorageBytes:   64 << 20,
+		republishInterval: time.Hour,
+
+		maxProviderKeys: 1024,
+		maxProvidedKeys: 65536,
+		provided:        make(map[string]struct{}),
+		providerTTL:     24 * time.Hour,
+		provideInterval: 12 * time.Hour,
+
+		refreshInterval: 15 * time.Minute,
+		maxPingFailures: 1,
+
+		evictionQueueSize: 64,
+		evictionWorkers:   1,
+
+		diversity:  DefaultDiversityLimits,
+		bucketSize: BucketSize,
+
+		snapshotInterval: 10 * time.Minute,
+
+		routeMaxHops: 16,
+		routeTimeout: 10 * time.Second,
+
+		routeQueueSize: 64,
+		routeWorkers:   8,
+
+		done: make(chan struct{}),
 	}
 
 	for _, opt := range opts {
@@ -46,31 +145,48 @@ func New(opts ...ProtocolOption) *Protocol {
 	return p
 }
 
-// Find executes the FIND_NODE S/Kademlia RPC call to find the closest peers to some given target public key. It
-// returns the IDs of the closest peers it finds.
-func (p *Protocol) Find(target cryptographic.PublicKey, opts ...IteratorOption) []cryptographic.ID {
-	return NewIterator(p.node, p.table, opts...).Find(target)
+// Find executes an iterative FIND_NODE S/Kademlia lookup to find the closest peers to some given target public key.
+// It returns the IDs of the closest peers it finds that responded to the lookup, which includes the target should it
+// be found. The lookup is aborted should ctx be cancelled or expire, in which case the closest peers found thus far
+// are returned. See (*Iterator).Find.
+func (p *Protocol) Find(
+	ctx context.Context, target cryptographic.PublicKey, opts ...IteratorOption,
+) []cryptographic.ID {
+	opts = append([]IteratorOption{WithIteratorPuzzle(p.puzzle)}, opts...)
+	return NewIterator(p.node, p.table, opts...).Find(ctx, target)
 }
 
 // Discover attempts to discover new peers to your node through peers your node  already knows about by calling
 // the FIND_NODE S/Kademlia RPC call with your nodes ID.
 func (p *Protocol) Discover(opts ...IteratorOption) []cryptographic.ID {
-	return p.Find(p.node.ID().PubKey, opts...)
+	return p.Find(context.Background(), p.node.ID().PubKey, opts...)
 }
 
 // Ping sends a ping request to addr, and returns no error if a pong is received back before ctx has expired/was
 // cancelled. It also throws an error if the connection to addr intermittently drops, or if handshaking with addr
-// should there be no live connection to addr yet fails.
+// should there be no live connection to addr yet fails. Should the peer at addr be in the routing table, the
+// round-trip time of the ping is recorded via (*Table).RecordRTT, or its failure via (*Table).RecordFailure.
 func (p *Protocol) Ping(ctx context.Context, addr string) error {
-	msg, err := p.node.RequestMessage(ctx, addr, Ping{})
+	client, err := p.node.Ping(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("failed to ping: %v", err)
+	}
+
+	start := time.Now()
+
+	msg, err := client.RequestMessage(ctx, Ping{})
 	if err != nil {
+		p.table.RecordFailure(client.ID().PubKey)
 		return fmt.Errorf("failed to ping: %v", err)
 	}
 
 	if _, ok := msg.(Pong); !ok {
+		p.table.RecordFailure(client.ID().PubKey)
 		return errors.New("did not get a pong back")
 	}
 
+	p.table.RecordRTT(client.ID().PubKey, time.Since(start))
+
 	return nil
 }
 
@@ -80,79 +196,65 @@ func (p *Protocol) Table() *Table {
 }
 
 // Ack attempts to insert a peer ID into your nodes routing table. If the routing table bucket in which your peer ID
-// was expected to be inserted on is full, the peer ID at the tail of the bucket is pinged. If the ping fails, the
-// peer ID at the tail of the bucket is evicted and your peer ID is inserted to the head of the bucket.
+// was expected to be inserted on is full, a liveness check of the peer ID at the tail of the bucket is queued, and Ack
+// returns without waiting for it. If the tail fails to respond to being pinged, it is evicted and your peer ID is
+// inserted to the head of the bucket. Otherwise, your peer ID is cached in the replacement cache of the bucket, from
+// which it may be promoted into the bucket once an entry of the bucket is evicted. Checks of the same tail are
+// coalesced. Peer IDs that do not solve the crypto puzzles of the network are never inserted, and peer IDs that would
+// exceed the IP diversity limits of the routing table are rejected. Unless your node has observed the peer over a
+// connection, the limits are enforced upon the host the peer claims.
 func (p *Protocol) Ack(id cryptographic.ID) {
-	for {
-		inserted, err := p.table.Update(id)
-		if err == nil {
-			if inserted {
-				p.logger.Debug("Peer was inserted into routing table.",
-					zap.String("peer_id", id.String()),
-					zap.String("peer_addr", id.Address),
-				)
-			}
-
-			if inserted {
-				if p.events.OnPeerAdmitted != nil {
-					p.events.OnPeerAdmitted(id)
-				}
-			} else {
-				if p.events.OnPeerActivity != nil {
-					p.events.OnPeerActivity(id)
-				}
-			}
-
-			return
-		}
+	p.ack(id, nil)
+}
 
-		bucket := p.table.Bucket(id.PubKey)
-		last := bucket[len(bucket)-1]
+// ack is (*Protocol).Ack for a peer your node is connected to, where host is the host of the connection as observed
+// by your node, or nil should the connection not be over IP. See (*Table).UpdateObserved.
+func (p *Protocol) ack(id cryptographic.ID, host net.IP) {
+	if !p.puzzle.VerifyID(id) {
+		p.logger.Debug("Peer was refused from routing table as its ID does not solve the crypto puzzles of the network.",
+			zap.String("peer_id", id.String()),
+			zap.String("peer_addr", id.Address),
+		)
 
-		ctx, cancel := context.WithTimeout(context.Background(), p.pingTimeout)
-		pong, err := p.node.RequestMessage(ctx, last.Address, Ping{})
-		cancel()
+		return
+	}
 
-		if err != nil {
-			if id, deleted := p.table.Delete(last.PubKey); deleted {
-				p.logger.Debug("Peer was evicted from routing table by failing to be pinged.",
-					zap.String("peer_id", id.String()),
-					zap.String("peer_addr", id.Address),
-					zap.Error(err),
-				)
+	inserted, err := p.table.UpdateObserved(id, host)
+	if errors.Is(err, ErrDiversityLimit) {
+		p.logger.Debug("Peer was refused from routing table as it would exceed its IP diversity limits.",
+			zap.String("peer_id", id.String()),
+			zap.String("peer_addr", id.Address),
+			zap.Error(err),
+		)
 
-				if p.events.OnPeerEvicted != nil {
-					p.events.OnPeerEvicted(id)
-				}
-			}
-			continue
+		if p.events.OnPeerRejected != nil {
+			p.events.OnPeerRejected(id, err)
 		}
 
-		if _, ok := pong.(Pong); !ok {
-			if id, deleted := p.table.Delete(last.PubKey); deleted {
-				p.logger.Debug("Peer was evicted from routing table by failing to be pinged.",
-					zap.String("peer_id", id.String()),
-					zap.String("peer_addr", id.Address),
-					zap.Error(err),
-				)
+		return
+	}
 
-				if p.events.OnPeerEvicted != nil {
-					p.events.OnPeerEvicted(id)
-				}
-			}
-			continue
+	if err != nil {
+		if bucket := p.table.Bucket(id.PubKey); len(bucket) > 0 {
+			p.queueEviction(bucket[len(bucket)-1], id, host)
 		}
 
-		p.logger.Debug("Peer failed to be inserted into routing table as it's intended bucket is full.",
+		return
+	}
+
+	if inserted {
+		p.logger.Debug("Peer was inserted into routing table.",
 			zap.String("peer_id", id.String()),
 			zap.String("peer_addr", id.Address),
 		)
 
-		if p.events.OnPeerEvicted != nil {
-			p.events.OnPeerEvicted(id)
+		if p.events.OnPeerAdmitted != nil {
+			p.events.OnPeerAdmitted(id)
+		}
+	} else {
+		if p.events.OnPeerActivity != nil {
+			p.events.OnPeerActivity(id)
 		}
-
-		return
 	}
 }
 
@@ -160,6 +262,7 @@ func (p *Protocol) Ack(id cryptographic.ID) {
 func (p *Protocol) Protocol() core_module.Protocol {
 	return core_module.Protocol{
 		Bind:            p.Bind,
+		Close:           p.Close,
 		OnPeerConnected: p.OnPeerConnected,
 		OnPingFailed:    p.OnPingFailed,
 		OnMessageSent:   p.OnMessageSent,
@@ -167,33 +270,127 @@ func (p *Protocol) Protocol() core_module.Protocol {
 	}
 }
 
-// Bind registers messages Ping, Pong, FindNodeRequest, FindNodeResponse, and handles them by registering the
-// (*Protocol).Handle Handler.
+// Bind registers messages Ping, Pong, FindNodeRequest, FindNodeResponse, PeerRecord, StoreRequest, StoreResponse,
+// FindValueRequest, FindValueResponse, AddProviderRequest, AddProviderResponse, GetProvidersRequest,
+// GetProvidersResponse, RoutedMessage, RoutedResponse, and handles them by registering the (*Protocol).Handle
+// Handler. Should the node be configured with a peerstore, the routing table is seeded with all peers recorded in the
+// peerstore, with the most recently seen peers taking precedence. Should a snapshot file be configured through
+// WithProtocolTableSnapshot, the routing table is first restored from it. Should no crypto puzzle difficulty be
+// configured through WithProtocolPuzzle, the difficulty configured on the node is used.
+//
+// Bind starts a goroutine which periodically expires and republishes stored values, re-announces provided keys,
+// maintains the routing table via (*Protocol).Refresh, and saves snapshots of the routing table, alongside the
+// goroutines which execute the liveness checks queued by (*Protocol).Ack and which forward routed messages on behalf of
+// other peers, until (*Protocol).Close is called.
 func (p *Protocol) Bind(node *core_module.Node) error {
 	p.node = node
-	p.table = NewTable(p.node.ID())
+	p.table = NewTable(p.node.ID(), WithTableBucketSize(p.bucketSize))
+	p.table.SetDiversityLimits(p.diversity)
+
+	p.routes = newRouteCache(2 * p.routeTimeout)
 
 	if p.logger == nil {
 		p.logger = p.node.Logger()
 	}
 
+	if p.puzzle == (cryptographic.Puzzle{}) {
+		p.puzzle = p.node.Puzzle()
+	}
+
+	if p.snapshotPath != "" {
+		p.loadSnapshot()
+	}
+
+	if store := p.node.Peerstore(); store != nil {
+		for _, peer := range store.Peers() {
+			if len(peer.Addresses) == 0 {
+				continue
+			}
+
+			id := peerstore.NewID(peer.PubKey, peer.Addresses[0].Addr)
+			id.Nonce = peer.Nonce
+
+			if !p.puzzle.VerifyID(id) {
+				continue
+			}
+
+			_, _ = p.table.Update(id)
+		}
+	}
+
 	node.RegisterMessage(Ping{}, UnmarshalPing)
 	node.RegisterMessage(Pong{}, UnmarshalPong)
 	node.RegisterMessage(FindNodeRequest{}, UnmarshalFindNodeRequest)
 	node.RegisterMessage(FindNodeResponse{}, UnmarshalFindNodeResponse)
+	node.RegisterMessage(PeerRecord{}, UnmarshalPeerRecord)
+	node.RegisterMessage(StoreRequest{}, UnmarshalStoreRequest)
+	node.RegisterMessage(StoreResponse{}, UnmarshalStoreResponse)
+	node.RegisterMessage(FindValueRequest{}, UnmarshalFindValueRequest)
+	node.RegisterMessage(FindValueResponse{}, UnmarshalFindValueResponse)
+	node.RegisterMessage(AddProviderRequest{}, UnmarshalAddProviderRequest)
+	node.RegisterMessage(AddProviderResponse{}, UnmarshalAddProviderResponse)
+	node.RegisterMessage(GetProvidersRequest{}, UnmarshalGetProvidersRequest)
+	node.RegisterMessage(GetProvidersResponse{}, UnmarshalGetProvidersResponse)
+	node.RegisterMessage(RoutedMessage{}, UnmarshalRoutedMessage)
+	node.RegisterMessage(RoutedResponse{}, UnmarshalRoutedResponse)
 
 	node.Handle(p.Handle)
 
+	if p.storage == nil {
+		p.storage = NewMemoryStorage()
+	}
+
+	p.quota = newStorageQuota(p.maxPeerKeys, p.maxPeerBytes, p.maxStorageBytes)
+
+	// Values stored on behalf of peers before your node restarted keep counting against their quota.
+
+	values, err := p.storage.Values()
+	if err != nil {
+		return fmt.Errorf("failed to read values from storage: %w", err)
+	}
+
+	now := time.Now()
+
+	for _, v := range values {
+		if !v.Expired(now) {
+			p.quota.restore(v)
+		}
+	}
+
+	p.providers = newProviderStore(4*p.table.BucketSize(), p.maxProviderKeys, p.maxProvidedKeys)
+
+	p.evictions = newEvictionQueue(p.evictionQueueSize)
+
+	p.wg.Add(1)
+	go p.maintain()
+
+	for i := 0; i < p.evictionWorkers; i++ {
+		p.wg.Add(1)
+		go p.evictor()
+	}
+
+	p.routing = make(chan routedRequest, p.routeQueueSize)
+
+	for i := 0; i < p.routeWorkers; i++ {
+		p.wg.Add(1)
+		go p.router()
+	}
+
 	return nil
 }
 
 // OnPeerConnected attempts to acknowledge the new peers existence by placing its entry into your nodes' routing table
-// via (*Protocol).Ack.
+// via (*Protocol).Ack, and sends the new peer the latest signed peer record of your node.
 func (p *Protocol) OnPeerConnected(client *core_module.Client) {
-	p.Ack(client.ID())
+	p.ack(reachableID(client), observedHost(client))
+
+	if err := client.SendMessage(PeerRecord{Record: p.node.Record()}); err != nil {
+		p.logger.Debug("Failed to send peer record.", zap.Error(err))
+	}
 }
 
-// OnPingFailed evicts peers that your node has failed to dial.
+// OnPingFailed evicts peers that your node has failed to dial, and promotes a candidate from the replacement cache of
+// their bucket in their place.
 func (p *Protocol) OnPingFailed(addr string, err error) {
 	if id, deleted := p.table.DeleteByAddress(addr); deleted {
 		p.logger.Debug("Peer was evicted from routing table by failing to be dialed.", zap.Error(err))
@@ -201,22 +398,48 @@ func (p *Protocol) OnPingFailed(addr string, err error) {
 		if p.events.OnPeerEvicted != nil {
 			p.events.OnPeerEvicted(id)
 		}
+
+		p.promote(id.PubKey)
 	}
 }
 
 // OnMessageSent implements .Protocol and attempts to push the position in which the clients ID resides in
 // your nodes' routing table's to the head of the bucket it reside within.
 func (p *Protocol) OnMessageSent(client *core_module.Client) {
-	p.Ack(client.ID())
+	p.ack(reachableID(client), observedHost(client))
 }
 
 // OnMessageRecv implements .Protocol and attempts to push the position in which the clients ID resides in
 // your nodes' routing table's to the head of the bucket it reside within.
 func (p *Protocol) OnMessageRecv(client *core_module.Client) {
-	p.Ack(client.ID())
+	p.ack(reachableID(client), observedHost(client))
+}
+
+// reachableID returns the ID of the peer client is connected to, bearing the address at which the peer may be
+// reached. See (*core_module.Client).Addr.
+func reachableID(client *core_module.Client) cryptographic.ID {
+	id := client.ID()
+	id.Address = client.Addr()
+
+	return id
+}
+
+// observedHost returns the host of the connection of client as observed by your node, or nil should the connection
+// not be over IP.
+func observedHost(client *core_module.Client) net.IP {
+	switch addr := client.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+
+	return nil
 }
 
-// Handle implements .Protocol and handles Ping and FindNodeRequest messages.
+// Handle implements .Protocol and handles Ping, FindNodeRequest, PeerRecord, StoreRequest, FindValueRequest,
+// AddProviderRequest, GetProvidersRequest and RoutedMessage messages. Peer records are only accepted from the peer
+// they vouch for, and only should their signature be valid.
 func (p *Protocol) Handle(ctx core_module.HandlerContext) error {
 	msg, err := ctx.DecodeMessage()
 	if err != nil {
@@ -233,8 +456,100 @@ func (p *Protocol) Handle(ctx core_module.HandlerContext) error {
 		if !ctx.IsRequest() {
 			return errors.New("got a find node request that was not sent as a request")
 		}
-		return ctx.SendMessage(FindNodeResponse{Results: p.table.FindClosest(msg.Target, BucketSize)})
+
+		closest := p.closest(msg.Target, ctx.ID().PubKey)
+
+		// Peers running older versions of this library only decode lists of IDs in the legacy layout.
+
+		if !ctx.Client().Extended() {
+			return ctx.SendMessage(FindNodeResponse{Unverified: closest, Legacy: true})
+		}
+
+		return ctx.SendMessage(FindNodeResponse{Records: p.records(closest)})
+	case PeerRecord:
+		if msg.Record.ID.PubKey != ctx.ID().PubKey {
+			return errors.New("got a peer record that does not belong to the sender")
+		}
+
+		if !msg.Record.Verify() {
+			return errors.New("got a peer record with an invalid signature")
+		}
+
+		if !p.puzzle.VerifyID(msg.Record.ID) { // Peers below the difficulty of the network are simply never admitted.
+			return nil
+		}
+
+		p.table.UpdateRecord(msg.Record)
+	case StoreRequest:
+		if !ctx.IsRequest() {
+			return errors.New("got a store request that was not sent as a request")
+		}
+		return p.handleStoreRequest(ctx, msg)
+	case FindValueRequest:
+		if !ctx.IsRequest() {
+			return errors.New("got a find value request that was not sent as a request")
+		}
+		return p.handleFindValueRequest(ctx, msg)
+	case AddProviderRequest:
+		if !ctx.IsRequest() {
+			return errors.New("got an add provider request that was not sent as a request")
+		}
+		return p.handleAddProviderRequest(ctx, msg)
+	case GetProvidersRequest:
+		if !ctx.IsRequest() {
+			return errors.New("got a get providers request that was not sent as a request")
+		}
+		return p.handleGetProvidersRequest(ctx, msg)
+	case RoutedMessage:
+		if !ctx.IsRequest() {
+			return errors.New("got a routed message that was not sent as a request")
+		}
+		return p.handleRoutedMessage(ctx, msg)
 	}
 
 	return nil
 }
+
+// closest returns the k peer IDs in the routing table closest to target, excluding the ID of the requester, where k
+// is the max capacity of each bucket of the routing table. Responsive peers are preferred over peers whose last pings
+// failed. Should target itself be in the routing table, it is included as the closest result.
+func (p *Protocol) closest(target, requester cryptographic.PublicKey) []cryptographic.ID {
+	k := p.table.BucketSize()
+	closest := make([]cryptographic.ID, 0, k+1)
+
+	if target != requester {
+		if info, exists := p.table.Info(target); exists {
+			closest = append(closest, info.ID)
+		}
+	}
+
+	for _, id := range p.table.FindClosestResponsive(target, k+1) {
+		if id.PubKey != requester {
+			closest = append(closest, id)
+		}
+	}
+
+	if len(closest) > k {
+		closest = closest[:k]
+	}
+
+	return closest
+}
+
+// records returns the signed peer records of ids. IDs for which no signed peer record is known are omitted.
+func (p *Protocol) records(ids []cryptographic.ID) []cryptographic.Record {
+	records := make([]cryptographic.Record, 0, len(ids))
+
+	for _, id := range ids {
+		if id.PubKey == p.node.ID().PubKey {
+			records = append(records, p.node.Record())
+			continue
+		}
+
+		if rec, exists := p.table.Record(id.PubKey); exists {
+			records = append(records, rec)
+		}
+	}
+
+	return records
+}
@@ -3,6 +3,8 @@ package kademlia
 import (
 	"time"
 
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
 	"go.uber.org/zap"
 )
 
@@ -32,3 +34,204 @@ func WithProtocolPingTimeout(pingTimeout time.Duration) ProtocolOption {
 		p.pingTimeout = pingTimeout
 	}
 }
+
+// WithProtocolPuzzle sets the difficulty of the S/Kademlia crypto puzzles the IDs of peers must solve in order to be
+// admitted into the routing table, or to be returned as the results of lookups. By default, the difficulty configured
+// on the node the protocol is bound to via WithNodePuzzle is used.
+func WithProtocolPuzzle(puzzle cryptographic.Puzzle) ProtocolOption {
+	return func(p *Protocol) {
+		p.puzzle = puzzle
+	}
+}
+
+// WithProtocolBucketSize sets the max capacity of each bucket of your nodes' routing table, otherwise known as k,
+// which is also the number of closest peers returned in response to lookups. By default, it is set to BucketSize.
+func WithProtocolBucketSize(k int) ProtocolOption {
+	return func(p *Protocol) {
+		p.bucketSize = k
+	}
+}
+
+// WithProtocolStorage sets the local storage backend values stored on your node on behalf of the DHT are kept in. By
+// default, values are kept in memory through a MemoryStorage.
+func WithProtocolStorage(storage Storage) ProtocolOption {
+	return func(p *Protocol) {
+		p.storage = storage
+	}
+}
+
+// WithProtocolStorageQuota sets the max number of keys, and the max number of bytes comprising of their keys and
+// values, that any single peer may have stored on your node through STORE requests. STORE requests that would exceed
+// the quota are refused until values stored on behalf of the peer expire. Non-positive limits are not enforced. By
+// default, each peer may have 1024 keys and 1 MiB stored.
+func WithProtocolStorageQuota(keys, bytes int) ProtocolOption {
+	return func(p *Protocol) {
+		p.maxPeerKeys = keys
+		p.maxPeerBytes = bytes
+	}
+}
+
+// WithProtocolStorageLimit sets the max number of bytes comprising of keys and values that all peers together may
+// have stored on your node through STORE requests. A non-positive limit is not enforced. By default, it is set to
+// 64 MiB.
+func WithProtocolStorageLimit(bytes int) ProtocolOption {
+	return func(p *Protocol) {
+		p.maxStorageBytes = bytes
+	}
+}
+
+// WithProtocolValidator sets the validator values are checked against before they are stored on your node, and after
+// they are fetched from peers through (*Protocol).Get. By default, all values are accepted, such that any peer may
+// store, or overwrite, any value under any key on your node. Applications that need values under a key to only ever
+// be written by some party should configure a validator that checks so, such as by checking that values are signed
+// by a key the key of the value is derived from.
+func WithProtocolValidator(validator Validator) ProtocolOption {
+	return func(p *Protocol) {
+		p.validator = validator
+	}
+}
+
+// WithProtocolValueTTL sets the amount of time values stored through (*Protocol).Put live for, which is also the max
+// amount of time values stored on your node by peers may live for. By default, it is set to 24 hours.
+func WithProtocolValueTTL(ttl time.Duration) ProtocolOption {
+	return func(p *Protocol) {
+		p.valueTTL = ttl
+	}
+}
+
+// WithProtocolRepublishInterval sets the interval at which values stored on your node are republished to the peers
+// closest to them, and at which expired values are deleted. A non-positive interval disables republishing. By
+// default, it is set to 1 hour.
+func WithProtocolRepublishInterval(interval time.Duration) ProtocolOption {
+	return func(p *Protocol) {
+		p.republishInterval = interval
+	}
+}
+
+// WithProtocolProviderTTL sets the amount of time your node remembers a peer as a provider of a key after the peer
+// announces itself as one, and the amount of time peers are asked to remember your node as a provider of keys passed
+// to (*Protocol).Provide. By default, it is set to 24 hours.
+func WithProtocolProviderTTL(ttl time.Duration) ProtocolOption {
+	return func(p *Protocol) {
+		p.providerTTL = ttl
+	}
+}
+
+// WithProtocolProviderLimits sets the max number of keys any single peer may be remembered by your node as a provider
+// of, and the max number of keys your node remembers providers of. Announcements that would exceed either limit are
+// refused until providers expire. Non-positive limits are not enforced. By default, each peer may provide 1024 keys,
+// and providers of 65536 keys are remembered.
+func WithProtocolProviderLimits(keysPerProvider, keys int) ProtocolOption {
+	return func(p *Protocol) {
+		p.maxProviderKeys = keysPerProvider
+		p.maxProvidedKeys = keys
+	}
+}
+
+// WithProtocolProvideInterval sets the interval at which your node re-announces itself as a provider of all keys
+// passed to (*Protocol).Provide, and at which expired providers are forgotten. It should be shorter than the provider
+// TTL. A non-positive interval disables re-announcing. By default, it is set to 12 hours.
+func WithProtocolProvideInterval(interval time.Duration) ProtocolOption {
+	return func(p *Protocol) {
+		p.provideInterval = interval
+	}
+}
+
+// WithProtocolRefreshInterval sets the interval at which your nodes' routing table is maintained via
+// (*Protocol).Refresh, which is also the amount of time after which peers that have not been seen are pinged, and
+// after which buckets that have not been touched are refreshed. A non-positive interval disables maintenance. By
+// default, it is set to 15 minutes.
+func WithProtocolRefreshInterval(interval time.Duration) ProtocolOption {
+	return func(p *Protocol) {
+		p.refreshInterval = interval
+	}
+}
+
+// WithProtocolMaxPingFailures sets the number of consecutive pings a peer in your nodes' routing table may fail to
+// respond to before it is evicted, either upon being pinged by (*Protocol).Refresh for not having been seen in a
+// while, or upon being the tail of a full bucket a new peer is waiting to be inserted into. Peers that fail to be
+// dialed are always evicted straight away. By default, it is set to 1.
+func WithProtocolMaxPingFailures(failures int) ProtocolOption {
+	return func(p *Protocol) {
+		p.maxPingFailures = failures
+	}
+}
+
+// WithProtocolEvictionQueueSize sets the max number of liveness checks of the tails of full buckets that may be queued
+// or in progress at once. Peers that would have a check queued while the queue is full are cached as replacements
+// straight away. By default, it is set to 64.
+func WithProtocolEvictionQueueSize(size int) ProtocolOption {
+	return func(p *Protocol) {
+		p.evictionQueueSize = size
+	}
+}
+
+// WithProtocolEvictionWorkers sets the number of goroutines which execute the liveness checks of the tails of full
+// buckets queued by (*Protocol).Ack, which bounds the number of checks in progress at once. By default, it is set to 1.
+func WithProtocolEvictionWorkers(workers int) ProtocolOption {
+	return func(p *Protocol) {
+		p.evictionWorkers = workers
+	}
+}
+
+// WithProtocolDiversityLimits sets the IP diversity limits enforced upon admitting peers into your nodes' routing
+// table. Peers that would exceed the limits are rejected, and fire the OnPeerRejected event. By default,
+// DefaultDiversityLimits are enforced.
+func WithProtocolDiversityLimits(limits DiversityLimits) ProtocolOption {
+	return func(p *Protocol) {
+		p.diversity = limits
+	}
+}
+
+// WithProtocolRouteMaxHops sets the max number of times a message routed by your node via (*Protocol).Route or
+// (*Protocol).RouteRequest may be forwarded on its way to the peer closest to its target. By default, it is set to
+// 16.
+func WithProtocolRouteMaxHops(hops uint8) ProtocolOption {
+	return func(p *Protocol) {
+		p.routeMaxHops = hops
+	}
+}
+
+// WithProtocolRouteTimeout sets the amount of time your node waits for a routed message it forwards on behalf of
+// some other peer to be handled by the peer closest to its target. By default, it is set to 10 seconds.
+func WithProtocolRouteTimeout(timeout time.Duration) ProtocolOption {
+	return func(p *Protocol) {
+		p.routeTimeout = timeout
+	}
+}
+
+// WithProtocolRouteQueueSize sets the max number of routed messages your node may have queued to be forwarded on
+// behalf of other peers at once. Routed messages received while the queue is full are refused. By default, it is set
+// to 64.
+func WithProtocolRouteQueueSize(size int) ProtocolOption {
+	return func(p *Protocol) {
+		p.routeQueueSize = size
+	}
+}
+
+// WithProtocolRouteWorkers sets the number of goroutines which forward routed messages on behalf of other peers, which
+// bounds the number of routed messages your node forwards at once. By default, it is set to 8.
+func WithProtocolRouteWorkers(workers int) ProtocolOption {
+	return func(p *Protocol) {
+		p.routeWorkers = workers
+	}
+}
+
+// WithProtocolTableSnapshot sets the file your nodes' routing table is restored from upon (*Protocol).Bind, and is
+// periodically saved to alongside upon (*Protocol).Close, such that your node may warm restart without having to
+// re-bootstrap from seed peers. Restored peers are re-validated once they are considered stale by (*Protocol).Refresh.
+// By default, no snapshots are restored or saved.
+func WithProtocolTableSnapshot(path string) ProtocolOption {
+	return func(p *Protocol) {
+		p.snapshotPath = path
+	}
+}
+
+// WithProtocolSnapshotInterval sets the interval at which snapshots of your nodes' routing table are saved to the file
+// configured through WithProtocolTableSnapshot. A non-positive interval only has snapshots saved upon
+// (*Protocol).Close. By default, it is set to 10 minutes.
+func WithProtocolSnapshotInterval(interval time.Duration) ProtocolOption {
+	return func(p *Protocol) {
+		p.snapshotInterval = interval
+	}
+}
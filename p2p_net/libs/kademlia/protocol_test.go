@@ -1,13 +1,22 @@
 package kademlia_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"awesomeProject/beacon/p2p_network/core_module"
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
 	"awesomeProject/beacon/p2p_network/libs/kademlia"
+	"awesomeProject/beacon/p2p_network/libs/peerstore"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
@@ -32,11 +41,13 @@ func getBucketIndex(self, target cryptographic.PublicKey) int {
 	return l
 }
 
-func TestTableEviction(t *testing.T) {
-	defer goleak.VerifyNone(t)
+// sameBucketKeys generates n key pairs, where the public keys of all but the first pair reside within the same bucket
+// of a routing table whose distance metric is defined against the first public key.
+func sameBucketKeys(t *testing.T, n int) ([]cryptographic.PublicKey, []cryptographic.PrivateKey) {
+	t.Helper()
 
-	publicKeys := make([]cryptographic.PublicKey, 0, kademlia.BucketSize+2)
-	privateKeys := make([]cryptographic.PrivateKey, 0, kademlia.BucketSize+2)
+	publicKeys := make([]cryptographic.PublicKey, 0, n)
+	privateKeys := make([]cryptographic.PrivateKey, 0, n)
 
 	for len(publicKeys) < cap(publicKeys) {
 		pub, pri, err := cryptographic.GenerateKeys(nil)
@@ -59,6 +70,14 @@ func TestTableEviction(t *testing.T) {
 		privateKeys = append(privateKeys, pri)
 	}
 
+	return publicKeys, privateKeys
+}
+
+func TestTableEviction(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	_, privateKeys := sameBucketKeys(t, kademlia.BucketSize+2)
+
 	leader, err := core_module.NewNode(core_module.WithNodePrivateKey(privateKeys[0]))
 	assert.NoError(t, err)
 	defer leader.Close()
@@ -118,8 +137,12 @@ func TestTableEviction(t *testing.T) {
 		client.WaitUntilReady()
 	}
 
-	// Query all peer IDs that the leader node knows about again, and check that node 0 was evicted and that
-	// the follower node has been put to the head of the bucket.
+	// Query all peer IDs that the leader node knows about again once the liveness check of node 0 has completed,
+	// and check that node 0 was evicted and that the follower node has been put to the head of the bucket.
+
+	assert.Eventually(t, func() bool {
+		return overlay.Table().Recorded(follower.ID().PubKey) && overlay.EvictionQueueDepth() == 0
+	}, 3*time.Second, 10*time.Millisecond)
 
 	after := overlay.Table().Bucket(nodes[0].ID().PubKey)
 	assert.Len(t, after, kademlia.BucketSize)
@@ -190,3 +213,1157 @@ func TestDiscoveryAcrossThreeNodes(t *testing.T) {
 	assert.Len(t, kb.Discover(), 2)
 	assert.Len(t, kc.Discover(), 2)
 }
+
+func TestTableSeededFromPeerstore(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	// The dynamic crypto puzzle requires peers to be seeded alongside the nonces they presented while handshaking.
+
+	for _, puzzle := range []cryptographic.Puzzle{{}, {C2: 8}} {
+		path := filepath.Join(t.TempDir(), "peers.json")
+
+		b, err := core_module.NewNode(core_module.WithNodePuzzle(puzzle))
+		assert.NoError(t, err)
+		defer b.Close()
+
+		assert.NoError(t, b.Listen())
+
+		a, err := core_module.NewNode(
+			core_module.WithNodePuzzle(puzzle),
+			core_module.WithNodePeerstore(peerstore.New(path)),
+		)
+		assert.NoError(t, err)
+
+		a.Bind(kademlia.New().Protocol())
+
+		assert.NoError(t, a.Listen())
+
+		_, err = a.Ping(context.TODO(), b.Addr())
+		assert.NoError(t, err)
+
+		assert.NoError(t, a.Close())
+
+		// Restart the node with the same peerstore, and expect the peer to already be in its routing table.
+
+		a, err = core_module.NewNode(
+			core_module.WithNodePuzzle(puzzle),
+			core_module.WithNodePeerstore(peerstore.New(path)),
+		)
+		assert.NoError(t, err)
+		defer a.Close()
+
+		overlay := kademlia.New()
+		a.Bind(overlay.Protocol())
+
+		assert.NoError(t, a.Listen())
+
+		assert.True(t, overlay.Table().Recorded(b.ID().PubKey))
+		assert.Equal(t, b.ID().Address, a.Peerstore().Peers()[0].Addresses[0].Addr)
+		assert.Equal(t, b.ID().Nonce, a.Peerstore().Peers()[0].Nonce)
+	}
+}
+
+func TestPeerstoreRecordsOnlyContactedPeers(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer b.Close()
+
+	kb := kademlia.New()
+	b.Bind(kb.Protocol())
+
+	assert.NoError(t, b.Listen())
+
+	// Have b know of a peer that may not be reached at the address it claims.
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close())
+
+	pub, priv, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	unreachable := cryptographic.NewID(pub, net.ParseIP("127.0.0.1"), uint16(l.Addr().(*net.TCPAddr).Port))
+
+	_, err = kb.Table().Update(unreachable)
+	assert.NoError(t, err)
+	assert.True(t, kb.Table().UpdateRecord(cryptographic.NewRecord(unreachable, 1, priv)))
+
+	a, err := core_module.NewNode(core_module.WithNodePeerstore(peerstore.New(filepath.Join(t.TempDir(), "peers.json"))))
+	assert.NoError(t, err)
+	defer a.Close()
+
+	ka := kademlia.New()
+	a.Bind(ka.Protocol())
+
+	assert.NoError(t, a.Listen())
+
+	_, err = a.Ping(context.TODO(), b.Addr())
+	assert.NoError(t, err)
+
+	ka.Find(context.TODO(), pub)
+
+	_, exists := a.Peerstore().Peer(b.ID().PubKey)
+	assert.True(t, exists)
+
+	_, exists = a.Peerstore().Peer(pub)
+	assert.False(t, exists)
+}
+
+// legacyFindNodeResponse is a FIND_NODE response as encoded by peers running older versions of this library, which
+// respond with unsigned IDs rather than signed peer records.
+type legacyFindNodeResponse struct {
+	ids []cryptographic.ID
+}
+
+func (r legacyFindNodeResponse) Marshal() []byte {
+	buf := []byte{byte(len(r.ids))}
+
+	for _, id := range r.ids {
+		buf = append(buf, id.Marshal()...)
+	}
+
+	return buf
+}
+
+func unmarshalLegacyFindNodeResponse([]byte) (legacyFindNodeResponse, error) {
+	return legacyFindNodeResponse{}, nil
+}
+
+func TestFindThroughLegacyPeer(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	c, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	c.Bind(kademlia.New().Protocol())
+
+	assert.NoError(t, c.Listen())
+
+	// Node B responds to FIND_NODE requests the way peers running older versions of this library do.
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer b.Close()
+
+	b.RegisterMessage(kademlia.Ping{}, kademlia.UnmarshalPing)
+	b.RegisterMessage(kademlia.Pong{}, kademlia.UnmarshalPong)
+	b.RegisterMessage(kademlia.FindNodeRequest{}, kademlia.UnmarshalFindNodeRequest)
+	b.RegisterMessage(legacyFindNodeResponse{}, unmarshalLegacyFindNodeResponse)
+
+	b.Handle(func(ctx core_module.HandlerContext) error {
+		msg, err := ctx.DecodeMessage()
+		if err != nil {
+			return nil
+		}
+
+		if _, ok := msg.(kademlia.FindNodeRequest); !ok {
+			return nil
+		}
+
+		return ctx.SendMessage(legacyFindNodeResponse{ids: []cryptographic.ID{c.ID()}})
+	})
+
+	assert.NoError(t, b.Listen())
+
+	a, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer a.Close()
+
+	ka := kademlia.New()
+	a.Bind(ka.Protocol())
+
+	assert.NoError(t, a.Listen())
+
+	_, err = a.Ping(context.TODO(), b.Addr())
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return ka.Table().Recorded(b.ID().PubKey)
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// The unsigned ID node B responds with is queried, and so node C is found.
+
+	found := false
+
+	for _, id := range ka.Find(context.TODO(), c.ID().PubKey) {
+		if id.PubKey == c.ID().PubKey {
+			found = true
+		}
+	}
+
+	assert.True(t, found)
+}
+
+func TestFindRejectsImpersonatedLegacyIDs(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	victim, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	// Node B responds to FIND_NODE requests the way peers running older versions of this library do, pairing the
+	// public key of a victim with its own address.
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer b.Close()
+
+	b.RegisterMessage(kademlia.Ping{}, kademlia.UnmarshalPing)
+	b.RegisterMessage(kademlia.Pong{}, kademlia.UnmarshalPong)
+	b.RegisterMessage(kademlia.FindNodeRequest{}, kademlia.UnmarshalFindNodeRequest)
+	b.RegisterMessage(legacyFindNodeResponse{}, unmarshalLegacyFindNodeResponse)
+
+	b.Handle(func(ctx core_module.HandlerContext) error {
+		msg, err := ctx.DecodeMessage()
+		if err != nil {
+			return nil
+		}
+
+		if _, ok := msg.(kademlia.FindNodeRequest); !ok {
+			return nil
+		}
+
+		impersonated := b.ID()
+		impersonated.PubKey = victim
+
+		return ctx.SendMessage(legacyFindNodeResponse{ids: []cryptographic.ID{impersonated}})
+	})
+
+	assert.NoError(t, b.Listen())
+
+	a, err := core_module.NewNode(core_module.WithNodePeerstore(peerstore.New(filepath.Join(t.TempDir(), "peers.json"))))
+	assert.NoError(t, err)
+	defer a.Close()
+
+	ka := kademlia.New()
+	a.Bind(ka.Protocol())
+
+	assert.NoError(t, a.Listen())
+
+	_, err = a.Ping(context.TODO(), b.Addr())
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return ka.Table().Recorded(b.ID().PubKey)
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// The peer at the address of the impersonated ID does not hold the public key of the victim, and so the ID is
+	// neither returned nor recorded.
+
+	for _, id := range ka.Find(context.TODO(), victim) {
+		assert.NotEqual(t, victim, id.PubKey)
+	}
+
+	_, exists := a.Peerstore().Peer(victim)
+	assert.False(t, exists)
+}
+
+func TestTableSnapshotWarmRestart(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	path := filepath.Join(t.TempDir(), "table.snapshot")
+
+	b, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer b.Close()
+
+	kb := kademlia.New()
+	b.Bind(kb.Protocol())
+
+	assert.NoError(t, b.Listen())
+
+	a, err := core_module.NewNode()
+	assert.NoError(t, err)
+
+	ka := kademlia.New(kademlia.WithProtocolTableSnapshot(path))
+	a.Bind(ka.Protocol())
+
+	assert.NoError(t, a.Listen())
+
+	connect(t, a, ka, b, kb)
+
+	// Closing the node saves a snapshot of its routing table.
+
+	assert.NoError(t, a.Close())
+	assert.FileExists(t, path)
+
+	// Restart the node under a new identity with the same snapshot, and expect the peer to already be in its routing
+	// table bearing its signed record.
+
+	a, err = core_module.NewNode()
+	assert.NoError(t, err)
+	defer a.Close()
+
+	ka = kademlia.New(kademlia.WithProtocolTableSnapshot(path))
+	a.Bind(ka.Protocol())
+
+	assert.NoError(t, a.Listen())
+
+	assert.Equal(t, []cryptographic.ID{b.ID()}, ka.Table().Peers())
+
+	_, recorded := ka.Table().Record(b.ID().PubKey)
+	assert.True(t, recorded)
+}
+
+func TestPingRecordsLiveness(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes, overlays := newOverlays(t, 2)
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	connect(t, nodes[0], overlays[0], nodes[1], overlays[1])
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, overlays[0].Ping(context.TODO(), nodes[1].Addr()))
+	}
+
+	info, exists := overlays[0].Table().Info(nodes[1].ID().PubKey)
+	assert.True(t, exists)
+	assert.Equal(t, nodes[1].ID().PubKey, info.ID.PubKey)
+	assert.NotZero(t, info.RTT)
+	assert.Zero(t, info.Failures)
+	assert.False(t, info.LastSeen.Before(info.AddedAt))
+}
+
+func TestPuzzleRefusesIDs(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	puzzle := cryptographic.Puzzle{C1: 4}
+
+	_, privateKey, _, err := puzzle.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	a, err := core_module.NewNode(core_module.WithNodePrivateKey(privateKey))
+	assert.NoError(t, err)
+	defer a.Close()
+
+	ka := kademlia.New(kademlia.WithProtocolPuzzle(puzzle))
+	a.Bind(ka.Protocol())
+
+	b, err := core_module.NewNode(core_module.WithNodePuzzle(puzzle))
+	assert.NoError(t, err)
+	defer b.Close()
+
+	kb := kademlia.New()
+	b.Bind(kb.Protocol())
+
+	_, privateKey, err = cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	for puzzle.VerifyStatic(privateKey.Public()) {
+		_, privateKey, err = cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+	}
+
+	c, err := core_module.NewNode(core_module.WithNodePrivateKey(privateKey))
+	assert.NoError(t, err)
+	defer c.Close()
+
+	c.Bind(kademlia.New().Protocol())
+
+	assert.NoError(t, a.Listen())
+	assert.NoError(t, b.Listen())
+	assert.NoError(t, c.Listen())
+
+	// Node A requires peers to solve the puzzle only at the Kademlia level, and so may still interact with node C.
+
+	assert.NoError(t, ka.Ping(context.TODO(), b.Addr()))
+	assert.NoError(t, ka.Ping(context.TODO(), c.Addr()))
+
+	assert.True(t, ka.Table().Recorded(b.ID().PubKey))
+	assert.False(t, ka.Table().Recorded(c.ID().PubKey))
+
+	// Node B inherits the difficulty configured on its node, and refuses to handshake with node C at all.
+
+	assert.Error(t, kb.Ping(context.TODO(), c.Addr()))
+	assert.False(t, kb.Table().Recorded(c.ID().PubKey))
+}
+
+func TestFindAcrossLineOfNodes(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes := make([]*core_module.Node, 0, 8)
+	overlays := make([]*kademlia.Protocol, 0, cap(nodes))
+
+	for i := 0; i < cap(nodes); i++ {
+		node, err := core_module.NewNode()
+		assert.NoError(t, err)
+		defer node.Close()
+
+		overlay := kademlia.New()
+		node.Bind(overlay.Protocol())
+
+		assert.NoError(t, node.Listen())
+
+		nodes = append(nodes, node)
+		overlays = append(overlays, overlay)
+	}
+
+	// Each node only knows of the nodes directly before and after it.
+
+	for i := 1; i < len(nodes); i++ {
+		assert.NoError(t, overlays[i].Ping(context.TODO(), nodes[i-1].Addr()))
+	}
+
+	for i := 1; i < len(nodes); i++ {
+		i := i
+
+		assert.Eventually(t, func() bool {
+			_, a := overlays[i].Table().Record(nodes[i-1].ID().PubKey)
+			_, b := overlays[i-1].Table().Record(nodes[i].ID().PubKey)
+			return a && b
+		}, 3*time.Second, 10*time.Millisecond)
+	}
+
+	target := nodes[len(nodes)-1].ID()
+
+	assert.False(t, overlays[0].Table().Recorded(target.PubKey))
+
+	found := overlays[0].Find(context.TODO(), target.PubKey)
+	if assert.NotEmpty(t, found) {
+		assert.Equal(t, target.PubKey, found[0].PubKey)
+		assert.Equal(t, target.Address, found[0].Address)
+	}
+
+	assert.Len(t, found, len(nodes)-1)
+
+	// A lookup whose context is done returns immediately.
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Empty(t, overlays[0].Find(ctx, target.PubKey))
+}
+
+func TestFindTrace(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes, overlays := newOverlays(t, 4)
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	for i := 1; i < len(nodes); i++ {
+		connect(t, nodes[i], overlays[i], nodes[i-1], overlays[i-1])
+	}
+
+	// Have the first node know of a peer that fails to respond.
+
+	pub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	dead := cryptographic.NewID(pub, net.ParseIP("127.0.0.1"), 1)
+	overlays[0].Ack(dead)
+
+	target := nodes[len(nodes)-1].ID()
+
+	var trace kademlia.LookupTrace
+
+	found := overlays[0].Find(context.TODO(), target.PubKey, kademlia.WithIteratorTrace(&trace))
+	assert.NotEmpty(t, found)
+
+	assert.Equal(t, nodes[0].ID().PubKey, trace.Origin)
+	assert.Equal(t, target.PubKey, trace.Target)
+	assert.NotZero(t, trace.Duration)
+	assert.Len(t, trace.Results, len(found))
+
+	for i, id := range found {
+		assert.Equal(t, id.PubKey, trace.Results[i])
+	}
+
+	peers := make(map[cryptographic.PublicKey]kademlia.LookupPeer, len(trace.Peers))
+	for _, peer := range trace.Peers {
+		peers[peer.PubKey] = peer
+	}
+
+	// Each node in the line is learned of one hop after the node before it.
+
+	for i := 1; i < len(nodes); i++ {
+		peer, exists := peers[nodes[i].ID().PubKey]
+		if !assert.True(t, exists) {
+			continue
+		}
+
+		assert.Equal(t, i-1, peer.Hop)
+		assert.Equal(t, nodes[i-1].ID().PubKey, peer.LearnedFrom)
+		assert.True(t, peer.Queried)
+		assert.Empty(t, peer.Error)
+		assert.NotZero(t, peer.Latency)
+
+		if i < len(nodes)-1 {
+			assert.Contains(t, peer.Returned, nodes[i+1].ID().PubKey)
+		}
+	}
+
+	if peer, exists := peers[dead.PubKey]; assert.True(t, exists) {
+		assert.Zero(t, peer.Hop)
+		assert.True(t, peer.Queried)
+		assert.NotEmpty(t, peer.Error)
+	}
+
+	queried, failed := trace.Queried()
+	assert.Equal(t, len(nodes), queried)
+	assert.Equal(t, 1, failed)
+
+	// The trace may be exported as JSON, or as a Graphviz digraph.
+
+	var buf bytes.Buffer
+
+	assert.NoError(t, trace.WriteJSON(&buf))
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, target.PubKey.String(), decoded["target"])
+	assert.Len(t, decoded["peers"], len(trace.Peers))
+
+	buf.Reset()
+
+	assert.NoError(t, trace.WriteDOT(&buf))
+	assert.True(t, strings.HasPrefix(buf.String(), "digraph lookup {"))
+	assert.Equal(t, len(trace.Peers), strings.Count(buf.String(), " -> "))
+	assert.Equal(t, 1, strings.Count(buf.String(), "color=red"))
+}
+
+func newOverlays(t *testing.T, n int, opts ...kademlia.ProtocolOption) ([]*core_module.Node, []*kademlia.Protocol) {
+	t.Helper()
+
+	nodes := make([]*core_module.Node, 0, n)
+	overlays := make([]*kademlia.Protocol, 0, n)
+
+	for i := 0; i < n; i++ {
+		node, err := core_module.NewNode()
+		assert.NoError(t, err)
+
+		overlay := kademlia.New(opts...)
+		node.Bind(overlay.Protocol())
+
+		assert.NoError(t, node.Listen())
+
+		nodes = append(nodes, node)
+		overlays = append(overlays, overlay)
+	}
+
+	return nodes, overlays
+}
+
+func connect(t *testing.T, a *core_module.Node, ka *kademlia.Protocol, b *core_module.Node, kb *kademlia.Protocol) {
+	t.Helper()
+
+	assert.NoError(t, ka.Ping(context.TODO(), b.Addr()))
+
+	assert.Eventually(t, func() bool {
+		_, x := ka.Table().Record(b.ID().PubKey)
+		_, y := kb.Table().Record(a.ID().PubKey)
+		return x && y
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestPutGet(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	validator := func(key, value []byte) error {
+		if bytes.HasPrefix(value, []byte("bad")) {
+			return errors.New("bad value")
+		}
+		return nil
+	}
+
+	nodes, overlays := newOverlays(t, 4, kademlia.WithProtocolValidator(validator))
+
+	for _, node := range nodes {
+		defer node.Close()
+	}
+
+	for i := 1; i < len(nodes); i++ {
+		connect(t, nodes[i], overlays[i], nodes[0], overlays[0])
+	}
+
+	assert.Error(t, overlays[1].Put(context.TODO(), []byte("key"), []byte("bad value")))
+	assert.NoError(t, overlays[1].Put(context.TODO(), []byte("key"), []byte("value")))
+
+	for _, overlay := range overlays {
+		value, err := overlay.Get(context.TODO(), []byte("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+	}
+
+	_, err := overlays[2].Get(context.TODO(), []byte("missing"))
+	assert.True(t, errors.Is(err, kademlia.ErrValueNotFound))
+
+	// A node joining later finds the value through a lookup, and caches it on the closest peer along the lookup path
+	// that did not have the value.
+
+	assert.NoError(t, overlays[0].Storage().Delete([]byte("key")))
+
+	joined, joinedOverlays := newOverlays(t, 1)
+	defer joined[0].Close()
+
+	connect(t, joined[0], joinedOverlays[0], nodes[0], overlays[0])
+
+	value, err := joinedOverlays[0].Get(context.TODO(), []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	_, exists, err := overlays[0].Storage().Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Peers with validators refuse to store values their validator rejects, no matter who sends them.
+
+	err = joinedOverlays[0].Put(context.TODO(), []byte("other"), []byte("bad value"))
+	assert.Error(t, err)
+
+	var remote *core_module.RemoteError
+	assert.True(t, errors.As(err, &remote))
+}
+
+func TestStorageQuota(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes, overlays := newOverlays(t, 1, kademlia.WithProtocolStorageQuota(2, 0))
+	defer nodes[0].Close()
+
+	other, otherOverlays := newOverlays(t, 1)
+	defer other[0].Close()
+
+	connect(t, other[0], otherOverlays[0], nodes[0], overlays[0])
+
+	assert.NoError(t, otherOverlays[0].Put(context.TODO(), []byte("a"), []byte("value")))
+	assert.NoError(t, otherOverlays[0].Put(context.TODO(), []byte("b"), []byte("value")))
+
+	// Peers that have as many keys stored as their quota allows are refused until their values expire.
+
+	err := otherOverlays[0].Put(context.TODO(), []byte("c"), []byte("value"))
+
+	var remote *core_module.RemoteError
+	if assert.True(t, errors.As(err, &remote)) {
+		assert.Equal(t, core_module.StatusUnavailable, remote.Code)
+	}
+
+	_, exists, err := overlays[0].Storage().Get([]byte("c"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Peers may still overwrite the values they have stored.
+
+	assert.NoError(t, otherOverlays[0].Put(context.TODO(), []byte("a"), []byte("other value")))
+}
+
+func TestIteratorConcurrentLookups(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes, overlays := newOverlays(t, 4)
+
+	for _, node := range nodes {
+		defer node.Close()
+	}
+
+	for i := 1; i < len(nodes); i++ {
+		connect(t, nodes[i], overlays[i], nodes[0], overlays[0])
+	}
+
+	assert.NoError(t, overlays[1].Put(context.TODO(), []byte("key"), []byte("value")))
+
+	// Lookups executed concurrently by a single iterator must not interfere with one another.
+
+	it := kademlia.NewIterator(nodes[1], overlays[1].Table())
+	target := nodes[3].ID().PubKey
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			value, _, found := it.FindValue(context.TODO(), []byte("key"))
+			assert.True(t, found)
+			assert.Equal(t, []byte("value"), value.Data)
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			found := it.Find(context.TODO(), target)
+			if assert.NotEmpty(t, found) {
+				assert.Equal(t, target, found[0].PubKey)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestValueExpiryAndRepublish(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes, overlays := newOverlays(t, 1,
+		kademlia.WithProtocolValueTTL(time.Second),
+		kademlia.WithProtocolRepublishInterval(20*time.Millisecond),
+	)
+	defer nodes[0].Close()
+
+	assert.NoError(t, overlays[0].Put(context.TODO(), []byte("key"), []byte("value")))
+
+	// A node that connects afterwards has the value republished to it.
+
+	other, otherOverlays := newOverlays(t, 1)
+	defer other[0].Close()
+
+	connect(t, other[0], otherOverlays[0], nodes[0], overlays[0])
+
+	assert.Eventually(t, func() bool {
+		_, exists, err := otherOverlays[0].Storage().Get([]byte("key"))
+		return err == nil && exists
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Values expire after their TTL, and are removed from storage upon being republished.
+
+	assert.Eventually(t, func() bool {
+		_, exists, err := overlays[0].Storage().Get([]byte("key"))
+		return err == nil && !exists
+	}, 3*time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		_, err := otherOverlays[0].Get(context.TODO(), []byte("key"))
+		return errors.Is(err, kademlia.ErrValueNotFound)
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestProvideFindProviders(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	opts := []kademlia.ProtocolOption{
+		kademlia.WithProtocolProviderTTL(time.Second),
+		kademlia.WithProtocolProvideInterval(20 * time.Millisecond),
+	}
+
+	nodes, overlays := newOverlays(t, 4, opts...)
+
+	for _, node := range nodes {
+		defer node.Close()
+	}
+
+	for i := 1; i < len(nodes); i++ {
+		connect(t, nodes[i], overlays[i], nodes[0], overlays[0])
+	}
+
+	collect := func(overlay *kademlia.Protocol, key string) []cryptographic.ID {
+		var providers []cryptographic.ID
+
+		for id := range overlay.FindProviders(context.TODO(), []byte(key)) {
+			providers = append(providers, id)
+		}
+
+		return providers
+	}
+
+	assert.Empty(t, collect(overlays[3], "blob"))
+
+	assert.NoError(t, overlays[1].Provide(context.TODO(), []byte("blob")))
+	assert.NoError(t, overlays[2].Provide(context.TODO(), []byte("blob")))
+
+	providers := collect(overlays[3], "blob")
+	assert.Len(t, providers, 2)
+	assert.ElementsMatch(t,
+		[]cryptographic.PublicKey{nodes[1].ID().PubKey, nodes[2].ID().PubKey},
+		[]cryptographic.PublicKey{providers[0].PubKey, providers[1].PubKey},
+	)
+
+	for _, provider := range providers {
+		assert.NotEmpty(t, provider.Address)
+	}
+
+	// A node that joins afterwards is found as a provider through peers that were announced to, and peers that join
+	// afterwards are announced to upon re-announcement.
+
+	joined, joinedOverlays := newOverlays(t, 1, opts...)
+	defer joined[0].Close()
+
+	connect(t, joined[0], joinedOverlays[0], nodes[1], overlays[1])
+
+	assert.Eventually(t, func() bool {
+		return len(collect(joinedOverlays[0], "blob")) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Streams close once ctx is cancelled, even should they not be drained.
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := overlays[3].FindProviders(ctx, []byte("blob"))
+	cancel()
+
+	for range stream {
+	}
+
+	// Providers that are no longer re-announced expire.
+
+	assert.NoError(t, overlays[1].Close())
+	assert.NoError(t, overlays[2].Close())
+
+	assert.Eventually(t, func() bool {
+		return len(collect(overlays[3], "blob")) == 0
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestRefresh(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes, overlays := newOverlays(t, 2)
+
+	for _, node := range nodes {
+		defer node.Close()
+	}
+
+	connect(t, nodes[1], overlays[1], nodes[0], overlays[0])
+
+	// A node refreshes the buckets of its routing table, and discovers peers through those it knows of.
+
+	var (
+		lock    sync.Mutex
+		evicted []cryptographic.ID
+	)
+
+	events := kademlia.Events{
+		OnPeerEvicted: func(id cryptographic.ID) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			evicted = append(evicted, id)
+		},
+	}
+
+	node, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer node.Close()
+
+	overlay := kademlia.New(
+		kademlia.WithProtocolEvents(events),
+		kademlia.WithProtocolRefreshInterval(0),
+		kademlia.WithProtocolPingTimeout(500*time.Millisecond),
+	)
+	node.Bind(overlay.Protocol())
+	assert.NoError(t, node.Listen())
+
+	assert.NoError(t, overlay.Ping(context.TODO(), nodes[0].Addr()))
+	overlay.Refresh(context.TODO())
+
+	assert.True(t, overlay.Table().Recorded(nodes[0].ID().PubKey))
+	assert.True(t, overlay.Table().Recorded(nodes[1].ID().PubKey))
+
+	// Peers that fail to respond to being pinged are evicted.
+
+	assert.NoError(t, nodes[1].Close())
+
+	overlay.Refresh(context.TODO())
+
+	assert.True(t, overlay.Table().Recorded(nodes[0].ID().PubKey))
+	assert.False(t, overlay.Table().Recorded(nodes[1].ID().PubKey))
+
+	lock.Lock()
+	assert.Len(t, evicted, 1)
+	assert.Equal(t, nodes[1].ID().PubKey, evicted[0].PubKey)
+	lock.Unlock()
+
+	// Routing tables are periodically refreshed in the background.
+
+	other, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer other.Close()
+
+	otherOverlay := kademlia.New(kademlia.WithProtocolRefreshInterval(20 * time.Millisecond))
+	other.Bind(otherOverlay.Protocol())
+	assert.NoError(t, other.Listen())
+
+	assert.NoError(t, otherOverlay.Ping(context.TODO(), nodes[0].Addr()))
+
+	assert.Eventually(t, func() bool {
+		return otherOverlay.Table().Recorded(node.ID().PubKey)
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestReplacementCache(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	_, privateKeys := sameBucketKeys(t, kademlia.BucketSize+3)
+
+	var (
+		lock     sync.Mutex
+		cached   []cryptographic.ID
+		promoted []cryptographic.ID
+	)
+
+	events := kademlia.Events{
+		OnPeerCached: func(id cryptographic.ID) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			cached = append(cached, id)
+		},
+		OnPeerPromoted: func(id cryptographic.ID) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			promoted = append(promoted, id)
+		},
+	}
+
+	leader, err := core_module.NewNode(core_module.WithNodePrivateKey(privateKeys[0]))
+	assert.NoError(t, err)
+	defer leader.Close()
+
+	overlay := kademlia.New(kademlia.WithProtocolEvents(events), kademlia.WithProtocolRefreshInterval(0))
+	leader.Bind(overlay.Protocol())
+
+	assert.NoError(t, leader.Listen())
+
+	nodes := make([]*core_module.Node, 0, kademlia.BucketSize+2)
+
+	for i := 0; i < kademlia.BucketSize+2; i++ {
+		node, err := core_module.NewNode(core_module.WithNodePrivateKey(privateKeys[i+1]))
+		assert.NoError(t, err)
+		defer node.Close()
+
+		node.Bind(kademlia.New().Protocol())
+		assert.NoError(t, node.Listen())
+
+		_, err = node.Ping(context.Background(), leader.Addr())
+		assert.NoError(t, err)
+
+		for _, client := range leader.Inbound() {
+			client.WaitUntilReady()
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	// The bucket is full and all of its entries are alive, so the last two peers are cached as replacements, with
+	// the most recently seen first.
+
+	target := nodes[0].ID().PubKey
+
+	assert.Eventually(t, func() bool {
+		return len(overlay.Table().Replacements(target)) == 2 && overlay.EvictionQueueDepth() == 0
+	}, 3*time.Second, 10*time.Millisecond)
+
+	assert.Len(t, overlay.Table().Bucket(target), kademlia.BucketSize)
+	assert.False(t, overlay.Table().Recorded(nodes[kademlia.BucketSize].ID().PubKey))
+
+	replacements := overlay.Table().Replacements(target)
+	assert.Len(t, replacements, 2)
+	assert.Equal(t, nodes[kademlia.BucketSize+1].ID().PubKey, replacements[0].PubKey)
+	assert.Equal(t, nodes[kademlia.BucketSize].ID().PubKey, replacements[1].PubKey)
+
+	lock.Lock()
+	assert.Len(t, cached, 2)
+	lock.Unlock()
+
+	// Once an entry fails, the most recently seen candidate is promoted in its place.
+
+	assert.NoError(t, nodes[3].Close())
+
+	overlay.Refresh(context.TODO())
+
+	bucket := overlay.Table().Bucket(target)
+	assert.Len(t, bucket, kademlia.BucketSize)
+	assert.False(t, overlay.Table().Recorded(nodes[3].ID().PubKey))
+	assert.True(t, overlay.Table().Recorded(nodes[kademlia.BucketSize+1].ID().PubKey))
+
+	replacements = overlay.Table().Replacements(target)
+	assert.Len(t, replacements, 1)
+	assert.Equal(t, nodes[kademlia.BucketSize].ID().PubKey, replacements[0].PubKey)
+
+	lock.Lock()
+	assert.Len(t, promoted, 1)
+	assert.Equal(t, nodes[kademlia.BucketSize+1].ID().PubKey, promoted[0].PubKey)
+	lock.Unlock()
+}
+
+func TestDiversityLimitsRejectPeers(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	var (
+		lock     sync.Mutex
+		rejected []error
+	)
+
+	events := kademlia.Events{
+		OnPeerRejected: func(id cryptographic.ID, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			rejected = append(rejected, err)
+		},
+	}
+
+	nodes, overlays := newOverlays(t, 1,
+		kademlia.WithProtocolEvents(events),
+		kademlia.WithProtocolDiversityLimits(kademlia.DiversityLimits{MaxPerSubnet: 1}),
+	)
+	defer nodes[0].Close()
+
+	// Both peers reside within the loopback subnet, which is not exempt from the limits.
+
+	ids := make([]cryptographic.ID, 0, 2)
+
+	for i := 0; i < cap(ids); i++ {
+		pub, _, err := cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+
+		ids = append(ids, cryptographic.NewID(pub, net.ParseIP("127.0.0.1"), uint16(3000+i)))
+		overlays[0].Ack(ids[i])
+	}
+
+	assert.True(t, overlays[0].Table().Recorded(ids[0].PubKey))
+	assert.False(t, overlays[0].Table().Recorded(ids[1].PubKey))
+
+	lock.Lock()
+	assert.Len(t, rejected, 1)
+	assert.True(t, errors.Is(rejected[0], kademlia.ErrDiversityLimit))
+	lock.Unlock()
+}
+
+// lineTowards connects nodes in a line ordered from the furthest to the closest node to target, such that each node
+// only knows of the nodes directly before and after it, the latter being the only node it knows of that is closer to
+// target than itself. It returns the indices of nodes in the order they were connected.
+func lineTowards(
+	t *testing.T, target cryptographic.PublicKey, nodes []*core_module.Node, overlays []*kademlia.Protocol,
+) []int {
+	t.Helper()
+
+	order := make([]int, len(nodes))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		a := kademlia.DistanceBetween(target, nodes[order[i]].ID().PubKey)
+		b := kademlia.DistanceBetween(target, nodes[order[j]].ID().PubKey)
+		return a.Cmp(b) > 0
+	})
+
+	for i := 1; i < len(order); i++ {
+		connect(t, nodes[order[i]], overlays[order[i]], nodes[order[i-1]], overlays[order[i-1]])
+	}
+
+	return order
+}
+
+func TestRoute(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes, overlays := newOverlays(t, 6)
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	target := kademlia.HashKey([]byte("key"))
+
+	order := lineTowards(t, target, nodes, overlays)
+
+	var (
+		lock      sync.Mutex
+		delivered []kademlia.RoutedMessage
+	)
+
+	for i := range overlays {
+		self := nodes[i].ID().PubKey
+
+		overlays[i].HandleRouted(func(msg kademlia.RoutedMessage) ([]byte, error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			delivered = append(delivered, msg)
+
+			return self[:], nil
+		})
+	}
+
+	origin, closest := order[0], order[len(order)-1]
+	expected := nodes[closest].ID().PubKey
+
+	// A request is routed through every node in the line, and the reply of the closest node is routed back.
+
+	reply, err := overlays[origin].RouteRequest(context.TODO(), target, []byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected[:], reply)
+
+	lock.Lock()
+	if assert.Len(t, delivered, 1) {
+		assert.Equal(t, []byte("hello"), delivered[0].Payload)
+		assert.Equal(t, nodes[origin].ID().PubKey, delivered[0].Origin.PubKey)
+		assert.EqualValues(t, len(nodes)-1, delivered[0].Hops)
+		assert.True(t, delivered[0].Reply)
+	}
+	delivered = nil
+	lock.Unlock()
+
+	// A message that asks for no reply is handled all the same.
+
+	assert.NoError(t, overlays[order[2]].Route(context.TODO(), target, []byte("world")))
+
+	lock.Lock()
+	if assert.Len(t, delivered, 1) {
+		assert.Equal(t, []byte("world"), delivered[0].Payload)
+		assert.EqualValues(t, len(nodes)-3, delivered[0].Hops)
+		assert.False(t, delivered[0].Reply)
+	}
+	delivered = nil
+	lock.Unlock()
+
+	// A message is handled by its origin should the origin be the closest node to the target.
+
+	reply, err = overlays[closest].RouteRequest(context.TODO(), target, []byte("self"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected[:], reply)
+
+	lock.Lock()
+	if assert.Len(t, delivered, 1) {
+		assert.Zero(t, delivered[0].Hops)
+	}
+	lock.Unlock()
+}
+
+func TestRouteFailures(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	nodes, overlays := newOverlays(t, 3, kademlia.WithProtocolRouteMaxHops(1))
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	target := kademlia.HashKey([]byte("key"))
+
+	order := lineTowards(t, target, nodes, overlays)
+
+	// A message delivered to a node with no handlers registered fails.
+
+	err := overlays[order[2]].Route(context.TODO(), target, nil)
+	assert.True(t, errors.Is(err, kademlia.ErrNoRoutedHandler))
+
+	var remote *core_module.RemoteError
+
+	err = overlays[order[1]].Route(context.TODO(), target, nil)
+	if assert.True(t, errors.As(err, &remote)) {
+		assert.Equal(t, core_module.StatusNotFound, remote.Code)
+	}
+
+	// A message that would be forwarded past its hop limit fails.
+
+	err = overlays[order[0]].Route(context.TODO(), target, nil)
+	if assert.True(t, errors.As(err, &remote)) {
+		assert.Equal(t, core_module.StatusInvalidRequest, remote.Code)
+	}
+}
@@ -0,0 +1,348 @@
+package kademlia
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/zap"
+)
+
+// Provide announces that your node provides the content or service identified by key, which may be any arbitrary
+// content hash or service name. Your node is recorded as a provider of key locally, and an ADD_PROVIDER RPC call is
+// sent to the peers closest to key found through an iterative FIND_NODE lookup. Providers expire after the TTL
+// configured through WithProtocolProviderTTL, and your node re-announces itself as a provider of key at the interval
+// configured through WithProtocolProvideInterval until (*Protocol).Close is called.
+//
+// It throws an error should there be peers closest to key yet none of them accept the announcement.
+func (p *Protocol) Provide(ctx context.Context, key []byte) error {
+	if len(key) > math.MaxUint16 {
+		return ErrKeyTooLarge
+	}
+
+	p.providedLock.Lock()
+	p.provided[string(key)] = struct{}{}
+	p.providedLock.Unlock()
+
+	return p.announce(ctx, key)
+}
+
+// FindProviders streams the IDs of the providers of key, first from the providers known locally, and then from the
+// providers peers respond with over an iterative GET_PROVIDERS lookup for the position of key in the key space. Each
+// provider is vouched for by a signed peer record, and is streamed at most once. The channel is closed once the lookup
+// is complete, or once ctx is cancelled or expires. Callers must either drain the channel or cancel ctx, otherwise
+// the lookup never completes.
+func (p *Protocol) FindProviders(ctx context.Context, key []byte, opts ...IteratorOption) <-chan cryptographic.ID {
+	opts = append([]IteratorOption{WithIteratorPuzzle(p.puzzle)}, opts...)
+
+	ch := make(chan cryptographic.ID)
+
+	go func() {
+		defer close(ch)
+
+		var (
+			lock sync.Mutex
+			seen = make(map[cryptographic.PublicKey]struct{})
+		)
+
+		found := func(id cryptographic.ID) {
+			lock.Lock()
+			_, exists := seen[id.PubKey]
+			seen[id.PubKey] = struct{}{}
+			lock.Unlock()
+
+			if exists {
+				return
+			}
+
+			select {
+			case ch <- id:
+			case <-ctx.Done():
+			}
+		}
+
+		for _, rec := range p.providers.get(key, time.Now()) {
+			found(rec.ID)
+		}
+
+		NewIterator(p.node, p.table, opts...).FindProviders(ctx, key, found)
+	}()
+
+	return ch
+}
+
+// announce records your node as a provider of key locally, and sends an ADD_PROVIDER RPC call to the peers closest to
+// key. It throws an error should there be peers closest to key yet none of them accept the announcement.
+func (p *Protocol) announce(ctx context.Context, key []byte) error {
+	record := p.node.Record()
+
+	if err := p.providers.add(key, record, time.Now().Add(p.providerTTL)); err != nil {
+		return fmt.Errorf("failed to record provider locally: %w", err)
+	}
+
+	closest := p.Find(ctx, HashKey(key))
+	if len(closest) == 0 {
+		return nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		accepted int
+		last     error
+	)
+
+	wg.Add(len(closest))
+
+	for _, id := range closest {
+		id := id
+
+		go func() {
+			defer wg.Done()
+
+			err := p.addProvider(ctx, id, key, record)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				last = err
+				return
+			}
+
+			accepted++
+		}()
+	}
+
+	wg.Wait()
+
+	if accepted == 0 {
+		return fmt.Errorf("failed to announce provider to any of the %d closest peers: %w", len(closest), last)
+	}
+
+	return nil
+}
+
+// addProvider sends an ADD_PROVIDER RPC call to id announcing the bearer of record as a provider of key.
+func (p *Protocol) addProvider(
+	ctx context.Context, id cryptographic.ID, key []byte, record cryptographic.Record,
+) error {
+	res, err := p.node.RequestMessage(ctx, id.Address, AddProviderRequest{Key: key, Record: record})
+	if err != nil {
+		return err
+	}
+
+	if _, ok := res.(AddProviderResponse); !ok {
+		return fmt.Errorf("did not get an add provider response back from %s", id.Address)
+	}
+
+	return nil
+}
+
+func (p *Protocol) handleAddProviderRequest(ctx core_module.HandlerContext, req AddProviderRequest) error {
+	// Peers may only announce themselves as providers, and never some other peer.
+
+	if req.Record.ID.PubKey != ctx.ID().PubKey {
+		return core_module.NewRemoteError(core_module.StatusInvalidRequest,
+			"provider record does not belong to the sender", nil,
+		)
+	}
+
+	if !req.Record.Verify() {
+		return core_module.NewRemoteError(core_module.StatusInvalidRequest,
+			"provider record has an invalid signature", nil,
+		)
+	}
+
+	if !p.puzzle.VerifyID(req.Record.ID) {
+		return core_module.NewRemoteError(core_module.StatusInvalidRequest,
+			"provider does not solve the crypto puzzles of the network", nil,
+		)
+	}
+
+	if err := p.providers.add(req.Key, req.Record, time.Now().Add(p.providerTTL)); err != nil {
+		return core_module.NewRemoteError(core_module.StatusUnavailable, err.Error(), nil)
+	}
+
+	return ctx.SendMessage(AddProviderResponse{})
+}
+
+func (p *Protocol) handleGetProvidersRequest(ctx core_module.HandlerContext, req GetProvidersRequest) error {
+	return ctx.SendMessage(GetProvidersResponse{
+		Providers: p.providers.get(req.Key, time.Now()),
+		Records:   p.records(p.closest(HashKey(req.Key), ctx.ID().PubKey)),
+	})
+}
+
+// reprovide forgets all expired providers, and re-announces your node as a provider of all keys passed to
+// (*Protocol).Provide.
+func (p *Protocol) reprovide() {
+	p.providers.expire(time.Now())
+
+	p.providedLock.Lock()
+	keys := make([][]byte, 0, len(p.provided))
+	for key := range p.provided {
+		keys = append(keys, []byte(key))
+	}
+	p.providedLock.Unlock()
+
+	ctx, cancel := p.maintenanceContext()
+	defer cancel()
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := p.announce(ctx, key); err != nil {
+			p.logger.Debug("Failed to re-announce provider.", zap.Error(err))
+		}
+	}
+}
+
+type provider struct {
+	record  cryptographic.Record
+	expires time.Time
+}
+
+// providerStore keeps track of the providers of keys, alongside the time at which each provider expires. Limits on
+// the number of keys any single provider may provide, and on the number of keys providers are tracked for, that are
+// non-positive are not enforced. Should a key have as many providers as it may, the providers closest to expiring are
+// forgotten first.
+type providerStore struct {
+	sync.Mutex
+	providers map[string]map[cryptographic.PublicKey]provider
+	keys      map[cryptographic.PublicKey]int
+
+	maxProvidersPerKey int
+	maxKeysPerProvider int
+	maxKeys            int
+}
+
+func newProviderStore(maxProvidersPerKey, maxKeysPerProvider, maxKeys int) *providerStore {
+	return &providerStore{
+		providers:          make(map[string]map[cryptographic.PublicKey]provider),
+		keys:               make(map[cryptographic.PublicKey]int),
+		maxProvidersPerKey: maxProvidersPerKey,
+		maxKeysPerProvider: maxKeysPerProvider,
+		maxKeys:            maxKeys,
+	}
+}
+
+// add records the bearer of record as a provider of key until expires. Records of a lower sequence number than the
+// record already known for the provider are ignored, though still extend the time until the provider expires. It
+// throws ErrProviderLimit should the bearer of record already provide as many keys as it may, or should key be new
+// and providers already be tracked for as many keys as they may be.
+func (s *providerStore) add(key []byte, record cryptographic.Record, expires time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	providers, exists := s.providers[string(key)]
+
+	if existing, exists := providers[record.ID.PubKey]; exists {
+		if existing.record.Seq > record.Seq {
+			record = existing.record
+		}
+
+		providers[record.ID.PubKey] = provider{record: record, expires: expires}
+
+		return nil
+	}
+
+	if s.maxKeysPerProvider > 0 && s.keys[record.ID.PubKey] >= s.maxKeysPerProvider {
+		return ErrProviderLimit
+	}
+
+	if !exists {
+		if s.maxKeys > 0 && len(s.providers) >= s.maxKeys {
+			return ErrProviderLimit
+		}
+
+		providers = make(map[cryptographic.PublicKey]provider)
+		s.providers[string(key)] = providers
+	}
+
+	if len(providers) >= s.maxProvidersPerKey {
+		var (
+			oldest cryptographic.PublicKey
+			first  = true
+		)
+
+		for pub, p := range providers {
+			if first || p.expires.Before(providers[oldest].expires) {
+				oldest, first = pub, false
+			}
+		}
+
+		if !providers[oldest].expires.Before(expires) {
+			return nil
+		}
+
+		s.remove(providers, oldest)
+	}
+
+	providers[record.ID.PubKey] = provider{record: record, expires: expires}
+	s.keys[record.ID.PubKey]++
+
+	return nil
+}
+
+// get returns the records of all providers of key that have yet to expire as of now. Expired providers are
+// forgotten.
+func (s *providerStore) get(key []byte, now time.Time) []cryptographic.Record {
+	s.Lock()
+	defer s.Unlock()
+
+	providers := s.providers[string(key)]
+	records := make([]cryptographic.Record, 0, len(providers))
+
+	for pub, p := range providers {
+		if !now.Before(p.expires) {
+			s.remove(providers, pub)
+			continue
+		}
+
+		records = append(records, p.record)
+	}
+
+	if len(providers) == 0 {
+		delete(s.providers, string(key))
+	}
+
+	return records
+}
+
+// expire forgets all providers that have expired as of now.
+func (s *providerStore) expire(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for key, providers := range s.providers {
+		for pub, p := range providers {
+			if !now.Before(p.expires) {
+				s.remove(providers, pub)
+			}
+		}
+
+		if len(providers) == 0 {
+			delete(s.providers, key)
+		}
+	}
+}
+
+// remove forgets pub as one of the providers of some key. It must be called with the store locked.
+func (s *providerStore) remove(providers map[cryptographic.PublicKey]provider, pub cryptographic.PublicKey) {
+	delete(providers, pub)
+
+	if s.keys[pub] <= 1 {
+		delete(s.keys, pub)
+		return
+	}
+
+	s.keys[pub]--
+}
@@ -0,0 +1,109 @@
+package kademlia
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	store := newProviderStore(4*BucketSize, 0, 0)
+
+	records := make([]cryptographic.Record, 0, store.maxProvidersPerKey+1)
+
+	for i := 0; i < cap(records); i++ {
+		pub, priv, err := cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+
+		id := cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), uint16(3000+i))
+		records = append(records, cryptographic.NewRecord(id, 2, priv))
+	}
+
+	assert.NoError(t, store.add([]byte("key"), records[0], now.Add(time.Minute)))
+	assert.Len(t, store.get([]byte("key"), now), 1)
+	assert.Empty(t, store.get([]byte("other"), now))
+
+	// Stale records never replace newer records, yet still extend the expiry of the provider.
+
+	stale := records[0]
+	stale.Seq = 1
+
+	assert.NoError(t, store.add([]byte("key"), stale, now.Add(time.Hour)))
+
+	providers := store.get([]byte("key"), now.Add(30*time.Minute))
+	assert.Len(t, providers, 1)
+	assert.EqualValues(t, 2, providers[0].Seq)
+
+	// Expired providers are forgotten.
+
+	assert.Empty(t, store.get([]byte("key"), now.Add(2*time.Hour)))
+	assert.Empty(t, store.providers)
+
+	// Keys have a bounded number of providers, with the providers closest to expiring forgotten first.
+
+	for i := 0; i < store.maxProvidersPerKey; i++ {
+		assert.NoError(t, store.add([]byte("key"), records[i], now.Add(time.Duration(i+1)*time.Minute)))
+	}
+
+	assert.NoError(t, store.add([]byte("key"), records[store.maxProvidersPerKey], now.Add(time.Hour)))
+
+	providers = store.get([]byte("key"), now)
+	assert.Len(t, providers, store.maxProvidersPerKey)
+
+	for _, provider := range providers {
+		assert.NotEqual(t, records[0].ID.PubKey, provider.ID.PubKey)
+	}
+
+	store.expire(now.Add(2 * time.Hour))
+	assert.Empty(t, store.providers)
+}
+
+func TestProviderStoreLimits(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	store := newProviderStore(4*BucketSize, 2, 3)
+
+	records := make([]cryptographic.Record, 0, 2)
+
+	for i := 0; i < cap(records); i++ {
+		pub, priv, err := cryptographic.GenerateKeys(nil)
+		assert.NoError(t, err)
+
+		id := cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), uint16(3000+i))
+		records = append(records, cryptographic.NewRecord(id, 1, priv))
+	}
+
+	// Providers may not provide more keys than they may, yet may still re-announce keys they already provide.
+
+	assert.NoError(t, store.add([]byte("a"), records[0], now.Add(time.Minute)))
+	assert.NoError(t, store.add([]byte("b"), records[0], now.Add(time.Minute)))
+	assert.True(t, errors.Is(store.add([]byte("c"), records[0], now.Add(time.Minute)), ErrProviderLimit))
+	assert.NoError(t, store.add([]byte("b"), records[0], now.Add(time.Hour)))
+
+	// Providers are not tracked for more keys than they may be, yet may still be tracked for keys already known.
+
+	assert.NoError(t, store.add([]byte("c"), records[1], now.Add(time.Hour)))
+	assert.True(t, errors.Is(store.add([]byte("d"), records[1], now.Add(time.Hour)), ErrProviderLimit))
+	assert.NoError(t, store.add([]byte("a"), records[1], now.Add(time.Hour)))
+
+	// Keys no longer count against providers once they expire.
+
+	store.expire(now.Add(30 * time.Minute))
+
+	assert.Equal(t, 1, store.keys[records[0].ID.PubKey])
+	assert.NoError(t, store.add([]byte("c"), records[0], now.Add(time.Hour)))
+	assert.True(t, errors.Is(store.add([]byte("d"), records[0], now.Add(time.Hour)), ErrProviderLimit))
+
+	store.expire(now.Add(2 * time.Hour))
+	assert.Empty(t, store.providers)
+	assert.Empty(t, store.keys)
+}
@@ -0,0 +1,115 @@
+package kademlia
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/zap"
+)
+
+// Refresh executes a single round of routing table maintenance. Peers that have not been seen within the refresh
+// interval configured through WithProtocolRefreshInterval are pinged, and evicted should they fail to respond to as
+// many consecutive pings as configured through WithProtocolMaxPingFailures. Buckets that have not been touched
+// within the refresh interval are then refreshed by executing a lookup for a random public key within their range.
+// Re-bootstrapping from seed peers should the routing table hold too few peers is left to a bootstrap manager, such
+// as the one provided by package bootstrap.
+//
+// Refresh is called periodically by the goroutine started by (*Protocol).Bind, though it may also be called by hand.
+func (p *Protocol) Refresh(ctx context.Context) {
+	p.pingStale(ctx)
+
+	for _, bucket := range p.table.StaleBuckets(time.Now().Add(-p.refreshInterval)) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		target, err := p.table.RandomKey(bucket)
+		if err != nil {
+			p.logger.Warn("Failed to refresh bucket.", zap.Int("bucket", bucket), zap.Error(err))
+			continue
+		}
+
+		p.Find(ctx, target)
+		p.table.Touch(target)
+	}
+}
+
+// pingStale pings all peers in the routing table that have not been seen within the refresh interval, and evicts those
+// that are unresponsive, promoting candidates from the replacement caches of their buckets in their place.
+func (p *Protocol) pingStale(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, id := range p.table.Stale(p.refreshInterval) {
+		id := id
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, p.pingTimeout)
+			defer cancel()
+
+			err := p.Ping(ctx, id.Address)
+			if err == nil || ctx.Err() == context.Canceled || !p.unresponsive(id.PubKey) {
+				return
+			}
+
+			if p.evict(id.PubKey, err) {
+				p.promote(id.PubKey)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// unresponsive returns true should target have failed to respond to as many consecutive pings as configured through
+// WithProtocolMaxPingFailures, or should target no longer be in the routing table.
+func (p *Protocol) unresponsive(target cryptographic.PublicKey) bool {
+	info, exists := p.table.Info(target)
+
+	return !exists || info.Failures >= p.maxPingFailures
+}
+
+// evict removes target from the routing table should it be in it, and fires the OnPeerEvicted event. It returns true
+// should target have been evicted.
+func (p *Protocol) evict(target cryptographic.PublicKey, err error) bool {
+	id, deleted := p.table.Delete(target)
+	if !deleted {
+		return false
+	}
+
+	p.logger.Debug("Peer was evicted from routing table by failing to be pinged.",
+		zap.String("peer_id", id.String()),
+		zap.String("peer_addr", id.Address),
+		zap.Error(err),
+	)
+
+	if p.events.OnPeerEvicted != nil {
+		p.events.OnPeerEvicted(id)
+	}
+
+	return true
+}
+
+// promote promotes the most recently seen candidate from the replacement cache of the bucket target resides within
+// into the bucket, and fires the OnPeerPromoted event.
+func (p *Protocol) promote(target cryptographic.PublicKey) {
+	id, promoted := p.table.Promote(target)
+	if !promoted {
+		return
+	}
+
+	p.logger.Debug("Peer was promoted into routing table from a replacement cache.",
+		zap.String("peer_id", id.String()),
+		zap.String("peer_addr", id.Address),
+	)
+
+	if p.events.OnPeerPromoted != nil {
+		p.events.OnPeerPromoted(id)
+	}
+}
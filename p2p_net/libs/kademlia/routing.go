@@ -0,0 +1,336 @@
+package kademlia
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/zap"
+)
+
+// maxRouteAttempts is the max number of next hops a peer attempts to forward a routed message to, should forwarding
+// the message to the closest next hops fail.
+const maxRouteAttempts = 3
+
+// maxSeenRoutes is the max number of routed message IDs a peer remembers in order to detect routing loops.
+const maxSeenRoutes = 4096
+
+// ErrRouteFailed is returned by (*Protocol).Route and (*Protocol).RouteRequest should a routed message fail to be
+// forwarded to any next hop closer to its target.
+var ErrRouteFailed = errors.New("failed to forward routed message")
+
+// ErrNoRoutedHandler is returned by (*Protocol).Route and (*Protocol).RouteRequest should a routed message be
+// delivered to your node while no RoutedHandler is registered.
+var ErrNoRoutedHandler = errors.New("no routed message handler is registered")
+
+// RoutedHandler handles a routed message delivered to your node for your node being the closest peer to its target.
+// The origin of msg is authenticated, as msg is only delivered should it be signed by its origin. Its hop count is
+// not, as it is incremented by each peer msg was forwarded by.
+// Should the origin of msg have asked for a reply through (*Protocol).RouteRequest, the reply returned is routed back
+// to the origin along the path msg took. Errors returned are sent back to the origin as a *core_module.RemoteError,
+// and may be a *core_module.RemoteError themselves.
+type RoutedHandler func(msg RoutedMessage) ([]byte, error)
+
+// HandleRouted registers handlers for routed messages delivered to your node. Handlers are invoked in the order they
+// were registered, until one of them returns either a non-nil reply or an error.
+func (p *Protocol) HandleRouted(handlers ...RoutedHandler) {
+	p.routedLock.Lock()
+	defer p.routedLock.Unlock()
+
+	p.routedHandlers = append(p.routedHandlers, handlers...)
+}
+
+// Route delivers payload to the peer closest to target w.r.t. XOR distance through recursive key-based routing,
+// without having to look the peer up first. Rather than executing an iterative lookup, your node forwards payload
+// to the peer in its routing table closest to target, which in turn forwards it to the peer in its routing table
+// closest to target, until it reaches a peer that knows of no peer closer to target than itself, which then handles
+// it through the handlers registered via (*Protocol).HandleRouted. Should your node itself be the closest peer to
+// target, payload is handled by your node.
+//
+// Should forwarding to the closest next hop fail, up to 3 next hops in total are attempted at each step. Messages are
+// forwarded at most as many times as configured through WithProtocolRouteMaxHops, and are dropped by peers they have
+// already been routed through. It returns nil once payload has been handled. Use HashKey to route payloads to the
+// peer responsible for some key.
+func (p *Protocol) Route(ctx context.Context, target cryptographic.PublicKey, payload []byte) error {
+	_, err := p.route(ctx, target, payload, false)
+	return err
+}
+
+// RouteRequest delivers payload to the peer closest to target w.r.t. XOR distance like (*Protocol).Route, and returns
+// the reply of that peer, which is routed back to your node along the path payload took.
+func (p *Protocol) RouteRequest(ctx context.Context, target cryptographic.PublicKey, payload []byte) ([]byte, error) {
+	return p.route(ctx, target, payload, true)
+}
+
+func (p *Protocol) route(
+	ctx context.Context, target cryptographic.PublicKey, payload []byte, reply bool,
+) ([]byte, error) {
+	msg := RoutedMessage{
+		Target:  target,
+		Origin:  p.node.ID(),
+		MaxHops: p.routeMaxHops,
+		Reply:   reply,
+		Payload: payload,
+	}
+
+	if _, err := rand.Read(msg.ID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate routed message id: %w", err)
+	}
+
+	copy(msg.Signature[:], p.node.Sign(msg.signed()))
+
+	p.routes.claim(msg.ID, time.Now())
+
+	return p.forward(ctx, msg, p.node.ID().PubKey)
+}
+
+// forward forwards msg to the closest next hop to its target that is closer to its target than your node, and
+// returns the reply of the peer that handled msg. Should there be no such next hop, msg is handled by your node.
+// The sender of msg is never considered as a next hop.
+func (p *Protocol) forward(ctx context.Context, msg RoutedMessage, sender cryptographic.PublicKey) ([]byte, error) {
+	hops := p.nextHops(msg, sender)
+	if len(hops) == 0 {
+		return p.deliver(msg)
+	}
+
+	if msg.Hops >= msg.MaxHops {
+		return nil, core_module.NewRemoteError(core_module.StatusInvalidRequest,
+			fmt.Sprintf("routed message exceeded its hop limit of %d", msg.MaxHops), nil,
+		)
+	}
+
+	msg.Hops++
+
+	var last error
+
+	for _, id := range hops {
+		res, err := p.node.RequestMessage(ctx, id.Address, msg)
+		if err == nil {
+			if res, ok := res.(RoutedResponse); ok {
+				return res.Payload, nil
+			}
+
+			err = fmt.Errorf("did not get a routed response back from %s", id.Address)
+		}
+
+		// Errors raised by the peer that handled msg, or that signal that msg may not be routed any further, are
+		// sent back as-is. Any other error has the next closest next hop be attempted.
+
+		var remote *core_module.RemoteError
+		if errors.As(err, &remote) && remote.Code != core_module.StatusUnavailable {
+			return nil, remote
+		}
+
+		p.logger.Debug("Failed to forward routed message.",
+			zap.String("peer_id", id.String()),
+			zap.String("peer_addr", id.Address),
+			zap.Error(err),
+		)
+
+		last = err
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("%w to any of %d next hop(s): %v", ErrRouteFailed, len(hops), last)
+}
+
+// nextHops returns up to maxRouteAttempts peers in the routing table that are closer to the target of msg than your
+// node, from the closest to the furthest, preferring responsive peers. The sender and origin of msg are omitted.
+func (p *Protocol) nextHops(msg RoutedMessage, sender cryptographic.PublicKey) []cryptographic.ID {
+	self := p.node.ID().PubKey
+
+	candidates := p.table.FindClosestResponsive(msg.Target, p.table.BucketSize())
+
+	if info, exists := p.table.Info(msg.Target); exists { // (*Table).FindClosestResponsive omits the target itself.
+		candidates = append([]cryptographic.ID{info.ID}, candidates...)
+	}
+
+	hops := make([]cryptographic.ID, 0, maxRouteAttempts)
+
+	for _, id := range candidates {
+		if len(hops) == maxRouteAttempts {
+			break
+		}
+
+		if id.PubKey == self || id.PubKey == sender || id.PubKey == msg.Origin.PubKey {
+			continue
+		}
+
+		if !closer(msg.Target, id.PubKey, self) || !p.puzzle.VerifyID(id) {
+			continue
+		}
+
+		hops = append(hops, id)
+	}
+
+	return hops
+}
+
+// deliver handles msg through the handlers registered via (*Protocol).HandleRouted, and returns the reply of the
+// first handler to return a non-nil reply should the origin of msg have asked for one.
+func (p *Protocol) deliver(msg RoutedMessage) ([]byte, error) {
+	p.routedLock.RLock()
+	handlers := p.routedHandlers
+	p.routedLock.RUnlock()
+
+	if len(handlers) == 0 {
+		return nil, ErrNoRoutedHandler
+	}
+
+	for _, handler := range handlers {
+		reply, err := handler(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		if reply != nil {
+			if !msg.Reply {
+				return nil, nil
+			}
+
+			return reply, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// routedRequest is a routed message received from some peer that is queued to be forwarded by a router.
+type routedRequest struct {
+	ctx core_module.HandlerContext
+	msg RoutedMessage
+}
+
+// handleRoutedMessage queues msg to be forwarded by a router, such that the worker handling msg is not held up for
+// as long as it takes msg to be handled by the peer closest to its target.
+func (p *Protocol) handleRoutedMessage(ctx core_module.HandlerContext, msg RoutedMessage) error {
+	// Every hop verifies that the message was signed by its origin, such that no peer along the way may forge the
+	// origin, payload or target of the message, nor raise its hop limit.
+
+	if !msg.Verify() {
+		return core_module.NewRemoteError(core_module.StatusInvalidRequest,
+			"routed message is not signed by its origin", nil,
+		)
+	}
+
+	if !p.routes.claim(msg.ID, time.Now()) {
+		return core_module.NewRemoteError(core_module.StatusUnavailable,
+			"routed message was already routed through this peer", nil,
+		)
+	}
+
+	select {
+	case p.routing <- routedRequest{ctx: ctx, msg: msg}:
+		return nil
+	default:
+		return core_module.NewRemoteError(core_module.StatusUnavailable, "too many routed messages are queued", nil)
+	}
+}
+
+// router forwards routed messages queued by (*Protocol).handleRoutedMessage one at a time, and sends back either the
+// reply or the error they result in, until (*Protocol).Close is called.
+func (p *Protocol) router() {
+	defer p.wg.Done()
+
+	ctx, cancel := p.maintenanceContext()
+	defer cancel()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case req := <-p.routing:
+			p.relayRouted(ctx, req)
+		}
+	}
+}
+
+// relayRouted forwards the routed message of req, and sends back either the reply or the error it results in.
+func (p *Protocol) relayRouted(ctx context.Context, req routedRequest) {
+	ctx, cancel := context.WithTimeout(ctx, p.routeTimeout)
+	defer cancel()
+
+	reply, err := p.forward(ctx, req.msg, req.ctx.ID().PubKey)
+	if err == nil {
+		err = req.ctx.SendMessage(RoutedResponse{Payload: reply})
+	} else {
+		err = req.ctx.SendError(routedError(err))
+	}
+
+	if err != nil {
+		p.logger.Debug("Failed to respond to routed message.",
+			zap.String("peer_id", req.ctx.ID().String()),
+			zap.String("peer_addr", req.ctx.ID().Address),
+			zap.Error(err),
+		)
+	}
+}
+
+// routedError converts an error raised forwarding a routed message into an error that may be sent back to the peer
+// the routed message was received from.
+func routedError(err error) *core_module.RemoteError {
+	var remote *core_module.RemoteError
+
+	switch {
+	case errors.As(err, &remote):
+		return remote
+	case errors.Is(err, ErrNoRoutedHandler):
+		return core_module.NewRemoteError(core_module.StatusNotFound, err.Error(), nil)
+	case errors.Is(err, ErrRouteFailed):
+		return core_module.NewRemoteError(core_module.StatusUnavailable, err.Error(), nil)
+	default:
+		return core_module.NewRemoteError(core_module.StatusInternal, err.Error(), nil)
+	}
+}
+
+// routeCache remembers the IDs of routed messages that have recently been routed through your node.
+type routeCache struct {
+	sync.Mutex
+
+	seen map[[16]byte]time.Time
+	ttl  time.Duration
+}
+
+func newRouteCache(ttl time.Duration) *routeCache {
+	return &routeCache{seen: make(map[[16]byte]time.Time), ttl: ttl}
+}
+
+// claim remembers id as of now, and returns true should id not have been routed through your node within the TTL of
+// the cache. Should the cache be full, all expired IDs are forgotten, followed by arbitrary IDs until there is room.
+func (c *routeCache) claim(id [16]byte, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if expires, exists := c.seen[id]; exists && now.Before(expires) {
+		return false
+	}
+
+	if len(c.seen) >= maxSeenRoutes {
+		for seen, expires := range c.seen {
+			if !now.Before(expires) {
+				delete(c.seen, seen)
+			}
+		}
+
+		for seen := range c.seen {
+			if len(c.seen) < maxSeenRoutes {
+				break
+			}
+
+			delete(c.seen, seen)
+		}
+	}
+
+	c.seen[id] = now.Add(c.ttl)
+
+	return true
+}
@@ -0,0 +1,31 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteCache(t *testing.T) {
+	t.Parallel()
+
+	cache := newRouteCache(time.Minute)
+	now := time.Now()
+
+	assert.True(t, cache.claim([16]byte{1}, now))
+	assert.False(t, cache.claim([16]byte{1}, now.Add(time.Second)))
+	assert.True(t, cache.claim([16]byte{2}, now))
+
+	// Claims expire after the TTL of the cache.
+
+	assert.True(t, cache.claim([16]byte{1}, now.Add(time.Minute)))
+
+	// The cache never grows past its max size.
+
+	for i := 0; i < maxSeenRoutes+10; i++ {
+		assert.True(t, cache.claim([16]byte{3, byte(i), byte(i >> 8)}, now))
+	}
+
+	assert.LessOrEqual(t, len(cache.seen), maxSeenRoutes)
+}
@@ -0,0 +1,278 @@
+package kademlia
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/zap"
+)
+
+// SnapshotVersion is the latest version of the snapshot format of routing tables.
+const SnapshotVersion = 1
+
+// snapshotMagic prefixes all routing table snapshots.
+var snapshotMagic = [4]byte{'k', 't', 'b', 'l'}
+
+// ErrUnsupportedSnapshotVersion is returned when restoring a routing table from a snapshot of a version that is not
+// supported by this version of the library.
+var ErrUnsupportedSnapshotVersion = errors.New("unsupported routing table snapshot version")
+
+const (
+	snapshotEntryID     byte = 0
+	snapshotEntryRecord byte = 1
+)
+
+// minSnapshotEntrySize is the size of the smallest entry a snapshot may hold, being the time it was last seen, its kind
+// byte, and an ID encoded in the legacy layout.
+const minSnapshotEntrySize = 8 + 1 + cryptographic.SizePublicKey + net.IPv6len + 2
+
+// Snapshot writes all entries of this routing table, excluding the ID which its distance metric is defined against,
+// to w. The snapshot comprises of a 4-byte magic, a version byte, and the number of entries as a 32-bit big-endian
+// integer. Each entry comprises of the time it was last seen as a 64-bit big-endian Unix timestamp in nanoseconds,
+// followed by a kind byte, followed by either the signed peer record of the entry should one be recorded, or its ID
+// otherwise. Entries are written bucket by bucket, from the head to the tail of each bucket.
+func (t *Table) Snapshot(w io.Writer) error {
+	t.RLock()
+
+	var buf bytes.Buffer
+
+	buf.Write(snapshotMagic[:])
+	buf.WriteByte(SnapshotVersion)
+
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], uint32(t.size-1))
+	buf.Write(count[:])
+
+	for _, bucket := range t.entries {
+		for _, id := range bucket {
+			if id.PubKey == t.self.PubKey {
+				continue
+			}
+
+			var seen [8]byte
+			binary.BigEndian.PutUint64(seen[:], uint64(t.info[id.PubKey].lastSeen.UnixNano()))
+			buf.Write(seen[:])
+
+			if rec, exists := t.records[id.PubKey]; exists {
+				buf.WriteByte(snapshotEntryRecord)
+				buf.Write(rec.Marshal())
+				continue
+			}
+
+			buf.WriteByte(snapshotEntryID)
+			buf.Write(id.Marshal())
+		}
+	}
+
+	t.RUnlock()
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// Restore reads a snapshot written by (*Table).Snapshot from r, and inserts its entries at the tail of their buckets
+// in the order they were written. It returns the number of entries inserted.
+//
+// Restored entries are not trusted blindly. Entries bearing a signed peer record whose signature is invalid, entries
+// whose buckets are full, and entries that would exceed the IP diversity limits of this routing table are skipped.
+// Restored entries keep the time they were last seen as of the snapshot, such that they are re-validated once they
+// are considered stale by (*Protocol).Refresh.
+func (t *Table) Restore(r io.Reader) (int, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(buf) < len(snapshotMagic)+1+4 || !bytes.Equal(buf[:len(snapshotMagic)], snapshotMagic[:]) {
+		return 0, fmt.Errorf("not a routing table snapshot: %w", io.ErrUnexpectedEOF)
+	}
+
+	if version := buf[len(snapshotMagic)]; version != SnapshotVersion {
+		return 0, fmt.Errorf("%w: %d", ErrUnsupportedSnapshotVersion, version)
+	}
+
+	count := binary.BigEndian.Uint32(buf[len(snapshotMagic)+1:])
+	buf = buf[len(snapshotMagic)+5:]
+
+	if uint64(count) > uint64(len(buf)/minSnapshotEntrySize) {
+		return 0, fmt.Errorf("snapshot claims %d entries, yet holds only %d bytes of entries: %w",
+			count, len(buf), io.ErrUnexpectedEOF,
+		)
+	}
+
+	type entry struct {
+		id     cryptographic.ID
+		record *cryptographic.Record
+		seen   time.Time
+	}
+
+	entries := make([]entry, 0, count)
+
+	for i := uint32(0); i < count; i++ {
+		if len(buf) < 9 {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		e := entry{seen: time.Unix(0, int64(binary.BigEndian.Uint64(buf[:8])))}
+		kind := buf[8]
+		buf = buf[9:]
+
+		switch kind {
+		case snapshotEntryID:
+			id, n, err := cryptographic.UnmarshalID(buf)
+			if err != nil {
+				return 0, fmt.Errorf("could not read entry id: %w", err)
+			}
+
+			e.id = id
+			buf = buf[n:]
+		case snapshotEntryRecord:
+			rec, n, err := cryptographic.UnmarshalRecord(buf)
+			if err != nil {
+				return 0, fmt.Errorf("could not read entry record: %w", err)
+			}
+
+			e.id, e.record = rec.ID, &rec
+			buf = buf[n:]
+		default:
+			return 0, fmt.Errorf("unknown snapshot entry kind %d", kind)
+		}
+
+		entries = append(entries, e)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	restored, now := 0, time.Now()
+
+	for _, e := range entries {
+		if e.id.PubKey == cryptographic.ZeroPublicKey || e.id.PubKey == t.self.PubKey {
+			continue
+		}
+
+		if e.record != nil && !e.record.Verify() {
+			continue
+		}
+
+		idx := t.getBucketIndex(e.id.PubKey)
+
+		if len(t.entries[idx]) >= t.k || t.admit(e.id, idx) != nil {
+			continue
+		}
+
+		exists := false
+
+		for _, id := range t.entries[idx] {
+			if id.PubKey == e.id.PubKey {
+				exists = true
+				break
+			}
+		}
+
+		if exists {
+			continue
+		}
+
+		t.entries[idx] = insertAt(t.entries[idx], len(t.entries[idx]), e.id, t.k)
+		t.info[e.id.PubKey] = entryInfo{addedAt: now, lastSeen: e.seen}
+		t.size++
+
+		if e.record != nil {
+			if existing, known := t.records[e.id.PubKey]; !known || existing.Seq < e.record.Seq {
+				t.records[e.id.PubKey] = *e.record
+			}
+		}
+
+		restored++
+	}
+
+	return restored, nil
+}
+
+// loadSnapshot restores the routing table from the snapshot file configured through WithProtocolTableSnapshot, should
+// it exist. Restored entries that do not solve the crypto puzzles of the network are removed.
+func (p *Protocol) loadSnapshot() {
+	f, err := os.Open(p.snapshotPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			p.logger.Warn("Failed to open routing table snapshot.", zap.Error(err))
+		}
+
+		return
+	}
+
+	defer f.Close()
+
+	restored, err := p.table.Restore(f)
+	if err != nil {
+		p.logger.Warn("Failed to restore routing table from snapshot.", zap.Error(err))
+		return
+	}
+
+	for _, id := range p.table.Entries() {
+		if !p.puzzle.VerifyID(id) {
+			p.table.Delete(id.PubKey)
+			restored--
+		}
+	}
+
+	p.logger.Debug("Restored routing table from snapshot.", zap.Int("num_entries", restored))
+}
+
+// saveSnapshot writes a snapshot of the routing table to the file configured through WithProtocolTableSnapshot. The
+// snapshot is written to a temporary file which is synced to disk and then renamed over the snapshot file, such that a
+// crash never leaves a partially written snapshot behind.
+func (p *Protocol) saveSnapshot() {
+	if p.snapshotPath == "" {
+		return
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p.snapshotPath), ".tmp-")
+	if err != nil {
+		p.logger.Warn("Failed to save routing table snapshot.", zap.Error(err))
+		return
+	}
+
+	if err := p.table.Snapshot(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+
+		p.logger.Warn("Failed to save routing table snapshot.", zap.Error(err))
+
+		return
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+
+		p.logger.Warn("Failed to save routing table snapshot.", zap.Error(err))
+
+		return
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+
+		p.logger.Warn("Failed to save routing table snapshot.", zap.Error(err))
+
+		return
+	}
+
+	if err := os.Rename(f.Name(), p.snapshotPath); err != nil {
+		_ = os.Remove(f.Name())
+
+		p.logger.Warn("Failed to save routing table snapshot.", zap.Error(err))
+	}
+}
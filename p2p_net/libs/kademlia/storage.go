@@ -0,0 +1,421 @@
+package kademlia
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// Value is a value stored in the DHT under some key, alongside the time at which it expires.
+type Value struct {
+	Key     []byte
+	Data    []byte
+	Expires time.Time
+
+	// Owner is the public key of the peer the value was stored on behalf of through a STORE request, or the zero
+	// public key should the value have been stored by your node.
+	Owner cryptographic.PublicKey
+}
+
+// Expired returns true should this value have expired as of now.
+func (v Value) Expired(now time.Time) bool {
+	return !now.Before(v.Expires)
+}
+
+// TTL returns the amount of time left as of now until this value expires.
+func (v Value) TTL(now time.Time) time.Duration {
+	if v.Expired(now) {
+		return 0
+	}
+
+	return v.Expires.Sub(now)
+}
+
+// Validator validates a value before it is stored, and after it is fetched from peers, returning an error should the
+// value be rejected.
+type Validator func(key, value []byte) error
+
+// HashKey returns the position of key in the Kademlia key space, which is the SHA-256 hash of key. Values are stored
+// on the peers whose public keys are closest to this position.
+func HashKey(key []byte) cryptographic.PublicKey {
+	return sha256.Sum256(key)
+}
+
+// Storage is a local storage backend for the values a node stores on behalf of the DHT. Implementations of Storage
+// must be safe for concurrent use, must keep the owner of each value alongside it, and need not be concerned with the
+// expiry of values.
+type Storage interface {
+	// Put stores value under its key, overwriting any value that is already stored under the key.
+	Put(value Value) error
+
+	// Get returns the value stored under key, and true should it exist.
+	Get(key []byte) (Value, bool, error)
+
+	// Delete removes the value stored under key, should it exist.
+	Delete(key []byte) error
+
+	// Values returns all stored values.
+	Values() ([]Value, error)
+}
+
+// MemoryStorage is an in-memory Storage.
+type MemoryStorage struct {
+	sync.RWMutex
+	values map[string]Value
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+// NewMemoryStorage returns a new, empty in-memory Storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{values: make(map[string]Value)}
+}
+
+// Put implements Storage.
+func (s *MemoryStorage) Put(value Value) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.values[string(value.Key)] = value
+
+	return nil
+}
+
+// Get implements Storage.
+func (s *MemoryStorage) Get(key []byte) (Value, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	value, exists := s.values[string(key)]
+
+	return value, exists, nil
+}
+
+// Delete implements Storage.
+func (s *MemoryStorage) Delete(key []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.values, string(key))
+
+	return nil
+}
+
+// Values implements Storage.
+func (s *MemoryStorage) Values() ([]Value, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	values := make([]Value, 0, len(s.values))
+
+	for _, value := range s.values {
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// diskValueExt is the file extension of values stored by a DiskStorage.
+const diskValueExt = ".value"
+
+// DiskStorage is a Storage which stores each value as a file within a directory. Each file is named after the
+// hex-encoded SHA-256 hash of the key of the value it stores, and comprises of the key prefixed by its length as a
+// 16-bit big-endian integer, the time the value expires as a 64-bit big-endian Unix timestamp in nanoseconds, the
+// public key of the owner of the value, and the value itself.
+type DiskStorage struct {
+	sync.RWMutex
+	dir string
+}
+
+var _ Storage = (*DiskStorage)(nil)
+
+// NewDiskStorage returns a Storage which stores values within dir, creating dir should it not exist.
+func NewDiskStorage(dir string) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &DiskStorage{dir: dir}, nil
+}
+
+// Put implements Storage. The value is written to a temporary file which is synced to disk and then renamed over the
+// file of the key, such that a crash never leaves a partially written value behind.
+func (s *DiskStorage) Put(value Value) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(value.Key) > math.MaxUint16 {
+		return ErrKeyTooLarge
+	}
+
+	buf := make([]byte, 2+len(value.Key)+8, 2+len(value.Key)+8+cryptographic.SizePublicKey+len(value.Data))
+	binary.BigEndian.PutUint16(buf[:2], uint16(len(value.Key)))
+	copy(buf[2:], value.Key)
+	binary.BigEndian.PutUint64(buf[2+len(value.Key):], uint64(value.Expires.UnixNano()))
+	buf = append(buf, value.Owner[:]...)
+	buf = append(buf, value.Data...)
+
+	f, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(value.Key))
+}
+
+// Get implements Storage.
+func (s *DiskStorage) Get(key []byte) (Value, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.read(s.path(key))
+}
+
+// Delete implements Storage.
+func (s *DiskStorage) Delete(key []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Values implements Storage.
+func (s *DiskStorage) Values() ([]Value, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]Value, 0, len(files))
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), diskValueExt) {
+			continue
+		}
+
+		value, exists, err := s.read(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+func (s *DiskStorage) path(key []byte) string {
+	hash := sha256.Sum256(key)
+	return filepath.Join(s.dir, hex.EncodeToString(hash[:])+diskValueExt)
+}
+
+func (s *DiskStorage) read(path string) (Value, bool, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Value{}, false, nil
+		}
+
+		return Value{}, false, err
+	}
+
+	if len(buf) < 2 {
+		return Value{}, false, io.ErrUnexpectedEOF
+	}
+
+	size := int(binary.BigEndian.Uint16(buf[:2]))
+	buf = buf[2:]
+
+	if len(buf) < size+8+cryptographic.SizePublicKey {
+		return Value{}, false, io.ErrUnexpectedEOF
+	}
+
+	value := Value{
+		Key:     buf[:size],
+		Data:    buf[size+8+cryptographic.SizePublicKey:],
+		Expires: time.Unix(0, int64(binary.BigEndian.Uint64(buf[size:size+8]))),
+	}
+
+	copy(value.Owner[:], buf[size+8:size+8+cryptographic.SizePublicKey])
+
+	return value, true, nil
+}
+
+// storageQuota accounts for the values stored on behalf of peers, being the key and data of each value, such that no
+// single peer, nor all peers together, may fill the storage of a node. Limits that are non-positive are not enforced.
+type storageQuota struct {
+	sync.Mutex
+
+	maxPeerKeys  int
+	maxPeerBytes int
+	maxBytes     int
+
+	owners map[string]storageOwner
+	usage  map[cryptographic.PublicKey]storageUsage
+	total  int
+}
+
+type storageOwner struct {
+	peer cryptographic.PublicKey
+	size int
+}
+
+type storageUsage struct {
+	keys  int
+	bytes int
+}
+
+func newStorageQuota(maxPeerKeys, maxPeerBytes, maxBytes int) *storageQuota {
+	return &storageQuota{
+		maxPeerKeys:  maxPeerKeys,
+		maxPeerBytes: maxPeerBytes,
+		maxBytes:     maxBytes,
+		owners:       make(map[string]storageOwner),
+		usage:        make(map[cryptographic.PublicKey]storageUsage),
+	}
+}
+
+// put stores v through put on behalf of peer, should v not exceed the quota of peer nor the total quota. Any value v
+// overwrites is no longer accounted for against the peer it was stored on behalf of. Values are stored while the
+// quota is locked, such that concurrent calls never exceed the quota between them.
+func (q *storageQuota) put(peer cryptographic.PublicKey, v Value, put func(Value) error) error {
+	q.Lock()
+	defer q.Unlock()
+
+	size := len(v.Key) + len(v.Data)
+
+	prev, exists := q.owners[string(v.Key)]
+
+	usage := q.usage[peer]
+	total := q.total + size
+
+	if exists {
+		total -= prev.size
+
+		if prev.peer == peer {
+			usage.keys--
+			usage.bytes -= prev.size
+		}
+	}
+
+	usage.keys++
+	usage.bytes += size
+
+	if q.maxPeerKeys > 0 && usage.keys > q.maxPeerKeys {
+		return ErrStorageQuotaExceeded
+	}
+
+	if q.maxPeerBytes > 0 && usage.bytes > q.maxPeerBytes {
+		return ErrStorageQuotaExceeded
+	}
+
+	if q.maxBytes > 0 && total > q.maxBytes {
+		return ErrStorageQuotaExceeded
+	}
+
+	if err := put(v); err != nil {
+		return err
+	}
+
+	if exists && prev.peer != peer {
+		q.release(prev)
+	}
+
+	q.owners[string(v.Key)] = storageOwner{peer: peer, size: size}
+	q.usage[peer] = usage
+	q.total = total
+
+	return nil
+}
+
+// restore accounts for v against the peer that owns it without enforcing any limits, should v be owned by some peer.
+// It is to be called for each value found in storage upon startup, such that values stored on behalf of peers before
+// a restart keep counting against their quota.
+func (q *storageQuota) restore(v Value) {
+	if v.Owner == cryptographic.ZeroPublicKey {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if prev, exists := q.owners[string(v.Key)]; exists {
+		q.release(prev)
+		q.total -= prev.size
+	}
+
+	size := len(v.Key) + len(v.Data)
+
+	usage := q.usage[v.Owner]
+	usage.keys++
+	usage.bytes += size
+
+	q.owners[string(v.Key)] = storageOwner{peer: v.Owner, size: size}
+	q.usage[v.Owner] = usage
+	q.total += size
+}
+
+// forget stops accounting for the value stored under key, should it have been stored on behalf of some peer. It is
+// to be called once the value is deleted, or overwritten by your node.
+func (q *storageQuota) forget(key []byte) {
+	q.Lock()
+	defer q.Unlock()
+
+	owner, exists := q.owners[string(key)]
+	if !exists {
+		return
+	}
+
+	delete(q.owners, string(key))
+
+	q.release(owner)
+	q.total -= owner.size
+}
+
+func (q *storageQuota) release(owner storageOwner) {
+	usage := q.usage[owner.peer]
+	usage.keys--
+	usage.bytes -= owner.size
+
+	if usage.keys <= 0 {
+		delete(q.usage, owner.peer)
+		return
+	}
+
+	q.usage[owner.peer] = usage
+}
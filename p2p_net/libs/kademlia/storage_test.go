@@ -0,0 +1,172 @@
+package kademlia
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStorage(t *testing.T, s Storage) {
+	_, exists, err := s.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	expires := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+	owner := cryptographic.PublicKey{1}
+
+	assert.NoError(t, s.Put(Value{Key: []byte("a"), Data: []byte("1"), Expires: expires}))
+	assert.NoError(t, s.Put(Value{Key: []byte("b"), Data: []byte("2"), Expires: expires, Owner: owner}))
+	assert.NoError(t, s.Put(Value{Key: []byte("a"), Data: []byte("3"), Expires: expires}))
+
+	v, exists, err := s.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []byte("3"), v.Data)
+	assert.True(t, expires.Equal(v.Expires))
+
+	values, err := s.Values()
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+
+	sort.Slice(values, func(i, j int) bool { return bytes.Compare(values[i].Key, values[j].Key) < 0 })
+	assert.Equal(t, []byte("a"), values[0].Key)
+	assert.Equal(t, []byte("b"), values[1].Key)
+	assert.Equal(t, cryptographic.ZeroPublicKey, values[0].Owner)
+	assert.Equal(t, owner, values[1].Owner)
+
+	assert.NoError(t, s.Delete([]byte("a")))
+	assert.NoError(t, s.Delete([]byte("a")))
+
+	_, exists, err = s.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, NewMemoryStorage())
+}
+
+func TestDiskStorage(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewDiskStorage(dir)
+	assert.NoError(t, err)
+
+	testStorage(t, s)
+
+	// Values outlive the storage instance they were stored through.
+
+	s, err = NewDiskStorage(dir)
+	assert.NoError(t, err)
+
+	v, exists, err := s.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []byte("2"), v.Data)
+	assert.Equal(t, cryptographic.PublicKey{1}, v.Owner)
+
+	// Keys of any size may be stored.
+
+	key := bytes.Repeat([]byte{0xff}, 4096)
+
+	assert.NoError(t, s.Put(Value{Key: key, Data: []byte("4"), Expires: time.Now().Add(time.Hour)}))
+
+	v, exists, err = s.Get(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, key, v.Key)
+}
+
+func TestValueTTL(t *testing.T) {
+	now := time.Now()
+
+	v := Value{Expires: now.Add(time.Minute)}
+	assert.False(t, v.Expired(now))
+	assert.Equal(t, time.Minute, v.TTL(now))
+
+	assert.True(t, v.Expired(now.Add(time.Minute)))
+	assert.Equal(t, time.Duration(0), v.TTL(now.Add(2*time.Minute)))
+}
+
+func TestStorageQuota(t *testing.T) {
+	s := NewMemoryStorage()
+	q := newStorageQuota(2, 16, 24)
+
+	var a, b cryptographic.PublicKey
+	a[0], b[0] = 1, 2
+
+	value := func(key, data string) Value {
+		return Value{Key: []byte(key), Data: []byte(data), Expires: time.Now().Add(time.Hour)}
+	}
+
+	assert.NoError(t, q.put(a, value("a", "1234"), s.Put))
+	assert.NoError(t, q.put(a, value("b", "1234"), s.Put))
+
+	// Peers may not exceed the number of keys they may have stored, yet may overwrite keys they have stored.
+
+	assert.True(t, errors.Is(q.put(a, value("c", "1"), s.Put), ErrStorageQuotaExceeded))
+	assert.NoError(t, q.put(a, value("b", "1234567"), s.Put))
+
+	// Peers may not exceed the number of bytes they may have stored.
+
+	assert.True(t, errors.Is(q.put(b, value("d", "1234567890123456"), s.Put), ErrStorageQuotaExceeded))
+	assert.NoError(t, q.put(b, value("d", "1234"), s.Put))
+
+	// Peers together may not exceed the total number of bytes that may be stored.
+
+	assert.True(t, errors.Is(q.put(b, value("e", "123456"), s.Put), ErrStorageQuotaExceeded))
+
+	_, exists, err := s.Get([]byte("e"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Keys overwritten by some other peer, or forgotten, no longer count against the peer that stored them.
+
+	assert.NoError(t, q.put(b, value("a", "1"), s.Put))
+	assert.NoError(t, q.put(a, value("c", "1"), s.Put))
+
+	q.forget([]byte("b"))
+	q.forget([]byte("b"))
+
+	assert.Equal(t, storageUsage{keys: 1, bytes: 2}, q.usage[a])
+	assert.Equal(t, storageUsage{keys: 2, bytes: 7}, q.usage[b])
+	assert.Equal(t, 9, q.total)
+}
+
+func TestStorageQuotaRestore(t *testing.T) {
+	s := NewMemoryStorage()
+
+	var a cryptographic.PublicKey
+	a[0] = 1
+
+	expires := time.Now().Add(time.Hour)
+
+	assert.NoError(t, s.Put(Value{Key: []byte("a"), Data: []byte("1234"), Expires: expires, Owner: a}))
+	assert.NoError(t, s.Put(Value{Key: []byte("b"), Data: []byte("1234"), Expires: expires}))
+
+	// Values stored on behalf of peers before a restart keep counting against their quota, while values stored by
+	// your node do not.
+
+	q := newStorageQuota(1, 0, 0)
+
+	values, err := s.Values()
+	assert.NoError(t, err)
+
+	for _, v := range values {
+		q.restore(v)
+	}
+
+	assert.Equal(t, storageUsage{keys: 1, bytes: 5}, q.usage[a])
+	assert.Equal(t, 5, q.total)
+
+	err = q.put(a, Value{Key: []byte("c"), Data: []byte("1"), Expires: expires}, s.Put)
+	assert.True(t, errors.Is(err, ErrStorageQuotaExceeded))
+
+	assert.NoError(t, q.put(a, Value{Key: []byte("a"), Data: []byte("1"), Expires: expires}, s.Put))
+}
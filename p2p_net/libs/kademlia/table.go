@@ -1,8 +1,11 @@
 package kademlia
 
 import (
+	"crypto/rand"
 	"fmt"
+	"net"
 	"sync"
+	"time"
 
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
 )
@@ -12,14 +15,43 @@ type Table struct {
 	sync.RWMutex
 
 	entries [cryptographic.SizePublicKey * 8][]cryptographic.ID
+	records map[cryptographic.PublicKey]cryptographic.Record
 	self    cryptographic.ID
 	size    int
+	k       int
+
+	touched [cryptographic.SizePublicKey * 8]time.Time
+	info    map[cryptographic.PublicKey]entryInfo
+
+	replacements [cryptographic.SizePublicKey * 8][]cryptographic.ID
+
+	// observed holds the host each peer that your node is connected to was observed at, which takes the place of the
+	// host the peer claims when enforcing the IP diversity limits of this routing table.
+	observed map[cryptographic.PublicKey]net.IP
+
+	limits DiversityLimits
 }
 
 // NewTable instantiates a new routing table whose XOR distance metric is defined with respect to some
-// given ID.
-func NewTable(self cryptographic.ID) *Table {
-	table := &Table{self: self}
+// given ID, which may be optionally configured with a variadic list of functional options.
+func NewTable(self cryptographic.ID, opts ...TableOption) *Table {
+	table := &Table{
+		self:     self,
+		k:        BucketSize,
+		records:  make(map[cryptographic.PublicKey]cryptographic.Record),
+		info:     make(map[cryptographic.PublicKey]entryInfo),
+		observed: make(map[cryptographic.PublicKey]net.IP),
+	}
+
+	for _, opt := range opts {
+		opt(table)
+	}
+
+	now := time.Now()
+
+	for i := range table.touched {
+		table.touched[i] = now
+	}
 
 	if _, err := table.Update(self); err != nil {
 		panic(err)
@@ -28,24 +60,62 @@ func NewTable(self cryptographic.ID) *Table {
 	return table
 }
 
+// SetDiversityLimits sets the IP diversity limits enforced upon admitting peers into this routing table. Peers already
+// in this routing table are not evicted should they exceed the new limits. By default, no limits are enforced.
+func (t *Table) SetDiversityLimits(limits DiversityLimits) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.limits = limits
+}
+
 // Self returns the ID which this routing table's XOR distance metric is defined with respect to.
 func (t *Table) Self() cryptographic.ID {
 	return t.self
 }
 
-// Bucket returns all IDs in the bucket where target resides within.
+// BucketSize returns the max capacity of each bucket of this routing table, otherwise known as k.
+func (t *Table) BucketSize() int {
+	return t.k
+}
+
+// ReplacementCacheSize returns the max capacity of the replacement cache of each bucket of this routing table, which is
+// the same as the max capacity of each bucket.
+func (t *Table) ReplacementCacheSize() int {
+	return t.k
+}
+
+// Bucket returns a copy of all IDs in the bucket where target resides within, ordered from the head to the tail of the
+// bucket.
 func (t *Table) Bucket(target cryptographic.PublicKey) []cryptographic.ID {
 	t.RLock()
 	defer t.RUnlock()
 
-	return t.entries[t.getBucketIndex(target)]
+	return append([]cryptographic.ID(nil), t.entries[t.getBucketIndex(target)]...)
 }
 
-// Update attempts to insert the target node/peer ID into this routing table. If the bucket it was expected
-// to be inserted within is full, ErrBucketFull is returned. If the ID already exists in its respective routing
-// table bucket, it is moved to the head of the bucket and false is returned. If the ID has yet to exist, it is
-// appended to the head of its intended bucket and true is returned.
+// Update attempts to insert the target node/peer ID into this routing table. If the bucket it was expected to be
+// inserted within is full, ErrBucketFull is returned. If the ID already exists in its respective routing table bucket,
+// it is moved to the head of the bucket and false is returned. If the ID has yet to exist, it is appended to the head
+// of its intended bucket and true is returned. Should a signed peer record of the target be recorded via
+// (*Table).UpdateRecord, the ID vouched for by the record is stored in place of the target ID. The target is marked as
+// last seen now with no consecutive failures, and its bucket as touched now, should it be inserted or moved. Targets
+// that are inserted are removed from the replacement cache of their bucket. Should inserting the target exceed the IP
+// diversity limits of this routing table, an error wrapping ErrDiversityLimit is returned.
 func (t *Table) Update(target cryptographic.ID) (bool, error) {
+	return t.update(target, nil)
+}
+
+// UpdateObserved is (*Table).Update for a target that your node is connected to, where host is the host of the
+// connection to target as observed by your node. The IP diversity limits of this routing table group target by host
+// rather than by the host target claims, and exempt target only should host be exempt. The observed host is kept for
+// as long as target is in this routing table or in the replacement cache of its bucket, and should the bucket of
+// target be full, such that target may be cached as a replacement through (*Table).AddReplacement.
+func (t *Table) UpdateObserved(target cryptographic.ID, host net.IP) (bool, error) {
+	return t.update(target, host)
+}
+
+func (t *Table) update(target cryptographic.ID, host net.IP) (bool, error) {
 	if target.PubKey == cryptographic.ZeroPublicKey {
 		return false, nil
 	}
@@ -53,24 +123,46 @@ func (t *Table) Update(target cryptographic.ID) (bool, error) {
 	t.Lock()
 	defer t.Unlock()
 
+	if host != nil {
+		t.observed[target.PubKey] = host
+	}
+
+	if rec, exists := t.records[target.PubKey]; exists { // Prefer the ID vouched for by the peers' signed record.
+		target = rec.ID
+	}
+
 	idx := t.getBucketIndex(target.PubKey)
+	now := time.Now()
 
 	for i, id := range t.entries[idx] {
 		if id.PubKey == target.PubKey { // Found the target ID already inside the routing table.
-			t.entries[idx] = append(append([]cryptographic.ID{target}, t.entries[idx][:i]...), t.entries[idx][i+1:]...)
+			moveToFront(t.entries[idx], i, target)
+			t.seen(target.PubKey, now)
+			t.touched[idx] = now
 			return false, nil
 		}
 	}
 
-	if len(t.entries[idx]) < BucketSize { // The bucket is not yet under full capacity.
-		t.entries[idx] = append([]cryptographic.ID{target}, t.entries[idx]...)
+	if err := t.admit(target, idx); err != nil {
+		if !t.known(idx, target.PubKey) {
+			delete(t.observed, target.PubKey)
+		}
+
+		return false, fmt.Errorf("cannot insert id %x into routing table: %w", target.PubKey, err)
+	}
+
+	if len(t.entries[idx]) < t.k { // The bucket is not yet under full capacity.
+		t.entries[idx] = insertAt(t.entries[idx], 0, target, t.k)
+		t.info[target.PubKey] = entryInfo{addedAt: now, lastSeen: now}
+		t.touched[idx] = now
 		t.size++
+		t.removeReplacement(idx, target.PubKey)
 		return true, nil
 	}
 
 	// The bucket is at full capacity. Return ErrBucketFull.
 
-	return false, fmt.Errorf("cannot insert id %x into routing table: %v", target.PubKey, ErrBucketFull)
+	return false, fmt.Errorf("cannot insert id %x into routing table: %w", target.PubKey, ErrBucketFull)
 }
 
 // Recorded returns true if target is already recorded in this routing table.
@@ -87,8 +179,64 @@ func (t *Table) Recorded(target cryptographic.PublicKey) bool {
 	return false
 }
 
-// Delete removes target from this routing table. It returns the id of the delted target and true if found, or
-// a zero-value ID and false otherwise.
+// UpdateRecord records rec as the latest signed peer record of its bearer, provided that the signature of rec is valid
+// and that no record of an equal or higher sequence number is already recorded for its bearer. Should the bearer of
+// rec already be in this routing table, its entry is replaced in-place with the ID vouched for by rec, unless moving
+// the entry to the host of rec would exceed the IP diversity limits of this routing table. It returns true if rec was
+// recorded.
+func (t *Table) UpdateRecord(rec cryptographic.Record) bool {
+	if rec.ID.PubKey == cryptographic.ZeroPublicKey || rec.ID.PubKey == t.self.PubKey || !rec.Verify() {
+		return false
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if existing, exists := t.records[rec.ID.PubKey]; exists && existing.Seq >= rec.Seq {
+		return false
+	}
+
+	idx := t.getBucketIndex(rec.ID.PubKey)
+
+	for i, id := range t.entries[idx] {
+		if id.PubKey != rec.ID.PubKey {
+			continue
+		}
+
+		if !id.Host.Equal(rec.ID.Host) && t.admit(rec.ID, idx) != nil {
+			return false
+		}
+
+		t.entries[idx][i] = rec.ID
+		break
+	}
+
+	t.records[rec.ID.PubKey] = rec
+
+	return true
+}
+
+// Record returns the latest signed peer record recorded for target, and true if one exists, or a zero-value record
+// and false otherwise.
+func (t *Table) Record(target cryptographic.PublicKey) (cryptographic.Record, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	rec, exists := t.records[target]
+
+	return rec, exists
+}
+
+// DeleteRecord removes the signed peer record recorded for target, should one exist.
+func (t *Table) DeleteRecord(target cryptographic.PublicKey) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.records, target)
+}
+
+// Delete removes target, alongside its signed peer record, from this routing table. It returns the id of the deleted
+// target and true if found, or a zero-value ID and false otherwise.
 func (t *Table) Delete(target cryptographic.PublicKey) (cryptographic.ID, bool) {
 	t.Lock()
 	defer t.Unlock()
@@ -97,8 +245,11 @@ func (t *Table) Delete(target cryptographic.PublicKey) (cryptographic.ID, bool)
 
 	for i, id := range t.entries[idx] {
 		if id.PubKey == target {
-			t.entries[idx] = append(t.entries[idx][:i], t.entries[idx][i+1:]...)
+			t.entries[idx] = removeAt(t.entries[idx], i)
 			t.size--
+			delete(t.records, target)
+			delete(t.info, target)
+			delete(t.observed, target)
 			return id, true
 		}
 	}
@@ -115,8 +266,11 @@ func (t *Table) DeleteByAddress(target string) (cryptographic.ID, bool) {
 	for i, bucket := range t.entries {
 		for j, id := range bucket {
 			if id.Address == target {
-				t.entries[i] = append(t.entries[i][:j], t.entries[i][j+1:]...)
+				t.entries[i] = removeAt(t.entries[i], j)
 				t.size--
+				delete(t.records, id.PubKey)
+				delete(t.info, id.PubKey)
+				delete(t.observed, id.PubKey)
 				return id, true
 			}
 		}
@@ -125,44 +279,303 @@ func (t *Table) DeleteByAddress(target string) (cryptographic.ID, bool) {
 	return cryptographic.ID{}, false
 }
 
-// Peers returns BucketSize closest peer IDs to the ID which this routing table's distance metric is defined against.
+// AddReplacement records target as the most recently seen candidate in the replacement cache of the bucket target
+// resides within, such that it may take the place of an entry that is evicted from the bucket. Should the cache be
+// full, the least recently seen candidate is dropped, alongside its signed peer record. It returns true should target
+// have been newly added to the cache, and false should it have been moved to the head of the cache, or should it
+// already be in this routing table.
+func (t *Table) AddReplacement(target cryptographic.ID) bool {
+	if target.PubKey == cryptographic.ZeroPublicKey || target.PubKey == t.self.PubKey {
+		return false
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	idx := t.getBucketIndex(target.PubKey)
+
+	for _, id := range t.entries[idx] {
+		if id.PubKey == target.PubKey {
+			return false
+		}
+	}
+
+	if rec, exists := t.records[target.PubKey]; exists {
+		target = rec.ID
+	}
+
+	cache := t.replacements[idx]
+
+	for i, id := range cache {
+		if id.PubKey == target.PubKey {
+			moveToFront(cache, i, target)
+			return false
+		}
+	}
+
+	if len(cache) >= t.k {
+		delete(t.records, cache[len(cache)-1].PubKey)
+		delete(t.observed, cache[len(cache)-1].PubKey)
+		cache = removeAt(cache, len(cache)-1)
+	}
+
+	t.replacements[idx] = insertAt(cache, 0, target, t.k)
+
+	return true
+}
+
+// Replacements returns a copy of all candidates in the replacement cache of the bucket where target resides within,
+// ordered from the most to the least recently seen.
+func (t *Table) Replacements(target cryptographic.PublicKey) []cryptographic.ID {
+	t.RLock()
+	defer t.RUnlock()
+
+	return append([]cryptographic.ID(nil), t.replacements[t.getBucketIndex(target)]...)
+}
+
+// DeleteReplacement removes target, alongside its signed peer record, from the replacement cache of the bucket target
+// resides within. It returns true should target have been in the cache.
+func (t *Table) DeleteReplacement(target cryptographic.PublicKey) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.removeReplacement(t.getBucketIndex(target), target) {
+		return false
+	}
+
+	delete(t.records, target)
+	delete(t.observed, target)
+
+	return true
+}
+
+// Promote moves the most recently seen candidate in the replacement cache of the bucket where target resides within
+// to the tail of the bucket, should the bucket not be full. Candidates whose admission would exceed the IP diversity
+// limits of this routing table are skipped over. It returns the ID of the promoted candidate and true
+// should a candidate have been promoted, or a zero-value ID and false otherwise.
+func (t *Table) Promote(target cryptographic.PublicKey) (cryptographic.ID, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	idx := t.getBucketIndex(target)
+
+	if len(t.entries[idx]) >= t.k {
+		return cryptographic.ID{}, false
+	}
+
+	var (
+		promoted cryptographic.ID
+		found    bool
+	)
+
+	for _, candidate := range t.replacements[idx] {
+		if rec, exists := t.records[candidate.PubKey]; exists {
+			candidate = rec.ID
+		}
+
+		if t.admit(candidate, idx) == nil {
+			promoted, found = candidate, true
+			break
+		}
+	}
+
+	if !found {
+		return cryptographic.ID{}, false
+	}
+
+	t.removeReplacement(idx, promoted.PubKey)
+
+	t.entries[idx] = insertAt(t.entries[idx], len(t.entries[idx]), promoted, t.k)
+	now := time.Now()
+	t.info[promoted.PubKey] = entryInfo{addedAt: now, lastSeen: now}
+	t.size++
+
+	return promoted, true
+}
+
+// known returns true should target be in the bucket or the replacement cache at index idx. The caller must hold the
+// lock.
+func (t *Table) known(idx int, target cryptographic.PublicKey) bool {
+	for _, id := range t.entries[idx] {
+		if id.PubKey == target {
+			return true
+		}
+	}
+
+	for _, id := range t.replacements[idx] {
+		if id.PubKey == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeReplacement removes target from the replacement cache at index idx, and returns true should it have been in
+// the cache. The caller must hold the write lock.
+func (t *Table) removeReplacement(idx int, target cryptographic.PublicKey) bool {
+	for i, id := range t.replacements[idx] {
+		if id.PubKey == target {
+			t.replacements[idx] = removeAt(t.replacements[idx], i)
+			return true
+		}
+	}
+
+	return false
+}
+
+// LastSeen returns the last time target was inserted or moved to the head of its bucket via (*Table).Update, or
+// responded to a ping recorded via (*Table).RecordRTT, and true should target be in this routing table, or a
+// zero-value time and false otherwise.
+func (t *Table) LastSeen(target cryptographic.PublicKey) (time.Time, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	info, exists := t.info[target]
+
+	return info.lastSeen, exists
+}
+
+// Touch marks the bucket target resides within as touched now. Buckets are touched whenever an ID is inserted into or
+// moved within them, and whenever a lookup for a target within them is executed.
+func (t *Table) Touch(target cryptographic.PublicKey) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.touched[t.getBucketIndex(target)] = time.Now()
+}
+
+// StaleBuckets returns the indices of the buckets that have not been touched since cutoff. Only buckets up to and
+// including the bucket after the non-empty bucket sharing the longest prefix with the ID which this routing table's
+// distance metric is defined against are considered, as the ranges of all deeper buckets are all but guaranteed to be
+// empty.
+func (t *Table) StaleBuckets(cutoff time.Time) []int {
+	t.RLock()
+	defer t.RUnlock()
+
+	last := 0
+
+	for i, bucket := range t.entries[:len(t.entries)-1] { // The last bucket holds the ID of this routing table.
+		if len(bucket) > 0 {
+			last = i + 1
+		}
+	}
+
+	var stale []int
+
+	for i := 0; i <= last; i++ {
+		if t.touched[i].Before(cutoff) {
+			stale = append(stale, i)
+		}
+	}
+
+	return stale
+}
+
+// RandomKey returns a random public key which resides within the bucket at index bucket, which is a key that shares
+// exactly bucket leading bits with the public key of the ID which this routing table's distance metric is defined
+// against.
+func (t *Table) RandomKey(bucket int) (cryptographic.PublicKey, error) {
+	var key cryptographic.PublicKey
+
+	if _, err := rand.Read(key[:]); err != nil {
+		return key, fmt.Errorf("failed to generate random key: %w", err)
+	}
+
+	for i := 0; i <= bucket && i < len(key)*8; i++ {
+		mask := byte(0x80) >> uint(i%8)
+		bit := t.self.PubKey[i/8] & mask
+
+		if i == bucket { // The first bit after the shared prefix must differ.
+			bit ^= mask
+		}
+
+		key[i/8] = key[i/8]&^mask | bit
+	}
+
+	return key, nil
+}
+
+// Peers returns the k closest peer IDs to the ID which this routing table's distance metric is defined against, where
+// k is the max capacity of each bucket of this routing table.
 func (t *Table) Peers() []cryptographic.ID {
-	return t.FindClosest(t.self.PubKey, BucketSize)
+	return t.FindClosest(t.self.PubKey, t.k)
 }
 
-// FindClosest returns the k closest peer IDs to target, and sorts them based on how close they are.
+// FindClosest returns the k closest peer IDs to target, and sorts them based on how close they are. Target itself is
+// omitted should it be in this routing table.
+//
+// Buckets are visited in order of how close their IDs are to target, and the closest IDs are selected through a
+// bounded max-heap. IDs in the bucket target resides within are closer to target than the IDs of any other bucket,
+// followed by the IDs in all buckets deeper than it, followed by the IDs in each shallower bucket from the deepest to
+// the shallowest, such that no further buckets are visited once k IDs have been selected.
 func (t *Table) FindClosest(target cryptographic.PublicKey, k int) []cryptographic.ID {
-	var closest []cryptographic.ID
+	return t.findClosest(target, k, false)
+}
+
+// FindClosestResponsive returns the k closest peer IDs to target like (*Table).FindClosest, though prefers peers that
+// are responsive. Peers whose last pings failed, as recorded via (*Table).RecordFailure, are only returned should
+// fewer than k responsive peers be in this routing table, and are placed after all responsive peers.
+func (t *Table) FindClosestResponsive(target cryptographic.PublicKey, k int) []cryptographic.ID {
+	return t.findClosest(target, k, true)
+}
+
+func (t *Table) findClosest(target cryptographic.PublicKey, k int, preferResponsive bool) []cryptographic.ID {
+	t.RLock()
+	defer t.RUnlock()
+
+	if k > t.size {
+		k = t.size
+	}
+
+	if k <= 0 {
+		return nil
+	}
+
+	h := closestHeap{target: target, ids: make([]cryptographic.ID, 0, k), k: k}
+	unresponsive := closestHeap{target: target}
+
+	if preferResponsive {
+		unresponsive.k = k
+	}
 
 	f := func(bucket []cryptographic.ID) {
 		for _, id := range bucket {
-			if id.PubKey != target {
-				closest = append(closest, id)
+			if id.PubKey == target {
+				continue
+			}
+
+			if preferResponsive && t.info[id.PubKey].failures > 0 {
+				unresponsive.push(id)
+				continue
 			}
+
+			h.push(id)
 		}
 	}
 
-	t.RLock()
-	defer t.RUnlock()
-
 	idx := t.getBucketIndex(target)
 
 	f(t.entries[idx])
 
-	for i := 1; len(closest) < k && (idx-i >= 0 || idx+i < len(t.entries)); i++ {
-		if idx-i >= 0 {
-			f(t.entries[idx-i])
+	if !h.full() {
+		for i := idx + 1; i < len(t.entries); i++ {
+			f(t.entries[i])
 		}
+	}
 
-		if idx+i < len(t.entries) {
-			f(t.entries[idx+i])
-		}
+	for i := idx - 1; i >= 0 && !h.full(); i-- {
+		f(t.entries[i])
 	}
 
-	closest = SortByDistance(target, closest)
+	closest := h.sorted()
 
-	if len(closest) > k {
-		closest = closest[:k]
+	for _, id := range unresponsive.sorted() {
+		if len(closest) >= k {
+			break
+		}
+
+		closest = append(closest, id)
 	}
 
 	return closest
@@ -193,10 +606,38 @@ func (t *Table) NumEntries() int {
 }
 
 func (t *Table) getBucketIndex(target cryptographic.PublicKey) int {
-	l := PrefixLen(XOR(target[:], t.self.PubKey[:]))
+	l := DistanceBetween(target, t.self.PubKey).PrefixLen()
 	if l == cryptographic.SizePublicKey*8 {
 		return l - 1
 	}
 
 	return l
 }
+
+// insertAt inserts id into s at index i, shifting all IDs after it towards the tail in-place. Should s have yet to be
+// allocated, it is allocated with a capacity of capacity.
+func insertAt(s []cryptographic.ID, i int, id cryptographic.ID, capacity int) []cryptographic.ID {
+	if s == nil {
+		s = make([]cryptographic.ID, 0, capacity)
+	}
+
+	s = append(s, cryptographic.ID{})
+	copy(s[i+1:], s[i:])
+	s[i] = id
+
+	return s
+}
+
+// removeAt removes the ID at index i from s, shifting all IDs after it towards the head in-place.
+func removeAt(s []cryptographic.ID, i int) []cryptographic.ID {
+	copy(s[i:], s[i+1:])
+	s[len(s)-1] = cryptographic.ID{}
+
+	return s[:len(s)-1]
+}
+
+// moveToFront moves the ID at index i of s to the head of s in-place, replacing it with id.
+func moveToFront(s []cryptographic.ID, i int, id cryptographic.ID) {
+	copy(s[1:i+1], s[:i])
+	s[0] = id
+}
@@ -0,0 +1,14 @@
+package kademlia
+
+// TableOption represents a functional option which may be passed to NewTable to configure Table.
+type TableOption func(t *Table)
+
+// WithTableBucketSize sets the max capacity of each bucket of a routing table, otherwise known as k. Non-positive
+// capacities are ignored. By default, it is set to BucketSize.
+func WithTableBucketSize(k int) TableOption {
+	return func(t *Table) {
+		if k > 0 {
+			t.k = k
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package kademlia
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"net"
 	"testing"
+	"time"
 
 	"awesomeProject/beacon/p2p_network/libs/cryptographic"
 
@@ -61,3 +66,636 @@ func TestDistanceMetric(t *testing.T) {
 
 	assert.Len(t, seen, cap(ids))
 }
+
+func TestTableRecords(t *testing.T) {
+	self, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	pub, priv, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	table := NewTable(cryptographic.NewID(self, net.ParseIP("10.0.0.1"), 3000))
+
+	id := cryptographic.NewID(pub, net.ParseIP("10.0.0.2"), 3000)
+
+	inserted, err := table.Update(id)
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+
+	// A record forged by some other key is rejected.
+
+	_, forger, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	forged := cryptographic.NewRecord(cryptographic.NewID(pub, net.ParseIP("6.6.6.6"), 3000), 100, forger)
+	assert.False(t, table.UpdateRecord(forged))
+
+	// Records of a higher sequence number supersede records of a lower sequence number.
+
+	assert.True(t, table.UpdateRecord(cryptographic.NewRecord(cryptographic.NewID(pub, net.ParseIP("10.0.0.3"), 3000), 2, priv)))
+	assert.Equal(t, "10.0.0.3:3000", table.Bucket(pub)[0].Address)
+
+	assert.False(t, table.UpdateRecord(cryptographic.NewRecord(cryptographic.NewID(pub, net.ParseIP("10.0.0.4"), 3000), 1, priv)))
+	assert.Equal(t, "10.0.0.3:3000", table.Bucket(pub)[0].Address)
+
+	// Acknowledging the peer under an older ID keeps the ID vouched for by its record.
+
+	_, err = table.Update(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.3:3000", table.Bucket(pub)[0].Address)
+
+	rec, exists := table.Record(pub)
+	assert.True(t, exists)
+	assert.EqualValues(t, 2, rec.Seq)
+
+	_, deleted := table.Delete(pub)
+	assert.True(t, deleted)
+
+	_, exists = table.Record(pub)
+	assert.False(t, exists)
+}
+
+func TestTableRefreshMetadata(t *testing.T) {
+	t.Parallel()
+
+	pub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	table := NewTable(cryptographic.NewID(pub, net.ParseIP("127.0.0.1"), 3000))
+
+	for _, bucket := range []int{0, 1, 7, 8, 100, 254, 255} {
+		key, err := table.RandomKey(bucket)
+		assert.NoError(t, err)
+		assert.Equal(t, bucket, table.getBucketIndex(key))
+	}
+
+	created := time.Now()
+
+	assert.Empty(t, table.StaleBuckets(created.Add(-time.Minute)))
+	assert.Equal(t, []int{0}, table.StaleBuckets(created.Add(time.Minute)))
+
+	other, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	_, seen := table.LastSeen(other)
+	assert.False(t, seen)
+
+	inserted, err := table.Update(cryptographic.NewID(other, net.ParseIP("127.0.0.1"), 3001))
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+
+	last, seen := table.LastSeen(other)
+	assert.True(t, seen)
+	assert.False(t, last.Before(created))
+
+	// Only buckets up to and including the one after the deepest non-empty bucket are considered, and the bucket
+	// the new peer was inserted into has been touched.
+
+	idx := table.getBucketIndex(other)
+	stale := table.StaleBuckets(last.Add(time.Nanosecond))
+	assert.Len(t, stale, idx+2)
+
+	cutoff := last
+	assert.NotContains(t, table.StaleBuckets(cutoff), idx)
+
+	key, err := table.RandomKey(idx + 1)
+	assert.NoError(t, err)
+
+	time.Sleep(time.Millisecond)
+	cutoff = time.Now()
+	table.Touch(key)
+
+	assert.NotContains(t, table.StaleBuckets(cutoff), idx+1)
+	assert.Contains(t, table.StaleBuckets(cutoff), idx)
+
+	_, deleted := table.Delete(other)
+	assert.True(t, deleted)
+
+	_, seen = table.LastSeen(other)
+	assert.False(t, seen)
+}
+
+func TestTableReplacementCache(t *testing.T) {
+	t.Parallel()
+
+	pub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	table := NewTable(cryptographic.NewID(pub, net.ParseIP("127.0.0.1"), 3000), WithTableBucketSize(4))
+	k := table.BucketSize()
+
+	// Fill up a single bucket, and overflow its replacement cache, which is as large as the bucket.
+
+	ids := make([]cryptographic.ID, 0, 2*k+1)
+
+	for i := 0; len(ids) < cap(ids); i++ {
+		key, err := table.RandomKey(0)
+		assert.NoError(t, err)
+
+		ids = append(ids, cryptographic.NewID(key, net.ParseIP("127.0.0.1"), uint16(3001+i)))
+	}
+
+	for _, id := range ids[:k] {
+		_, err := table.Update(id)
+		assert.NoError(t, err)
+	}
+
+	_, err = table.Update(ids[k])
+	assert.Error(t, err)
+
+	assert.False(t, table.AddReplacement(ids[0]))
+	assert.False(t, table.AddReplacement(table.Self()))
+
+	for _, id := range ids[k:] {
+		assert.True(t, table.AddReplacement(id))
+	}
+
+	assert.False(t, table.AddReplacement(ids[k+1]))
+
+	replacements := table.Replacements(ids[0].PubKey)
+	assert.Len(t, replacements, table.ReplacementCacheSize())
+	assert.Equal(t, ids[k+1], replacements[0])
+	assert.NotContains(t, replacements, ids[k])
+
+	// Candidates are only promoted once there is room in the bucket.
+
+	_, promoted := table.Promote(ids[0].PubKey)
+	assert.False(t, promoted)
+
+	_, deleted := table.Delete(ids[0].PubKey)
+	assert.True(t, deleted)
+
+	id, promoted := table.Promote(ids[0].PubKey)
+	assert.True(t, promoted)
+	assert.Equal(t, ids[k+1], id)
+	assert.True(t, table.Recorded(id.PubKey))
+	assert.Equal(t, id, table.Bucket(id.PubKey)[k-1])
+	assert.Len(t, table.Replacements(id.PubKey), table.ReplacementCacheSize()-1)
+
+	// Candidates that are inserted directly leave the replacement cache.
+
+	_, deleted = table.Delete(ids[1].PubKey)
+	assert.True(t, deleted)
+
+	inserted, err := table.Update(ids[k+2])
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+	assert.NotContains(t, table.Replacements(id.PubKey), ids[k+2])
+
+	assert.True(t, table.DeleteReplacement(ids[k+3].PubKey))
+	assert.False(t, table.DeleteReplacement(ids[k+3].PubKey))
+	assert.Len(t, table.Replacements(id.PubKey), table.ReplacementCacheSize()-3)
+}
+
+func TestTableDiversityLimits(t *testing.T) {
+	t.Parallel()
+
+	pub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	table := NewTable(cryptographic.NewID(pub, net.ParseIP("127.0.0.1"), 3000))
+	table.SetDiversityLimits(DiversityLimits{
+		MaxPerSubnetPerBucket: 2,
+		MaxPerSubnet:          3,
+		MaxPerGroup:           4,
+		Group: func(ip net.IP) string {
+			if ip.To4() != nil && ip.To4()[0] == 8 {
+				return "AS15169"
+			}
+			return ""
+		},
+		AllowPrivate: true,
+	})
+
+	id := func(bucket int, host string) cryptographic.ID {
+		key, err := table.RandomKey(bucket)
+		assert.NoError(t, err)
+
+		return cryptographic.NewID(key, net.ParseIP(host), 3000)
+	}
+
+	update := func(id cryptographic.ID) error {
+		_, err := table.Update(id)
+		return err
+	}
+
+	// Limits per subnet apply per bucket and for the whole table, over IPv4 /24 and IPv6 /48 subnets.
+
+	assert.NoError(t, update(id(0, "1.2.3.4")))
+	assert.NoError(t, update(id(0, "1.2.3.5")))
+	assert.True(t, errors.Is(update(id(0, "1.2.3.6")), ErrDiversityLimit))
+	assert.NoError(t, update(id(0, "1.2.4.6")))
+
+	assert.NoError(t, update(id(1, "1.2.3.7")))
+	assert.True(t, errors.Is(update(id(2, "1.2.3.8")), ErrDiversityLimit))
+
+	assert.NoError(t, update(id(0, "2001:db8:1::1")))
+	assert.NoError(t, update(id(0, "2001:db8:1:2::1")))
+	assert.True(t, errors.Is(update(id(0, "2001:db8:1:3::1")), ErrDiversityLimit))
+	assert.NoError(t, update(id(0, "2001:db8:2::1")))
+
+	// Limits per group apply across subnets.
+
+	assert.NoError(t, update(id(3, "8.0.0.1")))
+	assert.NoError(t, update(id(3, "8.0.1.1")))
+	assert.NoError(t, update(id(4, "8.0.2.1")))
+	assert.NoError(t, update(id(4, "8.0.3.1")))
+	assert.True(t, errors.Is(update(id(5, "8.0.4.1")), ErrDiversityLimit))
+
+	observe := func(id cryptographic.ID, host string) error {
+		_, err := table.UpdateObserved(id, net.ParseIP(host))
+		return err
+	}
+
+	// Peers observed at loopback and private hosts are exempt, and existing entries may always be moved.
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, observe(id(6, "127.0.0.1"), "127.0.0.1"))
+		assert.NoError(t, observe(id(6, "1.2.3.4"), "192.168.1.1"))
+	}
+
+	// Peers are grouped by the host they were observed at rather than the host they claim, and peers that claim
+	// private hosts yet were not observed at them are not exempt.
+
+	assert.True(t, errors.Is(observe(id(9, "192.168.1.1"), "1.2.3.10"), ErrDiversityLimit))
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, update(id(9+i, fmt.Sprintf("192.168.2.%d", i+1))))
+	}
+
+	assert.True(t, errors.Is(update(id(12, "192.168.2.4")), ErrDiversityLimit))
+
+	first := id(7, "9.9.9.1")
+	assert.NoError(t, update(first))
+	assert.NoError(t, update(id(7, "9.9.9.2")))
+	assert.NoError(t, update(first))
+
+	// Candidates that would exceed the limits are skipped over upon being promoted.
+
+	full := make([]cryptographic.ID, 0, BucketSize)
+	for i := 0; len(full) < cap(full); i++ {
+		full = append(full, id(8, fmt.Sprintf("10.0.0.%d", i+1)))
+		assert.NoError(t, observe(full[i], full[i].Host.String()))
+	}
+
+	allowed := id(8, "5.5.6.1")
+	assert.True(t, table.AddReplacement(allowed))
+	assert.True(t, table.AddReplacement(id(8, "1.2.3.9")))
+
+	_, deleted := table.Delete(full[0].PubKey)
+	assert.True(t, deleted)
+	assert.NotContains(t, table.observed, full[0].PubKey)
+
+	promoted, ok := table.Promote(full[0].PubKey)
+	assert.True(t, ok)
+	assert.Equal(t, allowed.PubKey, promoted.PubKey)
+}
+
+func TestTableSnapshot(t *testing.T) {
+	t.Parallel()
+
+	self, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	table := NewTable(cryptographic.NewID(self, net.ParseIP("10.0.0.1"), 3000))
+
+	ids := make([]cryptographic.ID, 0, 8)
+
+	for i := 0; i < cap(ids); i++ {
+		key, err := table.RandomKey(i % 2)
+		assert.NoError(t, err)
+
+		ids = append(ids, cryptographic.NewID(key, net.ParseIP(fmt.Sprintf("10.0.1.%d", i+1)), 3000))
+
+		_, err = table.Update(ids[i])
+		assert.NoError(t, err)
+	}
+
+	// Peers vouched for by a signed record are written alongside their record. Forged records are skipped upon being
+	// restored.
+
+	pub, priv, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	signed := cryptographic.NewID(pub, net.ParseIP("10.0.2.1"), 3000)
+
+	_, err = table.Update(signed)
+	assert.NoError(t, err)
+	assert.True(t, table.UpdateRecord(cryptographic.NewRecord(signed, 7, priv)))
+
+	_, forger, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	forgedPub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	forged := cryptographic.NewID(forgedPub, net.ParseIP("10.0.2.2"), 3000)
+
+	_, err = table.Update(forged)
+	assert.NoError(t, err)
+
+	table.records[forgedPub] = cryptographic.NewRecord(forged, 1, forger)
+
+	seen := time.Now().Add(-time.Hour).Round(0)
+	table.info[ids[0].PubKey] = entryInfo{addedAt: seen, lastSeen: seen}
+
+	var buf bytes.Buffer
+	assert.NoError(t, table.Snapshot(&buf))
+
+	restored := NewTable(table.self)
+
+	n, err := restored.Restore(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, len(ids)+1, n)
+	assert.Equal(t, len(ids)+2, restored.NumEntries())
+
+	expected := make([]cryptographic.ID, 0, len(ids)+2)
+
+	for _, id := range table.Entries() {
+		if id.PubKey != forgedPub {
+			expected = append(expected, id)
+		}
+	}
+
+	assert.Equal(t, expected, restored.Entries())
+
+	last, exists := restored.LastSeen(ids[0].PubKey)
+	assert.True(t, exists)
+	assert.True(t, seen.Equal(last))
+
+	rec, exists := restored.Record(pub)
+	assert.True(t, exists)
+	assert.EqualValues(t, 7, rec.Seq)
+
+	_, exists = restored.LastSeen(forgedPub)
+	assert.False(t, exists)
+
+	// Restoring the same snapshot again does not insert duplicate entries.
+
+	n, err = restored.Restore(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Snapshots bearing an unknown magic or version, or that are truncated, are rejected.
+
+	corrupt := append([]byte(nil), buf.Bytes()...)
+	corrupt[len(snapshotMagic)] = SnapshotVersion + 1
+
+	_, err = NewTable(table.self).Restore(bytes.NewReader(corrupt))
+	assert.True(t, errors.Is(err, ErrUnsupportedSnapshotVersion))
+
+	_, err = NewTable(table.self).Restore(bytes.NewReader([]byte("not a snapshot")))
+	assert.Error(t, err)
+
+	_, err = NewTable(table.self).Restore(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.Error(t, err)
+}
+
+func TestTableRestoreCorrupt(t *testing.T) {
+	t.Parallel()
+
+	self, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	table := NewTable(cryptographic.NewID(self, net.ParseIP("10.0.0.1"), 3000))
+
+	for i := 0; i < 4; i++ {
+		key, err := table.RandomKey(i)
+		assert.NoError(t, err)
+
+		_, err = table.Update(cryptographic.NewID(key, net.ParseIP(fmt.Sprintf("10.0.1.%d", i+1)), 3000))
+		assert.NoError(t, err)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, table.Snapshot(&buf))
+
+	// Snapshots that claim to hold more entries than their size allows for are rejected before any entry is read.
+
+	_, err = NewTable(table.self).Restore(bytes.NewReader([]byte("ktbl\x01\xff\xff\xff\xff")))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	inflated := append([]byte(nil), buf.Bytes()...)
+	binary.BigEndian.PutUint32(inflated[len(snapshotMagic)+1:], 5)
+
+	_, err = NewTable(table.self).Restore(bytes.NewReader(inflated))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	// Snapshots truncated at any point, or bearing entries of an unknown kind, are rejected without any of their
+	// entries being restored.
+
+	for i := 0; i < buf.Len(); i++ {
+		restored := NewTable(table.self)
+
+		_, err := restored.Restore(bytes.NewReader(buf.Bytes()[:i]))
+		assert.Error(t, err)
+		assert.Equal(t, 1, restored.NumEntries())
+	}
+
+	unknown := append([]byte(nil), buf.Bytes()...)
+	unknown[len(snapshotMagic)+5+8] = 0xff
+
+	restored := NewTable(table.self)
+
+	_, err = restored.Restore(bytes.NewReader(unknown))
+	assert.Error(t, err)
+	assert.Equal(t, 1, restored.NumEntries())
+}
+
+// fullTable returns a routing table whose shallowest n buckets are filled to max capacity.
+func fullTable(t testing.TB, n int, opts ...TableOption) *Table {
+	t.Helper()
+
+	pub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	table := NewTable(cryptographic.NewID(pub, net.ParseIP("127.0.0.1"), 3000), opts...)
+
+	for bucket := 0; bucket < n; bucket++ {
+		for i := 0; i < table.BucketSize(); i++ {
+			key, err := table.RandomKey(bucket)
+			assert.NoError(t, err)
+
+			_, err = table.Update(cryptographic.NewID(key, net.ParseIP("127.0.0.1"), 3000))
+			assert.NoError(t, err)
+		}
+	}
+
+	return table
+}
+
+func TestTableBucketSize(t *testing.T) {
+	t.Parallel()
+
+	table := fullTable(t, 4, WithTableBucketSize(4))
+	assert.Equal(t, 4, table.BucketSize())
+	assert.Equal(t, 4*4+1, table.NumEntries())
+	assert.Len(t, table.Peers(), 4)
+
+	key, err := table.RandomKey(2)
+	assert.NoError(t, err)
+
+	_, err = table.Update(cryptographic.NewID(key, net.ParseIP("127.0.0.1"), 3000))
+	assert.True(t, errors.Is(err, ErrBucketFull))
+
+	assert.Equal(t, BucketSize, NewTable(table.Self(), WithTableBucketSize(0)).BucketSize())
+}
+
+func TestTableFindClosest(t *testing.T) {
+	t.Parallel()
+
+	table := fullTable(t, 12)
+	entries := table.Entries()
+
+	targets := []cryptographic.PublicKey{table.Self().PubKey, entries[3].PubKey, entries[len(entries)/2].PubKey}
+	for _, key := range randomKeys(t, 8) {
+		targets = append(targets, key)
+	}
+
+	for _, target := range targets {
+		expected := make([]cryptographic.ID, 0, len(entries))
+
+		for _, id := range entries {
+			if id.PubKey != target {
+				expected = append(expected, id)
+			}
+		}
+
+		expected = SortByDistance(target, expected)
+
+		for _, k := range []int{1, BucketSize, 3 * BucketSize, len(entries) + 1} {
+			n := k
+			if n > len(expected) {
+				n = len(expected)
+			}
+
+			assert.Equal(t, expected[:n], table.FindClosest(target, k))
+		}
+	}
+
+	assert.Empty(t, table.FindClosest(targets[0], 0))
+}
+
+func TestTableAllocations(t *testing.T) {
+	table := fullTable(t, 8)
+	bucket := table.Bucket(table.Entries()[0].PubKey)
+	target := randomKeys(t, 1)[0]
+
+	// Moving an ID to the head of its bucket reorders the bucket in-place.
+
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		_, _ = table.Update(bucket[len(bucket)-1])
+		_, _ = table.Update(bucket[0])
+	}))
+
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		_ = DistanceBetween(target, table.Self().PubKey).PrefixLen()
+	}))
+
+	// Only the resultant slice of the k closest IDs is allocated.
+
+	assert.EqualValues(t, 1, testing.AllocsPerRun(100, func() {
+		_ = table.FindClosest(target, BucketSize)
+	}))
+}
+
+func BenchmarkTableUpdate(b *testing.B) {
+	table := fullTable(b, 16)
+	entries := table.Entries()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = table.Update(entries[i%len(entries)])
+	}
+}
+
+func BenchmarkTableFindClosest(b *testing.B) {
+	table := fullTable(b, 16)
+	targets := randomKeys(b, 64)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = table.FindClosest(targets[i%len(targets)], BucketSize)
+	}
+}
+
+func TestTableLiveness(t *testing.T) {
+	t.Parallel()
+
+	table := fullTable(t, 2)
+	entries := table.Bucket(table.Entries()[0].PubKey)
+
+	a, b := entries[0], entries[1]
+
+	info, exists := table.Info(a.PubKey)
+	assert.True(t, exists)
+	assert.Equal(t, a, info.ID)
+	assert.False(t, info.AddedAt.IsZero())
+	assert.Equal(t, info.AddedAt, info.LastSeen)
+	assert.Zero(t, info.Failures)
+	assert.Zero(t, info.RTT)
+
+	// Round-trip times are smoothed, and responding resets the number of consecutive failures.
+
+	assert.True(t, table.RecordRTT(a.PubKey, 80*time.Millisecond))
+
+	failures, exists := table.RecordFailure(a.PubKey)
+	assert.True(t, exists)
+	assert.Equal(t, 1, failures)
+
+	failures, _ = table.RecordFailure(a.PubKey)
+	assert.Equal(t, 2, failures)
+
+	assert.True(t, table.RecordRTT(a.PubKey, 160*time.Millisecond))
+
+	info, _ = table.Info(a.PubKey)
+	assert.Equal(t, 90*time.Millisecond, info.RTT)
+	assert.Zero(t, info.Failures)
+	assert.True(t, info.LastSeen.After(info.AddedAt))
+
+	// Acknowledging an entry also resets the number of consecutive failures.
+
+	_, _ = table.RecordFailure(a.PubKey)
+
+	_, err := table.Update(a)
+	assert.NoError(t, err)
+
+	info, _ = table.Info(a.PubKey)
+	assert.Zero(t, info.Failures)
+
+	// Peers that failed their last pings are only returned by FindClosestResponsive after all responsive peers.
+
+	_, _ = table.RecordFailure(a.PubKey)
+
+	closest := table.FindClosest(b.PubKey, table.NumEntries())
+	assert.Contains(t, closest, a)
+
+	expected := make([]cryptographic.ID, 0, len(closest))
+
+	for _, id := range closest {
+		if id.PubKey != a.PubKey {
+			expected = append(expected, id)
+		}
+	}
+
+	assert.Equal(t, append(expected, a), table.FindClosestResponsive(b.PubKey, table.NumEntries()))
+	assert.Equal(t, expected[:4], table.FindClosestResponsive(b.PubKey, 4))
+
+	// Entries that have not been seen in a while are stale.
+
+	assert.Empty(t, table.Stale(time.Hour))
+
+	table.info[b.PubKey] = entryInfo{lastSeen: time.Now().Add(-2 * time.Hour)}
+	assert.Equal(t, []cryptographic.ID{b}, table.Stale(time.Hour))
+
+	_, deleted := table.Delete(b.PubKey)
+	assert.True(t, deleted)
+
+	_, exists = table.Info(b.PubKey)
+	assert.False(t, exists)
+	assert.False(t, table.RecordRTT(b.PubKey, time.Millisecond))
+}
@@ -0,0 +1,229 @@
+package kademlia
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// LookupTrace records how a single lookup executed by an Iterator progressed, such that lookups which return fewer
+// results than expected may be diagnosed. A trace is recorded by passing WithIteratorTrace to NewIterator, or to any
+// of the lookups exposed by Protocol.
+type LookupTrace struct {
+	// Origin is the public key of the node which executed the lookup.
+	Origin cryptographic.PublicKey `json:"origin"`
+
+	// Target is the position in the key space the lookup was executed for.
+	Target cryptographic.PublicKey `json:"target"`
+
+	// Started is the time the lookup was started.
+	Started time.Time `json:"started"`
+
+	// Duration is the amount of time the lookup took to complete.
+	Duration time.Duration `json:"duration"`
+
+	// Peers lists every peer the lookup learned of, in the order they were learned of.
+	Peers []LookupPeer `json:"peers"`
+
+	// Results lists the public keys of the peers the lookup returned, sorted by their distance to the target.
+	Results []cryptographic.PublicKey `json:"results"`
+}
+
+// LookupPeer records what a lookup learned of, and from, a single peer.
+type LookupPeer struct {
+	// PubKey is the public key of the peer.
+	PubKey cryptographic.PublicKey `json:"public_key"`
+
+	// Address is the address the peer was queried at.
+	Address string `json:"address"`
+
+	// Path is the index of the disjoint path of the lookup the peer was learned of by.
+	Path int `json:"path"`
+
+	// Hop is the number of queries it took to learn of the peer, which is zero should the peer have been taken from
+	// the routing table of the origin.
+	Hop int `json:"hop"`
+
+	// LearnedFrom is the public key of the peer that returned the peer, which is the origin should Hop be zero.
+	LearnedFrom cryptographic.PublicKey `json:"learned_from"`
+
+	// Queried reports whether or not the peer was queried. Peers further from the target than the closest k peers of
+	// their path are never queried.
+	Queried bool `json:"queried"`
+
+	// Latency is the amount of time it took for the peer to respond, or to fail to respond.
+	Latency time.Duration `json:"latency,omitempty"`
+
+	// Error describes why the peer failed to respond, or is empty should it have responded.
+	Error string `json:"error,omitempty"`
+
+	// Returned lists the public keys of the valid peers the peer returned, in the order they were returned.
+	Returned []cryptographic.PublicKey `json:"returned,omitempty"`
+
+	// HeldValue reports whether or not the peer responded with the value a FIND_VALUE lookup was looking for.
+	HeldValue bool `json:"held_value,omitempty"`
+}
+
+// Queried returns the number of peers the lookup queried, and how many of them failed to respond.
+func (t *LookupTrace) Queried() (queried, failed int) {
+	for _, peer := range t.Peers {
+		if !peer.Queried {
+			continue
+		}
+
+		queried++
+
+		if peer.Error != "" {
+			failed++
+		}
+	}
+
+	return queried, failed
+}
+
+// WriteJSON writes this trace to w as indented JSON. Public keys are written as hex strings, and durations as
+// nanoseconds.
+func (t *LookupTrace) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(t)
+}
+
+// WriteDOT writes this trace to w as a Graphviz digraph. Each peer is drawn as a node labelled with the prefix of its
+// public key, its address and its latency, with an edge leading to it from the peer it was learned from. Peers that
+// failed to respond are drawn in red, peers that were never queried are dashed, and the peers the lookup returned are
+// drawn in bold. The origin and target are drawn as a double circle and a diamond respectively.
+func (t *LookupTrace) WriteDOT(w io.Writer) error {
+	results := make(map[cryptographic.PublicKey]struct{}, len(t.Results))
+	for _, result := range t.Results {
+		results[result] = struct{}{}
+	}
+
+	buf := bufio.NewWriter(w)
+
+	fmt.Fprintf(buf, "digraph lookup {\n")
+	fmt.Fprintf(buf, "\tlabel=%q;\n", fmt.Sprintf("lookup for %s (%s)", shortKey(t.Target), t.Duration))
+	fmt.Fprintf(buf, "\tnode [shape=box];\n")
+	fmt.Fprintf(buf, "\t%q [label=%q, shape=doublecircle];\n", t.Origin.String(), "origin\n"+shortKey(t.Origin))
+
+	target := false
+
+	for _, peer := range t.Peers {
+		label := shortKey(peer.PubKey) + "\n" + peer.Address
+
+		var attrs string
+
+		switch {
+		case !peer.Queried:
+			attrs = ", style=dashed"
+		case peer.Error != "":
+			label += fmt.Sprintf("\n%s\n%s", peer.Latency, peer.Error)
+			attrs = ", color=red"
+		default:
+			label += fmt.Sprintf("\n%s", peer.Latency)
+		}
+
+		if peer.HeldValue {
+			label += "\nheld value"
+		}
+
+		if _, returned := results[peer.PubKey]; returned {
+			attrs += ", penwidth=2"
+		}
+
+		if peer.PubKey == t.Target {
+			attrs += ", shape=diamond"
+			target = true
+		}
+
+		fmt.Fprintf(buf, "\t%q [label=%q%s];\n", peer.PubKey.String(), label, attrs)
+	}
+
+	if !target {
+		fmt.Fprintf(buf, "\t%q [label=%q, shape=diamond, style=dotted];\n", t.Target.String(),
+			"target\n"+shortKey(t.Target),
+		)
+	}
+
+	for _, peer := range t.Peers {
+		fmt.Fprintf(buf, "\t%q -> %q [label=%q];\n", peer.LearnedFrom.String(), peer.PubKey.String(),
+			fmt.Sprintf("path %d, hop %d", peer.Path, peer.Hop),
+		)
+	}
+
+	fmt.Fprintf(buf, "}\n")
+
+	return buf.Flush()
+}
+
+// shortKey returns the hex representation of the first 4 bytes of key.
+func shortKey(key cryptographic.PublicKey) string {
+	return hex.EncodeToString(key[:4])
+}
+
+// lookupTracer records a LookupTrace as a lookup progresses. It is guarded by the lock of the Iterator executing the
+// lookup.
+type lookupTracer struct {
+	trace LookupTrace
+	peers map[cryptographic.PublicKey]int
+}
+
+func newLookupTracer(origin, target cryptographic.PublicKey) *lookupTracer {
+	return &lookupTracer{
+		trace: LookupTrace{Origin: origin, Target: target, Started: time.Now()},
+		peers: make(map[cryptographic.PublicKey]int),
+	}
+}
+
+// learned records that id was learned of by path after hop queries, from the peer with public key from.
+func (t *lookupTracer) learned(id cryptographic.ID, from cryptographic.PublicKey, path, hop int) {
+	t.peers[id.PubKey] = len(t.trace.Peers)
+	t.trace.Peers = append(t.trace.Peers, LookupPeer{
+		PubKey:      id.PubKey,
+		Address:     id.Address,
+		Path:        path,
+		Hop:         hop,
+		LearnedFrom: from,
+	})
+}
+
+// queried records the response of the peer with public key id to a query that took latency to complete.
+func (t *lookupTracer) queried(
+	id cryptographic.PublicKey, latency time.Duration, results []cryptographic.ID, found bool, err error,
+) {
+	i, exists := t.peers[id]
+	if !exists {
+		return
+	}
+
+	peer := &t.trace.Peers[i]
+	peer.Queried = true
+	peer.Latency = latency
+	peer.HeldValue = found
+
+	if err != nil {
+		peer.Error = err.Error()
+	}
+
+	for _, result := range results {
+		peer.Returned = append(peer.Returned, result.PubKey)
+	}
+}
+
+// finish records that the lookup completed, returning closest.
+func (t *lookupTracer) finish(closest []cryptographic.ID) LookupTrace {
+	t.trace.Duration = time.Since(t.trace.Started)
+	t.trace.Results = make([]cryptographic.PublicKey, 0, len(closest))
+
+	for _, id := range closest {
+		t.trace.Results = append(t.trace.Results, id.PubKey)
+	}
+
+	return t.trace
+}
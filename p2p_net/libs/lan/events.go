@@ -0,0 +1,58 @@
+package lan
+
+import (
+	"net"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// DefaultGroup is the multicast group announcements are sent to and received from should none be configured. It
+// resides within the IPv4 organization-local scope, such that announcements never leave the local network.
+var DefaultGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 42, 99), Port: 44912}
+
+// Events comprise of callbacks that may be hooked against by a user to handle events that occur throughout the
+// lifecycle of this local discovery protocol.
+type Events struct {
+	// OnPeerDiscovered is called whenever a valid announcement is received from a peer that was not yet in the
+	// routing table of your node, and the peer has since been acknowledged via (*kademlia.Protocol).Ack.
+	OnPeerDiscovered func(id cryptographic.ID)
+}
+
+// Option is a functional option that may be configured when instantiating a new instance of this local discovery
+// protocol.
+type Option func(protocol *Protocol)
+
+// WithEvents registers a batch of callbacks onto a single local discovery protocol instance.
+func WithEvents(events Events) Option {
+	return func(protocol *Protocol) {
+		protocol.events = events
+	}
+}
+
+// WithGroup sets the IPv4 multicast group announcements are sent to and received from. By default, it is set to
+// DefaultGroup.
+func WithGroup(group *net.UDPAddr) Option {
+	return func(protocol *Protocol) {
+		protocol.group = group
+	}
+}
+
+// WithInterface sets the network interface announcements are sent from and received on. By default, the interface is
+// chosen by the system.
+func WithInterface(iface *net.Interface) Option {
+	return func(protocol *Protocol) {
+		protocol.iface = iface
+	}
+}
+
+// WithInterval sets the interval at which your node multicasts announcements. By default, it is set to 10 seconds.
+func WithInterval(interval time.Duration) Option {
+	return func(protocol *Protocol) {
+		if interval <= 0 {
+			return
+		}
+
+		protocol.interval = interval
+	}
+}
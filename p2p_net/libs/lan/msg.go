@@ -0,0 +1,63 @@
+package lan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// announcementMagic prefixes all announcements, such that stray datagrams sent to the multicast group are ignored.
+var announcementMagic = []byte("p2pa")
+
+// announcementVersion is the version of the layout of announcements.
+const announcementVersion = 1
+
+// ErrNotAnnouncement is returned by UnmarshalAnnouncement should a datagram not be an announcement.
+var ErrNotAnnouncement = errors.New("datagram is not an announcement")
+
+// Announcement is multicast periodically by a node to announce its presence to other nodes on the same network. It
+// bears the signed peer record of the node, through which the node vouches for its ID and the addresses it may be
+// reached at.
+type Announcement struct {
+	Record cryptographic.Record
+}
+
+// Marshal encodes this announcement as a 4-byte magic, followed by a version byte, followed by the peer record of
+// the node that announced it.
+func (a Announcement) Marshal() []byte {
+	buf := make([]byte, 0, len(announcementMagic)+1+a.Record.Size())
+	buf = append(buf, announcementMagic...)
+	buf = append(buf, announcementVersion)
+	buf = append(buf, a.Record.Marshal()...)
+
+	return buf
+}
+
+// UnmarshalAnnouncement decodes buf into an Announcement. The signature of the peer record it bears is not verified.
+// It throws ErrNotAnnouncement should buf not start with the magic of announcements, or an io.ErrUnexpectedEOF should
+// buf be malformed.
+func UnmarshalAnnouncement(buf []byte) (Announcement, error) {
+	if !bytes.HasPrefix(buf, announcementMagic) {
+		return Announcement{}, ErrNotAnnouncement
+	}
+
+	buf = buf[len(announcementMagic):]
+
+	if len(buf) < 1 {
+		return Announcement{}, io.ErrUnexpectedEOF
+	}
+
+	if buf[0] != announcementVersion {
+		return Announcement{}, fmt.Errorf("unsupported announcement version %d", buf[0])
+	}
+
+	rec, _, err := cryptographic.UnmarshalRecord(buf[1:])
+	if err != nil {
+		return Announcement{}, fmt.Errorf("could not read announcement record: %w", err)
+	}
+
+	return Announcement{Record: rec}, nil
+}
@@ -0,0 +1,293 @@
+// Package lan is an implementation of a local discovery protocol, through which nodes on the same network or machine
+// find one another without having addresses passed to them by hand. Nodes periodically multicast an announcement
+// bearing their signed peer record to an IPv4 multicast group, and listen for the announcements of other nodes. Peers
+// whose announcements are valid are fed into the routing table of a Kademlia overlay.
+//
+// Announcements are not protected against replays. A replayed announcement may only ever have your node acknowledge
+// a peer at an address it has at some point vouched for itself. Peers whose record does not vouch for a host are only
+// acknowledged at the host their announcement was received from once your node has dialed them there, and they have
+// proven to hold the public key of the announced ID through the handshake.
+package lan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/kademlia"
+
+	"go.uber.org/zap"
+)
+
+// maxAnnouncementSize is the max number of bytes of a single announcement that is read.
+const maxAnnouncementSize = 8192
+
+// verifyTimeout is the max amount of time spent dialing a peer that announced an unspecified host at the host its
+// announcement was received from.
+const verifyTimeout = 3 * time.Second
+
+// Protocol implements the local discovery protocol. It is expected that Protocol is bound to a .Node via
+// (*.Node).Bind alongside the Kademlia overlay it feeds peers into, before the node starts listening for incoming
+// peers.
+type Protocol struct {
+	overlay *kademlia.Protocol
+	node    *core_module.Node
+	logger  *zap.Logger
+	events  Events
+
+	group    *net.UDPAddr
+	iface    *net.Interface
+	interval time.Duration
+
+	conn   *net.UDPConn
+	sender *net.UDPConn
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New returns a new instance of the local discovery protocol which feeds peers into overlay.
+func New(overlay *kademlia.Protocol, opts ...Option) *Protocol {
+	p := &Protocol{
+		overlay:  overlay,
+		group:    DefaultGroup,
+		interval: 10 * time.Second,
+
+		done: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Protocol returns a .Protocol that may registered to a node via (*.Node).Bind.
+func (p *Protocol) Protocol() core_module.Protocol {
+	return core_module.Protocol{
+		Bind:  p.Bind,
+		Close: p.Close,
+	}
+}
+
+// Bind joins the multicast group, and starts a goroutine which receives announcements, alongside a goroutine which
+// multicasts an announcement straight away and then at the configured interval, until (*Protocol).Close is called.
+// It throws an error should the multicast group fail to be joined.
+func (p *Protocol) Bind(node *core_module.Node) error {
+	p.node = node
+	p.logger = node.Logger()
+
+	conn, err := net.ListenMulticastUDP("udp4", p.iface, p.group)
+	if err != nil {
+		return fmt.Errorf("failed to join multicast group %s: %w", p.group, err)
+	}
+
+	// Sending from the address of the configured interface has announcements be sent out through it.
+
+	var laddr *net.UDPAddr
+
+	if p.iface != nil {
+		ip, err := interfaceIPv4(p.iface)
+		if err != nil {
+			_ = conn.Close()
+			return err
+		}
+
+		laddr = &net.UDPAddr{IP: ip}
+	}
+
+	sender, err := net.DialUDP("udp4", laddr, p.group)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to dial multicast group %s: %w", p.group, err)
+	}
+
+	p.conn, p.sender = conn, sender
+
+	p.wg.Add(2)
+
+	go p.receive()
+	go p.announce()
+
+	return nil
+}
+
+// Close stops multicasting announcements, and leaves the multicast group. It may be called more than once.
+func (p *Protocol) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+
+		if p.conn != nil {
+			_ = p.conn.Close()
+			_ = p.sender.Close()
+		}
+	})
+
+	p.wg.Wait()
+
+	return nil
+}
+
+// Announce multicasts an announcement bearing the latest signed peer record of your node.
+func (p *Protocol) Announce() error {
+	if _, err := p.sender.Write(Announcement{Record: p.node.Record()}.Marshal()); err != nil {
+		return fmt.Errorf("failed to multicast announcement: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Protocol) announce() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Announce(); err != nil {
+			p.logger.Warn("Failed to announce your node.", zap.Error(err))
+		}
+
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Protocol) receive() {
+	defer p.wg.Done()
+
+	buf := make([]byte, maxAnnouncementSize)
+
+	for {
+		n, src, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.logger.Warn("Got an error receiving announcements.", zap.Error(err))
+			}
+
+			return
+		}
+
+		if err := p.handle(buf[:n], src); err != nil {
+			p.logger.Debug("Got an invalid announcement.", zap.String("src_addr", src.String()), zap.Error(err))
+		}
+	}
+}
+
+// handle verifies an announcement received from src, and acknowledges the peer it announces via
+// (*kademlia.Protocol).Ack should it be valid. Announcements of your own node are ignored, and peers that announce an
+// unspecified host are dialed at the host of src before being acknowledged.
+func (p *Protocol) handle(buf []byte, src *net.UDPAddr) error {
+	a, err := UnmarshalAnnouncement(buf)
+	if err != nil {
+		return err
+	}
+
+	rec := a.Record
+
+	if rec.ID.PubKey == p.node.ID().PubKey {
+		return nil
+	}
+
+	if !rec.Verify() {
+		return errors.New("announcement record has an invalid signature")
+	}
+
+	if !p.node.Puzzle().VerifyID(rec.ID) {
+		return errors.New("announced id does not solve the crypto puzzle of the network")
+	}
+
+	table := p.overlay.Table()
+	known := table.Recorded(rec.ID.PubKey) // Dialing the peer below has the overlay acknowledge it.
+
+	// Peers that do not know which host they may be reached at are taken to reside at the host the announcement was
+	// received from. Their record does not vouch for that host, and thus is not recorded. As anyone may replay their
+	// announcement from any host, they are only acknowledged once they prove to hold their public key at that host.
+
+	id := rec.ID
+	observed := id.Host == nil || id.Host.IsUnspecified()
+
+	if observed {
+		id = cryptographic.NewID(id.PubKey, src.IP, id.Port)
+
+		if err := p.verify(id); err != nil {
+			return err
+		}
+	}
+
+	p.overlay.Ack(id)
+
+	if !table.Recorded(id.PubKey) {
+		return nil
+	}
+
+	if !observed {
+		table.UpdateRecord(rec)
+	}
+
+	if known {
+		return nil
+	}
+
+	p.logger.Debug("Discovered a peer on the local network.",
+		zap.String("peer_id", id.String()),
+		zap.String("peer_addr", id.Address),
+	)
+
+	if p.events.OnPeerDiscovered != nil {
+		p.events.OnPeerDiscovered(id)
+	}
+
+	return nil
+}
+
+// verify dials id at its address, and returns an error should the peer at its address not hold the public key of id.
+func (p *Protocol) verify(id cryptographic.ID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-p.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	client, err := p.node.Ping(ctx, id.Address)
+	if err != nil {
+		return fmt.Errorf("failed to dial announced peer at %s: %w", id.Address, err)
+	}
+
+	if client.ID().PubKey != id.PubKey {
+		return fmt.Errorf("peer at %s does not hold the announced public key %s", id.Address, id.PubKey)
+	}
+
+	return nil
+}
+
+// interfaceIPv4 returns the first IPv4 address of iface.
+func interfaceIPv4(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of interface %s: %w", iface.Name, err)
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("interface %s has no ipv4 address", iface.Name)
+}
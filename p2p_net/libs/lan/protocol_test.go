@@ -0,0 +1,260 @@
+package lan
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/kademlia"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+// loopback returns the loopback interface, and a multicast group on a port that is not used by any other test, such
+// that announcements may be multicast and received over loopback.
+func loopback(t *testing.T) (*net.Interface, *net.UDPAddr) {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	assert.NoError(t, err)
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+
+		if iface.Flags&net.FlagLoopback == 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		if _, err := interfaceIPv4(iface); err != nil {
+			continue
+		}
+
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(t, err)
+
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		assert.NoError(t, conn.Close())
+
+		group := &net.UDPAddr{IP: net.IPv4(239, 255, 42, 99), Port: port}
+
+		if conn, err := net.ListenMulticastUDP("udp4", iface, group); err == nil {
+			assert.NoError(t, conn.Close())
+			return iface, group
+		}
+	}
+
+	t.Skip("multicast is not available over loopback")
+
+	return nil, nil
+}
+
+func TestAnnouncement(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	rec := cryptographic.NewRecord(cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), 3000), 7, priv)
+
+	buf := Announcement{Record: rec}.Marshal()
+
+	a, err := UnmarshalAnnouncement(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, rec.Seq, a.Record.Seq)
+	assert.Equal(t, rec.ID.Address, a.Record.ID.Address)
+	assert.True(t, a.Record.Verify())
+
+	_, err = UnmarshalAnnouncement([]byte("hello world"))
+	assert.True(t, errors.Is(err, ErrNotAnnouncement))
+
+	_, err = UnmarshalAnnouncement(buf[:len(announcementMagic)])
+	assert.Error(t, err)
+
+	_, err = UnmarshalAnnouncement(buf[:len(buf)-1])
+	assert.Error(t, err)
+
+	bad := append([]byte{}, buf...)
+	bad[len(announcementMagic)] = announcementVersion + 1
+
+	_, err = UnmarshalAnnouncement(bad)
+	assert.Error(t, err)
+}
+
+func TestDiscovery(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	iface, group := loopback(t)
+
+	var (
+		lock       sync.Mutex
+		discovered = make(map[cryptographic.PublicKey][]cryptographic.PublicKey)
+	)
+
+	newNode := func() (*core_module.Node, *kademlia.Protocol) {
+		node, err := core_module.NewNode()
+		assert.NoError(t, err)
+
+		events := Events{
+			OnPeerDiscovered: func(id cryptographic.ID) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				self := node.ID().PubKey
+				discovered[self] = append(discovered[self], id.PubKey)
+			},
+		}
+
+		overlay := kademlia.New()
+		node.Bind(overlay.Protocol())
+		node.Bind(New(overlay,
+			WithEvents(events),
+			WithGroup(group),
+			WithInterface(iface),
+			WithInterval(50*time.Millisecond),
+		).Protocol())
+
+		assert.NoError(t, node.Listen())
+
+		return node, overlay
+	}
+
+	alice, aliceOverlay := newNode()
+	defer alice.Close()
+
+	bob, bobOverlay := newNode()
+	defer bob.Close()
+
+	assert.Eventually(t, func() bool {
+		return aliceOverlay.Table().Recorded(bob.ID().PubKey) && bobOverlay.Table().Recorded(alice.ID().PubKey)
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Peers are discovered only once, regardless of how many announcements they multicast. Peers dialed to verify
+	// their announcement may have acknowledged the dialing node through its connection before receiving its
+	// announcement, in which case they do not discover it.
+
+	time.Sleep(200 * time.Millisecond)
+
+	lock.Lock()
+	assert.NotZero(t, len(discovered[alice.ID().PubKey])+len(discovered[bob.ID().PubKey]))
+
+	if len(discovered[alice.ID().PubKey]) > 0 {
+		assert.Equal(t, []cryptographic.PublicKey{bob.ID().PubKey}, discovered[alice.ID().PubKey])
+	}
+
+	if len(discovered[bob.ID().PubKey]) > 0 {
+		assert.Equal(t, []cryptographic.PublicKey{alice.ID().PubKey}, discovered[bob.ID().PubKey])
+	}
+	lock.Unlock()
+
+	// Discovered peers may be reached at the address they were discovered at.
+
+	for _, id := range aliceOverlay.Table().Bucket(bob.ID().PubKey) {
+		if id.PubKey == bob.ID().PubKey {
+			assert.NoError(t, aliceOverlay.Ping(context.TODO(), id.Address))
+		}
+	}
+}
+
+func TestForgedAnnouncements(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	node, err := core_module.NewNode()
+	assert.NoError(t, err)
+	defer node.Close()
+
+	overlay := kademlia.New()
+	node.Bind(overlay.Protocol())
+
+	assert.NoError(t, node.Listen())
+
+	p := New(overlay)
+	p.node, p.logger = node, node.Logger()
+
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 44912}
+
+	pub, priv, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	_, other, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	id := cryptographic.NewID(pub, net.ParseIP("10.0.0.1"), 3000)
+
+	// Records signed by anyone other than their bearer are refused.
+
+	forged := Announcement{Record: cryptographic.NewRecord(id, 1, other)}.Marshal()
+	assert.Error(t, p.handle(forged, src))
+	assert.False(t, overlay.Table().Recorded(pub))
+
+	// Records whose ID was tampered with after being signed are refused.
+
+	tampered := cryptographic.NewRecord(id, 1, priv)
+	tampered.ID = cryptographic.NewID(pub, net.ParseIP("10.0.0.2"), 3000)
+
+	assert.Error(t, p.handle(Announcement{Record: tampered}.Marshal(), src))
+	assert.False(t, overlay.Table().Recorded(pub))
+
+	// Announcements of your own node are ignored.
+
+	assert.NoError(t, p.handle(Announcement{Record: node.Record()}.Marshal(), src))
+	assert.Equal(t, 1, overlay.Table().NumEntries())
+
+	// Valid records are admitted, alongside the record itself.
+
+	assert.NoError(t, p.handle(Announcement{Record: cryptographic.NewRecord(id, 1, priv)}.Marshal(), src))
+	assert.True(t, overlay.Table().Recorded(pub))
+
+	rec, exists := overlay.Table().Record(pub)
+	assert.True(t, exists)
+	assert.EqualValues(t, 1, rec.Seq)
+
+	// Peers that announce an unspecified host are refused should they not be reachable at the host the announcement
+	// was received from.
+
+	pub, priv, err = cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	unreachable := cryptographic.NewRecord(cryptographic.NewID(pub, net.IPv6unspecified, 1), 1, priv)
+	assert.Error(t, p.handle(Announcement{Record: unreachable}.Marshal(), src))
+	assert.False(t, overlay.Table().Recorded(pub))
+
+	peer, err := core_module.NewNode(core_module.WithNodePrivateKey(priv), core_module.WithNodeBindHost(src.IP))
+	assert.NoError(t, err)
+	defer peer.Close()
+
+	assert.NoError(t, peer.Listen())
+
+	port := peer.ID().Port
+
+	// Replayed announcements are refused should the peer reachable at the host they were received from not hold the
+	// announced public key.
+
+	impostor, impostorPriv, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	replayed := cryptographic.NewRecord(cryptographic.NewID(impostor, net.IPv6unspecified, port), 1, impostorPriv)
+	assert.Error(t, p.handle(Announcement{Record: replayed}.Marshal(), src))
+	assert.False(t, overlay.Table().Recorded(impostor))
+
+	// Peers that announce an unspecified host are otherwise taken to reside at the host the announcement was
+	// received from.
+
+	unspecified := cryptographic.NewRecord(cryptographic.NewID(pub, net.IPv6unspecified, port), 1, priv)
+	assert.NoError(t, p.handle(Announcement{Record: unspecified}.Marshal(), src))
+	assert.True(t, overlay.Table().Recorded(pub))
+
+	for _, id := range overlay.Table().Bucket(pub) {
+		if id.PubKey == pub {
+			assert.True(t, id.Host.Equal(src.IP))
+		}
+	}
+
+	_, exists = overlay.Table().Record(pub)
+	assert.False(t, exists)
+}
@@ -0,0 +1,101 @@
+package peerstore
+
+import (
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// Source marks where the address of a peer was learned from.
+type Source uint8
+
+const (
+	// SourceUnknown marks an address whose source is not known.
+	SourceUnknown Source = iota
+
+	// SourceManual marks an address that was added by the user, such as a bootstrap address.
+	SourceManual
+
+	// SourceHandshake marks an address that was advertised by a peer on the ID it sent while handshaking.
+	SourceHandshake
+
+	// SourceKademlia marks an address at which a peer responded to a Kademlia lookup.
+	SourceKademlia
+
+	// SourceIdentify marks an address that a peer advertised it listens on through the identify protocol, and which is
+	// covered by the ID the peer signed while handshaking.
+	SourceIdentify
+)
+
+// advertised returns true should addresses learned of from this source be ones a peer merely listed alongside others,
+// rather than the address the peer handshaked with, the address it responded at, or an address added by the user.
+func (s Source) advertised() bool {
+	return s == SourceUnknown || s == SourceIdentify
+}
+
+// String returns a human-readable name for this source.
+func (s Source) String() string {
+	switch s {
+	case SourceManual:
+		return "manual"
+	case SourceHandshake:
+		return "handshake"
+	case SourceKademlia:
+		return "kademlia"
+	case SourceIdentify:
+		return "identify"
+	default:
+		return "unknown"
+	}
+}
+
+// Address is a network address a peer may be reached at, alongside where, and when last, it was learned of.
+type Address struct {
+	Addr     string    `json:"addr"`
+	Source   Source    `json:"source"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Peer comprises of all that is known about a single peer.
+type Peer struct {
+	// PubKey is the public key of the peer.
+	PubKey cryptographic.PublicKey `json:"-"`
+
+	// Addresses lists all addresses the peer may be reached at, with the most recently seen address first.
+	Addresses []Address `json:"addresses"`
+
+	// LastSeen is the last time your node was connected to the peer.
+	LastSeen time.Time `json:"last_seen"`
+
+	// LastDialFailure is the last time your node failed to dial the peer.
+	LastDialFailure time.Time `json:"last_dial_failure,omitempty"`
+
+	// RTT is a smoothed estimate of the round-trip time of requests sent to the peer.
+	RTT time.Duration `json:"rtt,omitempty"`
+
+	// Protocols lists the names of all protocols the peer advertised it supports.
+	Protocols []string `json:"protocols,omitempty"`
+
+	// Nonce is the solution of the peer to the S/Kademlia dynamic crypto puzzle, as last presented by the peer while
+	// handshaking with your node.
+	Nonce []byte `json:"nonce,omitempty"`
+}
+
+// lastSeen returns the last time your node was connected to this peer, or the last time an address of this peer was
+// learned of, whichever is most recent.
+func (p Peer) lastSeen() time.Time {
+	if len(p.Addresses) > 0 && p.Addresses[0].LastSeen.After(p.LastSeen) {
+		return p.Addresses[0].LastSeen
+	}
+
+	return p.LastSeen
+}
+
+// clone returns a deep copy of this peer.
+func (p Peer) clone() Peer {
+	p.Addresses = append([]Address(nil), p.Addresses...)
+	p.Protocols = append([]string(nil), p.Protocols...)
+	p.Nonce = append([]byte(nil), p.Nonce...)
+
+	return p
+}
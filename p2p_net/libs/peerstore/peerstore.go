@@ -0,0 +1,418 @@
+// Package peerstore keeps track of all that is known about peers across restarts of a node, keyed by their public
+// keys. For each peer, it records many addresses alongside where they were learned from, the last time the peer was
+// seen or failed to be dialed, a smoothed round-trip time of requests sent to the peer, and the protocols the peer
+// supports. A peerstore may be persisted to, and loaded from, a JSON file on disk.
+package peerstore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// MaxAddresses is the max number of addresses recorded for a single peer. Should the limit be exceeded, the least
+// recently seen address of the peer is forgotten, though addresses the peer merely listed alongside others never cause
+// addresses learned of from other sources to be forgotten.
+const MaxAddresses = 8
+
+// MaxPeers is the max number of peers recorded in a peerstore. Should the limit be exceeded, the least recently seen
+// peer is forgotten.
+const MaxPeers = 4096
+
+// Store is a peerstore. It is safe for concurrent use.
+type Store struct {
+	path string
+
+	sync.RWMutex
+
+	peers map[cryptographic.PublicKey]*Peer
+}
+
+// New returns a new, empty peerstore which is persisted to the file at path by (*Store).Save, and loaded from the
+// file at path by (*Store).Load. Should path be empty, the peerstore is only ever kept in memory.
+func New(path string) *Store {
+	return &Store{
+		path:  path,
+		peers: make(map[cryptographic.PublicKey]*Peer),
+	}
+}
+
+// Open returns a new peerstore that is loaded from the file at path. For more details, refer to New and
+// (*Store).Load.
+func Open(path string) (*Store, error) {
+	s := New(path)
+
+	if err := s.Load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Path returns the path to the file this peerstore is persisted to, or an empty string should it only ever be kept in
+// memory.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load loads all peers recorded in the file this peerstore is persisted to, replacing all that is known about any
+// peer recorded in the file. It does nothing should the file not exist, or should the peerstore only ever be kept in
+// memory.
+func (s *Store) Load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	buf, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to read peerstore: %w", err)
+	}
+
+	var records map[string]Peer
+
+	if err := json.Unmarshal(buf, &records); err != nil {
+		return fmt.Errorf("failed to decode peerstore: %w", err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for key, peer := range records {
+		pub, err := hex.DecodeString(key)
+		if err != nil || len(pub) != cryptographic.SizePublicKey {
+			return fmt.Errorf("peerstore has a malformed public key %q", key)
+		}
+
+		peer := peer
+		copy(peer.PubKey[:], pub)
+
+		s.peers[peer.PubKey] = &peer
+	}
+
+	for len(s.peers) > MaxPeers {
+		s.evict()
+	}
+
+	return nil
+}
+
+// Save persists all peers in this peerstore to the file at the path it was instantiated with. The file is replaced
+// atomically and synced to disk before being replaced, such that it is never left partially written. It does nothing
+// should the peerstore only ever be kept in memory.
+func (s *Store) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.RLock()
+
+	records := make(map[string]Peer, len(s.peers))
+	for pub, peer := range s.peers {
+		records[pub.String()] = *peer
+	}
+
+	buf, err := json.MarshalIndent(records, "", "  ")
+
+	s.RUnlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to encode peerstore: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save peerstore: %w", err)
+	}
+
+	if _, err := tmp.Write(buf); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+
+		return fmt.Errorf("failed to save peerstore: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+
+		return fmt.Errorf("failed to save peerstore: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save peerstore: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save peerstore: %w", err)
+	}
+
+	return nil
+}
+
+// AddAddress records that the peer whose public key is pub may be reached at addr, which was learned of from source.
+// It marks addr as having been seen just now. Addresses of a peer are only ever recorded once, with the source of
+// an address being replaced should it be learned of again from a different source. Addresses a peer merely listed
+// alongside others, such as through the identify protocol, never replace the source of an address learned of from
+// any other source. They also only ever cause other such addresses to be forgotten should MaxAddresses be exceeded,
+// and are otherwise not recorded.
+func (s *Store) AddAddress(pub cryptographic.PublicKey, addr string, source Source) {
+	if pub == cryptographic.ZeroPublicKey || addr == "" {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	peer := s.peer(pub)
+
+	entry := Address{Addr: addr, Source: source, LastSeen: time.Now()}
+
+	for i, existing := range peer.Addresses {
+		if existing.Addr == addr {
+			if source.advertised() && !existing.Source.advertised() {
+				entry.Source = existing.Source
+			}
+
+			peer.Addresses = append(peer.Addresses[:i], peer.Addresses[i+1:]...)
+
+			break
+		}
+	}
+
+	peer.Addresses = append([]Address{entry}, peer.Addresses...)
+
+	if len(peer.Addresses) <= MaxAddresses {
+		return
+	}
+
+	// Forget the least recently seen address, or the least recently seen address a peer merely listed alongside others
+	// should the address just recorded be one.
+
+	i := len(peer.Addresses) - 1
+
+	if entry.Source.advertised() {
+		for !peer.Addresses[i].Source.advertised() {
+			i--
+		}
+	}
+
+	peer.Addresses = append(peer.Addresses[:i], peer.Addresses[i+1:]...)
+}
+
+// MarkSeen records that your node was connected to the peer whose public key is pub just now.
+func (s *Store) MarkSeen(pub cryptographic.PublicKey) {
+	if pub == cryptographic.ZeroPublicKey {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.peer(pub).LastSeen = time.Now()
+}
+
+// MarkDialFailure records that your node failed to dial addr just now against all peers that may be reached at addr.
+func (s *Store) MarkDialFailure(addr string) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+
+	for _, peer := range s.peers {
+		for _, existing := range peer.Addresses {
+			if existing.Addr == addr {
+				peer.LastDialFailure = now
+				break
+			}
+		}
+	}
+}
+
+// UpdateRTT records a round-trip time measured for a request sent to the peer whose public key is pub. The
+// round-trip time recorded for the peer is smoothed as an exponentially-weighted moving average, with each new
+// measurement weighted by 1/8.
+func (s *Store) UpdateRTT(pub cryptographic.PublicKey, rtt time.Duration) {
+	if pub == cryptographic.ZeroPublicKey {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	peer := s.peer(pub)
+
+	if peer.RTT == 0 {
+		peer.RTT = rtt
+	} else {
+		peer.RTT = (7*peer.RTT + rtt) / 8
+	}
+}
+
+// SetProtocols records the names of all protocols the peer whose public key is pub supports.
+func (s *Store) SetProtocols(pub cryptographic.PublicKey, protocols []string) {
+	if pub == cryptographic.ZeroPublicKey {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.peer(pub).Protocols = append([]string(nil), protocols...)
+}
+
+// SetNonce records the solution of the peer whose public key is pub to the S/Kademlia dynamic crypto puzzle. It
+// should only be called with a nonce that the peer itself has presented.
+func (s *Store) SetNonce(pub cryptographic.PublicKey, nonce []byte) {
+	if pub == cryptographic.ZeroPublicKey {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.peer(pub).Nonce = append([]byte(nil), nonce...)
+}
+
+// Remove forgets all that is known about the peer whose public key is pub.
+func (s *Store) Remove(pub cryptographic.PublicKey) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.peers, pub)
+}
+
+// Peer returns a copy of all that is known about the peer whose public key is pub, and true should the peer be
+// known, or a zero-value Peer and false otherwise.
+func (s *Store) Peer(pub cryptographic.PublicKey) (Peer, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	peer, exists := s.peers[pub]
+	if !exists {
+		return Peer{}, false
+	}
+
+	return peer.clone(), true
+}
+
+// Peers returns a copy of all that is known about all peers, with the most recently seen peers first.
+func (s *Store) Peers() []Peer {
+	s.RLock()
+
+	peers := make([]Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer.clone())
+	}
+
+	s.RUnlock()
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].LastSeen.After(peers[j].LastSeen)
+	})
+
+	return peers
+}
+
+// ID returns an ID for the peer whose public key is pub comprised of its most recently seen address and its puzzle
+// nonce, and true, or a zero-value ID and false should no address of the peer be known.
+func (s *Store) ID(pub cryptographic.PublicKey) (cryptographic.ID, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	peer, exists := s.peers[pub]
+	if !exists || len(peer.Addresses) == 0 {
+		return cryptographic.ID{}, false
+	}
+
+	id := NewID(pub, peer.Addresses[0].Addr)
+
+	if len(peer.Nonce) > 0 {
+		id.Nonce = append([]byte(nil), peer.Nonce...)
+	}
+
+	return id, true
+}
+
+// Len returns the number of peers in this peerstore.
+func (s *Store) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return len(s.peers)
+}
+
+// peer returns the record of the peer whose public key is pub, creating one should the peer not yet be known. Should
+// creating a record exceed MaxPeers, the least recently seen peer is forgotten. It must be called with the peerstore
+// locked.
+func (s *Store) peer(pub cryptographic.PublicKey) *Peer {
+	peer, exists := s.peers[pub]
+	if !exists {
+		if len(s.peers) >= MaxPeers {
+			s.evict()
+		}
+
+		peer = &Peer{PubKey: pub}
+		s.peers[pub] = peer
+	}
+
+	return peer
+}
+
+// evict forgets the least recently seen peer. A peer is seen whenever your node is connected to it, or whenever an
+// address of it is learned of. It must be called with the peerstore locked.
+func (s *Store) evict() {
+	var (
+		oldest   cryptographic.PublicKey
+		lastSeen time.Time
+		found    bool
+	)
+
+	for pub, peer := range s.peers {
+		seen := peer.lastSeen()
+
+		if !found || seen.Before(lastSeen) {
+			oldest, lastSeen, found = pub, seen, true
+		}
+	}
+
+	if found {
+		delete(s.peers, oldest)
+	}
+}
+
+// NewID returns an ID for the peer whose public key is pub that may be reached at addr. Should addr be comprised
+// of an IP and a port, the host and port of the ID are populated.
+func NewID(pub cryptographic.PublicKey, addr string) cryptographic.ID {
+	id := cryptographic.ID{PubKey: pub, Address: addr}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return id
+	}
+
+	num, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return id
+	}
+
+	id.Port = uint16(num)
+	id.Host = net.ParseIP(host)
+
+	return id
+}
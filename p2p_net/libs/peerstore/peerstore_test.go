@@ -0,0 +1,128 @@
+package peerstore
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	pub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	s := New("")
+
+	_, exists := s.Peer(pub)
+	assert.False(t, exists)
+
+	s.AddAddress(pub, "10.0.0.1:3000", SourceHandshake)
+	s.AddAddress(pub, "10.0.0.2:3000", SourceIdentify)
+	s.AddAddress(pub, "10.0.0.1:3000", SourceKademlia)
+
+	peer, exists := s.Peer(pub)
+	assert.True(t, exists)
+	assert.Len(t, peer.Addresses, 2)
+	assert.Equal(t, "10.0.0.1:3000", peer.Addresses[0].Addr)
+	assert.Equal(t, SourceKademlia, peer.Addresses[0].Source)
+
+	for i := 0; i < MaxAddresses; i++ {
+		s.AddAddress(pub, "10.0.1.1:"+strconv.Itoa(4000+i), SourceManual)
+	}
+
+	peer, _ = s.Peer(pub)
+	assert.Len(t, peer.Addresses, MaxAddresses)
+
+	id, exists := s.ID(pub)
+	assert.True(t, exists)
+	assert.Equal(t, net.ParseIP("10.0.1.1"), id.Host)
+	assert.EqualValues(t, 4000+MaxAddresses-1, id.Port)
+
+	// Addresses learned of through the identify protocol neither replace the source of an address, nor push out
+	// addresses learned of from other sources.
+
+	s.AddAddress(pub, "10.0.1.1:4000", SourceIdentify)
+	s.AddAddress(pub, "10.0.2.1:3000", SourceIdentify)
+
+	peer, _ = s.Peer(pub)
+	assert.Len(t, peer.Addresses, MaxAddresses)
+	assert.Equal(t, "10.0.1.1:4000", peer.Addresses[0].Addr)
+
+	for _, addr := range peer.Addresses {
+		assert.Equal(t, SourceManual, addr.Source)
+	}
+
+	s.UpdateRTT(pub, 80*time.Millisecond)
+	s.UpdateRTT(pub, 160*time.Millisecond)
+
+	peer, _ = s.Peer(pub)
+	assert.Equal(t, 90*time.Millisecond, peer.RTT)
+
+	assert.True(t, peer.LastDialFailure.IsZero())
+	s.MarkDialFailure("10.0.1.1:4000")
+
+	peer, _ = s.Peer(pub)
+	assert.False(t, peer.LastDialFailure.IsZero())
+
+	s.Remove(pub)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	pub, _, err := cryptographic.GenerateKeys(nil)
+	assert.NoError(t, err)
+
+	s, err := Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, s.Len())
+
+	s.AddAddress(pub, "10.0.0.1:3000", SourceHandshake)
+	s.MarkSeen(pub)
+	s.UpdateRTT(pub, 50*time.Millisecond)
+	s.SetProtocols(pub, []string{"kademlia", "gossip"})
+
+	assert.NoError(t, s.Save())
+
+	loaded, err := Open(path)
+	assert.NoError(t, err)
+
+	expected, _ := s.Peer(pub)
+	actual, exists := loaded.Peer(pub)
+	assert.True(t, exists)
+
+	assert.Equal(t, expected.PubKey, actual.PubKey)
+	assert.Equal(t, expected.RTT, actual.RTT)
+	assert.Equal(t, expected.Protocols, actual.Protocols)
+	assert.True(t, expected.LastSeen.Equal(actual.LastSeen))
+	assert.Equal(t, expected.Addresses[0].Addr, actual.Addresses[0].Addr)
+	assert.Equal(t, expected.Addresses[0].Source, actual.Addresses[0].Source)
+}
+
+func TestStoreMaxPeers(t *testing.T) {
+	s := New("")
+
+	var oldest cryptographic.PublicKey
+	oldest[0] = 0xff
+
+	s.MarkSeen(oldest)
+	s.peers[oldest].LastSeen = time.Now().Add(-time.Hour)
+
+	for i := 1; i <= MaxPeers; i++ {
+		var pub cryptographic.PublicKey
+		pub[0], pub[1] = byte(i>>8), byte(i)
+
+		s.AddAddress(pub, "10.0.0.1:"+strconv.Itoa(3000+i), SourceKademlia)
+	}
+
+	assert.Equal(t, MaxPeers, s.Len())
+
+	_, exists := s.Peer(oldest)
+	assert.False(t, exists)
+}
@@ -0,0 +1,375 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/atomic"
+)
+
+// maxPayloadSize is the max number of bytes written to a circuit that are carried by a single Data message.
+const maxPayloadSize = 16 << 10
+
+// maxPendingFrames is the max number of Data messages ahead of the next expected one that are buffered by a circuit
+// awaiting those that were sent before them. A circuit that receives a Data message outside of this window is closed.
+const maxPendingFrames = 256
+
+// maxBufferedBytes is the number of bytes sent from the other end of a circuit that may be buffered awaiting Read
+// before Data messages stop being accepted, such that a slow reader slows down the other end of the circuit. A circuit
+// whose buffered bytes are not read within maxReadStall is closed.
+const maxBufferedBytes = 1 << 20
+
+// maxReadStall is how long a Data message waits for bytes buffered by a circuit to be read before the circuit is
+// closed.
+const maxReadStall = 10 * time.Second
+
+// addrSeparator separates the address of a relay from the public key of the peer reachable through it in the string
+// representation of an Addr.
+const addrSeparator = "/relay/"
+
+// Addr is the address of a peer that is reachable through a circuit opened on a relay. It is represented as a string
+// of the form '<relay address>/relay/<hex-encoded public key of peer>', which may be dialed by nodes configured with
+// (*Protocol).Dial as their .Dialer.
+type Addr struct {
+	Relay string
+	Peer  cryptographic.PublicKey
+}
+
+// NewAddr returns the address of the peer whose public key is peer, reachable through the relay at relay.
+func NewAddr(relay string, peer cryptographic.PublicKey) Addr {
+	return Addr{Relay: relay, Peer: peer}
+}
+
+// ParseAddr parses addr, which must be of the form '<relay address>/relay/<hex-encoded public key of peer>', into
+// an Addr.
+func ParseAddr(addr string) (Addr, error) {
+	i := strings.LastIndex(addr, addrSeparator)
+	if i <= 0 {
+		return Addr{}, fmt.Errorf("%q is not a relay address", addr)
+	}
+
+	buf, err := hex.DecodeString(addr[i+len(addrSeparator):])
+	if err != nil {
+		return Addr{}, fmt.Errorf("could not decode peer public key of %q: %w", addr, err)
+	}
+
+	if len(buf) != cryptographic.SizePublicKey {
+		return Addr{}, fmt.Errorf("peer public key of %q is %d bytes, but expected %d bytes",
+			addr, len(buf), cryptographic.SizePublicKey,
+		)
+	}
+
+	a := Addr{Relay: addr[:i]}
+	copy(a.Peer[:], buf)
+
+	return a, nil
+}
+
+// Network implements net.Addr and returns "relay".
+func (a Addr) Network() string {
+	return "relay"
+}
+
+// String implements net.Addr and returns the string representation of this address.
+func (a Addr) String() string {
+	return a.Relay + addrSeparator + a.Peer.String()
+}
+
+// ID returns a copy of id whose address is this address. Should id be the ID of the peer reachable through this
+// address, the ID returned may be advertised or stored in place of id, such as in a routing table, so that the
+// peer may be dialed through the relay.
+func (a Addr) ID(id cryptographic.ID) cryptographic.ID {
+	id.Address = a.String()
+	return id
+}
+
+// circuitKey identifies a circuit by the public key of a peer, and the ID the circuit is identified by between your
+// node and the peer.
+type circuitKey struct {
+	peer cryptographic.PublicKey
+	id   uint64
+}
+
+// Conn is one end of a circuit opened on a relay. Bytes written to a Conn are split into Data messages that are sent
+// to the relay, which forwards them to the other end of the circuit. Conn implements net.Conn.
+type Conn struct {
+	protocol *Protocol
+	relay    *core_module.Client
+	key      circuitKey
+	incoming bool
+
+	local, remote Addr
+
+	seq atomic.Uint64
+
+	sync.Mutex
+
+	buf     bytes.Buffer
+	pending map[uint64][]byte
+	next    uint64
+
+	closed   bool
+	deadline time.Time
+	notify   chan struct{}
+
+	drained chan struct{}
+	waiting int
+}
+
+func newConn(protocol *Protocol, relay *core_module.Client, circuit uint64, local, remote Addr) *Conn {
+	return &Conn{
+		protocol: protocol,
+		relay:    relay,
+		key:      circuitKey{peer: relay.ID().PubKey, id: circuit},
+
+		local:  local,
+		remote: remote,
+
+		pending: make(map[uint64][]byte),
+		notify:  make(chan struct{}, 1),
+		drained: make(chan struct{}),
+	}
+}
+
+// Read implements net.Conn and reads bytes sent from the other end of the circuit in the order they were written.
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.Lock()
+
+		if c.buf.Len() > 0 {
+			n, err := c.buf.Read(b)
+
+			if c.waiting > 0 && !c.closed && c.buf.Len() < maxBufferedBytes {
+				close(c.drained)
+				c.drained = make(chan struct{})
+			}
+
+			c.Unlock()
+
+			return n, err
+		}
+
+		if c.closed {
+			c.Unlock()
+			return 0, io.EOF
+		}
+
+		deadline := c.deadline
+
+		c.Unlock()
+
+		if deadline.IsZero() {
+			<-c.notify
+			continue
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-c.notify:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// Write implements net.Conn and sends b to the other end of the circuit through the relay.
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+
+	for len(b) > 0 {
+		c.Lock()
+		closed := c.closed
+		c.Unlock()
+
+		if closed {
+			return written, net.ErrClosed
+		}
+
+		size := len(b)
+		if size > maxPayloadSize {
+			size = maxPayloadSize
+		}
+
+		msg := Data{Circuit: c.key.id, Seq: c.seq.Inc() - 1, Payload: b[:size]}
+
+		if err := c.relay.SendMessage(msg); err != nil {
+			return written, err
+		}
+
+		written += size
+		b = b[size:]
+	}
+
+	return written, nil
+}
+
+// Close implements net.Conn, and closes the circuit should it not have already been closed.
+func (c *Conn) Close() error {
+	if !c.protocol.closeConn(c) {
+		return nil
+	}
+
+	if err := c.relay.SendMessage(Close{Circuit: c.key.id}); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+// LocalAddr implements net.Conn and returns the relay address of your node.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr implements net.Conn and returns the relay address of the other end of the circuit.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline implements net.Conn. Only the read deadline is respected.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.Lock()
+	c.deadline = t
+	c.Unlock()
+
+	c.wake()
+
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn. Writes are never blocked on the relay, and so write deadlines are ignored.
+func (c *Conn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// deliver buffers the payload of a Data message sent from the other end of the circuit, and makes all payloads
+// that are now in order available to Read. Should the Data message be more than maxPendingFrames messages ahead of
+// the next expected one, the circuit is closed so that no more than maxPendingFrames payloads are ever buffered.
+// Should the Data message be the next expected one while maxBufferedBytes are awaiting Read, deliver blocks until
+// they are read, and closes the circuit should they not be read within maxReadStall.
+func (c *Conn) deliver(msg Data) {
+	c.Lock()
+
+	if c.closed || msg.Seq < c.next {
+		c.Unlock()
+		return
+	}
+
+	if msg.Seq-c.next >= maxPendingFrames {
+		c.Unlock()
+		_ = c.Close()
+
+		return
+	}
+
+	if msg.Seq == c.next && !c.waitDrained() {
+		c.Unlock()
+		_ = c.Close()
+
+		return
+	}
+
+	if c.closed || msg.Seq < c.next {
+		c.Unlock()
+		return
+	}
+
+	c.pending[msg.Seq] = append([]byte(nil), msg.Payload...)
+
+	for {
+		payload, exists := c.pending[c.next]
+		if !exists {
+			break
+		}
+
+		delete(c.pending, c.next)
+		c.buf.Write(payload)
+		c.next++
+	}
+
+	c.Unlock()
+
+	c.wake()
+}
+
+// waitDrained blocks until less than maxBufferedBytes are awaiting Read, or until the circuit is closed. It returns
+// false should the bytes not have been read within maxReadStall. It must be called with the lock of the circuit held,
+// which is released while waiting.
+func (c *Conn) waitDrained() bool {
+	deadline := time.Now().Add(maxReadStall)
+
+	for !c.closed && c.buf.Len() >= maxBufferedBytes {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return false
+		}
+
+		drained := c.drained
+		c.waiting++
+
+		c.Unlock()
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-drained:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+
+		c.Lock()
+		c.waiting--
+	}
+
+	return true
+}
+
+// shutdown marks the circuit as closed, and returns true should it not have already been marked as closed.
+func (c *Conn) shutdown() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.closed = true
+	c.pending = nil
+
+	close(c.drained)
+
+	c.wake()
+
+	return true
+}
+
+func (c *Conn) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// Events comprise of callbacks that may be hooked against by a user to handle events that occur throughout the
+// lifecycle of this relay protocol.
+type Events struct {
+	// OnCircuitOpened is called on both ends of a circuit whenever a circuit has been opened through a relay. addr is
+	// the address through which the other end of the circuit is reachable through the relay.
+	OnCircuitOpened func(addr Addr)
+
+	// OnCircuitClosed is called on both ends of a circuit whenever a circuit opened through a relay has been closed.
+	OnCircuitClosed func(addr Addr)
+
+	// OnReservationExpired is called on a relay whenever the reservation of a peer has expired without being renewed.
+	OnReservationExpired func(peer cryptographic.PublicKey)
+}
+
+// Option is a functional option that may be configured when instantiating a new instance of this relay protocol.
+type Option func(protocol *Protocol)
+
+// WithEvents registers a batch of callbacks onto a single relay protocol instance.
+func WithEvents(events Events) Option {
+	return func(protocol *Protocol) {
+		protocol.events = events
+	}
+}
+
+// WithRelaying sets whether or not your node forwards circuits between peers, which should only be enabled should
+// your node be publicly reachable. By default, your node only opens and accepts circuits through other relays.
+func WithRelaying(relaying bool) Option {
+	return func(protocol *Protocol) {
+		protocol.relaying = relaying
+	}
+}
+
+// WithMaxReservations sets the max number of peers your node holds reservations for at any given moment in time
+// while relaying. By default, it is set to 128.
+func WithMaxReservations(maxReservations int) Option {
+	return func(protocol *Protocol) {
+		if maxReservations < 1 {
+			maxReservations = 1
+		}
+
+		protocol.maxReservations = maxReservations
+	}
+}
+
+// WithReservationTTL sets the duration a reservation is held for by your node while relaying before it must be
+// renewed. By default, it is set to 1 hour.
+func WithReservationTTL(ttl time.Duration) Option {
+	return func(protocol *Protocol) {
+		if ttl <= 0 {
+			ttl = time.Hour
+		}
+
+		protocol.reservationTTL = ttl
+	}
+}
+
+// WithMaxCircuitDuration sets the max duration a circuit is kept open by your node while relaying before it is
+// closed. By default, it is set to 10 minutes.
+func WithMaxCircuitDuration(duration time.Duration) Option {
+	return func(protocol *Protocol) {
+		if duration <= 0 {
+			duration = 10 * time.Minute
+		}
+
+		protocol.maxCircuitDuration = duration
+	}
+}
+
+// WithMaxCircuitBytes sets the max number of bytes forwarded by your node through a circuit, in both directions,
+// while relaying before the circuit is closed. By default, it is set to 64MB.
+func WithMaxCircuitBytes(maxBytes uint64) Option {
+	return func(protocol *Protocol) {
+		if maxBytes == 0 {
+			maxBytes = 64 << 20
+		}
+
+		protocol.maxCircuitBytes = maxBytes
+	}
+}
+
+// WithMaxIncomingCircuits sets the max number of circuits your node accepts through a single relay at any given moment
+// in time. By default, it is set to 16.
+func WithMaxIncomingCircuits(maxCircuits int) Option {
+	return func(protocol *Protocol) {
+		if maxCircuits < 1 {
+			maxCircuits = 1
+		}
+
+		protocol.maxIncomingCircuits = maxCircuits
+	}
+}
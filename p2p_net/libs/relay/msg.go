@@ -0,0 +1,182 @@
+package relay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+)
+
+// ReserveRequest is sent by a peer that cannot accept inbound connections to a relay, requesting that the relay
+// forward circuits opened by other peers to it over the connection the request was sent through.
+type ReserveRequest struct{}
+
+// Marshal implements .Serializable and returns a nil byte slice.
+func (r ReserveRequest) Marshal() []byte { return nil }
+
+// UnmarshalReserveRequest returns a ReserveRequest instance and never throws an error.
+func UnmarshalReserveRequest([]byte) (ReserveRequest, error) { return ReserveRequest{}, nil }
+
+// ReserveResponse is sent by a relay to acknowledge a ReserveRequest. It contains the duration the reservation is
+// held for, after which the reservation must be renewed by sending another ReserveRequest.
+type ReserveResponse struct {
+	TTL time.Duration
+}
+
+// Marshal implements .Serializable and encodes the TTL of this response as a 64-bit big-endian count of nanoseconds.
+func (r ReserveResponse) Marshal() []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(r.TTL))
+
+	return buf[:]
+}
+
+// UnmarshalReserveResponse decodes buf into a ReserveResponse. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalReserveResponse(buf []byte) (ReserveResponse, error) {
+	if len(buf) != 8 {
+		return ReserveResponse{}, fmt.Errorf("expected buf to be 8 bytes, but got %d bytes: %w",
+			len(buf), io.ErrUnexpectedEOF,
+		)
+	}
+
+	return ReserveResponse{TTL: time.Duration(binary.BigEndian.Uint64(buf))}, nil
+}
+
+// ConnectRequest is sent by a peer to a relay, requesting that a circuit be opened to a target peer that holds a
+// reservation on the relay. The circuit is identified between the peer and the relay by the ID Circuit, which is
+// chosen by the peer.
+type ConnectRequest struct {
+	Circuit uint64
+	Target  cryptographic.PublicKey
+}
+
+// Marshal implements .Serializable and encodes the circuit ID of this request as a 64-bit big-endian integer,
+// followed by the public key of the target of this request.
+func (r ConnectRequest) Marshal() []byte {
+	buf := make([]byte, 8, 8+cryptographic.SizePublicKey)
+	binary.BigEndian.PutUint64(buf, r.Circuit)
+
+	return append(buf, r.Target[:]...)
+}
+
+// UnmarshalConnectRequest decodes buf into a ConnectRequest. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalConnectRequest(buf []byte) (ConnectRequest, error) {
+	if len(buf) != 8+cryptographic.SizePublicKey {
+		return ConnectRequest{}, fmt.Errorf("expected buf to be %d bytes, but got %d bytes: %w",
+			8+cryptographic.SizePublicKey, len(buf), io.ErrUnexpectedEOF,
+		)
+	}
+
+	req := ConnectRequest{Circuit: binary.BigEndian.Uint64(buf[:8])}
+	copy(req.Target[:], buf[8:])
+
+	return req, nil
+}
+
+// ConnectResponse is sent by a relay once a circuit requested by a ConnectRequest has been opened.
+type ConnectResponse struct{}
+
+// Marshal implements .Serializable and returns a nil byte slice.
+func (r ConnectResponse) Marshal() []byte { return nil }
+
+// UnmarshalConnectResponse returns a ConnectResponse instance and never throws an error.
+func UnmarshalConnectResponse([]byte) (ConnectResponse, error) { return ConnectResponse{}, nil }
+
+// IncomingRequest is sent by a relay to a peer holding a reservation, notifying it that a circuit has been opened to
+// it by the peer Source. The circuit is identified between the relay and the peer by the ID Circuit, which is chosen
+// by the relay.
+type IncomingRequest struct {
+	Circuit uint64
+	Source  cryptographic.ID
+}
+
+// Marshal implements .Serializable and encodes the circuit ID of this request as a 64-bit big-endian integer,
+// followed by the ID of the peer that opened the circuit.
+func (r IncomingRequest) Marshal() []byte {
+	buf := make([]byte, 8, 8+r.Source.Size())
+	binary.BigEndian.PutUint64(buf, r.Circuit)
+
+	return append(buf, r.Source.Marshal()...)
+}
+
+// UnmarshalIncomingRequest decodes buf into an IncomingRequest. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalIncomingRequest(buf []byte) (IncomingRequest, error) {
+	if len(buf) < 8 {
+		return IncomingRequest{}, fmt.Errorf("expected buf to be at least 8 bytes, but got %d bytes: %w",
+			len(buf), io.ErrUnexpectedEOF,
+		)
+	}
+
+	source, _, err := cryptographic.UnmarshalID(buf[8:])
+	if err != nil {
+		return IncomingRequest{}, fmt.Errorf("could not read source id: %w", err)
+	}
+
+	return IncomingRequest{Circuit: binary.BigEndian.Uint64(buf), Source: source}, nil
+}
+
+// IncomingResponse is sent by a peer to a relay to accept a circuit it was notified of by an IncomingRequest.
+type IncomingResponse struct{}
+
+// Marshal implements .Serializable and returns a nil byte slice.
+func (r IncomingResponse) Marshal() []byte { return nil }
+
+// UnmarshalIncomingResponse returns an IncomingResponse instance and never throws an error.
+func UnmarshalIncomingResponse([]byte) (IncomingResponse, error) { return IncomingResponse{}, nil }
+
+// Data carries a chunk of the bytes written to one end of a circuit. Chunks are numbered per direction of a circuit by
+// Seq, as a relay may handle and forward chunks in any order. A relay rewrites Circuit to the ID the circuit is
+// identified by between the relay and the other end of the circuit before forwarding a chunk.
+type Data struct {
+	Circuit uint64
+	Seq     uint64
+	Payload []byte
+}
+
+// Marshal implements .Serializable and encodes the circuit ID and sequence number of this chunk as 64-bit big-endian
+// integers, followed by its payload.
+func (r Data) Marshal() []byte {
+	buf := make([]byte, 16, 16+len(r.Payload))
+	binary.BigEndian.PutUint64(buf[:8], r.Circuit)
+	binary.BigEndian.PutUint64(buf[8:16], r.Seq)
+
+	return append(buf, r.Payload...)
+}
+
+// UnmarshalData decodes buf into Data. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalData(buf []byte) (Data, error) {
+	if len(buf) < 16 {
+		return Data{}, fmt.Errorf("expected buf to be at least 16 bytes, but got %d bytes: %w", len(buf), io.ErrUnexpectedEOF)
+	}
+
+	return Data{
+		Circuit: binary.BigEndian.Uint64(buf[:8]),
+		Seq:     binary.BigEndian.Uint64(buf[8:16]),
+		Payload: buf[16:],
+	}, nil
+}
+
+// Close is sent by either end of a circuit to a relay, or by a relay to either end of a circuit, to mark that the
+// circuit has been closed.
+type Close struct {
+	Circuit uint64
+}
+
+// Marshal implements .Serializable and encodes the circuit ID of this message as a 64-bit big-endian integer.
+func (r Close) Marshal() []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], r.Circuit)
+
+	return buf[:]
+}
+
+// UnmarshalClose decodes buf into a Close. It throws an io.ErrUnexpectedEOF if buf is malformed.
+func UnmarshalClose(buf []byte) (Close, error) {
+	if len(buf) != 8 {
+		return Close{}, fmt.Errorf("expected buf to be 8 bytes, but got %d bytes: %w", len(buf), io.ErrUnexpectedEOF)
+	}
+
+	return Close{Circuit: binary.BigEndian.Uint64(buf)}, nil
+}
@@ -0,0 +1,532 @@
+// Package relay is an implementation of a circuit relay protocol, through which peers that cannot accept inbound
+// connections, such as those behind a NAT, remain reachable. A peer reserves a slot on a publicly reachable relay,
+// and other peers open circuits to it through the relay. Each end of a circuit is a net.Conn over which nodes perform
+// their usual handshake, such that the session between both ends is end-to-end encrypted, and the relay only ever
+// forwards opaque bytes.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/zap"
+)
+
+// incomingTimeout is the max duration a relay waits for a peer holding a reservation to accept a circuit.
+const incomingTimeout = 10 * time.Second
+
+// Protocol implements the circuit relay protocol. It is expected that Protocol is bound to a .Node via (*.Node).Bind
+// before the node starts listening for incoming peers, and that (*Protocol).Dial is configured as the .Dialer of the
+// node via .WithNodeDialer so that relay addresses may be dialed.
+type Protocol struct {
+	node   *core_module.Node
+	events Events
+
+	relaying           bool
+	maxReservations    int
+	reservationTTL     time.Duration
+	maxCircuitDuration time.Duration
+	maxCircuitBytes    uint64
+
+	maxIncomingCircuits int
+
+	sync.Mutex
+
+	reservations map[cryptographic.PublicKey]*reservation
+	circuits     map[circuitKey]*circuit
+	conns        map[circuitKey]*Conn
+	reserved     map[cryptographic.PublicKey]time.Time
+}
+
+// reservation is held by a relay for a peer that may be reached through circuits opened on the relay.
+type reservation struct {
+	client *core_module.Client
+	timer  *time.Timer
+}
+
+// circuit is forwarded by a relay between two ends, each of which identifies the circuit by a different ID.
+type circuit struct {
+	ends  [2]circuitKey
+	conns [2]*core_module.Client
+
+	bytes uint64
+	timer *time.Timer
+}
+
+// New returns a new instance of the circuit relay protocol.
+func New(opts ...Option) *Protocol {
+	p := &Protocol{
+		maxReservations:    128,
+		reservationTTL:     time.Hour,
+		maxCircuitDuration: 10 * time.Minute,
+		maxCircuitBytes:    64 << 20,
+
+		maxIncomingCircuits: 16,
+
+		reservations: make(map[cryptographic.PublicKey]*reservation),
+		circuits:     make(map[circuitKey]*circuit),
+		conns:        make(map[circuitKey]*Conn),
+		reserved:     make(map[cryptographic.PublicKey]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Protocol returns a .Protocol that may registered to a node via (*.Node).Bind.
+func (p *Protocol) Protocol() core_module.Protocol {
+	return core_module.Protocol{
+		Bind:               p.Bind,
+		OnPeerDisconnected: p.OnPeerDisconnected,
+	}
+}
+
+// Bind registers messages required by the circuit relay protocol, and handles them by registering the
+// (*Protocol).Handle Handler.
+func (p *Protocol) Bind(node *core_module.Node) error {
+	p.node = node
+
+	node.RegisterMessage(ReserveRequest{}, UnmarshalReserveRequest)
+	node.RegisterMessage(ReserveResponse{}, UnmarshalReserveResponse)
+	node.RegisterMessage(ConnectRequest{}, UnmarshalConnectRequest)
+	node.RegisterMessage(ConnectResponse{}, UnmarshalConnectResponse)
+	node.RegisterMessage(IncomingRequest{}, UnmarshalIncomingRequest)
+	node.RegisterMessage(IncomingResponse{}, UnmarshalIncomingResponse)
+	node.RegisterMessage(Data{}, UnmarshalData)
+	node.RegisterMessage(Close{}, UnmarshalClose)
+
+	node.Handle(p.Handle)
+
+	return nil
+}
+
+// OnPeerDisconnected closes all circuits opened through, or forwarded to, the disconnected peer, and releases any
+// reservation held by, or held on, the disconnected peer.
+func (p *Protocol) OnPeerDisconnected(client *core_module.Client) {
+	id := client.ID().PubKey
+
+	var (
+		conns    []*Conn
+		circuits []*circuit
+	)
+
+	p.Lock()
+
+	delete(p.reserved, id)
+
+	if res, exists := p.reservations[id]; exists && res.client == client {
+		res.timer.Stop()
+		delete(p.reservations, id)
+	}
+
+	for key, conn := range p.conns {
+		if key.peer == id {
+			conns = append(conns, conn)
+		}
+	}
+
+	for key, c := range p.circuits {
+		if key.peer == id {
+			circuits = append(circuits, c)
+		}
+	}
+
+	p.Unlock()
+
+	for _, conn := range conns {
+		p.closeConn(conn)
+	}
+
+	for _, c := range circuits {
+		p.closeCircuit(c)
+	}
+}
+
+// Reserve reserves a slot on the relay at addr, such that peers may open circuits to your node through the relay for
+// as long as your node stays connected to the relay. It returns the duration the reservation is held for, after
+// which it must be renewed by calling Reserve again. Your node only accepts circuits from relays it holds a
+// reservation on.
+func (p *Protocol) Reserve(ctx context.Context, addr string) (time.Duration, error) {
+	relay, err := p.node.Ping(ctx, addr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve a slot on relay %s: %w", addr, err)
+	}
+
+	obj, err := relay.RequestMessage(ctx, ReserveRequest{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve a slot on relay %s: %w", addr, err)
+	}
+
+	res, ok := obj.(ReserveResponse)
+	if !ok {
+		return 0, fmt.Errorf("got unexpected response from relay %s: %T", addr, obj)
+	}
+
+	p.Lock()
+	p.reserved[relay.ID().PubKey] = time.Now().Add(res.TTL)
+	p.Unlock()
+
+	return res.TTL, nil
+}
+
+// Dial implements .Dialer. Should addr be a relay address, a circuit is opened to the peer through the relay, and the
+// end of the circuit on the side of your node is returned. Otherwise, addr is dialed over TCP.
+func (p *Protocol) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	a, err := ParseAddr(addr)
+	if err != nil {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	if p.node == nil {
+		return nil, errors.New("relay protocol is not bound to a node")
+	}
+
+	relay, err := p.node.Ping(ctx, a.Relay)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newConn(p, relay, rand.Uint64(), NewAddr(a.Relay, p.node.ID().PubKey), a)
+
+	p.Lock()
+	for _, exists := p.conns[conn.key]; exists; _, exists = p.conns[conn.key] {
+		conn.key.id = rand.Uint64()
+	}
+	p.conns[conn.key] = conn
+	p.Unlock()
+
+	obj, err := relay.RequestMessage(ctx, ConnectRequest{Circuit: conn.key.id, Target: a.Peer})
+	if err == nil {
+		if _, ok := obj.(ConnectResponse); !ok {
+			err = fmt.Errorf("got unexpected response from relay %s: %T", a.Relay, obj)
+		}
+	}
+
+	if err != nil {
+		if conn.shutdown() {
+			p.removeConn(conn)
+		}
+
+		return nil, fmt.Errorf("failed to open circuit through relay %s: %w", a.Relay, err)
+	}
+
+	if p.events.OnCircuitOpened != nil {
+		p.events.OnCircuitOpened(conn.remote)
+	}
+
+	return conn, nil
+}
+
+// Handle implements .Protocol and handles all messages of the circuit relay protocol.
+func (p *Protocol) Handle(ctx core_module.HandlerContext) error {
+	obj, err := ctx.DecodeMessage()
+	if err != nil {
+		return nil
+	}
+
+	switch msg := obj.(type) {
+	case ReserveRequest:
+		return p.handleReserveRequest(ctx)
+	case ConnectRequest:
+		return p.handleConnectRequest(ctx, msg)
+	case IncomingRequest:
+		return p.handleIncomingRequest(ctx, msg)
+	case Data:
+		p.handleData(ctx, msg)
+	case Close:
+		p.handleClose(ctx, msg)
+	}
+
+	return nil
+}
+
+func (p *Protocol) handleReserveRequest(ctx core_module.HandlerContext) error {
+	if !ctx.IsRequest() {
+		return nil
+	}
+
+	if !p.relaying {
+		return core_module.NewRemoteError(core_module.StatusUnavailable, "relaying is disabled", nil)
+	}
+
+	id := ctx.ID().PubKey
+
+	p.Lock()
+
+	res, exists := p.reservations[id]
+	if !exists && len(p.reservations) >= p.maxReservations {
+		p.Unlock()
+		return core_module.NewRemoteError(core_module.StatusUnavailable, "too many reservations", nil)
+	}
+
+	if exists {
+		res.timer.Stop()
+	}
+
+	res = &reservation{client: ctx.Client()}
+	res.timer = time.AfterFunc(p.reservationTTL, func() { p.expireReservation(id, res) })
+
+	p.reservations[id] = res
+
+	p.Unlock()
+
+	return ctx.SendMessage(ReserveResponse{TTL: p.reservationTTL})
+}
+
+func (p *Protocol) handleConnectRequest(ctx core_module.HandlerContext, req ConnectRequest) error {
+	if !ctx.IsRequest() {
+		return nil
+	}
+
+	if !p.relaying {
+		return core_module.NewRemoteError(core_module.StatusUnavailable, "relaying is disabled", nil)
+	}
+
+	src := circuitKey{peer: ctx.ID().PubKey, id: req.Circuit}
+
+	p.Lock()
+
+	res, exists := p.reservations[req.Target]
+	if !exists {
+		p.Unlock()
+		return core_module.NewRemoteError(core_module.StatusNotFound, "peer holds no reservation", nil)
+	}
+
+	if _, exists := p.circuits[src]; exists {
+		p.Unlock()
+		return core_module.NewRemoteError(core_module.StatusInvalidRequest, "circuit is already open", nil)
+	}
+
+	dst := circuitKey{peer: req.Target, id: rand.Uint64()}
+	for _, exists := p.circuits[dst]; exists; _, exists = p.circuits[dst] {
+		dst.id = rand.Uint64()
+	}
+
+	c := &circuit{
+		ends:  [2]circuitKey{src, dst},
+		conns: [2]*core_module.Client{ctx.Client(), res.client},
+	}
+
+	p.circuits[src] = c
+	p.circuits[dst] = c
+
+	p.Unlock()
+
+	reqCtx, cancel := context.WithTimeout(context.Background(), incomingTimeout)
+	defer cancel()
+
+	obj, err := res.client.RequestMessage(reqCtx, IncomingRequest{Circuit: dst.id, Source: ctx.ID()})
+	if err == nil {
+		if _, ok := obj.(IncomingResponse); !ok {
+			err = fmt.Errorf("got unexpected response: %T", obj)
+		}
+	}
+
+	if err != nil {
+		p.Lock()
+		delete(p.circuits, src)
+		delete(p.circuits, dst)
+		p.Unlock()
+
+		return core_module.NewRemoteError(core_module.StatusUnavailable, "peer did not accept circuit", []byte(err.Error()))
+	}
+
+	p.Lock()
+	if p.circuits[src] == c {
+		c.timer = time.AfterFunc(p.maxCircuitDuration, func() { p.closeCircuit(c) })
+	}
+	p.Unlock()
+
+	return ctx.SendMessage(ConnectResponse{})
+}
+
+func (p *Protocol) handleIncomingRequest(ctx core_module.HandlerContext, req IncomingRequest) error {
+	if !ctx.IsRequest() {
+		return nil
+	}
+
+	relay := ctx.ID()
+
+	conn := newConn(p, ctx.Client(), req.Circuit,
+		NewAddr(relay.Address, p.node.ID().PubKey), NewAddr(relay.Address, req.Source.PubKey),
+	)
+	conn.incoming = true
+
+	p.Lock()
+
+	// Circuits are only accepted from relays your node holds a reservation on, as each circuit takes up a slot of the
+	// inbound peers of your node.
+
+	if expires, exists := p.reserved[relay.PubKey]; !exists || time.Now().After(expires) {
+		delete(p.reserved, relay.PubKey)
+		p.Unlock()
+
+		return core_module.NewRemoteError(core_module.StatusNotFound, "no reservation is held on this relay", nil)
+	}
+
+	if _, exists := p.conns[conn.key]; exists {
+		p.Unlock()
+		return core_module.NewRemoteError(core_module.StatusInvalidRequest, "circuit is already open", nil)
+	}
+
+	incoming := 0
+
+	for key, c := range p.conns {
+		if key.peer == relay.PubKey && c.incoming {
+			incoming++
+		}
+	}
+
+	if incoming >= p.maxIncomingCircuits {
+		p.Unlock()
+		return core_module.NewRemoteError(core_module.StatusUnavailable, "too many incoming circuits", nil)
+	}
+
+	p.conns[conn.key] = conn
+
+	p.Unlock()
+
+	p.node.Accept(conn)
+
+	if p.events.OnCircuitOpened != nil {
+		p.events.OnCircuitOpened(conn.remote)
+	}
+
+	return ctx.SendMessage(IncomingResponse{})
+}
+
+func (p *Protocol) handleData(ctx core_module.HandlerContext, msg Data) {
+	key := circuitKey{peer: ctx.ID().PubKey, id: msg.Circuit}
+
+	p.Lock()
+
+	if conn, exists := p.conns[key]; exists {
+		p.Unlock()
+		conn.deliver(msg)
+
+		return
+	}
+
+	c, exists := p.circuits[key]
+	if !exists {
+		p.Unlock()
+		return
+	}
+
+	c.bytes += uint64(len(msg.Payload))
+	exceeded := c.bytes > p.maxCircuitBytes
+
+	p.Unlock()
+
+	if exceeded {
+		ctx.Logger().Debug("Closing relayed circuit which exceeded its byte limit.", zap.Uint64("circuit", msg.Circuit))
+		p.closeCircuit(c)
+
+		return
+	}
+
+	i := 1
+	if c.ends[1] == key {
+		i = 0
+	}
+
+	msg.Circuit = c.ends[i].id
+
+	if err := c.conns[i].SendMessage(msg); err != nil {
+		p.closeCircuit(c)
+	}
+}
+
+func (p *Protocol) handleClose(ctx core_module.HandlerContext, msg Close) {
+	key := circuitKey{peer: ctx.ID().PubKey, id: msg.Circuit}
+
+	p.Lock()
+	conn, isConn := p.conns[key]
+	c, isCircuit := p.circuits[key]
+	p.Unlock()
+
+	if isConn {
+		p.closeConn(conn)
+		return
+	}
+
+	if isCircuit {
+		p.closeCircuit(c)
+	}
+}
+
+// closeConn marks conn as closed and forgets about it, and returns true should it not have already been closed.
+func (p *Protocol) closeConn(conn *Conn) bool {
+	if !conn.shutdown() {
+		return false
+	}
+
+	p.removeConn(conn)
+
+	if p.events.OnCircuitClosed != nil {
+		p.events.OnCircuitClosed(conn.remote)
+	}
+
+	return true
+}
+
+func (p *Protocol) removeConn(conn *Conn) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.conns[conn.key] == conn {
+		delete(p.conns, conn.key)
+	}
+}
+
+// closeCircuit stops forwarding c, and notifies both ends of c that it has been closed.
+func (p *Protocol) closeCircuit(c *circuit) {
+	p.Lock()
+
+	if p.circuits[c.ends[0]] != c {
+		p.Unlock()
+		return
+	}
+
+	delete(p.circuits, c.ends[0])
+	delete(p.circuits, c.ends[1])
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+
+	p.Unlock()
+
+	for i, end := range c.ends {
+		_ = c.conns[i].SendMessage(Close{Circuit: end.id})
+	}
+}
+
+func (p *Protocol) expireReservation(id cryptographic.PublicKey, res *reservation) {
+	p.Lock()
+
+	if p.reservations[id] != res {
+		p.Unlock()
+		return
+	}
+
+	delete(p.reservations, id)
+
+	p.Unlock()
+
+	if p.events.OnReservationExpired != nil {
+		p.events.OnReservationExpired(id)
+	}
+}
@@ -0,0 +1,240 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/kademlia"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func newRelayNode(t *testing.T, opts ...Option) (*core_module.Node, *Protocol) {
+	p := New(opts...)
+
+	node, err := core_module.NewNode(core_module.WithNodeDialer(p.Dial))
+	assert.NoError(t, err)
+
+	node.Bind(p.Protocol())
+	node.Handle(func(ctx core_module.HandlerContext) error {
+		if ctx.IsRequest() && bytes.Equal(ctx.Data(), []byte("hello")) {
+			return ctx.Send([]byte("world"))
+		}
+
+		return nil
+	})
+
+	assert.NoError(t, node.Listen())
+
+	return node, p
+}
+
+func TestRelay(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	r, _ := newRelayNode(t, WithRelaying(true))
+	defer r.Close()
+
+	b, pb := newRelayNode(t)
+	defer b.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	pa := New(WithEvents(Events{
+		OnCircuitOpened: func(addr Addr) {
+			wg.Done()
+		},
+	}))
+
+	a, err := core_module.NewNode(core_module.WithNodeDialer(pa.Dial))
+	assert.NoError(t, err)
+	defer a.Close()
+
+	overlay := kademlia.New()
+	a.Bind(pa.Protocol(), overlay.Protocol())
+
+	assert.NoError(t, a.Listen())
+
+	// Opening a circuit to a peer that holds no reservation should fail.
+
+	addr := NewAddr(r.Addr(), b.ID().PubKey)
+
+	_, err = a.Request(context.TODO(), addr.String(), []byte("hello"))
+	assert.Error(t, err)
+
+	ttl, err := pb.Reserve(context.TODO(), r.Addr())
+	assert.NoError(t, err)
+	assert.EqualValues(t, time.Hour, ttl)
+
+	res, err := a.Request(context.TODO(), addr.String(), []byte("hello"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, "world", res)
+
+	wg.Wait()
+
+	// The peer should be recorded in the routing table under its relay address.
+
+	assert.Contains(t, overlay.Table().Entries(), addr.ID(b.ID()))
+}
+
+func TestRelayIncomingCircuits(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	r, _ := newRelayNode(t, WithRelaying(true))
+	defer r.Close()
+
+	b, pb := newRelayNode(t, WithMaxIncomingCircuits(1))
+	defer b.Close()
+
+	a, _ := newRelayNode(t)
+	defer a.Close()
+
+	// Circuits should not be accepted from a peer your node holds no reservation on.
+
+	var remote *core_module.RemoteError
+
+	_, err := a.RequestMessage(context.TODO(), b.Addr(), IncomingRequest{Circuit: 1, Source: a.ID()})
+	if assert.True(t, errors.As(err, &remote)) {
+		assert.Equal(t, core_module.StatusNotFound, remote.Code)
+	}
+
+	_, err = r.RequestMessage(context.TODO(), b.Addr(), IncomingRequest{Circuit: 1, Source: a.ID()})
+	assert.Error(t, err)
+
+	// Circuits should be accepted from a relay your node holds a reservation on, up to the configured limit.
+
+	_, err = pb.Reserve(context.TODO(), r.Addr())
+	assert.NoError(t, err)
+
+	_, err = r.RequestMessage(context.TODO(), b.Addr(), IncomingRequest{Circuit: 1, Source: a.ID()})
+	assert.NoError(t, err)
+
+	_, err = r.RequestMessage(context.TODO(), b.Addr(), IncomingRequest{Circuit: 2, Source: a.ID()})
+	if assert.True(t, errors.As(err, &remote)) {
+		assert.Equal(t, core_module.StatusUnavailable, remote.Code)
+	}
+}
+
+func TestRelayCircuitByteLimit(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	r, _ := newRelayNode(t, WithRelaying(true), WithMaxCircuitBytes(8192))
+	defer r.Close()
+
+	b, pb := newRelayNode(t)
+	defer b.Close()
+
+	a, _ := newRelayNode(t)
+	defer a.Close()
+
+	_, err := pb.Reserve(context.TODO(), r.Addr())
+	assert.NoError(t, err)
+
+	client, err := a.Ping(context.TODO(), NewAddr(r.Addr(), b.ID().PubKey).String())
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.Send(make([]byte, 16384)))
+
+	client.WaitUntilClosed()
+}
+
+func TestRelayReorderWindow(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	r, _ := newRelayNode(t, WithRelaying(true))
+	defer r.Close()
+
+	b, pb := newRelayNode(t)
+	defer b.Close()
+
+	a, _ := newRelayNode(t)
+	defer a.Close()
+
+	_, err := pb.Reserve(context.TODO(), r.Addr())
+	assert.NoError(t, err)
+
+	client, err := a.Ping(context.TODO(), NewAddr(r.Addr(), b.ID().PubKey).String())
+	assert.NoError(t, err)
+
+	var conn *Conn
+
+	pb.Lock()
+	for _, c := range pb.conns {
+		conn = c
+	}
+	pb.Unlock()
+
+	assert.NotNil(t, conn)
+
+	// Data messages within the reorder window are buffered until those sent before them arrive.
+
+	conn.Lock()
+	next := conn.next
+	conn.Unlock()
+
+	conn.deliver(Data{Circuit: conn.key.id, Seq: next + maxPendingFrames - 1, Payload: []byte("later")})
+
+	conn.Lock()
+	assert.Len(t, conn.pending, 1)
+	assert.False(t, conn.closed)
+	conn.Unlock()
+
+	// A Data message outside of the reorder window closes the circuit.
+
+	conn.deliver(Data{Circuit: conn.key.id, Seq: next + maxPendingFrames, Payload: []byte("too far ahead")})
+
+	conn.Lock()
+	assert.Nil(t, conn.pending)
+	assert.True(t, conn.closed)
+	conn.Unlock()
+
+	client.WaitUntilClosed()
+}
+
+func TestRelayReadBackpressure(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	conn := &Conn{
+		pending: make(map[uint64][]byte),
+		notify:  make(chan struct{}, 1),
+		drained: make(chan struct{}),
+	}
+
+	// Fill up the bytes a circuit buffers awaiting Read.
+
+	next := uint64(0)
+
+	for ; conn.buf.Len() < maxBufferedBytes; next++ {
+		conn.deliver(Data{Seq: next, Payload: make([]byte, maxPayloadSize)})
+	}
+
+	// Data messages should not be accepted until buffered bytes are read.
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		conn.deliver(Data{Seq: next, Payload: []byte("later")})
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected data to not be accepted while the circuit is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err := conn.Read(make([]byte, maxPayloadSize))
+	assert.NoError(t, err)
+
+	<-done
+
+	assert.Equal(t, next+1, conn.next)
+	assert.False(t, conn.closed)
+}
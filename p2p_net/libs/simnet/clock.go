@@ -0,0 +1,189 @@
+package simnet
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock which only ever moves forward as a Network is run. Callbacks scheduled on a Clock are run
+// in the order of the virtual time they are scheduled at, with ties broken by the order they were scheduled in.
+type Clock struct {
+	sync.Mutex
+
+	now    time.Time
+	timers timerHeap
+	seq    uint64
+
+	notify chan struct{}
+}
+
+// NewClock returns a new virtual clock whose time is set to start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start, notify: make(chan struct{}, 1)}
+}
+
+// Now returns the current virtual time.
+func (c *Clock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+// Since returns the virtual time elapsed since t.
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// AfterFunc schedules f to be called once d of virtual time has elapsed. f is called on the goroutine that runs the
+// network the clock belongs to, and so must not block on the network making progress.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *Timer {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.schedule(c.now.Add(d), 0, f)
+}
+
+// After returns a channel which receives the virtual time once d of virtual time has elapsed.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+
+	return ch
+}
+
+// Timer is a callback scheduled on a Clock.
+type Timer struct {
+	clock *Clock
+
+	at   time.Time
+	link uint64
+	seq  uint64
+	f    func()
+
+	index int
+}
+
+// Stop prevents the callback of this timer from being called. It returns false should the callback already have been
+// called or the timer already have been stopped.
+func (t *Timer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+
+	if t.index < 0 {
+		return false
+	}
+
+	heap.Remove(&t.clock.timers, t.index)
+
+	return true
+}
+
+// scheduleAt schedules f to be called at the virtual time at. See (*Clock).schedule.
+func (c *Clock) scheduleAt(at time.Time, link uint64, f func()) *Timer {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.schedule(at, link, f)
+}
+
+// schedule schedules f to be called at the virtual time at. Callbacks scheduled at the same time are ordered by link,
+// and then by the order they were scheduled in, such that deliveries over different links are ordered the same way
+// regardless of which goroutines scheduled them first. The caller must hold the lock.
+func (c *Clock) schedule(at time.Time, link uint64, f func()) *Timer {
+	if at.Before(c.now) {
+		at = c.now
+	}
+
+	c.seq++
+
+	t := &Timer{clock: c, at: at, link: link, seq: c.seq, f: f}
+	heap.Push(&c.timers, t)
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+
+	return t
+}
+
+// next returns the virtual time of the earliest scheduled callback, and false should no callback be scheduled.
+func (c *Clock) next() (time.Time, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+
+	return c.timers[0].at, true
+}
+
+// advance moves the virtual time forward to at, and calls all callbacks scheduled at or before at in order, including
+// those scheduled by the callbacks themselves. It returns the number of callbacks called.
+func (c *Clock) advance(at time.Time) int {
+	called := 0
+
+	for {
+		c.Lock()
+
+		if len(c.timers) == 0 || c.timers[0].at.After(at) {
+			if at.After(c.now) {
+				c.now = at
+			}
+
+			c.Unlock()
+
+			return called
+		}
+
+		t := heap.Pop(&c.timers).(*Timer)
+		c.now = t.at
+
+		c.Unlock()
+
+		t.f()
+		called++
+	}
+}
+
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	if !h[i].at.Equal(h[j].at) {
+		return h[i].at.Before(h[j].at)
+	}
+
+	if h[i].link != h[j].link {
+		return h[i].link < h[j].link
+	}
+
+	return h[i].seq < h[j].seq
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+
+	return t
+}
@@ -0,0 +1,49 @@
+package simnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+
+	start := time.Unix(1000, 0)
+	clock := NewClock(start)
+
+	var order []int
+
+	clock.AfterFunc(2*time.Second, func() { order = append(order, 3) })
+	clock.AfterFunc(time.Second, func() { order = append(order, 1) })
+	clock.AfterFunc(time.Second, func() { order = append(order, 2) })
+
+	stopped := clock.AfterFunc(time.Second, func() { order = append(order, -1) })
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	// Callbacks scheduled at the same time over different links are ordered by link, regardless of the order they
+	// were scheduled in.
+
+	clock.scheduleAt(start.Add(3*time.Second), 2, func() { order = append(order, 5) })
+	clock.scheduleAt(start.Add(3*time.Second), 1, func() { order = append(order, 4) })
+
+	at, ok := clock.next()
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(time.Second), at)
+
+	assert.Equal(t, 2, clock.advance(start.Add(1500*time.Millisecond)))
+	assert.Equal(t, []int{1, 2}, order)
+	assert.Equal(t, 1500*time.Millisecond, clock.Since(start))
+
+	ch := clock.After(time.Second)
+
+	assert.Equal(t, 4, clock.advance(start.Add(time.Minute)))
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, order)
+	assert.Equal(t, start.Add(2500*time.Millisecond), <-ch)
+	assert.Equal(t, start.Add(time.Minute), clock.Now())
+
+	_, ok = clock.next()
+	assert.False(t, ok)
+}
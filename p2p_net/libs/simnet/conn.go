@@ -0,0 +1,284 @@
+package simnet
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Conn is one end of a connection between two hosts of a simulated network. Bytes written to a Conn are delivered to
+// the other end of the connection in order, once the virtual time it takes them to traverse the link between both
+// hosts has elapsed. Conn implements net.Conn.
+type Conn struct {
+	network *Network
+	path    *path
+	peer    *Conn
+
+	local, remote *net.TCPAddr
+
+	// rand is the source the conditions each segment written to this end of the connection is subject to are drawn
+	// from, and arrival is the virtual time the last segment written arrives at the other end. Both are guarded by
+	// the lock of the network.
+	rand    *rand.Rand
+	arrival time.Time
+
+	sync.Mutex
+
+	buf bytes.Buffer
+
+	closed   bool
+	eof      bool
+	err      error
+	deadline time.Time
+	notify   chan struct{}
+}
+
+func newConn(network *Network, path *path, rand *rand.Rand, local, remote *net.TCPAddr) *Conn {
+	return &Conn{
+		network: network,
+		path:    path,
+		rand:    rand,
+		local:   local,
+		remote:  remote,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Read implements net.Conn and reads bytes sent from the other end of the connection in the order they were written.
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.Lock()
+
+		if c.closed {
+			c.Unlock()
+			return 0, net.ErrClosed
+		}
+
+		if c.buf.Len() > 0 {
+			n, err := c.buf.Read(b)
+			if c.buf.Len() == 0 {
+				c.network.unread.Dec()
+			}
+
+			c.Unlock()
+
+			c.network.activity.Inc()
+
+			return n, err
+		}
+
+		if c.err != nil {
+			err := c.err
+			c.Unlock()
+
+			return 0, err
+		}
+
+		if c.eof {
+			c.Unlock()
+			return 0, io.EOF
+		}
+
+		deadline := c.deadline
+
+		c.Unlock()
+
+		if deadline.IsZero() {
+			<-c.notify
+			continue
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-c.notify:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// Write implements net.Conn and sends b to the other end of the connection. Writes never block, as the bytes written
+// are queued onto the link between both hosts.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.Lock()
+
+	if c.closed {
+		c.Unlock()
+		return 0, net.ErrClosed
+	}
+
+	if c.err != nil {
+		err := c.err
+		c.Unlock()
+
+		return 0, err
+	}
+
+	c.Unlock()
+
+	c.network.send(c, append([]byte(nil), b...))
+
+	return len(b), nil
+}
+
+// Close implements net.Conn, and has the other end of the connection read io.EOF once all bytes written beforehand
+// have been delivered to it.
+func (c *Conn) Close() error {
+	c.Lock()
+
+	if c.closed {
+		c.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	c.drop()
+
+	c.Unlock()
+
+	c.wake()
+
+	c.network.close(c)
+
+	return nil
+}
+
+// LocalAddr implements net.Conn and returns the address of this end of the connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr implements net.Conn and returns the address of the other end of the connection.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline implements net.Conn. Only the read deadline is respected.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn. Deadlines are in real time rather than in virtual time.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.Lock()
+	c.deadline = t
+	c.Unlock()
+
+	c.wake()
+
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn. Writes never block, and so write deadlines are ignored.
+func (c *Conn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// deliver makes payload available to Read, should this end of the connection still be open.
+func (c *Conn) deliver(payload []byte) {
+	c.Lock()
+
+	if c.closed || c.eof || c.err != nil {
+		c.Unlock()
+		return
+	}
+
+	if c.buf.Len() == 0 {
+		c.network.unread.Inc()
+	}
+
+	c.buf.Write(payload)
+
+	c.Unlock()
+
+	c.wake()
+}
+
+// finish has Read return io.EOF once all bytes delivered beforehand have been read.
+func (c *Conn) finish() {
+	c.Lock()
+	c.eof = true
+	c.Unlock()
+
+	c.wake()
+}
+
+// reset has all reads and writes on this end of the connection fail with err from now on. All bytes that have yet
+// to be read are discarded.
+func (c *Conn) reset(err error) {
+	c.Lock()
+
+	if c.err == nil {
+		c.err = err
+	}
+
+	c.drop()
+
+	c.Unlock()
+
+	c.wake()
+}
+
+// drop discards all bytes that have yet to be read. The caller must hold the lock.
+func (c *Conn) drop() {
+	if c.buf.Len() > 0 {
+		c.network.unread.Dec()
+	}
+
+	c.buf.Reset()
+}
+
+func (c *Conn) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Listener accepts connections dialed to an address of a simulated network. Listener implements net.Listener.
+type Listener struct {
+	network *Network
+	addr    *net.TCPAddr
+
+	conns     chan *Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept implements net.Listener and waits for the next connection dialed to the address of this listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		l.network.activity.Inc()
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener, and has all connections dialed to the address of this listener from now on be
+// refused. Connections that have yet to be accepted are reset.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.network.unlisten(l)
+	})
+
+	return nil
+}
+
+// Addr implements net.Listener and returns the address of this listener.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
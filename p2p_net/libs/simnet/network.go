@@ -0,0 +1,619 @@
+// Package simnet implements a simulated network, over which many nodes may be run within a single process to test
+// protocols such as Kademlia and gossip without relying on real sockets, and with data delivered in virtual time.
+//
+// Nodes instantiated through (*Network).NewNode listen and dial over the simulated network. Data sent between nodes
+// takes virtual time to be delivered, subject to the latency, jitter, loss, and bandwidth of the link between their
+// hosts, and hosts may be partitioned from one another. Virtual time only moves forward as the network is run, and
+// skips straight to the time the next segment of data is delivered once all nodes are idle, such that a lookup
+// across a network with hundreds of milliseconds of latency completes in far less real time than it would over
+// real sockets.
+//
+// All randomness of the network is derived from a seed. Keys and hosts are assigned to nodes in the order they are
+// instantiated, the conditions each segment of data is subject to are drawn from a random source that belongs to the
+// connection it is written to, and segments delivered at the same virtual time are delivered in an order fixed by
+// the links they traverse. Given the same seed, a failing scenario may thus be replayed with the same nodes, over the
+// same links, subject to the same conditions.
+//
+// The scope of the simulation is limited in three ways. First, the virtual clock only governs the delivery of data.
+// It is not injected into nodes or protocols, whose timeouts, deadlines, and maintenance intervals remain in real
+// time, and so scenarios should only rely on timeouts that are long in real time compared to how long the network
+// takes to deliver data.
+//
+// Second, the goroutines of nodes are still scheduled by the Go runtime. A network only moves its virtual clock
+// forward once no node has read or written any data for a short while of real time, which may be configured through
+// WithSettle, and no other goroutine appears to be runnable, which is told by repeatedly yielding to the Go scheduler
+// and timing how long it takes to be resumed. As the latter may only be told reliably while goroutines are run one at
+// a time, runs only replay exactly with GOMAXPROCS set to 1, and without the race detector, which randomizes the
+// order goroutines are scheduled in.
+//
+// Third, nodes still handshake, sign, and verify in full, which dominates the real time a run takes. On a single
+// core, networks of a few hundred nodes bootstrap within a minute, while networks of thousands of nodes are not
+// practical to run as part of a test suite.
+package simnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// DefaultPort is the port nodes instantiated through (*Network).NewNode listen on.
+const DefaultPort = 3000
+
+// ErrUnreachable is returned when dialing, reading from, or writing to a connection to a host that is partitioned
+// from the host of your node.
+var ErrUnreachable = errors.New("host is unreachable")
+
+// ErrRefused is returned when dialing an address that no listener is listening on.
+var ErrRefused = errors.New("connection refused")
+
+// maxSettle bounds the real time the network waits for other goroutines to stop being runnable, and for nodes to read
+// data that was delivered to them, before moving its virtual clock forward regardless.
+const maxSettle = time.Second
+
+// minIdleYields is the number of consecutive times the goroutine running the network must yield to other goroutines
+// and be resumed within maxIdleYield for the network to be considered idle. A yield that takes longer signals that
+// some other goroutine was runnable, and may yet read or write data.
+const (
+	minIdleYields = 3
+	maxIdleYield  = 50 * time.Microsecond
+)
+
+// Network is a simulated network of hosts, each identified by an IP address, over which nodes may listen and dial one
+// another. Network must be run through (*Network).Run, (*Network).RunUntil, or (*Network).Start for any data to be
+// delivered.
+type Network struct {
+	seed   int64
+	start  time.Time
+	link   Link
+	settle time.Duration
+
+	clock *Clock
+
+	sync.Mutex
+
+	rand  *rand.Rand
+	hosts uint32
+	ports map[string]uint16
+
+	links     map[[2]string]Link
+	paths     map[[2]string]*path
+	groups    map[string]int
+	listeners map[string]*Listener
+	conns     map[*Conn]struct{}
+
+	activity atomic.Uint64
+	unread   atomic.Int64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// path is the direction of a link that data takes from one host to another.
+type path struct {
+	id    uint64
+	link  Link
+	busy  time.Time
+	conns int64
+}
+
+// source returns a new random source for the next connection whose data takes this path, derived from seed. The
+// caller must hold the lock of the network.
+func (p *path) source(seed int64) *rand.Rand {
+	p.conns++
+	return rand.New(rand.NewSource(seed ^ int64(p.id) ^ p.conns*0x5851f42d4c957f2d))
+}
+
+// New returns a new simulated network with no hosts.
+func New(opts ...Option) *Network {
+	n := &Network{
+		seed:   1,
+		start:  time.Unix(0, 0),
+		link:   DefaultLink,
+		settle: 200 * time.Microsecond,
+
+		ports: make(map[string]uint16),
+
+		links:     make(map[[2]string]Link),
+		paths:     make(map[[2]string]*path),
+		groups:    make(map[string]int),
+		listeners: make(map[string]*Listener),
+		conns:     make(map[*Conn]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	n.clock = NewClock(n.start)
+	n.rand = rand.New(rand.NewSource(n.seed))
+
+	return n
+}
+
+// Clock returns the virtual clock of this network.
+func (n *Network) Clock() *Clock {
+	return n.clock
+}
+
+// NewNode instantiates a new node whose host is the next unassigned host of this network, and whose keys are derived
+// from the seed of this network. The node listens on DefaultPort of its host, and dials peers over this network.
+// Logging is disabled by default. opts are applied after the options set by NewNode, and so may override them.
+func (n *Network) NewNode(opts ...core_module.NodeOption) (*core_module.Node, error) {
+	n.Lock()
+
+	n.hosts++
+	host := net.IPv4(10, byte(n.hosts>>16), byte(n.hosts>>8), byte(n.hosts))
+
+	_, privateKey, err := cryptographic.GenerateKeys(n.rand)
+
+	n.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := n.Listen(host, DefaultPort)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := []core_module.NodeOption{
+		core_module.WithNodeLogger(zap.NewNop()),
+		core_module.WithNodePrivateKey(privateKey),
+		core_module.WithNodeBindHost(host),
+		core_module.WithNodeBindPort(DefaultPort),
+		core_module.WithNodeListener(listener),
+		core_module.WithNodeDialer(n.Dialer(host)),
+	}
+
+	node, err := core_module.NewNode(append(defaults, opts...)...)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// Listen returns a listener which accepts connections dialed to port of host. It throws an error should a listener
+// already be listening on port of host.
+func (n *Network) Listen(host net.IP, port uint16) (*Listener, error) {
+	addr := &net.TCPAddr{IP: host, Port: int(port)}
+
+	n.Lock()
+	defer n.Unlock()
+
+	if _, exists := n.listeners[addr.String()]; exists {
+		return nil, fmt.Errorf("address %s is already in use", addr)
+	}
+
+	l := &Listener{
+		network: n,
+		addr:    addr,
+		conns:   make(chan *Conn, 128),
+		done:    make(chan struct{}),
+	}
+
+	n.listeners[addr.String()] = l
+
+	return l, nil
+}
+
+// Dialer returns a .Dialer which dials addresses of this network from host.
+func (n *Network) Dialer(host net.IP) core_module.Dialer {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return n.Dial(ctx, host, addr)
+	}
+}
+
+// Dial establishes a connection from host to addr, which must be of the form 'ip:port'. The connection is accepted by
+// the listener at addr once the latency of the link between both hosts has elapsed, until which bytes written to it
+// are queued. It throws ErrUnreachable should both hosts be partitioned from one another, or ErrRefused should no
+// listener be listening at addr.
+func (n *Network) Dial(ctx context.Context, host net.IP, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	hostStr, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(hostStr)
+	if ip == nil {
+		return nil, fmt.Errorf("host of %q is not an ip address", addr)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("port of %q is invalid: %w", addr, err)
+	}
+
+	remote := &net.TCPAddr{IP: ip, Port: int(port)}
+
+	n.activity.Inc()
+
+	n.Lock()
+	defer n.Unlock()
+
+	if !n.reachable(host, ip) {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, ErrUnreachable)
+	}
+
+	l, exists := n.listeners[remote.String()]
+	if !exists {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, ErrRefused)
+	}
+
+	local := &net.TCPAddr{IP: host, Port: int(n.ephemeralPort(host))}
+
+	out, in := n.path(host, ip), n.path(ip, host)
+
+	dialer := newConn(n, out, out.source(n.seed), local, remote)
+	acceptor := newConn(n, in, in.source(n.seed), remote, local)
+
+	dialer.peer, acceptor.peer = acceptor, dialer
+
+	n.conns[dialer] = struct{}{}
+	n.conns[acceptor] = struct{}{}
+
+	n.clock.scheduleAt(n.clock.Now().Add(out.link.Latency), out.id, func() {
+		n.accept(l, acceptor)
+	})
+
+	return dialer, nil
+}
+
+// accept hands acceptor to l, or refuses it should l no longer be listening or both ends of acceptor no longer be
+// reachable from one another.
+func (n *Network) accept(l *Listener, acceptor *Conn) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.listeners[l.addr.String()] == l && n.reachable(acceptor.local.IP, acceptor.remote.IP) {
+		select {
+		case l.conns <- acceptor:
+			return
+		default:
+		}
+	}
+
+	n.refuse(acceptor)
+}
+
+// refuse resets acceptor, and has the end of the connection that dialed it fail with ErrRefused. The caller must hold
+// the lock.
+func (n *Network) refuse(acceptor *Conn) {
+	acceptor.reset(net.ErrClosed)
+	acceptor.peer.reset(ErrRefused)
+
+	delete(n.conns, acceptor)
+	delete(n.conns, acceptor.peer)
+}
+
+// unlisten stops l from accepting connections, and refuses all connections that l has yet to accept.
+func (n *Network) unlisten(l *Listener) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.listeners[l.addr.String()] == l {
+		delete(n.listeners, l.addr.String())
+	}
+
+	for {
+		select {
+		case acceptor := <-l.conns:
+			n.refuse(acceptor)
+		default:
+			return
+		}
+	}
+}
+
+// send queues payload onto the link from the host of c to the host of the other end of c, and schedules it to be
+// delivered to the other end of c.
+func (n *Network) send(c *Conn, payload []byte) {
+	n.activity.Inc()
+
+	n.Lock()
+	defer n.Unlock()
+
+	at := n.transmit(c, len(payload))
+	peer := c.peer
+
+	n.clock.scheduleAt(at, c.path.id, func() {
+		peer.deliver(payload)
+	})
+}
+
+// close schedules the other end of c to read io.EOF once all bytes written to c beforehand have been delivered.
+func (n *Network) close(c *Conn) {
+	n.activity.Inc()
+
+	n.Lock()
+	defer n.Unlock()
+
+	delete(n.conns, c)
+
+	if _, open := n.conns[c.peer]; !open {
+		return
+	}
+
+	at := n.transmit(c, 0)
+	peer := c.peer
+
+	n.clock.scheduleAt(at, c.path.id, func() {
+		peer.finish()
+	})
+}
+
+// transmit returns the virtual time a segment of size bytes written to c now arrives at the other end of c, subject
+// to the conditions of the link it traverses. The caller must hold the lock.
+func (n *Network) transmit(c *Conn, size int) time.Time {
+	p := c.path
+	now := n.clock.Now()
+
+	depart := now
+	if p.busy.After(depart) {
+		depart = p.busy
+	}
+
+	if p.link.Bandwidth > 0 {
+		depart = depart.Add(time.Duration(int64(size) * int64(time.Second) / int64(p.link.Bandwidth)))
+		p.busy = depart
+	}
+
+	at := depart.Add(p.link.Latency)
+
+	if p.link.Jitter > 0 {
+		at = at.Add(time.Duration(c.rand.Int63n(int64(p.link.Jitter))))
+	}
+
+	rto := p.link.RetransmitTimeout
+	if rto <= 0 {
+		rto = 200 * time.Millisecond
+	}
+
+	for p.link.Loss > 0 && c.rand.Float64() < p.link.Loss {
+		at = at.Add(rto)
+	}
+
+	if at.Before(c.arrival) {
+		at = c.arrival
+	}
+
+	c.arrival = at
+
+	return at
+}
+
+// path returns the direction of the link from src to dst. The caller must hold the lock.
+func (n *Network) path(src, dst net.IP) *path {
+	key := [2]string{src.String(), dst.String()}
+
+	if p, exists := n.paths[key]; exists {
+		return p
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key[0] + ">" + key[1]))
+
+	id := h.Sum64()
+
+	link, exists := n.links[key]
+	if !exists {
+		link = n.link
+	}
+
+	p := &path{id: id, link: link}
+	n.paths[key] = p
+
+	return p
+}
+
+// ephemeralPort returns the next port of host that connections dialed from host are bound to. The caller must hold
+// the lock.
+func (n *Network) ephemeralPort(host net.IP) uint16 {
+	key := host.String()
+
+	port := n.ports[key]
+	if port < 49152 {
+		port = 49152
+	}
+
+	n.ports[key] = port + 1
+
+	return port
+}
+
+// SetLink sets the conditions of the link between hosts a and b, in both directions. Connections that are already
+// open between both hosts are subject to the conditions from now on.
+func (n *Network) SetLink(a, b net.IP, link Link) {
+	n.Lock()
+	defer n.Unlock()
+
+	for _, key := range [][2]string{{a.String(), b.String()}, {b.String(), a.String()}} {
+		n.links[key] = link
+
+		if p, exists := n.paths[key]; exists {
+			p.link = link
+		}
+	}
+}
+
+// Partition partitions hosts into groups, such that hosts of different groups may no longer reach one another. Hosts
+// that are not in any of groups form a group of their own. Connections that are open between hosts of different
+// groups are reset, and have all reads and writes fail with ErrUnreachable. Any partition in place beforehand is
+// replaced.
+func (n *Network) Partition(groups ...[]net.IP) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.groups = make(map[string]int)
+
+	for i, group := range groups {
+		for _, host := range group {
+			n.groups[host.String()] = i + 1
+		}
+	}
+
+	for c := range n.conns {
+		if n.reachable(c.local.IP, c.remote.IP) {
+			continue
+		}
+
+		c.reset(ErrUnreachable)
+		delete(n.conns, c)
+	}
+}
+
+// Heal removes any partition in place, such that all hosts may reach one another again.
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// reachable returns true should hosts a and b not be partitioned from one another. The caller must hold the lock.
+func (n *Network) reachable(a, b net.IP) bool {
+	return n.groups[a.String()] == n.groups[b.String()]
+}
+
+// Run runs this network until d of virtual time has elapsed.
+func (n *Network) Run(d time.Duration) {
+	n.RunUntil(d, nil)
+}
+
+// RunUntil runs this network until cond returns true, or until d of virtual time has elapsed. cond is checked every
+// time all nodes are idle. It returns true should cond have returned true. RunUntil must not be called concurrently,
+// nor while the network is started through (*Network).Start.
+func (n *Network) RunUntil(d time.Duration, cond func() bool) bool {
+	until := n.clock.Now().Add(d)
+
+	for {
+		if cond != nil && cond() {
+			return true
+		}
+
+		n.wait()
+
+		if cond != nil && cond() {
+			return true
+		}
+
+		at, ok := n.clock.next()
+		if !ok || at.After(until) {
+			n.clock.advance(until)
+			n.wait()
+
+			return cond != nil && cond()
+		}
+
+		n.clock.advance(at)
+	}
+}
+
+// Start starts a goroutine which runs this network in the background, moving its virtual clock forward to the next
+// segment of data to be delivered whenever all nodes are idle, until (*Network).Stop is called. It is useful to drive
+// nodes through APIs that block until a response is received, such as (*kademlia.Protocol).Find.
+func (n *Network) Start() {
+	n.stop = make(chan struct{})
+	n.wg.Add(1)
+
+	go func() {
+		defer n.wg.Done()
+
+		for {
+			n.wait()
+
+			select {
+			case <-n.stop:
+				return
+			default:
+			}
+
+			at, ok := n.clock.next()
+			if !ok {
+				select {
+				case <-n.stop:
+					return
+				case <-n.clock.notify:
+				}
+
+				continue
+			}
+
+			n.clock.advance(at)
+		}
+	}()
+}
+
+// Stop stops the goroutine started by (*Network).Start, and waits for it to exit.
+func (n *Network) Stop() {
+	close(n.stop)
+	n.wg.Wait()
+}
+
+// Close resets all connections that are open over this network. Nodes should be closed before the network is.
+func (n *Network) Close() {
+	n.Lock()
+	defer n.Unlock()
+
+	for c := range n.conns {
+		c.reset(net.ErrClosed)
+	}
+
+	n.conns = make(map[*Conn]struct{})
+}
+
+// wait blocks until no data has been read or written over this network for the configured settle time, no other
+// goroutine was found to be runnable for the last few times the current goroutine yielded, and all data delivered has
+// been read. Should the latter two not hold within maxSettle, wait stops blocking once no data has been read or
+// written for the settle time regardless. The network is polled rather than slept on, as sleeps of under a
+// millisecond tend to oversleep.
+func (n *Network) wait() {
+	start := time.Now()
+
+	before := n.activity.Load()
+	idle := start
+	yields := 0
+
+	for {
+		yield := time.Now()
+		runtime.Gosched()
+
+		now := time.Now()
+
+		if now.Sub(yield) < maxIdleYield {
+			yields++
+		} else {
+			yields = 0
+		}
+
+		if activity := n.activity.Load(); activity != before {
+			before, idle, yields = activity, now, 0
+			continue
+		}
+
+		if now.Sub(idle) < n.settle {
+			continue
+		}
+
+		if now.Sub(start) >= maxSettle || (yields >= minIdleYields && n.unread.Load() == 0) {
+			return
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package simnet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"awesomeProject/beacon/p2p_network/core_module"
+	"awesomeProject/beacon/p2p_network/libs/cryptographic"
+	"awesomeProject/beacon/p2p_network/libs/gossip"
+	"awesomeProject/beacon/p2p_network/libs/kademlia"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"go.uber.org/goleak"
+)
+
+// arrivals writes count segments of size bytes from one host to another over n, and returns the virtual time each
+// segment took to arrive.
+func arrivals(t *testing.T, n *Network, count, size int) []time.Duration {
+	t.Helper()
+
+	a, b := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	l, err := n.Listen(b, DefaultPort)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	conn, err := n.Dial(context.TODO(), a, "10.0.0.2:3000")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	n.Run(time.Second)
+
+	accepted, err := l.Accept()
+	assert.NoError(t, err)
+	defer accepted.Close()
+
+	durations := make([]time.Duration, 0, count)
+	buf := make([]byte, size)
+
+	for i := 0; i < count; i++ {
+		start := n.Clock().Now()
+
+		buf[0] = byte(i)
+
+		_, err := conn.Write(buf)
+		assert.NoError(t, err)
+
+		assert.True(t, n.RunUntil(time.Hour, func() bool {
+			accepted.(*Conn).Lock()
+			defer accepted.(*Conn).Unlock()
+
+			return accepted.(*Conn).buf.Len() == size
+		}))
+
+		durations = append(durations, n.Clock().Since(start))
+
+		_, err = io.ReadFull(accepted, buf)
+		assert.NoError(t, err)
+		assert.EqualValues(t, i, buf[0])
+	}
+
+	return durations
+}
+
+func TestLink(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	// Segments take as long as the latency of the link to arrive, plus as long as it takes to send them at the
+	// bandwidth of the link.
+
+	n := New(WithLink(Link{Latency: 50 * time.Millisecond, Bandwidth: 1000}))
+
+	for _, d := range arrivals(t, n, 3, 500) {
+		assert.Equal(t, 550*time.Millisecond, d)
+	}
+
+	// Lost segments are retransmitted, and segments arrive later subject to jitter.
+
+	lossy := Link{Latency: 10 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.3, RetransmitTimeout: time.Second}
+
+	first := arrivals(t, New(WithSeed(42), WithLink(lossy)), 20, 10)
+
+	lost := 0
+
+	for _, d := range first {
+		assert.GreaterOrEqual(t, int64(d), int64(10*time.Millisecond))
+
+		if d >= time.Second {
+			lost++
+		}
+	}
+
+	assert.Greater(t, lost, 0)
+	assert.Less(t, lost, 20)
+
+	// Networks given the same seed subject segments to the same conditions.
+
+	assert.Equal(t, first, arrivals(t, New(WithSeed(42), WithLink(lossy)), 20, 10))
+	assert.NotEqual(t, first, arrivals(t, New(WithSeed(43), WithLink(lossy)), 20, 10))
+}
+
+func TestConn(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	n := New()
+
+	a, b := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	_, err := n.Dial(context.TODO(), a, "10.0.0.2:3000")
+	assert.True(t, errors.Is(err, ErrRefused))
+
+	l, err := n.Listen(b, DefaultPort)
+	assert.NoError(t, err)
+
+	_, err = n.Listen(b, DefaultPort)
+	assert.Error(t, err)
+
+	conn, err := n.Dial(context.TODO(), a, "10.0.0.2:3000")
+	assert.NoError(t, err)
+
+	// Bytes written before the connection is accepted are queued, and the other end reads io.EOF once all bytes
+	// written before the connection was closed have been read.
+
+	_, err = conn.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	_, err = conn.Write([]byte("world"))
+	assert.True(t, errors.Is(err, net.ErrClosed))
+
+	assert.True(t, n.RunUntil(time.Second, func() bool { return len(l.conns) == 1 }))
+
+	accepted, err := l.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:49152", accepted.RemoteAddr().String())
+
+	buf, err := io.ReadAll(accepted)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	assert.NoError(t, accepted.Close())
+
+	// Read deadlines are respected.
+
+	conn, err = n.Dial(context.TODO(), a, "10.0.0.2:3000")
+	assert.NoError(t, err)
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+
+	// Connections that have yet to be accepted are refused once the listener is closed.
+
+	assert.NoError(t, l.Close())
+
+	n.Run(time.Second)
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, ErrRefused))
+
+	_, err = l.Accept()
+	assert.True(t, errors.Is(err, net.ErrClosed))
+}
+
+// newOverlays instantiates count nodes over n, each with a Kademlia overlay bound to it.
+func newOverlays(t *testing.T, n *Network, count int) ([]*core_module.Node, []*kademlia.Protocol) {
+	t.Helper()
+
+	nodes := make([]*core_module.Node, 0, count)
+	overlays := make([]*kademlia.Protocol, 0, count)
+
+	for i := 0; i < count; i++ {
+		node, err := n.NewNode()
+		assert.NoError(t, err)
+
+		overlay := kademlia.New()
+		node.Bind(overlay.Protocol())
+
+		assert.NoError(t, node.Listen())
+
+		nodes = append(nodes, node)
+		overlays = append(overlays, overlay)
+	}
+
+	return nodes, overlays
+}
+
+func TestPartition(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	n := New(WithLink(Link{Latency: 100 * time.Millisecond}))
+	defer n.Close()
+
+	n.Start()
+	defer n.Stop()
+
+	nodes, overlays := newOverlays(t, n, 3)
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	a, b := nodes[0].ID().Host, nodes[1].ID().Host
+
+	start := n.Clock().Now()
+
+	assert.NoError(t, overlays[0].Ping(context.TODO(), nodes[1].Addr()))
+	assert.GreaterOrEqual(t, int64(n.Clock().Since(start)), int64(200*time.Millisecond)) // At least one round-trip.
+
+	n.Partition([]net.IP{a}, []net.IP{b})
+
+	assert.Eventually(t, func() bool {
+		return len(nodes[0].Outbound()) == 0 && len(nodes[1].Inbound()) == 0
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Peers dialed across the partition are unreachable.
+
+	err := overlays[0].Ping(context.TODO(), nodes[1].Addr())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ErrUnreachable.Error())
+	}
+
+	// Hosts that are not in any group form a group of their own.
+
+	err = overlays[0].Ping(context.TODO(), nodes[2].Addr())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ErrUnreachable.Error())
+	}
+
+	assert.NoError(t, overlays[2].Ping(context.TODO(), nodes[2].Addr()))
+
+	n.Heal()
+
+	assert.NoError(t, overlays[0].Ping(context.TODO(), nodes[1].Addr()))
+}
+
+func TestKademlia(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	n := New(WithSeed(7), WithLink(Link{Latency: 20 * time.Millisecond, Jitter: 20 * time.Millisecond, Loss: 0.01}))
+	defer n.Close()
+
+	n.Start()
+	defer n.Stop()
+
+	nodes, overlays := newOverlays(t, n, 32)
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	// Bootstrap every node off of the first node.
+
+	for i := 1; i < len(nodes); i++ {
+		assert.NoError(t, overlays[i].Ping(context.TODO(), nodes[0].Addr()))
+		overlays[i].Find(context.TODO(), nodes[i].ID().PubKey)
+	}
+
+	// Every node may find any other node.
+
+	for i, overlay := range overlays {
+		target := nodes[(i*7+3)%len(nodes)].ID()
+
+		found := false
+
+		for _, id := range overlay.Find(context.TODO(), target.PubKey) {
+			if id.PubKey == target.PubKey {
+				found = true
+			}
+		}
+
+		assert.True(t, found || target.PubKey == nodes[i].ID().PubKey, "node %d failed to find %s", i, target)
+	}
+}
+
+func TestGossip(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	n := New(WithLink(Link{Latency: 50 * time.Millisecond, Jitter: 50 * time.Millisecond, Bandwidth: 64 << 10}))
+	defer n.Close()
+
+	nodes := make([]*core_module.Node, 0, 16)
+	overlays := make([]*kademlia.Protocol, 0, 16)
+	hubs := make([]*gossip.Protocol, 0, 16)
+
+	var received atomic.Int32
+
+	for i := 0; i < 16; i++ {
+		node, err := n.NewNode()
+		assert.NoError(t, err)
+
+		overlay := kademlia.New()
+		hub := gossip.New(overlay, gossip.WithEvents(gossip.Events{
+			OnGossipReceived: func(sender cryptographic.ID, data []byte) error {
+				received.Inc()
+				return nil
+			},
+		}))
+
+		node.Bind(overlay.Protocol(), hub.Protocol())
+		assert.NoError(t, node.Listen())
+
+		nodes = append(nodes, node)
+		overlays = append(overlays, overlay)
+		hubs = append(hubs, hub)
+	}
+
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	n.Start()
+
+	for i := 1; i < len(nodes); i++ {
+		assert.NoError(t, overlays[i].Ping(context.TODO(), nodes[0].Addr()))
+		overlays[i].Find(context.TODO(), nodes[i].ID().PubKey)
+	}
+
+	n.Stop()
+
+	// Gossip spreads to every node, driven by running the network in the foreground.
+
+	go hubs[0].Push(context.TODO(), []byte("hello"))
+
+	assert.True(t, n.RunUntil(time.Minute, func() bool {
+		return received.Load() == int32(len(nodes)-1)
+	}))
+}
+
+func TestReplay(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector randomizes the order goroutines are scheduled in, and so runs may not be replayed")
+	}
+
+	defer goleak.VerifyNone(t)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// run bootstraps a network of nodes off of the first node, and returns the virtual time it took alongside the
+	// peers each node found when looking up the ID of the first node.
+	run := func(seed int64) (time.Duration, [][]cryptographic.PublicKey) {
+		n := New(WithSeed(seed), WithLink(Link{Latency: 30 * time.Millisecond, Jitter: 30 * time.Millisecond, Loss: 0.05}))
+		defer n.Close()
+
+		n.Start()
+		defer n.Stop()
+
+		nodes, overlays := newOverlays(t, n, 12)
+		defer func() {
+			for _, node := range nodes {
+				node.Close()
+			}
+		}()
+
+		found := make([][]cryptographic.PublicKey, 0, len(nodes))
+
+		for i := 1; i < len(nodes); i++ {
+			assert.NoError(t, overlays[i].Ping(context.TODO(), nodes[0].Addr()))
+
+			var keys []cryptographic.PublicKey
+
+			for _, id := range overlays[i].Find(context.TODO(), nodes[0].ID().PubKey) {
+				keys = append(keys, id.PubKey)
+			}
+
+			found = append(found, keys)
+		}
+
+		return n.Clock().Since(n.start), found
+	}
+
+	elapsed, found := run(3)
+
+	// Networks given the same seed and driven the same way replay the same way.
+
+	replayedElapsed, replayedFound := run(3)
+
+	assert.Equal(t, elapsed, replayedElapsed)
+	assert.Equal(t, found, replayedFound)
+
+	otherElapsed, _ := run(4)
+	assert.NotEqual(t, elapsed, otherElapsed)
+}
+
+func TestNewNode(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	ids := func(seed int64) []cryptographic.ID {
+		n := New(WithSeed(seed))
+		defer n.Close()
+
+		nodes, _ := newOverlays(t, n, 3)
+
+		ids := make([]cryptographic.ID, 0, len(nodes))
+
+		for _, node := range nodes {
+			ids = append(ids, node.ID())
+			node.Close()
+		}
+
+		return ids
+	}
+
+	first := ids(1)
+
+	assert.Equal(t, "10.0.0.1:3000", first[0].Address)
+	assert.Equal(t, "10.0.0.3:3000", first[2].Address)
+
+	// Networks given the same seed instantiate nodes with the same keys.
+
+	assert.Equal(t, first, ids(1))
+	assert.NotEqual(t, first[0].PubKey, ids(2)[0].PubKey)
+}
@@ -0,0 +1,69 @@
+package simnet
+
+import "time"
+
+// Link describes the conditions of the path data takes from one host to another.
+type Link struct {
+	// Latency is the virtual time data takes to travel from one host to the other.
+	Latency time.Duration
+
+	// Jitter is the max virtual time that is randomly added on top of Latency for each segment of data sent. Data
+	// sent over a connection is always delivered in order, regardless of jitter.
+	Jitter time.Duration
+
+	// Loss is the probability in [0, 1) that a segment of data is lost, and must be retransmitted after
+	// RetransmitTimeout. A retransmitted segment may be lost again.
+	Loss float64
+
+	// RetransmitTimeout is the virtual time after which a lost segment of data is retransmitted. It is set to 200
+	// milliseconds should it not be positive.
+	RetransmitTimeout time.Duration
+
+	// Bandwidth is the number of bytes per second of virtual time that may be sent from one host to the other. It is
+	// shared by all connections between both hosts. Bandwidth is unlimited should it not be positive.
+	Bandwidth int
+}
+
+// DefaultLink are the conditions of all links that are not configured otherwise through WithLink or
+// (*Network).SetLink. Data travels between hosts in 1 millisecond, is never lost, and bandwidth is unlimited.
+var DefaultLink = Link{Latency: time.Millisecond}
+
+// Option is a functional option that may be configured when instantiating a new simulated network.
+type Option func(n *Network)
+
+// WithSeed sets the seed all randomness of the network is derived from, including the keys of nodes instantiated
+// through (*Network).NewNode. Networks that are given the same seed and driven the same way behave the same way. By
+// default, it is set to 1.
+func WithSeed(seed int64) Option {
+	return func(n *Network) {
+		n.seed = seed
+	}
+}
+
+// WithLink sets the conditions of all links that are not configured otherwise through (*Network).SetLink. By default,
+// it is set to DefaultLink.
+func WithLink(link Link) Option {
+	return func(n *Network) {
+		n.link = link
+	}
+}
+
+// WithStart sets the virtual time the clock of the network starts at. By default, it is set to the Unix epoch.
+func WithStart(start time.Time) Option {
+	return func(n *Network) {
+		n.start = start
+	}
+}
+
+// WithSettle sets the real time the network must have been idle for before its virtual clock is moved forward, which
+// gives the goroutines of nodes the chance to react to data that was delivered to them. Raising it makes runs more
+// reproducible on busy machines, at the cost of runs taking longer. By default, it is set to 200 microseconds.
+func WithSettle(settle time.Duration) Option {
+	return func(n *Network) {
+		if settle <= 0 {
+			return
+		}
+
+		n.settle = settle
+	}
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package simnet
+
+// raceEnabled is true should tests be run with the race detector enabled.
+const raceEnabled = true